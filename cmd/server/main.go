@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"net/http"
@@ -13,6 +14,8 @@ import (
 	"kpopapi/internal/auth"
 	"kpopapi/internal/handlers"
 	"kpopapi/internal/middleware"
+	"kpopapi/internal/storage"
+	"kpopapi/internal/store"
 )
 
 func main() {
@@ -46,16 +49,64 @@ func main() {
 
 	// Setup services/handlers
 	authSvc := auth.NewAuthService(db, appConfig)
+	pgStore := store.NewPostgres(db)
+	files, err := storage.NewLocal(appConfig.Uploads.Dir)
+	if err != nil {
+		log.Fatalf("failed to open upload dir: %v", err)
+	}
 	mux := http.NewServeMux()
 
 	// Auth endpoints
 	mux.HandleFunc("/api/login", authSvc.HandleLogin)
 	mux.HandleFunc("/api/logout", authSvc.HandleLogout)
+	mux.HandleFunc("/api/me", authSvc.HandleMe)
+	mux.HandleFunc("/api/me/favorites", handlers.HandleMyFavorites(pgStore))
+	mux.HandleFunc("/api/me/favorites/{idol_id}", handlers.HandleMyFavorite(pgStore))
+	mux.HandleFunc("/api/me/calendar-token", handlers.HandleMyCalendarToken(pgStore))
 
 	// Protected endpoints
 	mux.HandleFunc("/api/data", handlers.HandleSecretData)
-	mux.HandleFunc("/api/idols", handlers.HandleIdols(db))
-	mux.HandleFunc("/api/idols/", handlers.HandleIdolByID(db))
+	mux.HandleFunc("/api/idols", handlers.HandleIdols(pgStore))
+	mux.HandleFunc("/api/idols/", handlers.HandleIdolByID(pgStore, files))
+	mux.HandleFunc("/api/idols/search", handlers.HandleIdolSearch(pgStore))
+	mux.HandleFunc("/api/idols/trash", handlers.HandleIdolTrash(pgStore))
+	changeFeed := handlers.NewChangeFeed(pgStore)
+	mux.HandleFunc("/api/idols/stream", handlers.HandleIdolStream(changeFeed, authSvc.Revoked))
+	mux.HandleFunc("/api/idols/stream/ticket", authSvc.HandleStreamTicket)
+	mux.HandleFunc("/api/idols/import", handlers.HandleIdolImport(pgStore))
+	mux.HandleFunc("/api/idols/export", handlers.HandleIdolExport(pgStore))
+	mux.HandleFunc("/api/idols/batch", handlers.HandleIdolBatch(pgStore))
+	mux.HandleFunc("/api/idols/{id}/restore", handlers.HandleIdolRestore(pgStore))
+	mux.HandleFunc("/api/idols/{id}/history", handlers.HandleIdolHistory(pgStore))
+	mux.HandleFunc("/api/idols/{id}/diff", handlers.HandleIdolDiff(pgStore))
+	mux.HandleFunc("/api/idols/{id}/revert", handlers.HandleIdolRevert(pgStore))
+	mux.HandleFunc("/api/groups", handlers.HandleGroups(pgStore))
+	mux.HandleFunc("/api/groups/{id}", handlers.HandleGroupByID(pgStore))
+	mux.HandleFunc("/api/groups/{id}/members", handlers.HandleGroupMembers(pgStore))
+	mux.HandleFunc("/api/groups/{id}/members/{membership_id}", handlers.HandleGroupMember(pgStore))
+	mux.HandleFunc("/api/idols/{id}/groups", handlers.HandleIdolGroups(pgStore))
+	mux.HandleFunc("/api/positions", handlers.HandlePositions(pgStore))
+	mux.HandleFunc("/api/positions/{id}", handlers.HandlePositionByID(pgStore))
+	mux.HandleFunc("/api/idols/{id}/photos", handlers.HandleIdolPhotos(pgStore, files))
+	mux.HandleFunc("/api/files/{key...}", handlers.HandleFiles(files))
+	mux.HandleFunc("/api/albums", handlers.HandleAlbums(pgStore))
+	mux.HandleFunc("/api/albums/{id}", handlers.HandleAlbumByID(pgStore))
+	mux.HandleFunc("/api/albums/{id}/tracks", handlers.HandleAlbumTracks(pgStore))
+	mux.HandleFunc("/api/albums/{id}/tracks/{track_id}", handlers.HandleAlbumTrack(pgStore))
+	mux.HandleFunc("/api/idols/{id}/credits", handlers.HandleIdolCredits(pgStore))
+	mux.HandleFunc("/api/events", handlers.HandleEvents(pgStore, pgStore))
+	mux.HandleFunc("/api/events/{id}", handlers.HandleEventByID(pgStore))
+	mux.HandleFunc("/api/calendar.ics", handlers.HandleCalendar(pgStore, pgStore, pgStore, pgStore))
+	mux.HandleFunc("/api/polls", handlers.HandlePolls(pgStore))
+	mux.HandleFunc("/api/polls/{id}", handlers.HandlePollByID(pgStore))
+	mux.HandleFunc("/api/polls/{id}/votes", handlers.HandlePollVotes(pgStore))
+
+	// Permanently remove idols that stayed in the trash past the retention
+	go store.RunTrashPurger(context.Background(), pgStore, files, appConfig.Trash.Retention, appConfig.Trash.PurgeInterval)
+	// One change log poller shared by every idol stream
+	go changeFeed.Run(context.Background())
+	// Drop Idempotency-Key responses once they expire
+	go store.RunKeyPurger(context.Background(), pgStore, appConfig.Idempotency.PurgeInterval)
 	
 
 	// Health endpoint
@@ -88,8 +139,8 @@ func main() {
 		http.ServeFile(w, r, "frontend/login.html")
 	})
 
-	// Compose middlewares: CORS -> Auth -> mux
-	handler := middleware.CORS(auth.JWTMiddleware(authSvc, mux))
+	// Compose middlewares: CORS -> Auth -> Idempotency-Key -> mux
+	handler := middleware.CORS(auth.JWTMiddleware(authSvc, middleware.Idempotency(pgStore, appConfig.Idempotency.TTL, appConfig.Idempotency.Lease, mux)))
 
 	port := os.Getenv("APP_PORT")
 	if port == "" {
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
 	"gopkg.in/yaml.v2"
@@ -27,6 +28,25 @@ type AppConfig struct {
     Defaults struct {
         UserRole string `yaml:"user_role"`
     } `yaml:"defaults"`
+    Trash struct {
+        // Retention is how long soft-deleted idols are kept before the
+        // background purger removes them; 0 keeps them forever.
+        Retention     time.Duration `yaml:"retention"`
+        PurgeInterval time.Duration `yaml:"purge_interval"`
+    } `yaml:"trash"`
+    Idempotency struct {
+        // TTL is how long an Idempotency-Key and its stored response are
+        // replayed before the key can be used again.
+        TTL           time.Duration `yaml:"ttl"`
+        PurgeInterval time.Duration `yaml:"purge_interval"`
+        // Lease is how long a request may run before a retry with its key
+        // takes over; it must outlast the slowest request.
+        Lease time.Duration `yaml:"lease"`
+    } `yaml:"idempotency"`
+    Uploads struct {
+        // Dir is where the local storage backend keeps uploaded files.
+        Dir string `yaml:"dir"`
+    } `yaml:"uploads"`
     Users []YAMLUser `yaml:"users"`
 }
 
@@ -53,6 +73,23 @@ func Load() (AppConfig, error) {
     cfg.Database.User = getenv("DB_USER", "postgres")
     cfg.Database.Password = getenv("DB_PASSWORD", "postgresaja")
     cfg.Database.Name = getenv("DB_NAME", "restapi_db")
+    cfg.Uploads.Dir = getenv("UPLOAD_DIR", "uploads")
+    var err error
+    if cfg.Trash.Retention, err = time.ParseDuration(getenv("TRASH_RETENTION", "720h")); err != nil {
+        return cfg, fmt.Errorf("TRASH_RETENTION: %w", err)
+    }
+    if cfg.Trash.PurgeInterval, err = time.ParseDuration(getenv("TRASH_PURGE_INTERVAL", "1h")); err != nil {
+        return cfg, fmt.Errorf("TRASH_PURGE_INTERVAL: %w", err)
+    }
+    if cfg.Idempotency.TTL, err = time.ParseDuration(getenv("IDEMPOTENCY_TTL", "24h")); err != nil {
+        return cfg, fmt.Errorf("IDEMPOTENCY_TTL: %w", err)
+    }
+    if cfg.Idempotency.PurgeInterval, err = time.ParseDuration(getenv("IDEMPOTENCY_PURGE_INTERVAL", "1h")); err != nil {
+        return cfg, fmt.Errorf("IDEMPOTENCY_PURGE_INTERVAL: %w", err)
+    }
+    if cfg.Idempotency.Lease, err = time.ParseDuration(getenv("IDEMPOTENCY_LEASE", "1m")); err != nil {
+        return cfg, fmt.Errorf("IDEMPOTENCY_LEASE: %w", err)
+    }
 
     // Optional config.yml
     if b, err := os.ReadFile("config.yml"); err == nil {
@@ -72,9 +109,15 @@ func getenv(key, def string) string {
     return def
 }
 
-// RunMigrations creates tables if not exists and seeds sample data
-func RunMigrations(db *sql.DB) error {
-    stmts := []string{
+// migration is a named group of statements that is applied once, inside a
+// transaction, and recorded in schema_migrations.
+type migration struct {
+    name  string
+    stmts []string
+}
+
+var migrations = []migration{
+    {name: "0001_idols", stmts: []string{
         `CREATE TABLE IF NOT EXISTS idols (
             id SERIAL PRIMARY KEY,
             name VARCHAR(100) NOT NULL,
@@ -95,13 +138,341 @@ func RunMigrations(db *sql.DB) error {
         `INSERT INTO idols (name, "group_name", position)
          SELECT 'Karina','AESPA','Leader'
          WHERE NOT EXISTS (SELECT 1 FROM idols WHERE name='Karina');`,
+    }},
+    // full-text vector plus trigram indexes for /api/idols/search
+    {name: "0002_idol_search", stmts: []string{
+        `CREATE EXTENSION IF NOT EXISTS pg_trgm;`,
+        `ALTER TABLE idols ADD COLUMN IF NOT EXISTS search_vector tsvector
+            GENERATED ALWAYS AS (to_tsvector('simple', name || ' ' || "group_name" || ' ' || position)) STORED;`,
+        `CREATE INDEX IF NOT EXISTS idols_search_vector_idx ON idols USING gin (search_vector);`,
+        `CREATE INDEX IF NOT EXISTS idols_name_trgm_idx ON idols USING gin (name gin_trgm_ops);`,
+        `CREATE INDEX IF NOT EXISTS idols_group_name_trgm_idx ON idols USING gin ("group_name" gin_trgm_ops);`,
+        `CREATE INDEX IF NOT EXISTS idols_position_trgm_idx ON idols USING gin (position gin_trgm_ops);`,
+    }},
+    // one snapshot per idol version; existing rows get a baseline revision
+    {name: "0003_idol_revisions", stmts: []string{
+        `CREATE TABLE IF NOT EXISTS idol_revisions (
+            id BIGSERIAL PRIMARY KEY,
+            idol_id INT NOT NULL REFERENCES idols(id) ON DELETE CASCADE,
+            version INT NOT NULL,
+            action VARCHAR(16) NOT NULL,
+            actor VARCHAR(64) NOT NULL,
+            snapshot JSONB NOT NULL,
+            created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            UNIQUE (idol_id, version)
+        );`,
+        `INSERT INTO idol_revisions (idol_id, version, action, actor, snapshot, created_at)
+         SELECT id, version, 'create', updated_by,
+                jsonb_build_object('id', id, 'name', name, 'group_name', "group_name", 'position', position,
+                    'created_at', created_at, 'updated_at', updated_at, 'created_by', created_by,
+                    'updated_by', updated_by, 'deleted_at', deleted_at, 'version', version),
+                updated_at
+         FROM idols;`,
+    }},
+    // groups become rows; idols point at them and keep group_name as a copy
+    {name: "0004_groups", stmts: []string{
+        `CREATE TABLE IF NOT EXISTS groups (
+            id SERIAL PRIMARY KEY,
+            name VARCHAR(100) NOT NULL,
+            debut_date DATE NULL,
+            fandom_name VARCHAR(100) NOT NULL DEFAULT '',
+            agency VARCHAR(100) NOT NULL DEFAULT '',
+            status VARCHAR(16) NOT NULL DEFAULT 'active',
+            created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+        );`,
+        `CREATE UNIQUE INDEX IF NOT EXISTS groups_name_lower_idx ON groups (LOWER(name));`,
+        `INSERT INTO groups (name)
+         SELECT DISTINCT ON (LOWER(TRIM("group_name"))) TRIM("group_name")
+         FROM idols
+         ORDER BY LOWER(TRIM("group_name")), id
+         ON CONFLICT DO NOTHING;`,
+        `ALTER TABLE idols ADD COLUMN IF NOT EXISTS group_id INT REFERENCES groups(id);`,
+        `UPDATE idols i SET group_id = g.id, "group_name" = g.name
+         FROM groups g WHERE LOWER(g.name) = LOWER(TRIM(i."group_name"));`,
+        `ALTER TABLE idols ALTER COLUMN group_id SET NOT NULL;`,
+        `CREATE INDEX IF NOT EXISTS idols_group_id_idx ON idols (group_id);`,
+    }},
+    {name: "0005_group_memberships", stmts: []string{
+        `ALTER TABLE groups ADD COLUMN IF NOT EXISTS parent_id INT NULL REFERENCES groups(id);`,
+        `CREATE TABLE IF NOT EXISTS group_memberships (
+            id SERIAL PRIMARY KEY,
+            idol_id INT NOT NULL REFERENCES idols(id) ON DELETE CASCADE,
+            group_id INT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+            role VARCHAR(100) NOT NULL DEFAULT '',
+            joined_on DATE NULL,
+            left_on DATE NULL,
+            created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            CHECK (joined_on IS NULL OR left_on IS NULL OR left_on >= joined_on)
+        );`,
+        `CREATE INDEX IF NOT EXISTS group_memberships_group_idx ON group_memberships (group_id);`,
+        `CREATE INDEX IF NOT EXISTS group_memberships_idol_idx ON group_memberships (idol_id);`,
+        `INSERT INTO group_memberships (idol_id, group_id)
+         SELECT id, group_id FROM idols;`,
+    }},
+    // positions catalogue; existing position strings are split on , / & ;
+    // and matched against names and aliases, unknown terms become entries
+    {name: "0006_positions", stmts: []string{
+        `CREATE TABLE IF NOT EXISTS positions (
+            id SERIAL PRIMARY KEY,
+            name VARCHAR(100) NOT NULL,
+            aliases TEXT[] NOT NULL DEFAULT '{}',
+            created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+        );`,
+        `CREATE UNIQUE INDEX IF NOT EXISTS positions_name_lower_idx ON positions (LOWER(name));`,
+        `INSERT INTO positions (name, aliases) VALUES
+            ('Leader', '{}'),
+            ('Main Vocalist', '{"Main Vocal"}'),
+            ('Lead Vocalist', '{"Lead Vocal"}'),
+            ('Sub Vocalist', '{"Sub Vocal"}'),
+            ('Main Rapper', '{"Main Rap"}'),
+            ('Lead Rapper', '{"Lead Rap"}'),
+            ('Sub Rapper', '{"Sub Rap"}'),
+            ('Main Dancer', '{"Main Dance"}'),
+            ('Lead Dancer', '{"Lead Dance"}'),
+            ('Visual', '{"Face of the Group"}'),
+            ('Center', '{"Centre"}'),
+            ('Maknae', '{"Youngest"}')
+         ON CONFLICT DO NOTHING;`,
+        `CREATE TABLE IF NOT EXISTS idol_positions (
+            idol_id INT NOT NULL REFERENCES idols(id) ON DELETE CASCADE,
+            position_id INT NOT NULL REFERENCES positions(id),
+            priority INT NOT NULL,
+            PRIMARY KEY (idol_id, position_id),
+            UNIQUE (idol_id, priority)
+        );`,
+        `CREATE INDEX IF NOT EXISTS idol_positions_position_idx ON idol_positions (position_id);`,
+        `CREATE TEMP TABLE idol_position_terms ON COMMIT DROP AS
+         SELECT i.id AS idol_id, TRIM(t.term) AS term, t.n
+         FROM idols i CROSS JOIN LATERAL regexp_split_to_table(i.position, '\s*[,/&;]\s*') WITH ORDINALITY AS t(term, n)
+         WHERE TRIM(t.term) <> '';`,
+        `INSERT INTO positions (name)
+         SELECT DISTINCT ON (LOWER(term)) INITCAP(term)
+         FROM idol_position_terms t
+         WHERE NOT EXISTS (SELECT 1 FROM positions p WHERE LOWER(p.name) = LOWER(t.term)
+            OR EXISTS (SELECT 1 FROM unnest(p.aliases) a WHERE LOWER(a) = LOWER(t.term)))
+         ORDER BY LOWER(term)
+         ON CONFLICT DO NOTHING;`,
+        `INSERT INTO idol_positions (idol_id, position_id, priority)
+         SELECT t.idol_id, p.id, ROW_NUMBER() OVER (PARTITION BY t.idol_id ORDER BY MIN(t.n))
+         FROM idol_position_terms t
+         JOIN positions p ON LOWER(p.name) = LOWER(t.term)
+            OR EXISTS (SELECT 1 FROM unnest(p.aliases) a WHERE LOWER(a) = LOWER(t.term))
+         GROUP BY t.idol_id, p.id;`,
+        `UPDATE idols SET position = COALESCE(
+            (SELECT string_agg(p.name, ', ' ORDER BY ip.priority) FROM idol_positions ip
+             JOIN positions p ON p.id = ip.position_id WHERE ip.idol_id = idols.id), '');`,
+    }},
+    // Idempotency-Key requests per user; status stays 0 until the first
+    // request has been answered
+    {name: "0007_idempotency_keys", stmts: []string{
+        `CREATE TABLE IF NOT EXISTS idempotency_keys (
+            username VARCHAR(64) NOT NULL,
+            key VARCHAR(255) NOT NULL,
+            fingerprint CHAR(64) NOT NULL,
+            status INT NOT NULL DEFAULT 0,
+            header JSONB NOT NULL DEFAULT '{}',
+            body BYTEA,
+            created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            expires_at TIMESTAMPTZ NOT NULL,
+            PRIMARY KEY (username, key)
+        );`,
+        `CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);`,
+    }},
+    // photo metadata; the files live in storage under the variants' keys
+    {name: "0008_idol_photos", stmts: []string{
+        `CREATE TABLE IF NOT EXISTS idol_photos (
+            id SERIAL PRIMARY KEY,
+            idol_id INT NOT NULL REFERENCES idols(id) ON DELETE CASCADE,
+            content_type VARCHAR(32) NOT NULL,
+            width INT NOT NULL,
+            height INT NOT NULL,
+            size_bytes BIGINT NOT NULL,
+            variants JSONB NOT NULL DEFAULT '[]',
+            uploaded_by VARCHAR(64) NOT NULL,
+            created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+        );`,
+        `CREATE INDEX IF NOT EXISTS idol_photos_idol_idx ON idol_photos (idol_id);`,
+    }},
+    // profile fields; name stays the stage name
+    {name: "0009_idol_profile", stmts: []string{
+        `ALTER TABLE idols
+            ADD COLUMN IF NOT EXISTS legal_name VARCHAR(100) NOT NULL DEFAULT '',
+            ADD COLUMN IF NOT EXISTS hangul_name VARCHAR(100) NOT NULL DEFAULT '',
+            ADD COLUMN IF NOT EXISTS birth_date DATE,
+            ADD COLUMN IF NOT EXISTS nationality VARCHAR(2) NOT NULL DEFAULT '',
+            ADD COLUMN IF NOT EXISTS debut_date DATE,
+            ADD COLUMN IF NOT EXISTS height_cm SMALLINT,
+            ADD COLUMN IF NOT EXISTS mbti VARCHAR(6) NOT NULL DEFAULT '',
+            ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'active';`,
+        `CREATE INDEX IF NOT EXISTS idols_birth_date_idx ON idols (birth_date);`,
+        `CREATE INDEX IF NOT EXISTS idols_nationality_idx ON idols (nationality);`,
+    }},
+    // discography; albums keep their group, tracks and credits go with
+    // their album
+    {name: "0010_discography", stmts: []string{
+        `CREATE TABLE IF NOT EXISTS albums (
+            id SERIAL PRIMARY KEY,
+            group_id INT NOT NULL REFERENCES groups(id),
+            title VARCHAR(100) NOT NULL,
+            kind VARCHAR(16) NOT NULL DEFAULT 'album',
+            release_date DATE NULL,
+            created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+        );`,
+        `CREATE INDEX IF NOT EXISTS albums_group_idx ON albums (group_id);`,
+        `CREATE TABLE IF NOT EXISTS tracks (
+            id SERIAL PRIMARY KEY,
+            album_id INT NOT NULL REFERENCES albums(id) ON DELETE CASCADE,
+            track_no INT NOT NULL CHECK (track_no > 0),
+            title VARCHAR(100) NOT NULL,
+            duration_sec INT NULL CHECK (duration_sec > 0),
+            UNIQUE (album_id, track_no)
+        );`,
+        `CREATE TABLE IF NOT EXISTS track_credits (
+            track_id INT NOT NULL REFERENCES tracks(id) ON DELETE CASCADE,
+            idol_id INT NOT NULL REFERENCES idols(id) ON DELETE CASCADE,
+            role VARCHAR(16) NOT NULL,
+            PRIMARY KEY (track_id, idol_id, role)
+        );`,
+        `CREATE INDEX IF NOT EXISTS track_credits_idol_idx ON track_credits (idol_id);`,
+    }},
+    // scheduled events; birthdays are derived from idols.birth_date
+    {name: "0011_events", stmts: []string{
+        `CREATE TABLE IF NOT EXISTS events (
+            id SERIAL PRIMARY KEY,
+            kind VARCHAR(16) NOT NULL,
+            title VARCHAR(100) NOT NULL,
+            description TEXT NOT NULL DEFAULT '',
+            venue VARCHAR(100) NOT NULL DEFAULT '',
+            starts_at TIMESTAMPTZ NOT NULL,
+            ends_at TIMESTAMPTZ NULL,
+            all_day BOOLEAN NOT NULL DEFAULT FALSE,
+            group_id INT NULL REFERENCES groups(id),
+            idol_id INT NULL REFERENCES idols(id) ON DELETE CASCADE,
+            created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            CHECK (ends_at IS NULL OR ends_at > starts_at),
+            CHECK (group_id IS NOT NULL OR idol_id IS NOT NULL)
+        );`,
+        `CREATE INDEX IF NOT EXISTS events_starts_at_idx ON events (starts_at);`,
+        `CREATE INDEX IF NOT EXISTS events_group_idx ON events (group_id);`,
+        `CREATE INDEX IF NOT EXISTS events_idol_idx ON events (idol_id);`,
+    }},
+    {name: "0012_favorites", stmts: []string{
+        `CREATE TABLE IF NOT EXISTS favorites (
+            username VARCHAR(64) NOT NULL,
+            idol_id INT NOT NULL REFERENCES idols(id) ON DELETE CASCADE,
+            sort_order INT NOT NULL,
+            added_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            PRIMARY KEY (username, idol_id)
+        );`,
+        `CREATE INDEX IF NOT EXISTS favorites_idol_idx ON favorites (idol_id);`,
+    }},
+    {name: "0013_polls", stmts: []string{
+        `CREATE TABLE IF NOT EXISTS polls (
+            id SERIAL PRIMARY KEY,
+            question VARCHAR(200) NOT NULL,
+            opens_at TIMESTAMPTZ NOT NULL,
+            closes_at TIMESTAMPTZ NOT NULL,
+            created_by VARCHAR(64) NOT NULL DEFAULT 'system',
+            created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            CHECK (closes_at > opens_at)
+        );`,
+        // votes is the tally, kept by the vote transaction.
+        `CREATE TABLE IF NOT EXISTS poll_options (
+            id SERIAL PRIMARY KEY,
+            poll_id INT NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+            sort_order INT NOT NULL,
+            idol_id INT NULL REFERENCES idols(id) ON DELETE CASCADE,
+            group_id INT NULL REFERENCES groups(id),
+            votes INT NOT NULL DEFAULT 0 CHECK (votes >= 0),
+            CHECK ((idol_id IS NULL) <> (group_id IS NULL)),
+            UNIQUE (poll_id, id),
+            UNIQUE (poll_id, idol_id),
+            UNIQUE (poll_id, group_id)
+        );`,
+        `CREATE INDEX IF NOT EXISTS poll_options_idol_idx ON poll_options (idol_id);`,
+        `CREATE INDEX IF NOT EXISTS poll_options_group_idx ON poll_options (group_id);`,
+        // The primary key allows one vote per user and poll; the foreign key
+        // keeps the option within the poll.
+        `CREATE TABLE IF NOT EXISTS poll_votes (
+            poll_id INT NOT NULL,
+            username VARCHAR(64) NOT NULL,
+            option_id INT NOT NULL,
+            created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            PRIMARY KEY (poll_id, username),
+            FOREIGN KEY (poll_id, option_id) REFERENCES poll_options (poll_id, id) ON DELETE CASCADE
+        );`,
+    }},
+    // one calendar feed token per user, stored as a SHA-256 hex digest
+    {name: "0014_feed_tokens", stmts: []string{
+        `CREATE TABLE IF NOT EXISTS feed_tokens (
+            username VARCHAR(64) PRIMARY KEY,
+            token_hash CHAR(64) NOT NULL UNIQUE,
+            created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+        );`,
+    }},
+    // unanswered Idempotency-Key reservations lapse after a lease; rows
+    // reserved before this migration lapse at once
+    {name: "0015_idempotency_lease", stmts: []string{
+        `ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMPTZ NOT NULL DEFAULT NOW();`,
+    }},
+    // each reservation of a key gets its own token, so a request that ran
+    // past its lease cannot settle the retry's reservation
+    {name: "0016_idempotency_token", stmts: []string{
+        `ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS token CHAR(32) NOT NULL DEFAULT '';`,
+    }},
+    // the change log hands a revision out once every transaction open when
+    // it was written has ended (xid8, PostgreSQL 13 or later)
+    {name: "0017_revision_settle_xid", stmts: []string{
+        `ALTER TABLE idol_revisions ADD COLUMN IF NOT EXISTS settle_xid xid8 NOT NULL DEFAULT pg_snapshot_xmax(pg_current_snapshot());`,
+        `CREATE INDEX IF NOT EXISTS idol_revisions_settle_xid_idx ON idol_revisions (settle_xid);`,
+    }},
+}
+
+// RunMigrations applies every migration that has not been recorded yet
+func RunMigrations(db *sql.DB) error {
+    if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+            name VARCHAR(100) PRIMARY KEY,
+            applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+        );`); err != nil {
+        return err
     }
-    for _, s := range stmts {
-        if _, err := db.Exec(s); err != nil {
-            return err
+    for _, m := range migrations {
+        if err := applyMigration(db, m); err != nil {
+            return fmt.Errorf("migration %s: %w", m.name, err)
         }
     }
     return nil
 }
 
-
+func applyMigration(db *sql.DB, m migration) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    // serialize concurrent server starts
+    if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(7316)`); err != nil {
+        return err
+    }
+    var done bool
+    if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE name=$1)`, m.name).Scan(&done); err != nil {
+        return err
+    }
+    if done {
+        return nil
+    }
+    for _, s := range m.stmts {
+        if _, err := tx.Exec(s); err != nil {
+            return err
+        }
+    }
+    if _, err := tx.Exec(`INSERT INTO schema_migrations (name) VALUES ($1)`, m.name); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
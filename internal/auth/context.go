@@ -0,0 +1,30 @@
+package auth
+
+import "context"
+
+type claimsKey struct{}
+
+type tokenKey struct{}
+
+// WithClaims returns a copy of ctx carrying the authenticated user's claims.
+func WithClaims(ctx context.Context, c *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, c)
+}
+
+// ClaimsFromContext returns the claims stored by JWTMiddleware, if any.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	c, ok := ctx.Value(claimsKey{}).(*Claims)
+	return c, ok && c != nil
+}
+
+// WithToken returns a copy of ctx carrying the bearer token the claims were
+// read from.
+func WithToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, tokenKey{}, token)
+}
+
+// TokenFromContext returns the bearer token stored by JWTMiddleware, if any.
+func TokenFromContext(ctx context.Context) (string, bool) {
+	token, ok := ctx.Value(tokenKey{}).(string)
+	return token, ok && token != ""
+}
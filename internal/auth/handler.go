@@ -4,6 +4,14 @@ import (
 	"encoding/json"
 	"net/http"
 	"time"
+
+	"kpopapi/pkg/validation"
+)
+
+// Login field limits. Usernames end up in the VARCHAR(64) audit columns.
+const (
+	maxUsernameLen = 64
+	maxPasswordLen = 256
 )
 
 type loginRequest struct {
@@ -11,6 +19,18 @@ type loginRequest struct {
 	Password string `json:"password"`
 }
 
+// validate cleans the username in place and checks the payload. The password
+// is compared exactly as sent.
+func (in *loginRequest) validate() error {
+	in.Username = validation.Clean(in.Username)
+	var v validation.Validator
+	v.Required("username", in.Username)
+	v.MaxLen("username", in.Username, maxUsernameLen)
+	v.Required("password", in.Password)
+	v.MaxLen("password", in.Password, maxPasswordLen)
+	return v.Err()
+}
+
 func (a *AuthService) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusNoContent)
@@ -25,6 +45,12 @@ func (a *AuthService) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid json", http.StatusBadRequest)
 		return
 	}
+	if err := in.validate(); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": "validation failed", "errors": err})
+		return
+	}
 
 	username := in.Username
 	// Validate only against YAML users; fallback to BASIC if YAML empty
@@ -87,3 +113,26 @@ func (a *AuthService) HandleLogout(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(map[string]string{"message": "logout success"})
 }
+
+// HandleMe returns the authenticated user as seen by JWTMiddleware.
+func (a *AuthService) HandleMe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims, ok := ClaimsFromContext(r.Context())
+	if !ok {
+		http.Error(w, "missing bearer token", http.StatusUnauthorized)
+		return
+	}
+	resp := map[string]interface{}{
+		"username": claims.Username,
+		"role":     claims.Role,
+	}
+	if claims.ExpiresAt != nil {
+		resp["expires_at"] = claims.ExpiresAt.Time
+		resp["expires_in"] = int(time.Until(claims.ExpiresAt.Time).Seconds())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
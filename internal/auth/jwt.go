@@ -20,11 +20,16 @@ type AuthService struct {
         sync.RWMutex
         m map[string]time.Time
     }
+    tickets struct {
+        sync.Mutex
+        m map[string]streamTicket
+    }
 }
 
 func NewAuthService(db *sql.DB, cfg config.AppConfig) *AuthService {
     as := &AuthService{db: db, cfg: cfg, jwtKey: []byte("secret_dev_key_change_me")}
     as.blacklist.m = make(map[string]time.Time)
+    as.tickets.m = make(map[string]streamTicket)
     return as
 }
 
@@ -72,6 +77,11 @@ func (a *AuthService) Blacklist(token string, exp time.Time) {
     a.blacklist.Unlock()
 }
 
+// Revoked reports whether token was blacklisted by a logout.
+func (a *AuthService) Revoked(token string) bool {
+    return a.isBlacklisted(token)
+}
+
 func (a *AuthService) isBlacklisted(token string) bool {
     a.blacklist.RLock()
     exp, ok := a.blacklist.m[token]
@@ -88,6 +98,9 @@ func JWTMiddleware(auth *AuthService, next http.Handler) http.Handler {
         path := r.URL.Path
         if strings.HasPrefix(path, "/api/login") ||
             strings.HasPrefix(path, "/swagger") ||
+            // calendar apps cannot send a bearer token; without one the
+            // feed checks its own ?token=
+            (path == "/api/calendar.ics" && r.Header.Get("Authorization") == "") ||
             path == "/" ||
             strings.HasSuffix(path, ".html") ||
             strings.HasSuffix(path, ".js") ||
@@ -96,16 +109,23 @@ func JWTMiddleware(auth *AuthService, next http.Handler) http.Handler {
             return
         }
         authz := r.Header.Get("Authorization")
-        if !strings.HasPrefix(authz, "Bearer ") {
+        token, ok := strings.CutPrefix(authz, "Bearer ")
+        // EventSource cannot send headers, so the idol stream also takes a
+        // single-use ?ticket= standing in for the token
+        if authz == "" && path == "/api/idols/stream" {
+            token, ok = auth.redeemStreamTicket(r.URL.Query().Get("ticket"))
+        }
+        if !ok {
             http.Error(w, "missing bearer token", http.StatusUnauthorized)
             return
         }
-        token := strings.TrimPrefix(authz, "Bearer ")
-        if _, err := auth.ParseToken(token); err != nil {
+        claims, err := auth.ParseToken(token)
+        if err != nil {
             http.Error(w, "invalid or expired token", http.StatusUnauthorized)
             return
         }
-        next.ServeHTTP(w, r)
+        ctx := WithToken(WithClaims(r.Context(), claims), token)
+        next.ServeHTTP(w, r.WithContext(ctx))
     })
 }
 
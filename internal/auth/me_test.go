@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kpopapi/config"
+)
+
+func TestHandleMe(t *testing.T) {
+	a := NewAuthService(nil, config.AppConfig{})
+	token, _, err := a.CreateToken("user2", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := JWTMiddleware(a, http.HandlerFunc(a.HandleMe))
+	tests := []struct {
+		name   string
+		method string
+		header string
+		status int
+	}{
+		{"bearer token", http.MethodGet, "Bearer " + token, http.StatusOK},
+		{"no token", http.MethodGet, "", http.StatusUnauthorized},
+		{"bad token", http.MethodGet, "Bearer not.a.token", http.StatusUnauthorized},
+		{"POST", http.MethodPost, "Bearer " + token, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/me", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var me struct {
+				Username  string `json:"username"`
+				Role      string `json:"role"`
+				ExpiresIn int    `json:"expires_in"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &me); err != nil {
+				t.Fatal(err)
+			}
+			if me.Username != "user2" || me.Role != "user" || me.ExpiresIn <= 0 {
+				t.Errorf("me = %+v", me)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// streamTicketTTL is how long a stream ticket can be redeemed. It only has
+// to cover the time between fetching it and opening the stream.
+const streamTicketTTL = 30 * time.Second
+
+// streamTicket stands in for a bearer token on one stream connection, so
+// the token itself never appears in a URL.
+type streamTicket struct {
+	token     string
+	expiresAt time.Time
+}
+
+// IssueStreamTicket returns a new single-use ticket for token.
+func (a *AuthService) IssueStreamTicket(token string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	ticket := hex.EncodeToString(b)
+	now := time.Now()
+	a.tickets.Lock()
+	defer a.tickets.Unlock()
+	for t, st := range a.tickets.m {
+		if !now.Before(st.expiresAt) {
+			delete(a.tickets.m, t)
+		}
+	}
+	a.tickets.m[ticket] = streamTicket{token: token, expiresAt: now.Add(streamTicketTTL)}
+	return ticket, nil
+}
+
+// redeemStreamTicket returns the token a ticket stands for and forgets the
+// ticket.
+func (a *AuthService) redeemStreamTicket(ticket string) (string, bool) {
+	if ticket == "" {
+		return "", false
+	}
+	a.tickets.Lock()
+	st, ok := a.tickets.m[ticket]
+	delete(a.tickets.m, ticket)
+	a.tickets.Unlock()
+	if !ok || !time.Now().Before(st.expiresAt) {
+		return "", false
+	}
+	return st.token, true
+}
+
+// HandleStreamTicket serves POST /api/idols/stream/ticket. It answers with a
+// ticket that opens one /api/idols/stream connection as the caller within
+// streamTicketTTL.
+func (a *AuthService) HandleStreamTicket(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token, ok := TokenFromContext(r.Context())
+	if !ok {
+		http.Error(w, "missing bearer token", http.StatusUnauthorized)
+		return
+	}
+	ticket, err := a.IssueStreamTicket(token)
+	if err != nil {
+		http.Error(w, "failed to create ticket", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"ticket":     ticket,
+		"expires_in": int(streamTicketTTL.Seconds()),
+	})
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"kpopapi/config"
+)
+
+func TestStreamTicketIsSingleUse(t *testing.T) {
+	a := NewAuthService(nil, config.AppConfig{})
+	token, _, err := a.CreateToken("user2", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := JWTMiddleware(a, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tok, ok := TokenFromContext(r.Context()); !ok || tok != token {
+			t.Errorf("token in context = %q", tok)
+		}
+	}))
+	open := func(query string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/idols/stream?"+query, nil))
+		return w.Code
+	}
+
+	ticket, err := a.IssueStreamTicket(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []int{open("ticket=" + ticket), open("ticket=" + ticket), open("access_token=" + token), open("ticket=")}
+	want := []int{http.StatusOK, http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("request %d: status %d, want %d", i, got[i], want[i])
+		}
+	}
+
+	stale, err := a.IssueStreamTicket(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.tickets.Lock()
+	st := a.tickets.m[stale]
+	st.expiresAt = time.Now().Add(-time.Second)
+	a.tickets.m[stale] = st
+	a.tickets.Unlock()
+	if code := open("ticket=" + stale); code != http.StatusUnauthorized {
+		t.Errorf("expired ticket: status %d, want 401", code)
+	}
+
+	// A ticket outlives neither a logout nor its token.
+	revoked, err := a.IssueStreamTicket(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Blacklist(token, time.Now().Add(time.Hour))
+	if code := open("ticket=" + revoked); code != http.StatusUnauthorized {
+		t.Errorf("ticket after logout: status %d, want 401", code)
+	}
+}
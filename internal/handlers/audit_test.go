@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"kpopapi/internal/auth"
+	"kpopapi/internal/models"
+	"kpopapi/internal/store"
+)
+
+// asUser runs h with the claims of username, as JWTMiddleware would.
+func asUser(h http.Handler, username string) http.Handler {
+	claims := &auth.Claims{Username: username, Role: "user"}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(auth.WithClaims(r.Context(), claims)))
+	})
+}
+
+// TestIdolWritesRecordActor makes each kind of idol write as a different
+// user and checks the audit fields and the revision actor it leaves.
+func TestIdolWritesRecordActor(t *testing.T) {
+	s := store.NewMemory()
+	if _, err := s.CreateGroup(context.Background(), models.Group{Name: "AESPA"}); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/idols", HandleIdols(s))
+	mux.HandleFunc("/api/idols/", HandleIdolByID(s, nil))
+	mux.HandleFunc("/api/idols/{id}/restore", HandleIdolRestore(s))
+	mux.HandleFunc("/api/idols/{id}/revert", HandleIdolRevert(s))
+
+	steps := []struct {
+		user   string
+		method string
+		target string
+		body   string
+		header []string
+		action string
+	}{
+		{"karina", http.MethodPost, "/api/idols", `{"name":"Winter","group_name":"AESPA","position":"Leader"}`, nil, store.RevisionCreate},
+		{"giselle", http.MethodPut, "/api/idols/1", `{"name":"Winter","group_name":"AESPA","position":"Main Vocalist"}`, nil, store.RevisionUpdate},
+		{"ningning", http.MethodPatch, "/api/idols/1", `{"position":"Visual"}`, []string{"Content-Type", "application/merge-patch+json"}, store.RevisionUpdate},
+		{"winter", http.MethodDelete, "/api/idols/1", "", nil, store.RevisionDelete},
+		{"karina", http.MethodPost, "/api/idols/1/restore", "", nil, store.RevisionRestore},
+		{"giselle", http.MethodPost, "/api/idols/1/revert?to=1", "", nil, store.RevisionRevert},
+		// Without claims the store records "system".
+		{"", http.MethodPatch, "/api/idols/1", `{"position":"Leader"}`, []string{"Content-Type", "application/merge-patch+json"}, store.RevisionUpdate},
+	}
+	for i, step := range steps {
+		h := http.Handler(mux)
+		if step.user != "" {
+			h = asUser(mux, step.user)
+		}
+		w := do(t, h, step.method, step.target, step.body, step.header...)
+		if w.Code != http.StatusOK && w.Code != http.StatusCreated {
+			t.Fatalf("step %d %s %s: status %d, body %s", i, step.method, step.target, w.Code, w.Body)
+		}
+		want := step.user
+		if want == "" {
+			want = "system"
+		}
+		revs, err := s.History(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rev := revs[len(revs)-1]; rev.Action != step.action || rev.Actor != want {
+			t.Errorf("step %d: revision %s by %s, want %s by %s", i, rev.Action, rev.Actor, step.action, want)
+		}
+	}
+
+	it, err := s.Get(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if it.CreatedBy != "karina" || it.UpdatedBy != "system" {
+		t.Errorf("created_by %q, updated_by %q", it.CreatedBy, it.UpdatedBy)
+	}
+}
+
+// TestIdolActorIgnoresBody checks that audit fields in a request body do
+// not override the authenticated user.
+func TestIdolActorIgnoresBody(t *testing.T) {
+	s, _ := newIdolServer(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/idols", HandleIdols(s))
+	mux.HandleFunc("/api/idols/", HandleIdolByID(s, nil))
+	h := asUser(mux, "user2")
+
+	created := createIdol(t, h, `{"name":"Karina","group_name":"AESPA","position":"Leader","created_by":"admin","updated_by":"admin"}`)
+	if created.CreatedBy != "user2" || created.UpdatedBy != "user2" {
+		t.Errorf("created = %+v", created)
+	}
+	w := do(t, h, http.MethodPut, "/api/idols/1", `{"name":"Karina","group_name":"AESPA","position":"Leader","updated_by":"admin"}`)
+	if got := decodeBody[models.Idol](t, w); got.UpdatedBy != "user2" || got.CreatedBy != "user2" {
+		t.Errorf("updated = %+v", got)
+	}
+}
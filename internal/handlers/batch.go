@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"kpopapi/internal/store"
+	"kpopapi/pkg/validation"
+)
+
+// maxBatchOps caps the operations of one batch.
+const maxBatchOps = 500
+
+// Batch operation kinds.
+const (
+	batchCreate = "create"
+	batchUpdate = "update"
+	batchDelete = "delete"
+)
+
+// batchOp is one operation of POST /api/idols/batch. Updates and deletes name
+// the idol by id; version, when set, must match like If-Match does.
+type batchOp struct {
+	Op      string     `json:"op"`
+	ID      int64      `json:"id,omitempty"`
+	Version int        `json:"version,omitempty"`
+	Idol    *idolInput `json:"idol,omitempty"`
+}
+
+func (op batchOp) validate() error {
+	var v validation.Validator
+	switch op.Op {
+	case batchCreate:
+		v.Check(op.ID == 0, "id", validation.CodeInvalid, "create must not have an id")
+	case batchUpdate, batchDelete:
+		v.Check(op.ID > 0, "id", validation.CodeRequired, op.Op+" needs an id")
+	default:
+		v.Add("op", validation.CodeOneOf, "op must be one of create, update, delete")
+		return v.Err()
+	}
+	switch {
+	case op.Op == batchDelete:
+		v.Check(op.Idol == nil, "idol", validation.CodeInvalid, "delete must not have an idol")
+	case op.Idol == nil:
+		v.Add("idol", validation.CodeRequired, op.Op+" needs an idol")
+	default:
+		v.Nest("idol", op.Idol.validate())
+	}
+	return v.Err()
+}
+
+// batchResult reports the outcome of one operation.
+type batchResult struct {
+	Index   int    `json:"index"`
+	Op      string `json:"op"`
+	Status  int    `json:"status"`
+	ID      int64  `json:"id,omitempty"`
+	Version int    `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// Errors lists the fields of an operation that failed validation.
+	Errors validation.Errors `json:"errors,omitempty"`
+}
+
+// errBatchFailed rolls a batch back after an operation failed.
+var errBatchFailed = errors.New("batch operation failed")
+
+// HandleIdolBatch serves POST /api/idols/batch. The operations run in order
+// in one transaction: either all of them are applied or none is. The answer
+// has one result per operation; after a failure the failing one carries its
+// status and error, earlier ones are rolled back and later ones are not run
+// (status 0).
+func HandleIdolBatch(idols store.AtomicStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		var body struct {
+			Operations []batchOp `json:"operations"`
+		}
+		dec := json.NewDecoder(io.LimitReader(r.Body, maxImportSize))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+			return
+		}
+		ops := body.Operations
+		if len(ops) == 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no operations"})
+			return
+		}
+		if len(ops) > maxBatchOps {
+			writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": fmt.Sprintf("at most %d operations per batch", maxBatchOps)})
+			return
+		}
+
+		results := make([]batchResult, len(ops))
+		invalid := false
+		for i, op := range ops {
+			results[i] = batchResult{Index: i, Op: op.Op}
+			if err := op.validate(); err != nil {
+				results[i].Status, results[i].Error = http.StatusUnprocessableEntity, err.Error()
+				errors.As(err, &results[i].Errors)
+				invalid = true
+			}
+		}
+		if invalid {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{"committed": false, "results": results})
+			return
+		}
+
+		failed := -1
+		err := idols.Atomic(r.Context(), func(tx store.Tx) error {
+			for i, op := range ops {
+				res, err := runBatchOp(r, tx, op)
+				if err != nil {
+					status, text := storeErrorStatus(err, "batch error")
+					if status == http.StatusInternalServerError {
+						return err
+					}
+					results[i].Status, results[i].Error = status, text
+					failed = i
+					return errBatchFailed
+				}
+				res.Index, res.Op = i, op.Op
+				results[i] = res
+			}
+			return nil
+		})
+		switch {
+		case errors.Is(err, errBatchFailed):
+			for i := range results {
+				if i < failed {
+					results[i].ID, results[i].Version = 0, 0
+				}
+			}
+			writeJSON(w, results[failed].Status, map[string]interface{}{"committed": false, "failed": failed, "results": results})
+		case err != nil:
+			writeStoreError(w, err, "batch error")
+		default:
+			writeJSON(w, http.StatusOK, map[string]interface{}{"committed": true, "results": results})
+		}
+	}
+}
+
+func runBatchOp(r *http.Request, idols store.IdolStore, op batchOp) (batchResult, error) {
+	ctx := r.Context()
+	switch op.Op {
+	case batchCreate:
+		it := op.Idol.idol()
+		it.CreatedBy = actor(r)
+		created, err := idols.Create(ctx, it)
+		return batchResult{Status: http.StatusCreated, ID: created.ID, Version: created.Version}, err
+	case batchUpdate:
+		it := op.Idol.idol()
+		it.ID, it.UpdatedBy = op.ID, actor(r)
+		version := op.Version
+		if version == 0 {
+			version = op.Idol.Version
+		}
+		updated, err := idols.Update(ctx, it, version)
+		return batchResult{Status: http.StatusOK, ID: updated.ID, Version: updated.Version}, err
+	}
+	cur, err := idols.Get(ctx, op.ID)
+	if err != nil {
+		return batchResult{}, err
+	}
+	if err := idols.SoftDelete(ctx, op.ID, op.Version, actor(r)); err != nil {
+		return batchResult{}, err
+	}
+	return batchResult{Status: http.StatusOK, ID: op.ID, Version: cur.Version + 1}, nil
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"kpopapi/internal/store"
+)
+
+type batchAnswer struct {
+	Committed bool          `json:"committed"`
+	Failed    *int          `json:"failed"`
+	Results   []batchResult `json:"results"`
+}
+
+func newBatchServer(t *testing.T) (*store.Memory, http.Handler) {
+	t.Helper()
+	s, h := newIdolServer(t)
+	mux := http.NewServeMux()
+	mux.Handle("/api/idols/batch", withAdmin(HandleIdolBatch(s)))
+	mux.Handle("/", h)
+	return s, mux
+}
+
+func TestIdolBatchCommits(t *testing.T) {
+	_, h := newBatchServer(t)
+	createIdol(t, h, `{"name":"Karina","group_name":"AESPA","position":"Leader"}`)
+
+	w := do(t, h, http.MethodPost, "/api/idols/batch", `{"operations":[
+		{"op":"create","idol":{"name":"Winter","group_name":"AESPA","position":"Main Vocalist"}},
+		{"op":"update","id":1,"version":1,"idol":{"name":"Karina","group_name":"AESPA","position":"Visual"}},
+		{"op":"delete","id":2}
+	]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body)
+	}
+	got := decodeBody[batchAnswer](t, w)
+	if !got.Committed || len(got.Results) != 3 {
+		t.Fatalf("answer = %+v", got)
+	}
+	want := []batchResult{
+		{Index: 0, Op: "create", Status: http.StatusCreated, ID: 2, Version: 1},
+		{Index: 1, Op: "update", Status: http.StatusOK, ID: 1, Version: 2},
+		{Index: 2, Op: "delete", Status: http.StatusOK, ID: 2, Version: 2},
+	}
+	for i := range want {
+		if got.Results[i].Index != want[i].Index || got.Results[i].Op != want[i].Op || got.Results[i].Status != want[i].Status ||
+			got.Results[i].ID != want[i].ID || got.Results[i].Version != want[i].Version {
+			t.Errorf("result %d = %+v, want %+v", i, got.Results[i], want[i])
+		}
+	}
+	if w := do(t, h, http.MethodGet, "/api/idols/2", ""); w.Code != http.StatusNotFound {
+		t.Errorf("deleted in batch: status %d, want 404", w.Code)
+	}
+}
+
+// TestIdolBatchStopsAtFirstFailure checks that a failing operation answers
+// with its own status, rolls back the earlier ones and skips the rest.
+func TestIdolBatchStopsAtFirstFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		second string
+		status int
+	}{
+		{"missing idol", `{"op":"update","id":9,"idol":{"name":"X","group_name":"NCT","position":"Leader"}}`, http.StatusNotFound},
+		{"stale version", `{"op":"delete","id":1,"version":5}`, http.StatusConflict},
+		{"unknown group", `{"op":"create","idol":{"name":"X","group_name":"NTC","position":"Leader"}}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, h := newBatchServer(t)
+			createIdol(t, h, `{"name":"Karina","group_name":"AESPA","position":"Leader"}`)
+
+			w := do(t, h, http.MethodPost, "/api/idols/batch", `{"operations":[
+				{"op":"create","idol":{"name":"Winter","group_name":"AESPA","position":"Main Vocalist"}},
+				`+tt.second+`,
+				{"op":"delete","id":1}
+			]}`)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+			got := decodeBody[batchAnswer](t, w)
+			if got.Committed || got.Failed == nil || *got.Failed != 1 {
+				t.Fatalf("answer = %+v", got)
+			}
+			if r := got.Results[0]; r.Status != http.StatusCreated || r.ID != 0 {
+				t.Errorf("rolled back result = %+v, want no id", r)
+			}
+			if r := got.Results[1]; r.Status != tt.status || r.Error == "" {
+				t.Errorf("failed result = %+v", r)
+			}
+			if r := got.Results[2]; r.Status != 0 {
+				t.Errorf("result after the failure ran: %+v", r)
+			}
+			page := decodeBody[store.Page](t, do(t, h, http.MethodGet, "/api/idols", ""))
+			if len(page.Items) != 1 || page.Items[0].Name != "Karina" || page.Items[0].Version != 1 {
+				t.Errorf("idols after rollback = %+v", page.Items)
+			}
+			if revs, _ := s.History(t.Context(), 1); len(revs) != 1 {
+				t.Errorf("history has %d revisions, want 1", len(revs))
+			}
+		})
+	}
+}
+
+func TestIdolBatchValidatesFirst(t *testing.T) {
+	_, h := newBatchServer(t)
+	w := do(t, h, http.MethodPost, "/api/idols/batch", `{"operations":[
+		{"op":"create","idol":{"name":"Winter","group_name":"AESPA","position":"Main Vocalist"}},
+		{"op":"frob"},
+		{"op":"delete"}
+	]}`)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status %d, body %s", w.Code, w.Body)
+	}
+	got := decodeBody[batchAnswer](t, w)
+	if got.Results[0].Status != 0 || got.Results[1].Status != http.StatusUnprocessableEntity || got.Results[2].Status != http.StatusUnprocessableEntity {
+		t.Errorf("results = %+v", got.Results)
+	}
+	page := decodeBody[store.Page](t, do(t, h, http.MethodGet, "/api/idols", ""))
+	if len(page.Items) != 0 {
+		t.Errorf("idols = %+v, want none", page.Items)
+	}
+}
@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"kpopapi/internal/models"
+	"kpopapi/internal/store"
+	"kpopapi/pkg/validation"
+)
+
+type albumInput struct {
+	GroupID     int64        `json:"group_id"`
+	Title       string       `json:"title"`
+	Kind        string       `json:"kind"`
+	ReleaseDate *models.Date `json:"release_date"`
+}
+
+// validate cleans the text fields in place and checks the payload.
+func (in *albumInput) validate() error {
+	validation.CleanAll(&in.Title, &in.Kind)
+	var v validation.Validator
+	v.Check(in.GroupID != 0, "group_id", validation.CodeRequired, "group_id is required")
+	v.Check(in.GroupID >= 0, "group_id", validation.CodeInvalid, "group_id must be positive")
+	if v.Required("title", in.Title) {
+		v.MaxLen("title", in.Title, maxFieldLen)
+	}
+	v.OneOf("kind", in.Kind, models.AlbumFull, models.AlbumEP, models.AlbumSingle)
+	return v.Err()
+}
+
+func (in albumInput) album() models.Album {
+	return models.Album{GroupID: in.GroupID, Title: in.Title, Kind: in.Kind, ReleaseDate: in.ReleaseDate}
+}
+
+func decodeAlbum(w http.ResponseWriter, r *http.Request) (albumInput, bool) {
+	var in albumInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+		return in, false
+	}
+	if err := in.validate(); err != nil {
+		writeInvalid(w, err)
+		return in, false
+	}
+	return in, true
+}
+
+type creditInput struct {
+	IdolID int64  `json:"idol_id"`
+	Role   string `json:"role"`
+}
+
+// trackInput is a track with its complete list of credits; writing a track
+// replaces the credits it had.
+type trackInput struct {
+	Number   int           `json:"number"`
+	Title    string        `json:"title"`
+	Duration *int          `json:"duration_sec"`
+	Credits  []creditInput `json:"credits"`
+}
+
+// validate cleans the text fields in place and checks the payload.
+func (in *trackInput) validate() error {
+	in.Title = validation.Clean(in.Title)
+	var v validation.Validator
+	v.Check(in.Number > 0, "number", validation.CodeInvalid, "number must be positive")
+	if v.Required("title", in.Title) {
+		v.MaxLen("title", in.Title, maxFieldLen)
+	}
+	v.Check(in.Duration == nil || *in.Duration > 0, "duration_sec", validation.CodeInvalid, "duration_sec must be positive")
+	seen := map[creditInput]bool{}
+	for i := range in.Credits {
+		c := &in.Credits[i]
+		c.Role = validation.Clean(c.Role)
+		field := fmt.Sprintf("credits[%d]", i)
+		v.Check(c.IdolID > 0, field+".idol_id", validation.CodeInvalid, field+".idol_id must be positive")
+		if v.Required(field+".role", c.Role) {
+			v.OneOf(field+".role", c.Role, models.CreditVocals, models.CreditRap, models.CreditLyrics, models.CreditComposition)
+		}
+		if seen[*c] {
+			v.Add(field, validation.CodeInvalid, field+" repeats an earlier credit")
+		}
+		seen[*c] = true
+	}
+	return v.Err()
+}
+
+func (in trackInput) track(albumID int64) models.Track {
+	t := models.Track{AlbumID: albumID, Number: in.Number, Title: in.Title, Duration: in.Duration}
+	for _, c := range in.Credits {
+		t.Credits = append(t.Credits, models.Credit{IdolID: c.IdolID, Role: c.Role})
+	}
+	return t
+}
+
+func decodeTrack(w http.ResponseWriter, r *http.Request) (trackInput, bool) {
+	var in trackInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+		return in, false
+	}
+	if err := in.validate(); err != nil {
+		writeInvalid(w, err)
+		return in, false
+	}
+	return in, true
+}
+
+// HandleAlbums serves GET and POST /api/albums. GET takes an optional
+// ?group_id= filter.
+func HandleAlbums(albums store.DiscographyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			var groupID int64
+			if raw := r.URL.Query().Get("group_id"); raw != "" {
+				id, err := parseID(raw)
+				if err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid group_id"})
+					return
+				}
+				groupID = id
+			}
+			list, err := albums.ListAlbums(r.Context(), groupID)
+			if err != nil {
+				writeStoreError(w, err, "db error")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"items": list})
+		case http.MethodPost:
+			in, ok := decodeAlbum(w, r)
+			if !ok {
+				return
+			}
+			a, err := albums.CreateAlbum(r.Context(), in.album())
+			if err != nil {
+				writeStoreError(w, err, "insert error")
+				return
+			}
+			writeJSON(w, http.StatusCreated, a)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// HandleAlbumByID serves GET, PUT and DELETE /api/albums/{id}. Deleting an
+// album deletes its tracks and credits.
+func HandleAlbumByID(albums store.DiscographyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseID(r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			a, err := albums.GetAlbum(r.Context(), id)
+			if err != nil {
+				writeStoreError(w, err, "db error")
+				return
+			}
+			writeJSON(w, http.StatusOK, a)
+		case http.MethodPut:
+			in, ok := decodeAlbum(w, r)
+			if !ok {
+				return
+			}
+			a := in.album()
+			a.ID = id
+			updated, err := albums.UpdateAlbum(r.Context(), a)
+			if err != nil {
+				writeStoreError(w, err, "update error")
+				return
+			}
+			writeJSON(w, http.StatusOK, updated)
+		case http.MethodDelete:
+			if err := albums.DeleteAlbum(r.Context(), id); err != nil {
+				writeStoreError(w, err, "delete error")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// HandleAlbumTracks serves GET and POST /api/albums/{id}/tracks.
+func HandleAlbumTracks(albums store.DiscographyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		albumID, err := parseID(r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			list, err := albums.AlbumTracks(r.Context(), albumID)
+			if err != nil {
+				writeStoreError(w, err, "db error")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"items": list})
+		case http.MethodPost:
+			in, ok := decodeTrack(w, r)
+			if !ok {
+				return
+			}
+			t, err := albums.AddTrack(r.Context(), in.track(albumID))
+			if err != nil {
+				writeStoreError(w, err, "insert error")
+				return
+			}
+			writeJSON(w, http.StatusCreated, t)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// HandleAlbumTrack serves PUT and DELETE /api/albums/{id}/tracks/{track_id}.
+func HandleAlbumTrack(albums store.DiscographyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		albumID, err := parseID(r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		id, err := parseID(r.PathValue("track_id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			in, ok := decodeTrack(w, r)
+			if !ok {
+				return
+			}
+			t := in.track(albumID)
+			t.ID = id
+			updated, err := albums.UpdateTrack(r.Context(), t)
+			if err != nil {
+				writeStoreError(w, err, "update error")
+				return
+			}
+			writeJSON(w, http.StatusOK, updated)
+		case http.MethodDelete:
+			if err := albums.RemoveTrack(r.Context(), albumID, id); err != nil {
+				writeStoreError(w, err, "delete error")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// HandleIdolCredits serves GET /api/idols/{id}/credits, the tracks an idol
+// is credited on.
+func HandleIdolCredits(albums store.DiscographyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		id, err := parseID(r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		list, err := albums.IdolCredits(r.Context(), id)
+		if err != nil {
+			writeStoreError(w, err, "db error")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"items": list})
+	}
+}
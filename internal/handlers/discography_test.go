@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"strings"
+	"testing"
+
+	"kpopapi/internal/models"
+	"kpopapi/pkg/validation"
+)
+
+// newDiscographyServer adds the album, track and credit endpoints to
+// newIdolServer and creates Karina (1) and Winter (2) of AESPA and the
+// AESPA EP Savage (1).
+func newDiscographyServer(t *testing.T) http.Handler {
+	t.Helper()
+	s, h := newIdolServer(t)
+	mux := http.NewServeMux()
+	mux.Handle("/api/albums", withAdmin(HandleAlbums(s)))
+	mux.Handle("/api/albums/{id}", withAdmin(HandleAlbumByID(s)))
+	mux.Handle("/api/albums/{id}/tracks", withAdmin(HandleAlbumTracks(s)))
+	mux.Handle("/api/albums/{id}/tracks/{track_id}", withAdmin(HandleAlbumTrack(s)))
+	mux.Handle("/api/idols/{id}/credits", withAdmin(HandleIdolCredits(s)))
+	mux.Handle("/", h)
+	createIdol(t, mux, `{"name":"Karina","group_name":"AESPA","position":"Leader"}`)
+	createIdol(t, mux, `{"name":"Winter","group_name":"AESPA","position":"Main Vocalist"}`)
+	if w := do(t, mux, http.MethodPost, "/api/albums", `{"group_id":1,"title":"Savage","kind":"ep","release_date":"2021-10-05"}`); w.Code != http.StatusCreated {
+		t.Fatalf("album: status %d, body %s", w.Code, w.Body)
+	}
+	return mux
+}
+
+// credits renders a track's credits as "name:role" in the order listed.
+func credits(tr models.Track) string {
+	var list []string
+	for _, c := range tr.Credits {
+		list = append(list, c.IdolName+":"+c.Role)
+	}
+	return strings.Join(list, ",")
+}
+
+func TestAlbumCRUD(t *testing.T) {
+	h := newDiscographyServer(t)
+	w := do(t, h, http.MethodPost, "/api/albums", `{"group_id":2,"title":" Sticker "}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST: status %d, body %s", w.Code, w.Body)
+	}
+	if got := decodeBody[models.Album](t, w); got.ID != 2 || got.Title != "Sticker" || got.Kind != models.AlbumFull || got.GroupName != "NCT" {
+		t.Errorf("created = %+v", got)
+	}
+	do(t, h, http.MethodPost, "/api/albums", `{"group_id":1,"title":"Black Mamba","kind":"single","release_date":"2020-11-17"}`)
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		// Dated albums come first, oldest first.
+		{"", "Black Mamba,Savage,Sticker"},
+		{"?group_id=1", "Black Mamba,Savage"},
+		{"?group_id=2", "Sticker"},
+		{"?group_id=9", ""},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, a := range decodeBody[struct{ Items []models.Album }](t, do(t, h, http.MethodGet, "/api/albums"+tt.query, "")).Items {
+			got = append(got, a.Title)
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("list%s = %v, want %s", tt.query, got, tt.want)
+		}
+	}
+
+	w = do(t, h, http.MethodPut, "/api/albums/1", `{"group_id":1,"title":"Savage","kind":"album"}`)
+	if got := decodeBody[models.Album](t, w); w.Code != http.StatusOK || got.Kind != models.AlbumFull || got.ReleaseDate != nil {
+		t.Errorf("PUT: status %d, album %+v", w.Code, got)
+	}
+
+	// Deleting an album takes its tracks along.
+	do(t, h, http.MethodPost, "/api/albums/1/tracks", `{"number":1,"title":"Aenergy","credits":[{"idol_id":1,"role":"vocals"}]}`)
+	if w := do(t, h, http.MethodDelete, "/api/albums/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("DELETE: status %d, body %s", w.Code, w.Body)
+	}
+	if w := do(t, h, http.MethodGet, "/api/albums/1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET deleted: status %d, want 404", w.Code)
+	}
+	if w := do(t, h, http.MethodGet, "/api/albums/1/tracks", ""); w.Code != http.StatusNotFound {
+		t.Errorf("tracks of a deleted album: status %d, want 404", w.Code)
+	}
+	if list := decodeBody[struct{ Items []models.IdolCredit }](t, do(t, h, http.MethodGet, "/api/idols/1/credits", "")).Items; len(list) != 0 {
+		t.Errorf("credits of a deleted album: %+v", list)
+	}
+}
+
+// TestTrackCredits writes tracks with credits and reads them back from
+// the album and from the idol.
+func TestTrackCredits(t *testing.T) {
+	h := newDiscographyServer(t)
+	do(t, h, http.MethodPost, "/api/albums", `{"group_id":1,"title":"Black Mamba","kind":"single","release_date":"2020-11-17"}`)
+	for _, step := range []struct{ target, body string }{
+		{"/api/albums/1/tracks", `{"number":2,"title":"Savage","duration_sec":239,"credits":[{"idol_id":2,"role":"vocals"},{"idol_id":1,"role":"rap"},{"idol_id":1,"role":"vocals"}]}`},
+		{"/api/albums/1/tracks", `{"number":1,"title":"Aenergy","credits":[]}`},
+		{"/api/albums/2/tracks", `{"number":1,"title":"Black Mamba","credits":[{"idol_id":1,"role":"lyrics"}]}`},
+	} {
+		if w := do(t, h, http.MethodPost, step.target, step.body); w.Code != http.StatusCreated {
+			t.Fatalf("POST %s: status %d, body %s", step.body, w.Code, w.Body)
+		}
+	}
+
+	tracks := decodeBody[struct{ Items []models.Track }](t, do(t, h, http.MethodGet, "/api/albums/1/tracks", "")).Items
+	if len(tracks) != 2 || tracks[0].Title != "Aenergy" || tracks[1].Title != "Savage" {
+		t.Fatalf("tracks = %+v", tracks)
+	}
+	if got := credits(tracks[1]); got != "Karina:rap,Karina:vocals,Winter:vocals" {
+		t.Errorf("Savage credits = %s", got)
+	}
+	if tracks[1].Duration == nil || *tracks[1].Duration != 239 || len(tracks[0].Credits) != 0 {
+		t.Errorf("tracks = %+v", tracks)
+	}
+
+	list := decodeBody[struct{ Items []models.IdolCredit }](t, do(t, h, http.MethodGet, "/api/idols/1/credits", "")).Items
+	var got []string
+	for _, c := range list {
+		got = append(got, fmt.Sprintf("%s/%d %s:%s", c.AlbumTitle, c.TrackNumber, c.TrackTitle, c.Role))
+		if c.GroupName != "AESPA" || c.ReleaseDate == nil {
+			t.Errorf("credit = %+v", c)
+		}
+	}
+	// The older album comes first.
+	if want := "Black Mamba/1 Black Mamba:lyrics,Savage/2 Savage:rap,Savage/2 Savage:vocals"; strings.Join(got, ",") != want {
+		t.Errorf("Karina's credits = %v, want %s", got, want)
+	}
+
+	// A write replaces the whole list of credits.
+	w := do(t, h, http.MethodPut, "/api/albums/1/tracks/1", `{"number":2,"title":"Savage","credits":[{"idol_id":2,"role":"composition"}]}`)
+	if tr := decodeBody[models.Track](t, w); w.Code != http.StatusOK || credits(tr) != "Winter:composition" || tr.Duration != nil {
+		t.Errorf("PUT: status %d, track %+v", w.Code, tr)
+	}
+	if list := decodeBody[struct{ Items []models.IdolCredit }](t, do(t, h, http.MethodGet, "/api/idols/1/credits", "")).Items; len(list) != 1 {
+		t.Errorf("Karina kept replaced credits: %+v", list)
+	}
+
+	if w := do(t, h, http.MethodDelete, "/api/albums/1/tracks/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("DELETE track: status %d", w.Code)
+	}
+	if list := decodeBody[struct{ Items []models.IdolCredit }](t, do(t, h, http.MethodGet, "/api/idols/2/credits", "")).Items; len(list) != 0 {
+		t.Errorf("credits of a deleted track: %+v", list)
+	}
+}
+
+// TestTrackCreditsOfTrashedIdols checks that the trash hides an idol's
+// credits without losing them, and that a purge drops them.
+func TestTrackCreditsOfTrashedIdols(t *testing.T) {
+	h := newDiscographyServer(t)
+	do(t, h, http.MethodPost, "/api/albums/1/tracks", `{"number":1,"title":"Savage","credits":[{"idol_id":1,"role":"rap"},{"idol_id":2,"role":"vocals"}]}`)
+	track := func() string {
+		return credits(decodeBody[struct{ Items []models.Track }](t, do(t, h, http.MethodGet, "/api/albums/1/tracks", "")).Items[0])
+	}
+
+	do(t, h, http.MethodDelete, "/api/idols/2", "")
+	if got := track(); got != "Karina:rap" {
+		t.Errorf("with Winter trashed = %s", got)
+	}
+	if w := do(t, h, http.MethodGet, "/api/idols/2/credits", ""); w.Code != http.StatusNotFound {
+		t.Errorf("credits of a trashed idol: status %d, want 404", w.Code)
+	}
+	// A write that cannot see Winter's credit keeps it.
+	do(t, h, http.MethodPut, "/api/albums/1/tracks/1", `{"number":1,"title":"Savage","credits":[{"idol_id":1,"role":"vocals"}]}`)
+	do(t, h, http.MethodPost, "/api/idols/2/restore", "")
+	if got := track(); got != "Karina:vocals,Winter:vocals" {
+		t.Errorf("with Winter restored = %s", got)
+	}
+
+	do(t, h, http.MethodDelete, "/api/idols/2?hard=true", "")
+	if got := track(); got != "Karina:vocals" {
+		t.Errorf("with Winter purged = %s", got)
+	}
+}
+
+func TestDiscographyValidation(t *testing.T) {
+	h := newDiscographyServer(t)
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		want   map[string]string
+	}{
+		{"album without group", "/api/albums", `{"title":"Drama"}`, map[string]string{"group_id": validation.CodeRequired}},
+		{"album negative group", "/api/albums", `{"group_id":-1,"title":"Drama"}`, map[string]string{"group_id": validation.CodeInvalid}},
+		{"album without title", "/api/albums", `{"group_id":1,"title":" "}`, map[string]string{"title": validation.CodeRequired}},
+		{"album title too long", "/api/albums", `{"group_id":1,"title":"` + strings.Repeat("x", 101) + `"}`, map[string]string{"title": validation.CodeTooLong}},
+		{"album kind", "/api/albums", `{"group_id":1,"title":"Drama","kind":"mixtape"}`, map[string]string{"kind": validation.CodeOneOf}},
+		{"track number", "/api/albums/1/tracks", `{"number":0,"title":"Drama"}`, map[string]string{"number": validation.CodeInvalid}},
+		{"track without title", "/api/albums/1/tracks", `{"number":1}`, map[string]string{"title": validation.CodeRequired}},
+		{"track duration", "/api/albums/1/tracks", `{"number":1,"title":"Drama","duration_sec":0}`, map[string]string{"duration_sec": validation.CodeInvalid}},
+		{"credit idol", "/api/albums/1/tracks", `{"number":1,"title":"Drama","credits":[{"idol_id":0,"role":"rap"}]}`,
+			map[string]string{"credits[0].idol_id": validation.CodeInvalid}},
+		{"credit without role", "/api/albums/1/tracks", `{"number":1,"title":"Drama","credits":[{"idol_id":1}]}`,
+			map[string]string{"credits[0].role": validation.CodeRequired}},
+		{"credit role", "/api/albums/1/tracks", `{"number":1,"title":"Drama","credits":[{"idol_id":1,"role":"dance"}]}`,
+			map[string]string{"credits[0].role": validation.CodeOneOf}},
+		{"repeated credit", "/api/albums/1/tracks", `{"number":1,"title":"Drama","credits":[{"idol_id":1,"role":"rap"},{"idol_id":1,"role":" rap "}]}`,
+			map[string]string{"credits[1]": validation.CodeInvalid}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fieldErrors(t, do(t, h, http.MethodPost, tt.target, tt.body)); !maps.Equal(got, tt.want) {
+				t.Errorf("errors %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiscographyErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{"album of an unknown group", http.MethodPost, "/api/albums", `{"group_id":9,"title":"Drama"}`, http.StatusUnprocessableEntity},
+		{"album moved to an unknown group", http.MethodPut, "/api/albums/1", `{"group_id":9,"title":"Savage"}`, http.StatusUnprocessableEntity},
+		{"album invalid json", http.MethodPost, "/api/albums", `[`, http.StatusBadRequest},
+		{"album list bad group", http.MethodGet, "/api/albums?group_id=aespa", "", http.StatusBadRequest},
+		{"update missing album", http.MethodPut, "/api/albums/9", `{"group_id":1,"title":"Drama"}`, http.StatusNotFound},
+		{"delete missing album", http.MethodDelete, "/api/albums/9", "", http.StatusNotFound},
+		{"bad album id", http.MethodGet, "/api/albums/savage", "", http.StatusBadRequest},
+		{"track on a missing album", http.MethodPost, "/api/albums/9/tracks", `{"number":1,"title":"Drama"}`, http.StatusNotFound},
+		{"track number taken", http.MethodPost, "/api/albums/1/tracks", `{"number":1,"title":"Drama"}`, http.StatusConflict},
+		{"track renumbered onto another", http.MethodPut, "/api/albums/1/tracks/2", `{"number":1,"title":"Drama"}`, http.StatusConflict},
+		{"credit of an unknown idol", http.MethodPost, "/api/albums/1/tracks", `{"number":3,"title":"Drama","credits":[{"idol_id":9,"role":"rap"}]}`, http.StatusUnprocessableEntity},
+		{"credit of a trashed idol", http.MethodPost, "/api/albums/1/tracks", `{"number":3,"title":"Drama","credits":[{"idol_id":3,"role":"rap"}]}`, http.StatusUnprocessableEntity},
+		{"track of another album", http.MethodPut, "/api/albums/2/tracks/1", `{"number":1,"title":"Savage"}`, http.StatusNotFound},
+		{"delete track of another album", http.MethodDelete, "/api/albums/2/tracks/1", "", http.StatusNotFound},
+		{"delete missing track", http.MethodDelete, "/api/albums/1/tracks/9", "", http.StatusNotFound},
+		{"bad track id", http.MethodDelete, "/api/albums/1/tracks/first", "", http.StatusBadRequest},
+		{"credits of a missing idol", http.MethodGet, "/api/idols/9/credits", "", http.StatusNotFound},
+		{"credits POST", http.MethodPost, "/api/idols/1/credits", "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newDiscographyServer(t)
+			createIdol(t, h, `{"name":"Giselle","group_name":"AESPA","position":"Main Rapper"}`)
+			do(t, h, http.MethodDelete, "/api/idols/3", "")
+			do(t, h, http.MethodPost, "/api/albums", `{"group_id":1,"title":"Black Mamba","kind":"single"}`)
+			do(t, h, http.MethodPost, "/api/albums/1/tracks", `{"number":1,"title":"Savage"}`)
+			do(t, h, http.MethodPost, "/api/albums/1/tracks", `{"number":2,"title":"Aenergy"}`)
+			if w := do(t, h, tt.method, tt.target, tt.body); w.Code != tt.status {
+				t.Errorf("status %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
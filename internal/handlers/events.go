@@ -0,0 +1,498 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"kpopapi/internal/auth"
+	"kpopapi/internal/models"
+	"kpopapi/internal/store"
+	"kpopapi/pkg/ical"
+	"kpopapi/pkg/validation"
+)
+
+const (
+	// maxDescriptionLen caps event descriptions.
+	maxDescriptionLen = 2000
+	// defaultEventSpan and maxEventSpan bound GET /api/events.
+	defaultEventSpan = 30 * 24 * time.Hour
+	maxEventSpan     = 366 * 24 * time.Hour
+	// calendarHistory is how far back the calendar feed reaches.
+	calendarHistory = 365 * 24 * time.Hour
+	// calendarTZID names models.KST in the calendar feed.
+	calendarTZID = "Asia/Seoul"
+)
+
+// eventTimeLayouts are the accepted times without an offset, read in KST.
+var eventTimeLayouts = []string{"2006-01-02T15:04:05", "2006-01-02T15:04"}
+
+// parseEventTime reads an RFC 3339 time, a time without an offset in KST
+// or, when allDay is set, a date that stands for midnight KST.
+func parseEventTime(raw string, allDay bool) (time.Time, error) {
+	if allDay {
+		t, err := time.ParseInLocation(models.DateLayout, raw, models.KST)
+		if err != nil {
+			return t, errors.New("must be a date (YYYY-MM-DD) for all-day events")
+		}
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t.In(models.KST), nil
+	}
+	for _, layout := range eventTimeLayouts {
+		if t, err := time.ParseInLocation(layout, raw, models.KST); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("must be a time such as 2024-05-27T18:00 (KST) or 2024-05-27T09:00:00Z")
+}
+
+// eventInput is an event as clients write it. Times without an offset are
+// in KST; all-day events take dates and end on an exclusive date.
+type eventInput struct {
+	Kind        string `json:"kind"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Venue       string `json:"venue"`
+	StartsAt    string `json:"starts_at"`
+	EndsAt      string `json:"ends_at"`
+	AllDay      bool   `json:"all_day"`
+	GroupID     *int64 `json:"group_id"`
+	IdolID      *int64 `json:"idol_id"`
+
+	start time.Time
+	end   *time.Time
+}
+
+// validate cleans the text fields in place, checks the payload and parses
+// its times.
+func (in *eventInput) validate() error {
+	validation.CleanAll(&in.Kind, &in.Title, &in.Description, &in.Venue, &in.StartsAt, &in.EndsAt)
+	var v validation.Validator
+	if v.Required("kind", in.Kind) {
+		v.OneOf("kind", in.Kind, models.EventComeback, models.EventConcert, models.EventFanMeeting)
+	}
+	if v.Required("title", in.Title) {
+		v.MaxLen("title", in.Title, maxFieldLen)
+	}
+	v.MaxLen("description", in.Description, maxDescriptionLen)
+	v.MaxLen("venue", in.Venue, maxFieldLen)
+	v.Check(in.GroupID != nil || in.IdolID != nil, "group_id", validation.CodeRequired, "group_id or idol_id is required")
+	v.Check(in.GroupID == nil || *in.GroupID > 0, "group_id", validation.CodeInvalid, "group_id must be positive")
+	v.Check(in.IdolID == nil || *in.IdolID > 0, "idol_id", validation.CodeInvalid, "idol_id must be positive")
+	if v.Required("starts_at", in.StartsAt) {
+		t, err := parseEventTime(in.StartsAt, in.AllDay)
+		if err != nil {
+			v.Add("starts_at", validation.CodeInvalid, "starts_at "+err.Error())
+		}
+		in.start = t
+	}
+	in.end = nil
+	if in.EndsAt != "" {
+		t, err := parseEventTime(in.EndsAt, in.AllDay)
+		switch {
+		case err != nil:
+			v.Add("ends_at", validation.CodeInvalid, "ends_at "+err.Error())
+		case !in.start.IsZero() && !t.After(in.start):
+			v.Add("ends_at", validation.CodeInvalid, "ends_at must be after starts_at")
+		default:
+			in.end = &t
+		}
+	}
+	return v.Err()
+}
+
+func (in eventInput) event() models.Event {
+	return models.Event{
+		Kind: in.Kind, Title: in.Title, Description: in.Description, Venue: in.Venue,
+		StartsAt: in.start, EndsAt: in.end, AllDay: in.AllDay, GroupID: in.GroupID, IdolID: in.IdolID,
+	}
+}
+
+func decodeEvent(w http.ResponseWriter, r *http.Request) (eventInput, bool) {
+	var in eventInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+		return in, false
+	}
+	if err := in.validate(); err != nil {
+		writeInvalid(w, err)
+		return in, false
+	}
+	return in, true
+}
+
+// HandleEvents serves GET and POST /api/events. GET lists the events that
+// overlap [from, to), 30 days from today (KST) by default and at most 366
+// days, with birthdays derived from idol birth dates. It also takes
+// ?group_id= and ?kind=.
+func HandleEvents(events store.EventStore, idols store.IdolStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			f, status, err := eventFilter(r)
+			if err != nil {
+				writeJSON(w, status, map[string]string{"error": err.Error()})
+				return
+			}
+			list, err := events.ListEvents(r.Context(), f)
+			if err != nil {
+				writeStoreError(w, err, "db error")
+				return
+			}
+			if f.Kind == "" || f.Kind == models.EventBirthday {
+				err := idols.Each(r.Context(), idolsOfGroup(f.GroupID), func(it models.Idol) error {
+					list = append(list, birthdays(it, f.From, f.To)...)
+					return nil
+				})
+				if err != nil {
+					writeStoreError(w, err, "db error")
+					return
+				}
+				sort.SliceStable(list, func(i, j int) bool { return list[i].StartsAt.Before(list[j].StartsAt) })
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"items": list, "from": f.From, "to": f.To})
+		case http.MethodPost:
+			in, ok := decodeEvent(w, r)
+			if !ok {
+				return
+			}
+			e, err := events.CreateEvent(r.Context(), in.event())
+			if err != nil {
+				writeStoreError(w, err, "insert error")
+				return
+			}
+			writeJSON(w, http.StatusCreated, e)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// eventFilter reads the query of GET /api/events. On failure it also
+// returns the status to answer.
+func eventFilter(r *http.Request) (store.EventFilter, int, error) {
+	q := r.URL.Query()
+	now := time.Now().In(models.KST)
+	f := store.EventFilter{From: time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, models.KST), Kind: q.Get("kind")}
+	bound := func(name string, t *time.Time) error {
+		raw := q.Get(name)
+		if raw == "" {
+			return nil
+		}
+		parsed, err := parseEventTime(raw, len(raw) == len(models.DateLayout))
+		if err != nil {
+			return fmt.Errorf("%s %v", name, err)
+		}
+		*t = parsed
+		return nil
+	}
+	if err := bound("from", &f.From); err != nil {
+		return f, http.StatusBadRequest, err
+	}
+	f.To = f.From.Add(defaultEventSpan)
+	if err := bound("to", &f.To); err != nil {
+		return f, http.StatusBadRequest, err
+	}
+	switch {
+	case !f.To.After(f.From):
+		return f, http.StatusBadRequest, errors.New("to must be after from")
+	case f.To.Sub(f.From) > maxEventSpan:
+		return f, http.StatusBadRequest, errors.New("from and to must be at most 366 days apart")
+	}
+	if raw := q.Get("group_id"); raw != "" {
+		id, err := parseID(raw)
+		if err != nil {
+			return f, http.StatusBadRequest, errors.New("invalid group_id")
+		}
+		f.GroupID = id
+	}
+	switch f.Kind {
+	case "", models.EventComeback, models.EventConcert, models.EventFanMeeting, models.EventBirthday:
+	default:
+		return f, http.StatusBadRequest, errors.New("kind must be one of comeback, concert, fan_meeting, birthday")
+	}
+	return f, 0, nil
+}
+
+// idolsOfGroup lists the idols of a group, or every idol for 0.
+func idolsOfGroup(groupID int64) store.ListOptions {
+	opts := store.ListOptions{Filters: map[string]string{}}
+	if groupID != 0 {
+		opts.Filters["group_id"] = strconv.FormatInt(groupID, 10)
+	}
+	return opts
+}
+
+// birthdayOn returns the birthday of someone born on birth in year, at
+// midnight KST. Those born on 29 February celebrate on the 28th in common
+// years.
+func birthdayOn(birth models.Date, year int) time.Time {
+	month, day := birth.Month(), birth.Day()
+	if month == time.February && day == 29 && time.Date(year, time.March, 0, 0, 0, 0, 0, time.UTC).Day() != 29 {
+		day = 28
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, models.KST)
+}
+
+// birthdays returns the birthdays of it that overlap [from, to).
+func birthdays(it models.Idol, from, to time.Time) []models.Event {
+	if it.BirthDate == nil {
+		return nil
+	}
+	var list []models.Event
+	for year := max(from.In(models.KST).Year(), it.BirthDate.Year()+1); year <= to.In(models.KST).Year(); year++ {
+		start := birthdayOn(*it.BirthDate, year)
+		end := start.AddDate(0, 0, 1)
+		if !start.Before(to) || !end.After(from) {
+			continue
+		}
+		e := birthdayEvent(it, start)
+		e.EndsAt = &end
+		e.Description = fmt.Sprintf("%s turns %d", it.Name, year-it.BirthDate.Year())
+		list = append(list, e)
+	}
+	return list
+}
+
+func birthdayEvent(it models.Idol, start time.Time) models.Event {
+	e := models.Event{
+		Kind: models.EventBirthday, Title: it.Name + "'s birthday", StartsAt: start, AllDay: true,
+		IdolID: &it.ID, IdolName: it.Name,
+	}
+	if it.GroupID != 0 {
+		e.GroupID, e.GroupName = &it.GroupID, it.Group
+	}
+	return e
+}
+
+// HandleEventByID serves GET, PUT and DELETE /api/events/{id}.
+func HandleEventByID(events store.EventStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseID(r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			e, err := events.GetEvent(r.Context(), id)
+			if err != nil {
+				writeStoreError(w, err, "db error")
+				return
+			}
+			writeJSON(w, http.StatusOK, e)
+		case http.MethodPut:
+			in, ok := decodeEvent(w, r)
+			if !ok {
+				return
+			}
+			e := in.event()
+			e.ID = id
+			updated, err := events.UpdateEvent(r.Context(), e)
+			if err != nil {
+				writeStoreError(w, err, "update error")
+				return
+			}
+			writeJSON(w, http.StatusOK, updated)
+		case http.MethodDelete:
+			if err := events.DeleteEvent(r.Context(), id); err != nil {
+				writeStoreError(w, err, "delete error")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// HandleCalendar serves GET /api/calendar.ics, an iCalendar feed of the
+// events from the past year on and of every idol's birthday as a yearly
+// event. ?group= takes a group id or name. Calendar apps cannot send a
+// bearer token, so without one the feed takes the caller's feed token as
+// ?token= (see HandleMyCalendarToken).
+func HandleCalendar(events store.EventStore, idols store.IdolStore, groups store.GroupStore, tokens store.FeedTokenStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		if _, ok := auth.ClaimsFromContext(r.Context()); !ok {
+			token := r.URL.Query().Get("token")
+			if token == "" {
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+				return
+			}
+			if _, err := tokens.FeedTokenUser(r.Context(), token); err != nil {
+				if errors.Is(err, store.ErrNotFound) {
+					writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+					return
+				}
+				writeStoreError(w, err, "db error")
+				return
+			}
+		}
+		name := "K-pop events"
+		var groupID int64
+		if raw := strings.TrimSpace(r.URL.Query().Get("group")); raw != "" {
+			g, err := findGroup(r, groups, raw)
+			if err != nil {
+				writeStoreError(w, err, "db error")
+				return
+			}
+			groupID, name = g.ID, g.Name+" events"
+		}
+		list, err := events.ListEvents(r.Context(), store.EventFilter{From: time.Now().Add(-calendarHistory), GroupID: groupID})
+		if err != nil {
+			writeStoreError(w, err, "db error")
+			return
+		}
+		var born []models.Idol
+		err = idols.Each(r.Context(), idolsOfGroup(groupID), func(it models.Idol) error {
+			if it.BirthDate != nil {
+				born = append(born, it)
+			}
+			return nil
+		})
+		if err != nil {
+			writeStoreError(w, err, "db error")
+			return
+		}
+
+		w.Header().Set("Content-Type", ical.ContentType)
+		w.Header().Set("Content-Disposition", `inline; filename="calendar.ics"`)
+		cw := ical.NewWriter(w)
+		cw.Begin("VCALENDAR")
+		cw.Prop("VERSION", "2.0")
+		cw.Prop("PRODID", "-//kpopapi//events//EN")
+		cw.Prop("CALSCALE", "GREGORIAN")
+		cw.Prop("METHOD", "PUBLISH")
+		cw.Text("X-WR-CALNAME", name)
+		cw.Prop("X-WR-TIMEZONE", calendarTZID)
+		writeKST(cw)
+		for _, e := range list {
+			writeEvent(cw, e, fmt.Sprintf("event-%d@kpopapi", e.ID), e.UpdatedAt, "")
+		}
+		for _, it := range born {
+			// The first birthday starts the series.
+			e := birthdayEvent(it, birthdayOn(*it.BirthDate, it.BirthDate.Year()+1))
+			rule := "FREQ=YEARLY"
+			if it.BirthDate.Month() == time.February && it.BirthDate.Day() == 29 {
+				// The last day of February, so common years are not skipped.
+				rule = "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=-1"
+			}
+			writeEvent(cw, e, fmt.Sprintf("birthday-%d@kpopapi", it.ID), it.UpdatedAt, rule)
+		}
+		cw.End("VCALENDAR")
+		if err := cw.Flush(); err != nil {
+			log.Printf("calendar: %v", err)
+		}
+	}
+}
+
+// HandleMyCalendarToken serves POST and DELETE /api/me/calendar-token. POST
+// issues a new feed token for the caller, revoking the previous one, and
+// answers with it and the feed URL; the token is not shown again. DELETE
+// revokes it.
+func HandleMyCalendarToken(tokens store.FeedTokenStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(w, r)
+		if !ok {
+			return
+		}
+		switch r.Method {
+		case http.MethodPost:
+			token, err := tokens.IssueFeedToken(r.Context(), user)
+			if err != nil {
+				writeStoreError(w, err, "insert error")
+				return
+			}
+			writeJSON(w, http.StatusCreated, map[string]string{
+				"token": token,
+				"url":   "/api/calendar.ics?token=" + token,
+			})
+		case http.MethodDelete:
+			if err := tokens.RevokeFeedToken(r.Context(), user); err != nil {
+				writeStoreError(w, err, "delete error")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// findGroup resolves ?group= by id or, failing that, by name.
+func findGroup(r *http.Request, groups store.GroupStore, raw string) (models.Group, error) {
+	if id, err := parseID(raw); err == nil {
+		return groups.GetGroup(r.Context(), id)
+	}
+	list, err := groups.ListGroups(r.Context())
+	if err != nil {
+		return models.Group{}, err
+	}
+	for _, g := range list {
+		if strings.EqualFold(g.Name, raw) {
+			return g, nil
+		}
+	}
+	return models.Group{}, store.ErrNotFound
+}
+
+// writeKST defines calendarTZID; KST has had a single offset since 1988.
+func writeKST(cw *ical.Writer) {
+	cw.Begin("VTIMEZONE")
+	cw.Prop("TZID", calendarTZID)
+	cw.Begin("STANDARD")
+	cw.Prop("DTSTART", "19700101T000000")
+	cw.Prop("TZOFFSETFROM", "+0900")
+	cw.Prop("TZOFFSETTO", "+0900")
+	cw.Prop("TZNAME", "KST")
+	cw.End("STANDARD")
+	cw.End("VTIMEZONE")
+}
+
+// writeEvent writes e as a VEVENT. A recurring event, given its RRULE,
+// does not block time.
+func writeEvent(cw *ical.Writer, e models.Event, uid string, modified time.Time, rrule string) {
+	cw.Begin("VEVENT")
+	cw.Text("UID", uid)
+	cw.Prop("DTSTAMP", ical.UTC(modified))
+	cw.Prop("LAST-MODIFIED", ical.UTC(modified))
+	start := e.StartsAt.In(models.KST)
+	if e.AllDay {
+		end := start.AddDate(0, 0, 1)
+		if e.EndsAt != nil {
+			end = e.EndsAt.In(models.KST)
+		}
+		cw.Prop("DTSTART;VALUE=DATE", ical.Date(start))
+		cw.Prop("DTEND;VALUE=DATE", ical.Date(end))
+	} else {
+		cw.Prop("DTSTART;TZID="+calendarTZID, ical.LocalTime(start))
+		if e.EndsAt != nil {
+			cw.Prop("DTEND;TZID="+calendarTZID, ical.LocalTime(e.EndsAt.In(models.KST)))
+		}
+	}
+	cw.Text("SUMMARY", e.Title)
+	if e.Description != "" {
+		cw.Text("DESCRIPTION", e.Description)
+	}
+	if e.Venue != "" {
+		cw.Text("LOCATION", e.Venue)
+	}
+	cw.Text("CATEGORIES", strings.ToUpper(e.Kind))
+	if rrule != "" {
+		cw.Prop("RRULE", rrule)
+		cw.Prop("TRANSP", "TRANSPARENT")
+	}
+	cw.End("VEVENT")
+}
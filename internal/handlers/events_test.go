@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"kpopapi/internal/models"
+	"kpopapi/internal/store"
+)
+
+// newCalendarServer serves the calendar feed from a store.Memory holding
+// Karina, born 2000-04-11, and Jisung, born 2004-02-29.
+func newCalendarServer(t *testing.T) (*store.Memory, http.Handler) {
+	t.Helper()
+	ctx := context.Background()
+	s := store.NewMemory()
+	if _, err := s.CreateGroup(ctx, models.Group{Name: "AESPA"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range []struct{ name, born string }{{"Karina", "2000-04-11"}, {"Jisung", "2004-02-29"}} {
+		born, err := models.ParseDate(in.born)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Create(ctx, models.Idol{Name: in.name, Group: "AESPA", Position: "Leader", BirthDate: &born}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s, HandleCalendar(s, s, s, s)
+}
+
+// vevents unfolds a calendar and returns its events by UID, each as its
+// property lines.
+func vevents(t *testing.T, body string) map[string][]string {
+	t.Helper()
+	body = strings.ReplaceAll(body, "\r\n ", "")
+	events := map[string][]string{}
+	var cur []string
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\r\n"), "\r\n") {
+		switch {
+		case line == "BEGIN:VEVENT":
+			cur = []string{}
+		case line == "END:VEVENT":
+			for _, p := range cur {
+				if uid, ok := strings.CutPrefix(p, "UID:"); ok {
+					events[uid] = cur
+				}
+			}
+			cur = nil
+		case cur != nil:
+			cur = append(cur, line)
+		}
+	}
+	return events
+}
+
+func TestCalendarBirthdayRules(t *testing.T) {
+	_, h := newCalendarServer(t)
+	w := do(t, withAdmin(h), http.MethodGet, "/api/calendar.ics", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body)
+	}
+	events := vevents(t, w.Body.String())
+	tests := []struct {
+		uid   string
+		start string
+		rule  string
+	}{
+		{"birthday-1@kpopapi", "20010411", "FREQ=YEARLY"},
+		// Leap day birthdays fall on the last day of February.
+		{"birthday-2@kpopapi", "20050228", "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=-1"},
+	}
+	for _, tt := range tests {
+		props, ok := events[tt.uid]
+		if !ok {
+			t.Errorf("no event %s in %v", tt.uid, events)
+			continue
+		}
+		for _, want := range []string{"DTSTART;VALUE=DATE:" + tt.start, "RRULE:" + tt.rule, "TRANSP:TRANSPARENT", "CATEGORIES:BIRTHDAY"} {
+			found := false
+			for _, p := range props {
+				found = found || p == want
+			}
+			if !found {
+				t.Errorf("%s lacks %s: %v", tt.uid, want, props)
+			}
+		}
+	}
+}
+
+func TestCalendarFeedToken(t *testing.T) {
+	s, h := newCalendarServer(t)
+	token, err := s.IssueFeedToken(context.Background(), "user2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{"no token", "/api/calendar.ics", http.StatusUnauthorized},
+		{"unknown token", "/api/calendar.ics?token=" + strings.Repeat("0", 64), http.StatusUnauthorized},
+		{"feed token", "/api/calendar.ics?token=" + token, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := do(t, h, http.MethodGet, tt.target, ""); w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+
+	if err := s.RevokeFeedToken(context.Background(), "user2"); err != nil {
+		t.Fatal(err)
+	}
+	if w := do(t, h, http.MethodGet, "/api/calendar.ics?token="+token, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked token: status %d, want 401", w.Code)
+	}
+}
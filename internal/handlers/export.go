@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"kpopapi/internal/models"
+	"kpopapi/internal/store"
+	"kpopapi/pkg/xlsx"
+)
+
+// exportFlushEvery is how many rows are buffered before they are flushed to
+// the client.
+const exportFlushEvery = 500
+
+// exportColumn is one column of the CSV and XLSX exports. The columns
+// without audit match what POST /api/idols/import reads back.
+type exportColumn struct {
+	name  string
+	value func(models.Idol) interface{}
+}
+
+var (
+	exportColumns = []exportColumn{
+		{"id", func(it models.Idol) interface{} { return it.ID }},
+		{"name", func(it models.Idol) interface{} { return it.Name }},
+		{"group_id", func(it models.Idol) interface{} { return it.GroupID }},
+		{"group_name", func(it models.Idol) interface{} { return it.Group }},
+		{"position", func(it models.Idol) interface{} { return it.Position }},
+		{"legal_name", func(it models.Idol) interface{} { return it.LegalName }},
+		{"hangul_name", func(it models.Idol) interface{} { return it.HangulName }},
+		{"birth_date", func(it models.Idol) interface{} { return dateCell(it.BirthDate) }},
+		{"nationality", func(it models.Idol) interface{} { return it.Nationality }},
+		{"debut_date", func(it models.Idol) interface{} { return dateCell(it.DebutDate) }},
+		{"height_cm", func(it models.Idol) interface{} { return intCell(it.HeightCM) }},
+		{"mbti", func(it models.Idol) interface{} { return it.MBTI }},
+		{"status", func(it models.Idol) interface{} { return it.Status }},
+	}
+	exportAuditColumns = []exportColumn{
+		{"version", func(it models.Idol) interface{} { return it.Version }},
+		{"created_at", func(it models.Idol) interface{} { return it.CreatedAt.UTC().Format(time.RFC3339) }},
+		{"created_by", func(it models.Idol) interface{} { return it.CreatedBy }},
+		{"updated_at", func(it models.Idol) interface{} { return it.UpdatedAt.UTC().Format(time.RFC3339) }},
+		{"updated_by", func(it models.Idol) interface{} { return it.UpdatedBy }},
+	}
+)
+
+// dateCell and intCell turn optional values into cells; missing ones are
+// empty.
+func dateCell(d *models.Date) interface{} {
+	if d == nil {
+		return nil
+	}
+	return d.String()
+}
+
+func intCell(n *int) interface{} {
+	if n == nil {
+		return nil
+	}
+	return *n
+}
+
+// exportRecord is an NDJSON line without audit fields.
+type exportRecord struct {
+	ID          int64        `json:"id"`
+	Name        string       `json:"name"`
+	GroupID     int64        `json:"group_id"`
+	Group       string       `json:"group_name"`
+	Position    string       `json:"position"`
+	Positions   []string     `json:"positions"`
+	LegalName   string       `json:"legal_name,omitempty"`
+	HangulName  string       `json:"hangul_name,omitempty"`
+	BirthDate   *models.Date `json:"birth_date,omitempty"`
+	Nationality string       `json:"nationality,omitempty"`
+	DebutDate   *models.Date `json:"debut_date,omitempty"`
+	HeightCM    *int         `json:"height_cm,omitempty"`
+	MBTI        string       `json:"mbti,omitempty"`
+	Status      string       `json:"status"`
+}
+
+// rowWriter is one export format.
+type rowWriter interface {
+	header(cols []exportColumn) error
+	row(cols []exportColumn, it models.Idol) error
+	flush() error
+	close() error
+}
+
+// HandleIdolExport serves GET /api/idols/export?format=csv|ndjson|xlsx. It
+// takes the same filters and sort as GET /api/idols, streams every matching
+// idol straight from the store and adds the audit columns with
+// ?include_audit=true.
+func HandleIdolExport(idols store.IdolStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		opts, err := parseListOptions(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		opts.Limit, opts.After = 0, nil
+		delete(opts.Filters, "include_audit")
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "csv"
+		}
+		var contentType string
+		switch format {
+		case "csv":
+			contentType = "text/csv; charset=utf-8"
+		case "ndjson":
+			contentType = "application/x-ndjson"
+		case "xlsx":
+			contentType = xlsx.ContentType
+		default:
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "format must be csv, ndjson or xlsx"})
+			return
+		}
+		audit := r.URL.Query().Get("include_audit") == "true"
+		cols := exportColumns
+		if audit {
+			cols = append(append([]exportColumn{}, exportColumns...), exportAuditColumns...)
+		}
+
+		// Nothing is written until the first row, or the end of an empty
+		// listing, so a store error can still be answered properly.
+		var out rowWriter
+		start := func() error {
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Content-Disposition", `attachment; filename="idols-`+time.Now().UTC().Format("20060102")+`.`+format+`"`)
+			w.WriteHeader(http.StatusOK)
+			var err error
+			out, err = newRowWriter(format, w, audit)
+			if err != nil {
+				return err
+			}
+			return out.header(cols)
+		}
+		n := 0
+		err = idols.Each(r.Context(), opts, func(it models.Idol) error {
+			if out == nil {
+				if err := start(); err != nil {
+					return err
+				}
+			}
+			if err := out.row(cols, it); err != nil {
+				return err
+			}
+			if n++; n%exportFlushEvery == 0 {
+				return out.flush()
+			}
+			return nil
+		})
+		if err != nil && out == nil {
+			writeStoreError(w, err, "db error")
+			return
+		}
+		if err == nil && out == nil {
+			err = start()
+		}
+		if err == nil {
+			err = out.close()
+		}
+		if err != nil {
+			// The status is already sent; cutting the stream short is all
+			// that is left.
+			log.Printf("idol export: %v", err)
+		}
+	}
+}
+
+func newRowWriter(format string, w http.ResponseWriter, audit bool) (rowWriter, error) {
+	switch format {
+	case "ndjson":
+		return &ndjsonWriter{w: w, enc: json.NewEncoder(w), audit: audit}, nil
+	case "xlsx":
+		xw, err := xlsx.NewWriter(w, "Idols")
+		if err != nil {
+			return nil, err
+		}
+		return &xlsxWriter{w: w, xw: xw}, nil
+	}
+	return &csvWriter{w: w, cw: csv.NewWriter(w)}, nil
+}
+
+func flushHTTP(w io.Writer) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+type csvWriter struct {
+	w  io.Writer
+	cw *csv.Writer
+}
+
+func (c *csvWriter) header(cols []exportColumn) error {
+	names := make([]string, len(cols))
+	for i, col := range cols {
+		names[i] = col.name
+	}
+	return c.cw.Write(names)
+}
+
+func (c *csvWriter) row(cols []exportColumn, it models.Idol) error {
+	rec := make([]string, len(cols))
+	for i, col := range cols {
+		switch v := col.value(it).(type) {
+		case string:
+			rec[i] = v
+		case int64:
+			rec[i] = strconv.FormatInt(v, 10)
+		case int:
+			rec[i] = strconv.Itoa(v)
+		}
+	}
+	return c.cw.Write(rec)
+}
+
+func (c *csvWriter) flush() error {
+	c.cw.Flush()
+	flushHTTP(c.w)
+	return c.cw.Error()
+}
+
+func (c *csvWriter) close() error { return c.flush() }
+
+type ndjsonWriter struct {
+	w     io.Writer
+	enc   *json.Encoder
+	audit bool
+}
+
+func (n *ndjsonWriter) header([]exportColumn) error { return nil }
+
+func (n *ndjsonWriter) row(_ []exportColumn, it models.Idol) error {
+	if n.audit {
+		return n.enc.Encode(it)
+	}
+	return n.enc.Encode(exportRecord{
+		ID: it.ID, Name: it.Name, GroupID: it.GroupID, Group: it.Group, Position: it.Position, Positions: it.Positions,
+		LegalName: it.LegalName, HangulName: it.HangulName, BirthDate: it.BirthDate, Nationality: it.Nationality,
+		DebutDate: it.DebutDate, HeightCM: it.HeightCM, MBTI: it.MBTI, Status: it.Status,
+	})
+}
+
+func (n *ndjsonWriter) flush() error {
+	flushHTTP(n.w)
+	return nil
+}
+
+func (n *ndjsonWriter) close() error { return n.flush() }
+
+type xlsxWriter struct {
+	w  io.Writer
+	xw *xlsx.Writer
+}
+
+func (x *xlsxWriter) header(cols []exportColumn) error {
+	cells := make([]interface{}, len(cols))
+	for i, col := range cols {
+		cells[i] = col.name
+	}
+	return x.xw.WriteRow(cells...)
+}
+
+func (x *xlsxWriter) row(cols []exportColumn, it models.Idol) error {
+	cells := make([]interface{}, len(cols))
+	for i, col := range cols {
+		cells[i] = col.value(it)
+	}
+	return x.xw.WriteRow(cells...)
+}
+
+func (x *xlsxWriter) flush() error {
+	err := x.xw.Flush()
+	flushHTTP(x.w)
+	return err
+}
+
+func (x *xlsxWriter) close() error {
+	err := x.xw.Close()
+	flushHTTP(x.w)
+	return err
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"kpopapi/internal/auth"
+	"kpopapi/internal/store"
+	"kpopapi/pkg/validation"
+)
+
+// favoriteInput is the optional body of PUT /api/me/favorites/{idol_id}.
+type favoriteInput struct {
+	Position int `json:"position"`
+}
+
+func (in *favoriteInput) validate() error {
+	var v validation.Validator
+	v.Check(in.Position >= 0, "position", validation.CodeInvalid, "position must not be negative")
+	return v.Err()
+}
+
+// currentUser returns the username of the token, writing 401 when there is
+// none. Favorites always belong to the token's user, never to a name taken
+// from the request.
+func currentUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	c, ok := auth.ClaimsFromContext(r.Context())
+	if !ok || c.Username == "" {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return "", false
+	}
+	return c.Username, true
+}
+
+// HandleMyFavorites serves GET /api/me/favorites, the caller's favorites in
+// their order.
+func HandleMyFavorites(favorites store.FavoriteStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		user, ok := currentUser(w, r)
+		if !ok {
+			return
+		}
+		list, err := favorites.Favorites(r.Context(), user)
+		if err != nil {
+			writeStoreError(w, err, "db error")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"items": list})
+	}
+}
+
+// HandleMyFavorite serves PUT and DELETE /api/me/favorites/{idol_id}. PUT
+// adds the idol or, with {"position": n}, moves it to the n-th place; it
+// answers 201 when the idol was not a favorite yet.
+func HandleMyFavorite(favorites store.FavoriteStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseID(r.PathValue("idol_id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		user, ok := currentUser(w, r)
+		if !ok {
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			var in favoriteInput
+			if err := json.NewDecoder(r.Body).Decode(&in); err != nil && !errors.Is(err, io.EOF) {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+				return
+			}
+			if err := in.validate(); err != nil {
+				writeInvalid(w, err)
+				return
+			}
+			f, created, err := favorites.SetFavorite(r.Context(), user, id, in.Position)
+			if err != nil {
+				writeStoreError(w, err, "update error")
+				return
+			}
+			status := http.StatusOK
+			if created {
+				status = http.StatusCreated
+			}
+			writeJSON(w, status, f)
+		case http.MethodDelete:
+			if err := favorites.RemoveFavorite(r.Context(), user, id); err != nil {
+				writeStoreError(w, err, "delete error")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
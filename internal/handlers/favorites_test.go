@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"kpopapi/internal/models"
+)
+
+// newFavoriteServer adds the favorites endpoints to newIdolServer, without
+// claims of their own, and creates Karina (1), Winter (2), Giselle (3) and
+// Ningning (4).
+func newFavoriteServer(t *testing.T) http.Handler {
+	t.Helper()
+	s, h := newIdolServer(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/me/favorites", HandleMyFavorites(s))
+	mux.HandleFunc("/api/me/favorites/{idol_id}", HandleMyFavorite(s))
+	mux.Handle("/", h)
+	for _, body := range []string{
+		`{"name":"Karina","group_name":"AESPA","position":"Leader"}`,
+		`{"name":"Winter","group_name":"AESPA","position":"Main Vocalist"}`,
+		`{"name":"Giselle","group_name":"AESPA","position":"Main Rapper"}`,
+		`{"name":"Ningning","group_name":"AESPA","position":"Main Vocalist"}`,
+	} {
+		createIdol(t, mux, body)
+	}
+	return mux
+}
+
+// favorites lists the shown favorites of h's user as "position:name".
+func favorites(t *testing.T, h http.Handler) string {
+	t.Helper()
+	w := do(t, h, http.MethodGet, "/api/me/favorites", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET favorites: status %d, body %s", w.Code, w.Body)
+	}
+	var list []string
+	for _, f := range decodeBody[struct{ Items []models.Favorite }](t, w).Items {
+		list = append(list, fmt.Sprintf("%d:%s", f.Position, f.Idol.Name))
+	}
+	return strings.Join(list, ",")
+}
+
+// setFavorites adds the idols to h's user's favorites in order.
+func setFavorites(t *testing.T, h http.Handler, ids ...int) {
+	t.Helper()
+	for _, id := range ids {
+		if w := do(t, h, http.MethodPut, fmt.Sprintf("/api/me/favorites/%d", id), ""); w.Code != http.StatusCreated {
+			t.Fatalf("add %d: status %d, body %s", id, w.Code, w.Body)
+		}
+	}
+}
+
+func TestFavoritesOrder(t *testing.T) {
+	h := asUser(newFavoriteServer(t), "my")
+	setFavorites(t, h, 1, 2, 3)
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		status int
+		at     int // position of the answered favorite
+		want   string
+	}{
+		{"add last", "/api/me/favorites/4", "", http.StatusCreated, 4, "1:Karina,2:Winter,3:Giselle,4:Ningning"},
+		{"again without a position", "/api/me/favorites/2", "", http.StatusOK, 2, "1:Karina,2:Winter,3:Giselle,4:Ningning"},
+		{"position 0 stays", "/api/me/favorites/2", `{"position":0}`, http.StatusOK, 2, "1:Karina,2:Winter,3:Giselle,4:Ningning"},
+		{"move up", "/api/me/favorites/4", `{"position":1}`, http.StatusOK, 1, "1:Ningning,2:Karina,3:Winter,4:Giselle"},
+		{"move down", "/api/me/favorites/4", `{"position":3}`, http.StatusOK, 3, "1:Karina,2:Winter,3:Ningning,4:Giselle"},
+		{"past the end", "/api/me/favorites/1", `{"position":99}`, http.StatusOK, 4, "1:Winter,2:Ningning,3:Giselle,4:Karina"},
+		{"same place", "/api/me/favorites/3", `{"position":3}`, http.StatusOK, 3, "1:Winter,2:Ningning,3:Giselle,4:Karina"},
+	}
+	for _, tt := range tests {
+		w := do(t, h, http.MethodPut, tt.target, tt.body)
+		if w.Code != tt.status {
+			t.Fatalf("%s: status %d, want %d (body %s)", tt.name, w.Code, tt.status, w.Body)
+		}
+		if f := decodeBody[models.Favorite](t, w); f.Position != tt.at {
+			t.Errorf("%s: answered position %d, want %d", tt.name, f.Position, tt.at)
+		}
+		if got := favorites(t, h); got != tt.want {
+			t.Errorf("%s: favorites %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	if w := do(t, h, http.MethodDelete, "/api/me/favorites/2", ""); w.Code != http.StatusOK {
+		t.Fatalf("DELETE: status %d", w.Code)
+	}
+	if got := favorites(t, h); got != "1:Ningning,2:Giselle,3:Karina" {
+		t.Errorf("after DELETE: %s", got)
+	}
+}
+
+// TestFavoritesReorderWithTrashed reorders a list that holds a trashed idol:
+// positions count only the shown idols, and the trashed one keeps its place
+// for when it is restored.
+func TestFavoritesReorderWithTrashed(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		body     string
+		at       int
+		shown    string
+		restored string
+	}{
+		{"move down past it", "/api/me/favorites/1", `{"position":2}`, 2,
+			"1:Giselle,2:Karina,3:Ningning", "1:Winter,2:Giselle,3:Karina,4:Ningning"},
+		{"move up past it", "/api/me/favorites/4", `{"position":2}`, 2,
+			"1:Karina,2:Ningning,3:Giselle", "1:Karina,2:Winter,3:Ningning,4:Giselle"},
+		{"move to the top", "/api/me/favorites/3", `{"position":1}`, 1,
+			"1:Giselle,2:Karina,3:Ningning", "1:Giselle,2:Karina,3:Winter,4:Ningning"},
+		{"past the end", "/api/me/favorites/1", `{"position":99}`, 3,
+			"1:Giselle,2:Ningning,3:Karina", "1:Winter,2:Giselle,3:Ningning,4:Karina"},
+		{"without a position", "/api/me/favorites/3", "", 2,
+			"1:Karina,2:Giselle,3:Ningning", "1:Karina,2:Winter,3:Giselle,4:Ningning"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFavoriteServer(t)
+			h := asUser(srv, "my")
+			setFavorites(t, h, 1, 2, 3, 4)
+			if w := do(t, srv, http.MethodDelete, "/api/idols/2", ""); w.Code != http.StatusOK {
+				t.Fatalf("trash: status %d", w.Code)
+			}
+			if got := favorites(t, h); got != "1:Karina,2:Giselle,3:Ningning" {
+				t.Fatalf("with Winter trashed: %s", got)
+			}
+
+			w := do(t, h, http.MethodPut, tt.target, tt.body)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d, body %s", w.Code, w.Body)
+			}
+			if f := decodeBody[models.Favorite](t, w); f.Position != tt.at {
+				t.Errorf("answered position %d, want %d", f.Position, tt.at)
+			}
+			if got := favorites(t, h); got != tt.shown {
+				t.Errorf("shown %s, want %s", got, tt.shown)
+			}
+			if w := do(t, srv, http.MethodPost, "/api/idols/2/restore", ""); w.Code != http.StatusOK {
+				t.Fatalf("restore: status %d", w.Code)
+			}
+			if got := favorites(t, h); got != tt.restored {
+				t.Errorf("restored %s, want %s", got, tt.restored)
+			}
+		})
+	}
+}
+
+// TestFavoritesPerUser checks that lists belong to the token's user, that
+// favorite_count follows them and that a purge drops the idol from every
+// list.
+func TestFavoritesPerUser(t *testing.T) {
+	srv := newFavoriteServer(t)
+	mine, theirs := asUser(srv, "my"), asUser(srv, "their")
+	setFavorites(t, mine, 1, 2)
+	setFavorites(t, theirs, 2)
+
+	count := func(id string) int {
+		return decodeBody[models.Idol](t, do(t, srv, http.MethodGet, "/api/idols/"+id, "")).FavoriteCount
+	}
+	if c1, c2, c3 := count("1"), count("2"), count("3"); c1 != 1 || c2 != 2 || c3 != 0 {
+		t.Errorf("favorite_count = %d, %d, %d, want 1, 2, 0", c1, c2, c3)
+	}
+	if got := favorites(t, theirs); got != "1:Winter" {
+		t.Errorf("their favorites = %s", got)
+	}
+
+	if w := do(t, theirs, http.MethodDelete, "/api/me/favorites/1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE another user's favorite: status %d, want 404", w.Code)
+	}
+	if w := do(t, mine, http.MethodDelete, "/api/me/favorites/2", ""); w.Code != http.StatusOK {
+		t.Fatalf("DELETE: status %d", w.Code)
+	}
+	if c := count("2"); c != 1 {
+		t.Errorf("favorite_count after DELETE = %d, want 1", c)
+	}
+	if w := do(t, mine, http.MethodDelete, "/api/me/favorites/2", ""); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE twice: status %d, want 404", w.Code)
+	}
+
+	if w := do(t, srv, http.MethodDelete, "/api/idols/2?hard=true", ""); w.Code != http.StatusOK {
+		t.Fatalf("purge: status %d", w.Code)
+	}
+	if got := favorites(t, theirs); got != "" {
+		t.Errorf("purged idol still a favorite: %s", got)
+	}
+	if w := do(t, theirs, http.MethodPut, "/api/me/favorites/2", ""); w.Code != http.StatusNotFound {
+		t.Errorf("favorite a purged idol: status %d, want 404", w.Code)
+	}
+}
+
+func TestFavoritesErrors(t *testing.T) {
+	srv := newFavoriteServer(t)
+	do(t, srv, http.MethodDelete, "/api/idols/4", "")
+	h := asUser(srv, "my")
+	tests := []struct {
+		name    string
+		handler http.Handler
+		method  string
+		target  string
+		body    string
+		status  int
+	}{
+		{"list without a token", srv, http.MethodGet, "/api/me/favorites", "", http.StatusUnauthorized},
+		{"add without a token", srv, http.MethodPut, "/api/me/favorites/1", "", http.StatusUnauthorized},
+		{"list POST", h, http.MethodPost, "/api/me/favorites", "", http.StatusMethodNotAllowed},
+		{"bad id", h, http.MethodPut, "/api/me/favorites/karina", "", http.StatusBadRequest},
+		{"invalid json", h, http.MethodPut, "/api/me/favorites/1", `{"position":`, http.StatusBadRequest},
+		{"negative position", h, http.MethodPut, "/api/me/favorites/1", `{"position":-1}`, http.StatusUnprocessableEntity},
+		{"missing idol", h, http.MethodPut, "/api/me/favorites/9", "", http.StatusNotFound},
+		{"trashed idol", h, http.MethodPut, "/api/me/favorites/4", "", http.StatusNotFound},
+		{"remove a non-favorite", h, http.MethodDelete, "/api/me/favorites/3", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := do(t, tt.handler, tt.method, tt.target, tt.body); w.Code != tt.status {
+				t.Errorf("status %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+	if got := favorites(t, h); got != "" {
+		t.Errorf("refused writes left favorites: %s", got)
+	}
+}
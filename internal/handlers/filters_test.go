@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"kpopapi/internal/store"
+)
+
+// newFilterServer returns newIdolServer holding four idols that differ in
+// every filterable field.
+func newFilterServer(t *testing.T) http.Handler {
+	t.Helper()
+	_, h := newIdolServer(t)
+	for _, body := range []string{
+		`{"name":"Karina","group_name":"AESPA","positions":["Leader","Main Dancer"],"birth_date":"2000-04-11","nationality":"KR","mbti":"ENTP"}`,
+		`{"name":"Winter","group_name":"AESPA","position":"Main Vocalist","birth_date":"2001-01-01","nationality":"KR","mbti":"ISFJ"}`,
+		`{"name":"Giselle","group_name":"AESPA","position":"Main Rapper","birth_date":"2000-10-30","nationality":"JP","status":"hiatus"}`,
+		`{"name":"Jisung","group_name":"NCT","position":"Main Dancer","birth_date":"2002-02-05","nationality":"KR"}`,
+	} {
+		createIdol(t, h, body)
+	}
+	return h
+}
+
+// names lists the idols of one page in order.
+func names(t *testing.T, h http.Handler, query string) string {
+	t.Helper()
+	w := do(t, h, http.MethodGet, "/api/idols?"+query, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("?%s: status %d, body %s", query, w.Code, w.Body)
+	}
+	var list []string
+	for _, it := range decodeBody[store.Page](t, w).Items {
+		list = append(list, it.Name)
+	}
+	return strings.Join(list, ",")
+}
+
+func TestIdolListFilters(t *testing.T) {
+	h := newFilterServer(t)
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"group_name=AESPA", "Karina,Winter,Giselle"},
+		{"group_name=aespa", "Karina,Winter,Giselle"},
+		{"group_id=2", "Jisung"},
+		{"position=main%20dancer", "Karina,Jisung"},
+		{"name_prefix=Ka", "Karina"},
+		{"name_prefix=%25", ""},
+		{"nationality=jp", "Giselle"},
+		{"status=hiatus", "Giselle"},
+		{"mbti=ENTP", "Karina"},
+		{"born_after=2000-04-11", "Winter,Giselle,Jisung"},
+		{"born_before=2001-01-01", "Karina,Giselle"},
+		{"group_name=AESPA&nationality=KR", "Karina,Winter"},
+		{"group_name=BTS", ""},
+	}
+	for _, tt := range tests {
+		if got := names(t, h, tt.query); got != tt.want {
+			t.Errorf("?%s = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestIdolListSort(t *testing.T) {
+	h := newFilterServer(t)
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "Karina,Winter,Giselle,Jisung"},
+		{"sort=name", "Giselle,Jisung,Karina,Winter"},
+		{"sort=-name", "Winter,Karina,Jisung,Giselle"},
+		// Ties on the key fall back to id order, also when descending.
+		{"sort=group_name", "Karina,Winter,Giselle,Jisung"},
+		{"sort=-group_name", "Jisung,Giselle,Winter,Karina"},
+		{"sort=created_at", "Karina,Winter,Giselle,Jisung"},
+		{"sort=-created_at", "Jisung,Giselle,Winter,Karina"},
+		{"sort=-name&group_name=AESPA", "Winter,Karina,Giselle"},
+		// Pages follow the sort.
+		{"sort=name&limit=2", "Giselle,Jisung"},
+	}
+	for _, tt := range tests {
+		if got := names(t, h, tt.query); got != tt.want {
+			t.Errorf("?%s = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+
+	page := decodeBody[store.Page](t, do(t, h, http.MethodGet, "/api/idols?sort=name&limit=2", ""))
+	if got := names(t, h, "sort=name&limit=2&cursor="+page.NextCursor); got != "Karina,Winter" {
+		t.Errorf("second page by name = %q", got)
+	}
+	// A cursor only continues the sort it was made for.
+	if w := do(t, h, http.MethodGet, "/api/idols?sort=-name&cursor="+page.NextCursor, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("cursor of another sort: status %d, want 400", w.Code)
+	}
+}
+
+// TestIdolListAllowlist checks that parameters outside the allowlists are
+// rejected with the parameter named, rather than ignored or passed on.
+func TestIdolListAllowlist(t *testing.T) {
+	h := newFilterServer(t)
+	tests := []struct {
+		query string
+		param string
+	}{
+		{"sort=height", "sort"},
+		{"sort=-password", "sort"},
+		{"sort=name%3BDROP%20TABLE%20idols", "sort"},
+		{"shoe_size=1", "shoe_size"},
+		{"created_by=admin", "created_by"},
+		{"group_id=one", "group_id"},
+		{"born_after=11-04-2000", "born_after"},
+		{"born_before=yesterday", "born_before"},
+	}
+	for _, tt := range tests {
+		w := do(t, h, http.MethodGet, "/api/idols?"+tt.query, "")
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("?%s: status %d, want 400", tt.query, w.Code)
+			continue
+		}
+		if got := decodeBody[map[string]string](t, w); got["error"] != "invalid query" || got["param"] != tt.param {
+			t.Errorf("?%s: body %v, want param %s", tt.query, got, tt.param)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"kpopapi/internal/models"
+	"kpopapi/internal/store"
+	"kpopapi/pkg/validation"
+)
+
+type groupInput struct {
+	Name       string       `json:"name"`
+	ParentID   *int64       `json:"parent_id"`
+	DebutDate  *models.Date `json:"debut_date"`
+	FandomName string       `json:"fandom_name"`
+	Agency     string       `json:"agency"`
+	Status     string       `json:"status"`
+}
+
+// validate cleans the text fields in place and checks them.
+func (in *groupInput) validate() error {
+	validation.CleanAll(&in.Name, &in.FandomName, &in.Agency, &in.Status)
+	var v validation.Validator
+	v.Required("name", in.Name)
+	for _, f := range []struct{ name, value string }{
+		{"name", in.Name}, {"fandom_name", in.FandomName}, {"agency", in.Agency},
+	} {
+		v.MaxLen(f.name, f.value, maxFieldLen)
+	}
+	v.Check(in.ParentID == nil || *in.ParentID > 0, "parent_id", validation.CodeInvalid, "parent_id must be positive")
+	v.OneOf("status", in.Status, models.GroupActive, models.GroupHiatus, models.GroupDisbanded)
+	return v.Err()
+}
+
+func (in groupInput) group() models.Group {
+	return models.Group{Name: in.Name, ParentID: in.ParentID, DebutDate: in.DebutDate, FandomName: in.FandomName, Agency: in.Agency, Status: in.Status}
+}
+
+// HandleGroups serves GET and POST /api/groups.
+func HandleGroups(groups store.GroupStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			list, err := groups.ListGroups(r.Context())
+			if err != nil {
+				writeStoreError(w, err, "db error")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"items": list})
+		case http.MethodPost:
+			var in groupInput
+			if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+				return
+			}
+			if err := in.validate(); err != nil {
+				writeInvalid(w, err)
+				return
+			}
+			g, err := groups.CreateGroup(r.Context(), in.group())
+			if err != nil {
+				writeStoreError(w, err, "insert error")
+				return
+			}
+			writeJSON(w, http.StatusCreated, g)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// HandleGroupByID serves GET, PUT and DELETE /api/groups/{id}.
+func HandleGroupByID(groups store.GroupStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseID(r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			g, err := groups.GetGroup(r.Context(), id)
+			if err != nil {
+				writeStoreError(w, err, "db error")
+				return
+			}
+			writeJSON(w, http.StatusOK, g)
+		case http.MethodPut:
+			var in groupInput
+			if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+				return
+			}
+			if err := in.validate(); err != nil {
+				writeInvalid(w, err)
+				return
+			}
+			g := in.group()
+			g.ID = id
+			updated, err := groups.UpdateGroup(r.Context(), g, actor(r))
+			if err != nil {
+				writeStoreError(w, err, "update error")
+				return
+			}
+			writeJSON(w, http.StatusOK, updated)
+		case http.MethodDelete:
+			if err := groups.DeleteGroup(r.Context(), id); err != nil {
+				writeStoreError(w, err, "delete error")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+	"testing"
+
+	"kpopapi/internal/models"
+	"kpopapi/internal/store"
+	"kpopapi/pkg/validation"
+)
+
+// newGroupServer adds the group endpoints and idol history to the idol
+// endpoints of newIdolServer, whose groups are AESPA (1) and NCT (2).
+func newGroupServer(t *testing.T) http.Handler {
+	t.Helper()
+	s, h := newIdolServer(t)
+	mux := http.NewServeMux()
+	mux.Handle("/api/groups", withAdmin(HandleGroups(s)))
+	mux.Handle("/api/groups/{id}", withAdmin(HandleGroupByID(s)))
+	mux.Handle("/api/idols/{id}/history", withAdmin(HandleIdolHistory(s)))
+	mux.Handle("/", h)
+	return mux
+}
+
+func TestGroupCRUD(t *testing.T) {
+	h := newGroupServer(t)
+	w := do(t, h, http.MethodPost, "/api/groups", `{"name":" NCT 127 ","parent_id":2,"debut_date":"2016-07-07","agency":"SM"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST: status %d, body %s", w.Code, w.Body)
+	}
+	created := decodeBody[models.Group](t, w)
+	if created.ID != 3 || created.Name != "NCT 127" || created.ParentID == nil || *created.ParentID != 2 || created.Status != models.GroupActive {
+		t.Errorf("created = %+v", created)
+	}
+
+	if got := decodeBody[models.Group](t, do(t, h, http.MethodGet, "/api/groups/3", "")); got.Agency != "SM" || got.DebutDate == nil {
+		t.Errorf("GET = %+v", got)
+	}
+	var names []string
+	for _, g := range decodeBody[struct{ Items []models.Group }](t, do(t, h, http.MethodGet, "/api/groups", "")).Items {
+		names = append(names, g.Name)
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"AESPA", "NCT", "NCT 127"}) {
+		t.Errorf("list = %v", names)
+	}
+
+	w = do(t, h, http.MethodPut, "/api/groups/3", `{"name":"NCT 127","parent_id":2,"status":"hiatus"}`)
+	if got := decodeBody[models.Group](t, w); w.Code != http.StatusOK || got.Status != models.GroupHiatus || got.Agency != "" {
+		t.Errorf("PUT: status %d, group %+v", w.Code, got)
+	}
+
+	if w := do(t, h, http.MethodDelete, "/api/groups/3", ""); w.Code != http.StatusOK {
+		t.Fatalf("DELETE: status %d, body %s", w.Code, w.Body)
+	}
+	if w := do(t, h, http.MethodGet, "/api/groups/3", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET deleted: status %d, want 404", w.Code)
+	}
+}
+
+func TestGroupValidation(t *testing.T) {
+	h := newGroupServer(t)
+	tests := []struct {
+		name string
+		body string
+		want map[string]string
+	}{
+		{"missing name", `{"agency":"SM"}`, map[string]string{"name": validation.CodeRequired}},
+		{"blank name", `{"name":"   "}`, map[string]string{"name": validation.CodeRequired}},
+		{"status", `{"name":"WayV","status":"retired"}`, map[string]string{"status": validation.CodeOneOf}},
+		{"parent id", `{"name":"WayV","parent_id":0}`, map[string]string{"parent_id": validation.CodeInvalid}},
+		{"every field at once", `{"status":"retired","parent_id":-1}`, map[string]string{
+			"name": validation.CodeRequired, "status": validation.CodeOneOf, "parent_id": validation.CodeInvalid,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fieldErrors(t, do(t, h, http.MethodPost, "/api/groups", tt.body)); !maps.Equal(got, tt.want) {
+				t.Errorf("errors %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{"invalid json", http.MethodPost, "/api/groups", `{`, http.StatusBadRequest},
+		{"duplicate name", http.MethodPost, "/api/groups", `{"name":"aespa"}`, http.StatusConflict},
+		{"unknown parent", http.MethodPost, "/api/groups", `{"name":"WayV","parent_id":9}`, http.StatusUnprocessableEntity},
+		{"own parent", http.MethodPut, "/api/groups/2", `{"name":"NCT","parent_id":2}`, http.StatusUnprocessableEntity},
+		{"parent cycle", http.MethodPut, "/api/groups/2", `{"name":"NCT","parent_id":3}`, http.StatusUnprocessableEntity},
+		{"rename onto another", http.MethodPut, "/api/groups/2", `{"name":"AESPA"}`, http.StatusConflict},
+		{"update missing", http.MethodPut, "/api/groups/9", `{"name":"WayV"}`, http.StatusNotFound},
+		{"get missing", http.MethodGet, "/api/groups/9", "", http.StatusNotFound},
+		{"bad id", http.MethodGet, "/api/groups/one", "", http.StatusBadRequest},
+		{"delete with idols", http.MethodDelete, "/api/groups/1", "", http.StatusConflict},
+		{"delete with subunits", http.MethodDelete, "/api/groups/2", "", http.StatusConflict},
+		{"delete missing", http.MethodDelete, "/api/groups/9", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newGroupServer(t)
+			createIdol(t, h, `{"name":"Karina","group_name":"AESPA","position":"Leader"}`)
+			if w := do(t, h, http.MethodPost, "/api/groups", `{"name":"NCT 127","parent_id":2}`); w.Code != http.StatusCreated {
+				t.Fatalf("subunit: status %d", w.Code)
+			}
+			if w := do(t, h, tt.method, tt.target, tt.body); w.Code != tt.status {
+				t.Errorf("status %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+// TestGroupRenameVersionsIdols renames a group and checks that its idols
+// carry the new name with a new version and revision, and that idols can
+// refer to the group by its new name only.
+func TestGroupRenameVersionsIdols(t *testing.T) {
+	h := newGroupServer(t)
+	createIdol(t, h, `{"name":"Karina","group_name":"AESPA","position":"Leader"}`)
+	createIdol(t, h, `{"name":"Jisung","group_name":"NCT","position":"Main Dancer"}`)
+
+	if w := do(t, h, http.MethodPut, "/api/groups/1", `{"name":"æspa"}`); w.Code != http.StatusOK {
+		t.Fatalf("rename: status %d, body %s", w.Code, w.Body)
+	}
+	karina := decodeBody[models.Idol](t, do(t, h, http.MethodGet, "/api/idols/1", ""))
+	if karina.Group != "æspa" || karina.GroupID != 1 || karina.Version != 2 || karina.UpdatedBy != "admin" {
+		t.Errorf("renamed group's idol = %+v", karina)
+	}
+	revs := decodeBody[struct{ Items []models.IdolRevision }](t, do(t, h, http.MethodGet, "/api/idols/1/history", "")).Items
+	if len(revs) != 2 || revs[1].Action != store.RevisionUpdate || revs[1].Snapshot.Group != "æspa" {
+		t.Errorf("history = %+v", revs)
+	}
+	if jisung := decodeBody[models.Idol](t, do(t, h, http.MethodGet, "/api/idols/2", "")); jisung.Version != 1 {
+		t.Errorf("other group's idol was versioned: %+v", jisung)
+	}
+
+	if w := do(t, h, http.MethodPost, "/api/idols", `{"name":"Winter","group_name":"AESPA","position":"Main Vocalist"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("old group name: status %d, want 422", w.Code)
+	}
+	if winter := createIdol(t, h, `{"name":"Winter","group_name":"ÆSPA","position":"Main Vocalist"}`); winter.GroupID != 1 || winter.Group != "æspa" {
+		t.Errorf("new group name = %+v", winter)
+	}
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"kpopapi/internal/models"
+	"kpopapi/internal/store"
+)
+
+// newHistoryServer adds history, diff and revert to newIdolServer and
+// gives Karina three versions: created in AESPA as Leader, moved to NCT,
+// then renamed.
+func newHistoryServer(t *testing.T) http.Handler {
+	t.Helper()
+	s, h := newIdolServer(t)
+	mux := http.NewServeMux()
+	mux.Handle("/api/idols/{id}/history", withAdmin(HandleIdolHistory(s)))
+	mux.Handle("/api/idols/{id}/diff", withAdmin(HandleIdolDiff(s)))
+	mux.Handle("/api/idols/{id}/revert", withAdmin(HandleIdolRevert(s)))
+	mux.Handle("/", h)
+	createIdol(t, mux, `{"name":"Karina","group_name":"AESPA","position":"Leader"}`)
+	for _, body := range []string{
+		`{"name":"Karina","group_name":"NCT","position":"Leader","version":1}`,
+		`{"name":"Yu Jimin","group_name":"NCT","position":"Leader","version":2}`,
+	} {
+		if w := do(t, mux, http.MethodPut, "/api/idols/1", body); w.Code != http.StatusOK {
+			t.Fatalf("PUT %s: status %d, body %s", body, w.Code, w.Body)
+		}
+	}
+	return mux
+}
+
+func TestIdolHistory(t *testing.T) {
+	h := newHistoryServer(t)
+	w := do(t, h, http.MethodGet, "/api/idols/1/history", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body)
+	}
+	revs := decodeBody[struct{ Items []models.IdolRevision }](t, w).Items
+	want := []struct {
+		action, name, group string
+	}{
+		{store.RevisionCreate, "Karina", "AESPA"},
+		{store.RevisionUpdate, "Karina", "NCT"},
+		{store.RevisionUpdate, "Yu Jimin", "NCT"},
+	}
+	if len(revs) != len(want) {
+		t.Fatalf("%d revisions, want %d", len(revs), len(want))
+	}
+	for i, rev := range revs {
+		if rev.Version != i+1 || rev.Action != want[i].action || rev.Actor != "admin" ||
+			rev.Snapshot.Name != want[i].name || rev.Snapshot.Group != want[i].group {
+			t.Errorf("revision %d = %+v", i+1, rev)
+		}
+	}
+}
+
+func TestIdolDiff(t *testing.T) {
+	h := newHistoryServer(t)
+	tests := []struct {
+		from, to string
+		want     []store.FieldChange
+	}{
+		{"1", "2", []store.FieldChange{{Field: "group_id", From: 1.0, To: 2.0}, {Field: "group_name", From: "AESPA", To: "NCT"}}},
+		{"2", "3", []store.FieldChange{{Field: "name", From: "Karina", To: "Yu Jimin"}}},
+		{"3", "1", []store.FieldChange{
+			{Field: "group_id", From: 2.0, To: 1.0}, {Field: "group_name", From: "NCT", To: "AESPA"}, {Field: "name", From: "Yu Jimin", To: "Karina"},
+		}},
+		{"2", "2", []store.FieldChange{}},
+	}
+	for _, tt := range tests {
+		w := do(t, h, http.MethodGet, "/api/idols/1/diff?from="+tt.from+"&to="+tt.to, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s..%s: status %d, body %s", tt.from, tt.to, w.Code, w.Body)
+		}
+		got := decodeBody[struct{ Changes []store.FieldChange }](t, w).Changes
+		if len(got) != len(tt.want) {
+			t.Errorf("%s..%s = %+v, want %+v", tt.from, tt.to, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s..%s change %d = %+v, want %+v", tt.from, tt.to, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestIdolRevert(t *testing.T) {
+	h := newHistoryServer(t)
+	w := do(t, h, http.MethodPost, "/api/idols/1/revert?to=1", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body)
+	}
+	got := decodeBody[models.Idol](t, w)
+	if got.Name != "Karina" || got.Group != "AESPA" || got.Version != 4 || w.Header().Get("ETag") != `"4"` {
+		t.Errorf("reverted = %+v, ETag %s", got, w.Header().Get("ETag"))
+	}
+
+	revs := decodeBody[struct{ Items []models.IdolRevision }](t, do(t, h, http.MethodGet, "/api/idols/1/history", "")).Items
+	if last := revs[len(revs)-1]; len(revs) != 4 || last.Action != store.RevisionRevert || last.Version != 4 || last.Snapshot.Name != "Karina" {
+		t.Errorf("revert revision = %+v", last)
+	}
+	// Reverting writes a new version; the reverted-over ones stay.
+	changes := decodeBody[struct{ Changes []store.FieldChange }](t, do(t, h, http.MethodGet, "/api/idols/1/diff?from=1&to=4", "")).Changes
+	if len(changes) != 0 {
+		t.Errorf("version 4 differs from version 1: %+v", changes)
+	}
+}
+
+func TestIdolRevisionErrors(t *testing.T) {
+	h := newHistoryServer(t)
+	tests := []struct {
+		name   string
+		method string
+		target string
+		status int
+	}{
+		{"history of a missing idol", http.MethodGet, "/api/idols/9/history", http.StatusNotFound},
+		{"history with a bad id", http.MethodGet, "/api/idols/x/history", http.StatusBadRequest},
+		{"history POST", http.MethodPost, "/api/idols/1/history", http.StatusMethodNotAllowed},
+		{"diff without from", http.MethodGet, "/api/idols/1/diff?to=2", http.StatusBadRequest},
+		{"diff with version 0", http.MethodGet, "/api/idols/1/diff?from=0&to=2", http.StatusBadRequest},
+		{"diff with a word", http.MethodGet, "/api/idols/1/diff?from=1&to=last", http.StatusBadRequest},
+		{"diff to a missing version", http.MethodGet, "/api/idols/1/diff?from=1&to=9", http.StatusNotFound},
+		{"diff of a missing idol", http.MethodGet, "/api/idols/9/diff?from=1&to=2", http.StatusNotFound},
+		{"revert without to", http.MethodPost, "/api/idols/1/revert", http.StatusBadRequest},
+		{"revert to a missing version", http.MethodPost, "/api/idols/1/revert?to=9", http.StatusNotFound},
+		{"revert a missing idol", http.MethodPost, "/api/idols/9/revert?to=1", http.StatusNotFound},
+		{"revert GET", http.MethodGet, "/api/idols/1/revert?to=1", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := do(t, h, tt.method, tt.target, ""); w.Code != tt.status {
+				t.Errorf("status %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+// TestIdolRevertTrashed checks that a trashed idol has to be restored
+// before it can be reverted.
+func TestIdolRevertTrashed(t *testing.T) {
+	h := newHistoryServer(t)
+	if w := do(t, h, http.MethodDelete, "/api/idols/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("DELETE: status %d", w.Code)
+	}
+	if w := do(t, h, http.MethodPost, "/api/idols/1/revert?to=1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("revert trashed: status %d, want 404", w.Code)
+	}
+	// The history stays readable in the trash.
+	revs := decodeBody[struct{ Items []models.IdolRevision }](t, do(t, h, http.MethodGet, "/api/idols/1/history", "")).Items
+	if len(revs) != 4 || revs[3].Action != store.RevisionDelete {
+		t.Errorf("history of a trashed idol = %+v", revs)
+	}
+}
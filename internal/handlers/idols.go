@@ -0,0 +1,712 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/language"
+
+	"kpopapi/internal/auth"
+	"kpopapi/internal/models"
+	"kpopapi/internal/storage"
+	"kpopapi/internal/store"
+	"kpopapi/pkg/jsonpatch"
+	"kpopapi/pkg/validation"
+)
+
+// idolInput is the writable part of an idol. A group is named either by
+// group_id or by group_name; group_id wins when both are set, and either must
+// name an existing group. Positions are catalogue names or aliases in
+// priority order; without them the position string is split on , / & ;.
+// A PUT keeps the stored profile fields its body leaves out, so clients
+// that predate them do not erase them; null or "" clears one.
+type idolInput struct {
+	Name        string       `json:"name"`
+	GroupID     int64        `json:"group_id,omitempty"`
+	Group       string       `json:"group_name"`
+	Position    string       `json:"position"`
+	Positions   []string     `json:"positions,omitempty"`
+	LegalName   string       `json:"legal_name,omitempty"`
+	HangulName  string       `json:"hangul_name,omitempty"`
+	BirthDate   *models.Date `json:"birth_date,omitempty"`
+	Nationality string       `json:"nationality,omitempty"`
+	DebutDate   *models.Date `json:"debut_date,omitempty"`
+	HeightCM    *int         `json:"height_cm,omitempty"`
+	MBTI        string       `json:"mbti,omitempty"`
+	Status      string       `json:"status,omitempty"`
+	// Version, when set, is the version the client last read.
+	Version int `json:"version,omitempty"`
+}
+
+// inputOf returns the writable part of it.
+func inputOf(it models.Idol) idolInput {
+	return idolInput{
+		Name: it.Name, GroupID: it.GroupID, Group: it.Group, Position: it.Position, Positions: it.Positions,
+		LegalName: it.LegalName, HangulName: it.HangulName, BirthDate: it.BirthDate, Nationality: it.Nationality,
+		DebutDate: it.DebutDate, HeightCM: it.HeightCM, MBTI: it.MBTI, Status: it.Status, Version: it.Version,
+	}
+}
+
+func (in idolInput) idol() models.Idol {
+	return models.Idol{
+		Name: in.Name, GroupID: in.GroupID, Group: in.Group, Position: in.Position, Positions: in.Positions,
+		LegalName: in.LegalName, HangulName: in.HangulName, BirthDate: in.BirthDate, Nationality: in.Nationality,
+		DebutDate: in.DebutDate, HeightCM: in.HeightCM, MBTI: in.MBTI, Status: in.Status,
+	}
+}
+
+// profileFields are the JSON names of the idolInput profile fields.
+var profileFields = []string{"legal_name", "hangul_name", "birth_date", "nationality", "debut_date", "height_cm", "mbti", "status"}
+
+// hasProfile reports whether body names every profile field.
+func hasProfile(body map[string]json.RawMessage) bool {
+	for _, name := range profileFields {
+		if _, ok := body[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// keepProfile copies from cur the profile fields that are not keys of body.
+func (in *idolInput) keepProfile(cur models.Idol, body map[string]json.RawMessage) {
+	for _, name := range profileFields {
+		if _, ok := body[name]; ok {
+			continue
+		}
+		switch name {
+		case "legal_name":
+			in.LegalName = cur.LegalName
+		case "hangul_name":
+			in.HangulName = cur.HangulName
+		case "birth_date":
+			in.BirthDate = cur.BirthDate
+		case "nationality":
+			in.Nationality = cur.Nationality
+		case "debut_date":
+			in.DebutDate = cur.DebutDate
+		case "height_cm":
+			in.HeightCM = cur.HeightCM
+		case "mbti":
+			in.MBTI = cur.MBTI
+		case "status":
+			in.Status = cur.Status
+		}
+	}
+}
+
+// maxFieldLen matches the VARCHAR(100) idol columns.
+const maxFieldLen = 100
+
+// validate cleans the text fields in place and checks them.
+func (in *idolInput) validate() error {
+	validation.CleanAll(&in.Name, &in.Group, &in.Position)
+	var v validation.Validator
+	v.Required("name", in.Name)
+	v.MaxLen("name", in.Name, maxFieldLen)
+	if in.GroupID == 0 {
+		v.Required("group_name", in.Group)
+	}
+	v.Check(in.GroupID >= 0, "group_id", validation.CodeInvalid, "group_id must be positive")
+	v.MaxLen("group_name", in.Group, maxFieldLen)
+	if len(in.Positions) == 0 {
+		v.Required("position", in.Position)
+	}
+	v.MaxLen("position", in.Position, maxFieldLen)
+	for i := range in.Positions {
+		in.Positions[i] = validation.Clean(in.Positions[i])
+		v.Required(fmt.Sprintf("positions[%d]", i), in.Positions[i])
+	}
+	if utf8.RuneCountInString(strings.Join(in.Positions, ", ")) > maxFieldLen {
+		v.Add("positions", validation.CodeTooLong, fmt.Sprintf("positions must be at most %d characters together", maxFieldLen))
+	}
+	in.validateProfile(&v)
+	return v.Err()
+}
+
+// Profile field limits.
+const (
+	minHeightCM = 100
+	maxHeightCM = 250
+)
+
+var (
+	mbtiPattern = regexp.MustCompile(`^[EI][SN][TF][JP](-[AT])?$`)
+	// earliestBirth rules out dates that can only be typos.
+	earliestBirth = time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// validateProfile cleans and checks the profile fields. Nationality is
+// stored as an upper-case ISO 3166-1 alpha-2 code; alpha-3 and numeric codes
+// are converted.
+func (in *idolInput) validateProfile(v *validation.Validator) {
+	validation.CleanAll(&in.LegalName, &in.HangulName, &in.Nationality, &in.MBTI, &in.Status)
+	v.MaxLen("legal_name", in.LegalName, maxFieldLen)
+	if v.MaxLen("hangul_name", in.HangulName, maxFieldLen) {
+		v.Check(isHangul(in.HangulName), "hangul_name", validation.CodeInvalid, "hangul_name must be written in Hangul")
+	}
+	if in.Nationality != "" {
+		region, err := language.ParseRegion(in.Nationality)
+		if err != nil || !region.IsCountry() {
+			v.Add("nationality", validation.CodeInvalid, "nationality must be an ISO 3166-1 country code such as KR")
+		} else {
+			in.Nationality = region.String()
+		}
+	}
+	in.MBTI = strings.ToUpper(in.MBTI)
+	v.Check(in.MBTI == "" || mbtiPattern.MatchString(in.MBTI), "mbti", validation.CodeInvalid, "mbti must be a type such as INFP or ENTJ-A")
+	in.Status = strings.ToLower(in.Status)
+	v.OneOf("status", in.Status, models.IdolActive, models.IdolHiatus, models.IdolDeparted)
+	if in.HeightCM != nil {
+		v.Check(*in.HeightCM >= minHeightCM && *in.HeightCM <= maxHeightCM, "height_cm", validation.CodeInvalid,
+			fmt.Sprintf("height_cm must be between %d and %d", minHeightCM, maxHeightCM))
+	}
+	if in.BirthDate != nil {
+		v.Check(!in.BirthDate.Before(earliestBirth) && !in.BirthDate.After(time.Now()), "birth_date", validation.CodeInvalid,
+			"birth_date must be between 1900-01-01 and today")
+	}
+	if in.BirthDate != nil && in.DebutDate != nil {
+		v.Check(in.DebutDate.After(in.BirthDate.Time), "debut_date", validation.CodeInvalid, "debut_date must be after birth_date")
+	}
+}
+
+// isHangul reports whether s only holds Hangul letters, spaces and the
+// middle dot some names use.
+func isHangul(s string) bool {
+	for _, r := range s {
+		if !unicode.Is(unicode.Hangul, r) && r != ' ' && r != '·' {
+			return false
+		}
+	}
+	return true
+}
+
+// writeInvalid answers 422 for a payload that failed validation, listing the
+// field errors when there are any.
+func writeInvalid(w http.ResponseWriter, err error) {
+	var fields validation.Errors
+	if errors.As(err, &fields) {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{"error": "validation failed", "errors": fields})
+		return
+	}
+	writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+}
+
+const (
+	defaultPageSize = 20
+	// maxPageSize is the hard server-side cap on ?limit.
+	maxPageSize = 100
+)
+
+// listParams are the query parameters of GET /api/idols that are not filters.
+var listParams = map[string]bool{"limit": true, "cursor": true, "format": true, "sort": true}
+
+// parseListOptions reads ?limit, ?cursor, ?sort and treats every other
+// parameter as a filter; the store rejects filters outside its allowlist.
+// Limits above maxPageSize are clamped rather than rejected.
+func parseListOptions(r *http.Request) (store.ListOptions, error) {
+	q := r.URL.Query()
+	opts := store.ListOptions{Limit: defaultPageSize, Sort: q.Get("sort")}
+	for name := range q {
+		if !listParams[name] {
+			if opts.Filters == nil {
+				opts.Filters = make(map[string]string)
+			}
+			opts.Filters[name] = q.Get(name)
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return opts, errors.New("limit must be a positive integer")
+		}
+		opts.Limit = min(n, maxPageSize)
+	}
+	if v := q.Get("cursor"); v != "" {
+		c, err := store.DecodeCursor(v)
+		if err != nil {
+			return opts, err
+		}
+		opts.After = &c
+	}
+	return opts, nil
+}
+
+// legacyList reports whether the client asked for the pre-pagination bare
+// array response via ?format=array.
+func legacyList(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "array"
+}
+
+// listAll follows the cursors from opts to the last page, for bare array
+// clients that never learnt to page.
+func listAll(ctx context.Context, idols store.IdolStore, opts store.ListOptions) ([]models.Idol, error) {
+	opts.Limit = maxPageSize
+	items := []models.Idol{}
+	for {
+		page, err := idols.List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page.Items...)
+		if page.NextCursor == "" {
+			return items, nil
+		}
+		c, err := store.DecodeCursor(page.NextCursor)
+		if err != nil {
+			return nil, err
+		}
+		opts.After = &c
+	}
+}
+
+func HandleIdols(idols store.IdolStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			opts, err := parseListOptions(r)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			if legacyList(r) && !r.URL.Query().Has("limit") {
+				items, err := listAll(r.Context(), idols, opts)
+				if err != nil {
+					writeStoreError(w, err, "db error")
+					return
+				}
+				writeJSON(w, http.StatusOK, items)
+				return
+			}
+			page, err := idols.List(r.Context(), opts)
+			if err != nil {
+				writeStoreError(w, err, "db error")
+				return
+			}
+			if legacyList(r) {
+				// The bare array has no room for the cursor, so hand it out
+				// as a header instead.
+				if page.NextCursor != "" {
+					w.Header().Set("X-Next-Cursor", page.NextCursor)
+				}
+				writeJSON(w, http.StatusOK, page.Items)
+				return
+			}
+			writeJSON(w, http.StatusOK, page)
+		case http.MethodPost:
+			var in idolInput
+			if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+				return
+			}
+			if err := in.validate(); err != nil {
+				writeInvalid(w, err)
+				return
+			}
+			it := in.idol()
+			it.CreatedBy = actor(r)
+			created, err := idols.Create(r.Context(), it)
+			if err != nil {
+				writeStoreError(w, err, "insert error")
+				return
+			}
+			writeIdol(w, http.StatusCreated, created)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// HandleIdolSearch serves GET /api/idols/search?q=&limit=.
+func HandleIdolSearch(idols store.IdolStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		q := strings.TrimSpace(r.URL.Query().Get("q"))
+		if q == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing q"})
+			return
+		}
+		limit := defaultPageSize
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be a positive integer"})
+				return
+			}
+			limit = min(n, maxPageSize)
+		}
+		results, err := idols.Search(r.Context(), q, limit)
+		if err != nil {
+			writeStoreError(w, err, "search error")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"items": results})
+	}
+}
+
+// parseID parses a resource id path segment. Only positive decimal integers
+// are accepted.
+func parseID(raw string) (int64, error) {
+	if raw == "" {
+		return 0, errors.New("missing id")
+	}
+	for _, c := range raw {
+		if c < '0' || c > '9' {
+			return 0, errors.New("invalid id")
+		}
+	}
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid id")
+	}
+	return id, nil
+}
+
+// HandleIdolByID serves /api/idols/{id}. A hard DELETE also removes the
+// idol's photo files from files.
+func HandleIdolByID(idols store.IdolStore, files storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseID(strings.TrimPrefix(r.URL.Path, "/api/idols/"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		// If-Match only conditions writes; GET ignores it.
+		var ifMatch int
+		var hasIfMatch bool
+		switch r.Method {
+		case http.MethodPut, http.MethodPatch, http.MethodDelete:
+			ifMatch, hasIfMatch, err = parseIfMatch(r.Header.Get("If-Match"))
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+		}
+		switch r.Method {
+		case http.MethodGet:
+			it, err := idols.Get(r.Context(), id)
+			if err != nil {
+				writeStoreError(w, err, "db error")
+				return
+			}
+			writeIdol(w, http.StatusOK, it)
+		case http.MethodPut:
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "cannot read body"})
+				return
+			}
+			var in idolInput
+			var fields map[string]json.RawMessage
+			if json.Unmarshal(body, &in) != nil || json.Unmarshal(body, &fields) != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+				return
+			}
+			version, status := expectedVersion(ifMatch, hasIfMatch, in.Version)
+			if !hasProfile(fields) {
+				cur, err := idols.Get(r.Context(), id)
+				if err != nil {
+					writeStoreError(w, err, "db error")
+					return
+				}
+				in.keepProfile(cur, fields)
+				// The kept fields are only current as of cur.
+				if version == 0 {
+					version = cur.Version
+				}
+			}
+			if err := in.validate(); err != nil {
+				writeInvalid(w, err)
+				return
+			}
+			it := in.idol()
+			it.ID = id
+			it.UpdatedBy = actor(r)
+			updated, err := idols.Update(r.Context(), it, version)
+			if err != nil {
+				writeWriteError(w, r, idols, id, err, status, "update error")
+				return
+			}
+			writeIdol(w, http.StatusOK, updated)
+		case http.MethodPatch:
+			cur, err := idols.Get(r.Context(), id)
+			if err != nil {
+				writeStoreError(w, err, "db error")
+				return
+			}
+			if hasIfMatch && ifMatch != cur.Version {
+				writeWriteError(w, r, idols, id, store.ErrVersionConflict, http.StatusPreconditionFailed, "update error")
+				return
+			}
+			in, status, err := applyIdolPatch(cur, r)
+			if err != nil {
+				writeJSON(w, status, map[string]string{"error": err.Error()})
+				return
+			}
+			if err := in.validate(); err != nil {
+				writeInvalid(w, err)
+				return
+			}
+			// The write is conditional on the version the patch was applied
+			// to, unless the patch itself asserted another one.
+			version, status := cur.Version, http.StatusConflict
+			if hasIfMatch {
+				status = http.StatusPreconditionFailed
+			} else if in.Version != 0 {
+				version = in.Version
+			}
+			it := in.idol()
+			it.ID = id
+			it.UpdatedBy = actor(r)
+			updated, err := idols.Update(r.Context(), it, version)
+			if err != nil {
+				writeWriteError(w, r, idols, id, err, status, "update error")
+				return
+			}
+			writeIdol(w, http.StatusOK, updated)
+		case http.MethodDelete:
+			if r.URL.Query().Get("hard") == "true" {
+				if !isAdmin(r) {
+					writeJSON(w, http.StatusForbidden, map[string]string{"error": "admin only"})
+					return
+				}
+				keys, err := idols.Purge(r.Context(), id)
+				if err != nil {
+					writeStoreError(w, err, "delete error")
+					return
+				}
+				if err := storage.DeleteAll(context.WithoutCancel(r.Context()), files, keys); err != nil {
+					log.Printf("purge idol %d files: %v", id, err)
+				}
+				writeJSON(w, http.StatusOK, map[string]string{"status": "purged"})
+				return
+			}
+			// DELETE may carry {"version": n} instead of If-Match.
+			var in idolInput
+			if err := json.NewDecoder(r.Body).Decode(&in); err != nil && !errors.Is(err, io.EOF) {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+				return
+			}
+			version, status := expectedVersion(ifMatch, hasIfMatch, in.Version)
+			if err := idols.SoftDelete(r.Context(), id, version, actor(r)); err != nil {
+				writeWriteError(w, r, idols, id, err, status, "delete error")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// HandleIdolTrash serves GET /api/idols/trash, the soft-deleted idols. It
+// takes the same paging, filter and sort parameters as GET /api/idols.
+func HandleIdolTrash(idols store.IdolStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		opts, err := parseListOptions(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		opts.Deleted = true
+		page, err := idols.List(r.Context(), opts)
+		if err != nil {
+			writeStoreError(w, err, "db error")
+			return
+		}
+		writeJSON(w, http.StatusOK, page)
+	}
+}
+
+// HandleIdolRestore serves POST /api/idols/{id}/restore.
+func HandleIdolRestore(idols store.IdolStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		id, err := parseID(r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if err := idols.Restore(r.Context(), id, actor(r)); err != nil {
+			writeStoreError(w, err, "restore error")
+			return
+		}
+		it, err := idols.Get(r.Context(), id)
+		if err != nil {
+			writeStoreError(w, err, "db error")
+			return
+		}
+		writeIdol(w, http.StatusOK, it)
+	}
+}
+
+// isAdmin reports whether the authenticated user has the admin role.
+func isAdmin(r *http.Request) bool {
+	c, ok := auth.ClaimsFromContext(r.Context())
+	return ok && c.Role == "admin"
+}
+
+// actor returns the authenticated username to record on writes. The store
+// falls back to "system" when it is empty.
+func actor(r *http.Request) string {
+	if c, ok := auth.ClaimsFromContext(r.Context()); ok {
+		return c.Username
+	}
+	return ""
+}
+
+// maxPatchSize caps PATCH bodies.
+const maxPatchSize = 1 << 20
+
+// applyIdolPatch applies a merge patch or JSON patch request body to the
+// editable fields of cur. On failure it also returns the status to answer.
+func applyIdolPatch(cur models.Idol, r *http.Request) (idolInput, int, error) {
+	var in idolInput
+	doc, err := json.Marshal(inputOf(cur))
+	if err != nil {
+		return in, http.StatusInternalServerError, err
+	}
+	patch, err := io.ReadAll(io.LimitReader(r.Body, maxPatchSize))
+	if err != nil {
+		return in, http.StatusBadRequest, errors.New("cannot read body")
+	}
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var out []byte
+	switch ct {
+	case jsonpatch.MergePatchType:
+		out, err = jsonpatch.MergePatch(doc, patch)
+	case jsonpatch.JSONPatchType:
+		out, err = jsonpatch.Apply(doc, patch)
+	default:
+		return in, http.StatusUnsupportedMediaType, fmt.Errorf("content type must be %s or %s", jsonpatch.MergePatchType, jsonpatch.JSONPatchType)
+	}
+	if errors.Is(err, jsonpatch.ErrTestFailed) {
+		return in, http.StatusConflict, err
+	}
+	if err != nil {
+		return in, http.StatusBadRequest, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(out))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&in); err != nil {
+		return in, http.StatusUnprocessableEntity, fmt.Errorf("patched idol is invalid: %v", err)
+	}
+	// A patch that only renames the group moves the idol by name.
+	if in.Group != cur.Group && in.GroupID == cur.GroupID {
+		in.GroupID = 0
+	}
+	// Likewise a patch that only rewrites position replaces the positions.
+	if in.Position != cur.Position && slices.Equal(in.Positions, cur.Positions) {
+		in.Positions = nil
+	}
+	return in, 0, nil
+}
+
+// etag derives the entity tag of an idol from its version.
+func etag(it models.Idol) string {
+	return `"` + strconv.Itoa(it.Version) + `"`
+}
+
+// writeIdol writes a single idol together with its ETag.
+func writeIdol(w http.ResponseWriter, status int, it models.Idol) {
+	w.Header().Set("ETag", etag(it))
+	writeJSON(w, status, it)
+}
+
+// parseIfMatch reads the version out of an If-Match header. Weak tags are
+// accepted; an absent header or "*" imposes no version (ok is false).
+func parseIfMatch(h string) (version int, ok bool, err error) {
+	h = strings.TrimSpace(h)
+	if h == "" || h == "*" {
+		return 0, false, nil
+	}
+	tag := strings.TrimPrefix(h, "W/")
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false, errors.New("invalid If-Match header")
+	}
+	version, err = strconv.Atoi(tag[1 : len(tag)-1])
+	if err != nil || version < 1 {
+		return 0, false, errors.New("invalid If-Match header")
+	}
+	return version, true, nil
+}
+
+// expectedVersion picks the precondition of a write and the status to answer
+// a mismatch with: If-Match wins and fails with 412, a version in the body
+// fails with 409. A zero version means the write is unconditional.
+func expectedVersion(ifMatch int, hasIfMatch bool, bodyVersion int) (int, int) {
+	if hasIfMatch {
+		return ifMatch, http.StatusPreconditionFailed
+	}
+	return bodyVersion, http.StatusConflict
+}
+
+// writeWriteError reports a failed write. Version conflicts are answered with
+// the given status and the current representation so the client can merge.
+func writeWriteError(w http.ResponseWriter, r *http.Request, idols store.IdolStore, id int64, err error, status int, msg string) {
+	if !errors.Is(err, store.ErrVersionConflict) {
+		writeStoreError(w, err, msg)
+		return
+	}
+	cur, err := idols.Get(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err, msg)
+		return
+	}
+	w.Header().Set("ETag", etag(cur))
+	writeJSON(w, status, map[string]interface{}{"error": "version conflict", "current": cur})
+}
+
+// writeStoreError maps store sentinel errors to HTTP statuses and falls back
+// to a 500 with the given message.
+func writeStoreError(w http.ResponseWriter, err error, msg string) {
+	var qe *store.QueryError
+	if errors.As(err, &qe) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid query", "param": qe.Param, "message": qe.Message})
+		return
+	}
+	status, text := storeErrorStatus(err, msg)
+	writeJSON(w, status, map[string]string{"error": text})
+}
+
+// storeErrorStatus returns the status and error text writeStoreError
+// answers err with.
+func storeErrorStatus(err error, msg string) (int, string) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return http.StatusNotFound, "not found"
+	case errors.Is(err, store.ErrInvalidCursor):
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, store.ErrUnknownGroup), errors.Is(err, store.ErrUnknownIdol), errors.Is(err, store.ErrGroupCycle),
+		errors.Is(err, store.ErrUnknownPosition), errors.Is(err, store.ErrPositionsTooLong), errors.Is(err, store.ErrUnknownOption):
+		return http.StatusUnprocessableEntity, err.Error()
+	case errors.Is(err, store.ErrVersionConflict), errors.Is(err, store.ErrDuplicate), errors.Is(err, store.ErrInUse),
+		errors.Is(err, store.ErrOverlap), errors.Is(err, store.ErrAlreadyVoted), errors.Is(err, store.ErrPollNotOpen):
+		return http.StatusConflict, err.Error()
+	}
+	return http.StatusInternalServerError, msg
+}
@@ -0,0 +1,415 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kpopapi/internal/auth"
+	"kpopapi/internal/models"
+	"kpopapi/internal/storage"
+	"kpopapi/internal/store"
+	"kpopapi/pkg/validation"
+)
+
+// newIdolServer routes the idol endpoints to a fresh store.Memory holding
+// the groups AESPA and NCT. Requests run as an admin.
+func newIdolServer(t *testing.T) (*store.Memory, http.Handler) {
+	t.Helper()
+	s := store.NewMemory()
+	for _, name := range []string{"AESPA", "NCT"} {
+		if _, err := s.CreateGroup(context.Background(), models.Group{Name: name}); err != nil {
+			t.Fatalf("CreateGroup %s: %v", name, err)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/idols", HandleIdols(s))
+	files, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.HandleFunc("/api/idols/", HandleIdolByID(s, files))
+	mux.HandleFunc("/api/idols/trash", HandleIdolTrash(s))
+	mux.HandleFunc("/api/idols/{id}/restore", HandleIdolRestore(s))
+	return s, withAdmin(mux)
+}
+
+// withAdmin runs h with the claims of an admin, as JWTMiddleware would.
+func withAdmin(h http.Handler) http.Handler {
+	claims := &auth.Claims{Username: "admin", Role: "admin"}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(auth.WithClaims(r.Context(), claims)))
+	})
+}
+
+// do sends a request with an optional JSON body and header pairs.
+func do(t *testing.T, h http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func decodeBody[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatalf("decode %q: %v", w.Body.String(), err)
+	}
+	return v
+}
+
+// fieldErrors decodes a 422 validation answer into the code of each
+// failed field.
+func fieldErrors(t *testing.T, w *httptest.ResponseRecorder) map[string]string {
+	t.Helper()
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status %d, want 422 (body %s)", w.Code, w.Body)
+	}
+	body := decodeBody[struct {
+		Error  string
+		Errors validation.Errors
+	}](t, w)
+	if body.Error != "validation failed" {
+		t.Fatalf("error %q, want field errors", body.Error)
+	}
+	codes := make(map[string]string, len(body.Errors))
+	for _, fe := range body.Errors {
+		codes[fe.Field] = fe.Code
+	}
+	return codes
+}
+
+func createIdol(t *testing.T, h http.Handler, body string) models.Idol {
+	t.Helper()
+	w := do(t, h, http.MethodPost, "/api/idols", body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST %s: status %d, body %s", body, w.Code, w.Body)
+	}
+	return decodeBody[models.Idol](t, w)
+}
+
+func TestIdolCreateAndGet(t *testing.T) {
+	_, h := newIdolServer(t)
+	created := createIdol(t, h, `{"name":"Karina","group_name":"aespa","position":"Leader, Main Dance"}`)
+	if created.ID == 0 || created.Version != 1 || created.CreatedBy != "admin" {
+		t.Errorf("created = %+v", created)
+	}
+	if created.Group != "AESPA" || created.Position != "Leader, Main Dancer" {
+		t.Errorf("group %q, position %q: want the catalogue names", created.Group, created.Position)
+	}
+
+	w := do(t, h, http.MethodGet, "/api/idols/1", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET: status %d", w.Code)
+	}
+	if got := w.Header().Get("ETag"); got != `"1"` {
+		t.Errorf("ETag = %s, want \"1\"", got)
+	}
+	if got := decodeBody[models.Idol](t, w); got.Name != "Karina" || got.GroupID != created.GroupID {
+		t.Errorf("GET = %+v", got)
+	}
+	// If-Match only conditions writes.
+	if w := do(t, h, http.MethodGet, "/api/idols/1", "", "If-Match", "garbage"); w.Code != http.StatusOK {
+		t.Errorf("GET with a malformed If-Match: status %d, want 200", w.Code)
+	}
+}
+
+func TestIdolCreateErrors(t *testing.T) {
+	_, h := newIdolServer(t)
+	tests := []struct {
+		name, body string
+		status     int
+	}{
+		{"invalid json", `{`, http.StatusBadRequest},
+		{"missing name", `{"group_name":"NCT","position":"Leader"}`, http.StatusUnprocessableEntity},
+		{"missing group", `{"name":"Jisung","position":"Leader"}`, http.StatusUnprocessableEntity},
+		{"unknown group", `{"name":"Jisung","group_name":"NTC","position":"Leader"}`, http.StatusUnprocessableEntity},
+		{"unknown group id", `{"name":"Jisung","group_id":99,"position":"Leader"}`, http.StatusUnprocessableEntity},
+		{"unknown position", `{"name":"Jisung","group_name":"NCT","position":"Chef"}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := do(t, h, http.MethodPost, "/api/idols", tt.body); w.Code != tt.status {
+				t.Errorf("status %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestIdolGetErrors(t *testing.T) {
+	_, h := newIdolServer(t)
+	for target, status := range map[string]int{
+		"/api/idols/1":   http.StatusNotFound,
+		"/api/idols/0":   http.StatusBadRequest,
+		"/api/idols/-1":  http.StatusBadRequest,
+		"/api/idols/abc": http.StatusBadRequest,
+	} {
+		if w := do(t, h, http.MethodGet, target, ""); w.Code != status {
+			t.Errorf("GET %s: status %d, want %d", target, w.Code, status)
+		}
+	}
+}
+
+// TestIdolIDValidation sends malformed ids to every method of
+// /api/idols/{id}; none may reach the store.
+func TestIdolIDValidation(t *testing.T) {
+	_, h := newIdolServer(t)
+	createIdol(t, h, `{"name":"Karina","group_name":"AESPA","position":"Leader"}`)
+	body := `{"name":"Karina","group_name":"AESPA","position":"Leader"}`
+	ids := []struct {
+		raw  string
+		want string
+	}{
+		{"", "missing id"},
+		{"abc", "invalid id"},
+		{"0", "invalid id"},
+		{"-1", "invalid id"},
+		{"+1", "invalid id"},
+		{"1.0", "invalid id"},
+		{"1e3", "invalid id"},
+		{"0x1", "invalid id"},
+		{"%201", "invalid id"},
+		{"1%20", "invalid id"},
+		{"1/", "invalid id"},
+		{"1/extra", "invalid id"},
+		{"99999999999999999999", "invalid id"},
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		for _, tt := range ids {
+			w := do(t, h, method, "/api/idols/"+tt.raw, body)
+			if w.Code != http.StatusBadRequest || decodeBody[map[string]string](t, w)["error"] != tt.want {
+				t.Errorf("%s /api/idols/%s: status %d, body %s; want 400 %q", method, tt.raw, w.Code, w.Body, tt.want)
+			}
+		}
+	}
+	// Leading zeros are still the same decimal id.
+	if w := do(t, h, http.MethodGet, "/api/idols/001", ""); w.Code != http.StatusOK {
+		t.Errorf("GET /api/idols/001: status %d, want 200", w.Code)
+	}
+}
+
+// TestIdolMissingIs404 checks that ids that were never used and ids of
+// trashed idols answer 404 with the same body on every method.
+func TestIdolMissingIs404(t *testing.T) {
+	_, h := newIdolServer(t)
+	createIdol(t, h, `{"name":"Karina","group_name":"AESPA","position":"Leader"}`)
+	if w := do(t, h, http.MethodDelete, "/api/idols/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("DELETE: status %d", w.Code)
+	}
+	tests := []struct {
+		method, body, contentType string
+	}{
+		{http.MethodGet, "", ""},
+		{http.MethodPut, `{"name":"Karina","group_name":"AESPA","position":"Leader"}`, ""},
+		{http.MethodPatch, `{"position":"Visual"}`, "application/merge-patch+json"},
+		{http.MethodDelete, "", ""},
+	}
+	for _, target := range []string{"/api/idols/1", "/api/idols/42"} {
+		for _, tt := range tests {
+			var header []string
+			if tt.contentType != "" {
+				header = []string{"Content-Type", tt.contentType}
+			}
+			w := do(t, h, tt.method, target, tt.body, header...)
+			if w.Code != http.StatusNotFound || decodeBody[map[string]string](t, w)["error"] != "not found" {
+				t.Errorf("%s %s: status %d, body %s; want 404", tt.method, target, w.Code, w.Body)
+			}
+		}
+	}
+}
+
+func TestIdolList(t *testing.T) {
+	_, h := newIdolServer(t)
+	for _, name := range []string{"Karina", "Winter", "Giselle"} {
+		createIdol(t, h, `{"name":"`+name+`","group_name":"AESPA","position":"Leader"}`)
+	}
+	createIdol(t, h, `{"name":"Jisung","group_name":"NCT","position":"Main Dancer"}`)
+
+	w := do(t, h, http.MethodGet, "/api/idols", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	var names []string
+	for _, it := range decodeBody[store.Page](t, w).Items {
+		names = append(names, it.Name)
+	}
+	if strings.Join(names, ",") != "Karina,Winter,Giselle,Jisung" {
+		t.Errorf("listed %v", names)
+	}
+}
+
+func TestIdolUpdate(t *testing.T) {
+	_, h := newIdolServer(t)
+	createIdol(t, h, `{"name":"Karina","group_name":"AESPA","position":"Leader"}`)
+
+	w := do(t, h, http.MethodPut, "/api/idols/1", `{"name":"Karina","group_name":"NCT","position":"Visual","version":1}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT: status %d, body %s", w.Code, w.Body)
+	}
+	if got := decodeBody[models.Idol](t, w); got.Version != 2 || got.Group != "NCT" || got.Position != "Visual" {
+		t.Errorf("updated = %+v", got)
+	}
+
+	w = do(t, h, http.MethodPut, "/api/idols/1", `{"name":"Stale","group_name":"NCT","position":"Visual","version":1}`)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("stale body version: status %d, want 409", w.Code)
+	}
+	conflict := decodeBody[struct {
+		Current models.Idol `json:"current"`
+	}](t, w)
+	if conflict.Current.Version != 2 || conflict.Current.Name != "Karina" {
+		t.Errorf("conflict current = %+v", conflict.Current)
+	}
+
+	body := `{"name":"Stale","group_name":"NCT","position":"Visual"}`
+	if w := do(t, h, http.MethodPut, "/api/idols/1", body, "If-Match", `"1"`); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale If-Match: status %d, want 412", w.Code)
+	}
+	if w := do(t, h, http.MethodPut, "/api/idols/1", body, "If-Match", "garbage"); w.Code != http.StatusBadRequest {
+		t.Errorf("malformed If-Match: status %d, want 400", w.Code)
+	}
+	if w := do(t, h, http.MethodPut, "/api/idols/1", `{"name":""}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("invalid body: status %d, want 422", w.Code)
+	}
+	if w := do(t, h, http.MethodPut, "/api/idols/9", body); w.Code != http.StatusNotFound {
+		t.Errorf("missing idol: status %d, want 404", w.Code)
+	}
+	// Without a version the write is unconditional.
+	if w := do(t, h, http.MethodPut, "/api/idols/1", body); w.Code != http.StatusOK {
+		t.Errorf("unconditional PUT: status %d, want 200", w.Code)
+	}
+}
+
+func TestIdolPatch(t *testing.T) {
+	_, h := newIdolServer(t)
+	createIdol(t, h, `{"name":"Karina","group_name":"AESPA","position":"Leader"}`)
+
+	w := do(t, h, http.MethodPatch, "/api/idols/1", `{"mbti":"ENFP"}`, "Content-Type", "application/merge-patch+json")
+	if w.Code != http.StatusOK {
+		t.Fatalf("merge patch: status %d, body %s", w.Code, w.Body)
+	}
+	if got := decodeBody[models.Idol](t, w); got.MBTI != "ENFP" || got.Name != "Karina" || got.Version != 2 {
+		t.Errorf("merge patched = %+v", got)
+	}
+	w = do(t, h, http.MethodPatch, "/api/idols/1", `[{"op":"replace","path":"/name","value":"Yu Jimin"}]`,
+		"Content-Type", "application/json-patch+json", "If-Match", `"2"`)
+	if got := decodeBody[models.Idol](t, w); w.Code != http.StatusOK || got.Name != "Yu Jimin" {
+		t.Errorf("json patch: status %d, idol %+v", w.Code, got)
+	}
+}
+
+func TestIdolSoftDeleteAndRestore(t *testing.T) {
+	s, h := newIdolServer(t)
+	createIdol(t, h, `{"name":"Karina","group_name":"AESPA","position":"Leader"}`)
+	createIdol(t, h, `{"name":"Winter","group_name":"AESPA","position":"Main Vocalist"}`)
+
+	if w := do(t, h, http.MethodDelete, "/api/idols/1", `{"version":7}`); w.Code != http.StatusConflict {
+		t.Errorf("stale delete: status %d, want 409", w.Code)
+	}
+	if w := do(t, h, http.MethodDelete, "/api/idols/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("DELETE: status %d, body %s", w.Code, w.Body)
+	}
+	if w := do(t, h, http.MethodGet, "/api/idols/1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET deleted: status %d, want 404", w.Code)
+	}
+	if w := do(t, h, http.MethodDelete, "/api/idols/1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE twice: status %d, want 404", w.Code)
+	}
+	live := decodeBody[store.Page](t, do(t, h, http.MethodGet, "/api/idols", ""))
+	if len(live.Items) != 1 || live.Items[0].Name != "Winter" {
+		t.Errorf("live list = %+v", live.Items)
+	}
+	trash := decodeBody[store.Page](t, do(t, h, http.MethodGet, "/api/idols/trash", ""))
+	if len(trash.Items) != 1 || trash.Items[0].ID != 1 || trash.Items[0].DeletedAt == nil {
+		t.Errorf("trash = %+v", trash.Items)
+	}
+
+	w := do(t, h, http.MethodPost, "/api/idols/1/restore", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("restore: status %d, body %s", w.Code, w.Body)
+	}
+	if got := decodeBody[models.Idol](t, w); got.DeletedAt != nil || got.Version != 3 {
+		t.Errorf("restored = %+v", got)
+	}
+	if w := do(t, h, http.MethodPost, "/api/idols/1/restore", ""); w.Code != http.StatusNotFound {
+		t.Errorf("restore live idol: status %d, want 404", w.Code)
+	}
+	if w := do(t, h, http.MethodGet, "/api/idols/1/restore", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET restore: status %d, want 405", w.Code)
+	}
+
+	history, err := s.History(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, rev := range history {
+		actions = append(actions, rev.Action)
+	}
+	if strings.Join(actions, ",") != "create,delete,restore" {
+		t.Errorf("history = %v", actions)
+	}
+}
+
+func TestIdolHardDeleteIsAdminOnly(t *testing.T) {
+	s, _ := newIdolServer(t)
+	user := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(auth.WithClaims(r.Context(), &auth.Claims{Username: "user2", Role: "user"}))
+		HandleIdolByID(s, nil).ServeHTTP(w, r)
+	})
+	if _, err := s.Create(context.Background(), models.Idol{Name: "Karina", Group: "AESPA", Position: "Leader"}); err != nil {
+		t.Fatal(err)
+	}
+	if w := do(t, user, http.MethodDelete, "/api/idols/1?hard=true", ""); w.Code != http.StatusForbidden {
+		t.Errorf("user hard delete: status %d, want 403", w.Code)
+	}
+	if _, err := s.Get(context.Background(), 1); err != nil {
+		t.Errorf("idol gone after a refused purge: %v", err)
+	}
+}
+
+func TestIdolHardDeleteRemovesPhotoFiles(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newIdolServer(t)
+	files, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := withAdmin(HandleIdolByID(s, files))
+	karina, err := s.Create(ctx, models.Idol{Name: "Karina", Group: "AESPA", Position: "Leader"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{"photos/1/a/original.jpg", "photos/1/a/small.jpg"}
+	for _, key := range keys {
+		if err := files.Put(ctx, key, strings.NewReader("jpeg")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.AddPhoto(ctx, models.Photo{IdolID: karina.ID, Variants: []models.PhotoVariant{
+		{Name: "original", Key: keys[0]}, {Name: "small", Key: keys[1]},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := do(t, h, http.MethodDelete, "/api/idols/1?hard=true", ""); w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body)
+	}
+	for _, key := range keys {
+		if f, err := files.Open(ctx, key); err == nil {
+			f.Close()
+			t.Errorf("%s kept after the purge", key)
+		}
+	}
+}
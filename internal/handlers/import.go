@@ -0,0 +1,445 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"kpopapi/internal/models"
+	"kpopapi/internal/store"
+	"kpopapi/pkg/validation"
+)
+
+const (
+	// maxImportSize caps import bodies.
+	maxImportSize = 5 << 20
+	// maxImportRows caps the rows of one import.
+	maxImportRows = 1000
+)
+
+// Import row actions.
+const (
+	importCreate = "create"
+	importUpdate = "update"
+	importSkip   = "skip"
+	importError  = "error"
+)
+
+// importRecord is one row of an import body. Rows with an id update that
+// idol; rows without one update the live idol with the same name in the same
+// group, or create a new one.
+type importRecord struct {
+	ID int64 `json:"id,omitempty"`
+	idolInput
+}
+
+type importRow struct {
+	line int
+	rec  importRecord
+	err  error
+}
+
+// importResult reports what happened, or would happen, to one row.
+type importResult struct {
+	Line   int    `json:"line"`
+	Action string `json:"action"`
+	ID     int64  `json:"id,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Errors lists the fields of a row that failed validation.
+	Errors validation.Errors `json:"errors,omitempty"`
+}
+
+var (
+	errDryRun      = errors.New("dry run")
+	errImportRows  = errors.New("import has invalid rows")
+	importCSVField = map[string]bool{
+		"id": true, "name": true, "group_id": true, "group_name": true, "position": true, "version": true,
+		"legal_name": true, "hangul_name": true, "birth_date": true, "nationality": true, "debut_date": true,
+		"height_cm": true, "mbti": true, "status": true,
+	}
+)
+
+// HandleIdolImport serves POST /api/idols/import. The body is CSV with a
+// header row (text/csv), a JSON array (application/json) or NDJSON
+// (application/x-ndjson). Every row is validated like POST /api/idols and
+// the batch is applied in one transaction, or not at all when any row fails.
+// ?dry_run=true reports the outcome without keeping any change.
+func HandleIdolImport(idols store.AtomicStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		dryRun := r.URL.Query().Get("dry_run") == "true"
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxImportSize+1))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "cannot read body"})
+			return
+		}
+		if len(body) > maxImportSize {
+			writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": fmt.Sprintf("body must be at most %d bytes", maxImportSize)})
+			return
+		}
+		ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		var rows []importRow
+		switch ct {
+		case "text/csv":
+			rows, err = parseImportCSV(body)
+		case "application/json":
+			rows, err = parseImportJSON(body)
+		case "application/x-ndjson", "application/ndjson":
+			rows, err = parseImportNDJSON(body)
+		default:
+			writeJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": "content type must be text/csv, application/json or application/x-ndjson"})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if len(rows) == 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no rows"})
+			return
+		}
+		if len(rows) > maxImportRows {
+			writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": fmt.Sprintf("at most %d rows per import", maxImportRows)})
+			return
+		}
+
+		var results []importResult
+		err = idols.Atomic(r.Context(), func(tx store.Tx) error {
+			results = make([]importResult, len(rows))
+			failed := false
+			for i, row := range rows {
+				// Each row is its own nested unit, so a row the database
+				// rejects does not abort the rows after it.
+				var res importResult
+				err := tx.Atomic(r.Context(), func(rowTx store.Tx) error {
+					var err error
+					res, err = importOne(r.Context(), rowTx, row, actor(r))
+					if err == nil && res.Action == importError {
+						return errImportRows
+					}
+					return err
+				})
+				if err != nil && !errors.Is(err, errImportRows) {
+					return err
+				}
+				if dryRun && res.Action == importCreate {
+					// The id only exists in the rolled back transaction.
+					res.ID = 0
+				}
+				failed = failed || res.Action == importError
+				results[i] = res
+			}
+			switch {
+			case failed:
+				return errImportRows
+			case dryRun:
+				return errDryRun
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errImportRows) && !errors.Is(err, errDryRun) {
+			writeStoreError(w, err, "import error")
+			return
+		}
+		summary := map[string]int{importCreate: 0, importUpdate: 0, importSkip: 0, importError: 0}
+		for _, res := range results {
+			summary[res.Action]++
+		}
+		status := http.StatusOK
+		if errors.Is(err, errImportRows) {
+			status = http.StatusUnprocessableEntity
+		}
+		writeJSON(w, status, map[string]interface{}{
+			"dry_run": dryRun,
+			"applied": err == nil,
+			"summary": summary,
+			"rows":    results,
+		})
+	}
+}
+
+// importOne validates and applies one row. Errors that only concern the row
+// end up in the result; the returned error aborts the whole import.
+func importOne(ctx context.Context, idols store.IdolStore, row importRow, user string) (importResult, error) {
+	res := importResult{Line: row.line, Name: row.rec.Name}
+	fail := func(err error) (importResult, error) {
+		res.Action, res.Error = importError, err.Error()
+		errors.As(err, &res.Errors)
+		return res, nil
+	}
+	if row.err != nil {
+		return fail(row.err)
+	}
+	if err := row.rec.validate(); err != nil {
+		return fail(err)
+	}
+	in := row.rec.idolInput
+	cur, found, err := findImportTarget(ctx, idols, row.rec)
+	if errors.Is(err, store.ErrNotFound) {
+		return fail(fmt.Errorf("idol %d not found", row.rec.ID))
+	}
+	if err != nil {
+		return res, err
+	}
+	it := in.idol()
+	var saved models.Idol
+	if !found {
+		it.CreatedBy = user
+		saved, err = idols.Create(ctx, it)
+		res.Action = importCreate
+	} else {
+		if unchanged(cur, in) {
+			res.Action, res.ID = importSkip, cur.ID
+			return res, nil
+		}
+		it.ID, it.UpdatedBy = cur.ID, user
+		saved, err = idols.Update(ctx, it, in.Version)
+		res.Action = importUpdate
+	}
+	if isRowError(err) {
+		return fail(err)
+	}
+	if err != nil {
+		return res, err
+	}
+	res.ID = saved.ID
+	return res, nil
+}
+
+// findImportTarget returns the idol a row updates, if any.
+func findImportTarget(ctx context.Context, idols store.IdolStore, rec importRecord) (models.Idol, bool, error) {
+	if rec.ID != 0 {
+		it, err := idols.Get(ctx, rec.ID)
+		return it, err == nil, err
+	}
+	filters := map[string]string{"name_prefix": strings.TrimSpace(rec.Name)}
+	if rec.GroupID != 0 {
+		filters["group_id"] = strconv.FormatInt(rec.GroupID, 10)
+	} else {
+		filters["group_name"] = strings.TrimSpace(rec.Group)
+	}
+	opts := store.ListOptions{Limit: maxPageSize, Filters: filters}
+	for {
+		page, err := idols.List(ctx, opts)
+		if err != nil {
+			return models.Idol{}, false, err
+		}
+		for _, it := range page.Items {
+			if strings.EqualFold(it.Name, strings.TrimSpace(rec.Name)) {
+				return it, true, nil
+			}
+		}
+		if page.NextCursor == "" {
+			return models.Idol{}, false, nil
+		}
+		c, err := store.DecodeCursor(page.NextCursor)
+		if err != nil {
+			return models.Idol{}, false, err
+		}
+		opts.After = &c
+	}
+}
+
+// unchanged reports whether writing in over cur would change nothing.
+func unchanged(cur models.Idol, in idolInput) bool {
+	if cur.Name != in.Name {
+		return false
+	}
+	if in.GroupID != 0 && in.GroupID != cur.GroupID || in.GroupID == 0 && !strings.EqualFold(cur.Group, strings.TrimSpace(in.Group)) {
+		return false
+	}
+	terms := in.Positions
+	if len(terms) == 0 {
+		terms = store.SplitPositions(in.Position)
+	}
+	if !strings.EqualFold(strings.Join(terms, ", "), cur.Position) {
+		return false
+	}
+	// The rest of the profile compares as stored; validate has already
+	// normalized in.
+	want := in.idol()
+	if want.Status == "" {
+		want.Status = models.IdolActive
+	}
+	return cur.LegalName == want.LegalName && cur.HangulName == want.HangulName &&
+		sameDate(cur.BirthDate, want.BirthDate) && cur.Nationality == want.Nationality &&
+		sameDate(cur.DebutDate, want.DebutDate) && sameInt(cur.HeightCM, want.HeightCM) &&
+		cur.MBTI == want.MBTI && cur.Status == want.Status
+}
+
+func sameDate(a, b *models.Date) bool {
+	return a == nil && b == nil || a != nil && b != nil && a.Equal(b.Time)
+}
+
+func sameInt(a, b *int) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
+
+// isRowError reports whether a store error is caused by the row itself.
+func isRowError(err error) bool {
+	for _, target := range []error{store.ErrUnknownGroup, store.ErrUnknownPosition, store.ErrPositionsTooLong, store.ErrNotFound, store.ErrVersionConflict, store.ErrDuplicate} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseImportCSV(body []byte) ([]importRow, error) {
+	cr := csv.NewReader(bytes.NewReader(body))
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("csv header: %v", err)
+	}
+	for i, h := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		if !importCSVField[header[i]] {
+			return nil, fmt.Errorf("unknown csv column %q", h)
+		}
+	}
+	var rows []importRow
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		var pe *csv.ParseError
+		if errors.As(err, &pe) && pe.Err == csv.ErrFieldCount {
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		row := importRow{line: line}
+		if len(rec) != len(header) {
+			row.err = fmt.Errorf("row has %d fields, header has %d", len(rec), len(header))
+		}
+		for i := 0; i < len(rec) && i < len(header) && row.err == nil; i++ {
+			row.err = setCSVField(&row.rec, header[i], strings.TrimSpace(rec[i]))
+		}
+		rows = append(rows, row)
+	}
+}
+
+func setCSVField(rec *importRecord, field, v string) error {
+	var err error
+	switch field {
+	case "id":
+		if v != "" {
+			rec.ID, err = strconv.ParseInt(v, 10, 64)
+		}
+	case "group_id":
+		if v != "" {
+			rec.GroupID, err = strconv.ParseInt(v, 10, 64)
+		}
+	case "version":
+		if v != "" {
+			rec.Version, err = strconv.Atoi(v)
+		}
+	case "height_cm":
+		if v != "" {
+			var n int
+			n, err = strconv.Atoi(v)
+			rec.HeightCM = &n
+		}
+	case "birth_date", "debut_date":
+		if v == "" {
+			return nil
+		}
+		d, err := models.ParseDate(v)
+		if err != nil {
+			return fmt.Errorf("%s: %v", field, err)
+		}
+		if field == "birth_date" {
+			rec.BirthDate = &d
+		} else {
+			rec.DebutDate = &d
+		}
+	case "legal_name":
+		rec.LegalName = v
+	case "hangul_name":
+		rec.HangulName = v
+	case "nationality":
+		rec.Nationality = v
+	case "mbti":
+		rec.MBTI = v
+	case "status":
+		rec.Status = v
+	case "name":
+		rec.Name = v
+	case "group_name":
+		rec.Group = v
+	case "position":
+		rec.Position = v
+	}
+	if err != nil {
+		return fmt.Errorf("%s must be an integer", field)
+	}
+	return nil
+}
+
+func parseImportJSON(body []byte) ([]importRow, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return nil, errors.New("body must be a JSON array")
+	}
+	var rows []importRow
+	for dec.More() {
+		// Skip to the first byte of the element to find its line.
+		off := int(dec.InputOffset())
+		for off < len(body) && strings.IndexByte(" \t\r\n,", body[off]) >= 0 {
+			off++
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineAt(body, off), err)
+		}
+		rows = append(rows, decodeImportRow(lineAt(body, off), raw))
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, errors.New("body must be a JSON array")
+	}
+	return rows, nil
+}
+
+func parseImportNDJSON(body []byte) ([]importRow, error) {
+	var rows []importRow
+	for i, line := range bytes.Split(body, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		rows = append(rows, decodeImportRow(i+1, line))
+	}
+	return rows, nil
+}
+
+// decodeImportRow decodes one JSON object; a malformed row only fails
+// itself.
+func decodeImportRow(line int, raw []byte) importRow {
+	row := importRow{line: line}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&row.rec); err != nil {
+		row.err = fmt.Errorf("invalid row: %v", err)
+	}
+	return row
+}
+
+func lineAt(body []byte, off int) int {
+	return bytes.Count(body[:min(off, len(body))], []byte("\n")) + 1
+}
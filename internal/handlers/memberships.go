@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"kpopapi/internal/models"
+	"kpopapi/internal/store"
+	"kpopapi/pkg/validation"
+)
+
+type membershipInput struct {
+	IdolID   int64        `json:"idol_id"`
+	Role     string       `json:"role"`
+	JoinedOn *models.Date `json:"joined_on"`
+	LeftOn   *models.Date `json:"left_on"`
+}
+
+// validate cleans the role in place and checks the payload.
+func (in *membershipInput) validate() error {
+	in.Role = validation.Clean(in.Role)
+	var v validation.Validator
+	v.MaxLen("role", in.Role, maxFieldLen)
+	v.Check(in.IdolID >= 0, "idol_id", validation.CodeInvalid, "idol_id must be positive")
+	if in.JoinedOn != nil && in.LeftOn != nil && in.LeftOn.Before(in.JoinedOn.Time) {
+		v.Add("left_on", validation.CodeInvalid, "left_on must not be before joined_on")
+	}
+	return v.Err()
+}
+
+func (in membershipInput) membership(groupID int64) models.Membership {
+	return models.Membership{IdolID: in.IdolID, GroupID: groupID, Role: in.Role, JoinedOn: in.JoinedOn, LeftOn: in.LeftOn}
+}
+
+func decodeMembership(w http.ResponseWriter, r *http.Request) (membershipInput, bool) {
+	var in membershipInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+		return in, false
+	}
+	if err := in.validate(); err != nil {
+		writeInvalid(w, err)
+		return in, false
+	}
+	return in, true
+}
+
+// HandleGroupMembers serves GET and POST /api/groups/{id}/members. GET
+// returns the lineup on ?at=YYYY-MM-DD, today by default, or every
+// membership ever with ?all=true.
+func HandleGroupMembers(members store.MembershipStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		groupID, err := parseID(r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			var at *models.Date
+			if r.URL.Query().Get("all") != "true" {
+				d := models.Date{Time: time.Now().UTC().Truncate(24 * time.Hour)}
+				if raw := r.URL.Query().Get("at"); raw != "" {
+					if d, err = models.ParseDate(raw); err != nil {
+						writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+						return
+					}
+				}
+				at = &d
+			}
+			list, err := members.GroupMembers(r.Context(), groupID, at)
+			if err != nil {
+				writeStoreError(w, err, "db error")
+				return
+			}
+			resp := map[string]interface{}{"items": list}
+			if at != nil {
+				resp["at"] = at
+			}
+			writeJSON(w, http.StatusOK, resp)
+		case http.MethodPost:
+			in, ok := decodeMembership(w, r)
+			if !ok {
+				return
+			}
+			if in.IdolID == 0 {
+				writeInvalid(w, validation.Errors{{Field: "idol_id", Code: validation.CodeRequired, Message: "idol_id is required"}})
+				return
+			}
+			ms, err := members.AddMembership(r.Context(), in.membership(groupID))
+			if err != nil {
+				writeStoreError(w, err, "insert error")
+				return
+			}
+			writeJSON(w, http.StatusCreated, ms)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// HandleGroupMember serves PUT and DELETE
+// /api/groups/{id}/members/{membership_id}.
+func HandleGroupMember(members store.MembershipStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		groupID, err := parseID(r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		id, err := parseID(r.PathValue("membership_id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			in, ok := decodeMembership(w, r)
+			if !ok {
+				return
+			}
+			ms := in.membership(groupID)
+			ms.ID = id
+			updated, err := members.UpdateMembership(r.Context(), ms)
+			if err != nil {
+				writeStoreError(w, err, "update error")
+				return
+			}
+			writeJSON(w, http.StatusOK, updated)
+		case http.MethodDelete:
+			if err := members.RemoveMembership(r.Context(), groupID, id); err != nil {
+				writeStoreError(w, err, "delete error")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// HandleIdolGroups serves GET /api/idols/{id}/groups, the idol's membership
+// timeline.
+func HandleIdolGroups(members store.MembershipStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		id, err := parseID(r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		list, err := members.IdolMemberships(r.Context(), id)
+		if err != nil {
+			writeStoreError(w, err, "db error")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"items": list})
+	}
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"kpopapi/internal/models"
+)
+
+// newMembershipServer adds the lineup and membership timeline endpoints to
+// the idol endpoints of newIdolServer.
+func newMembershipServer(t *testing.T) http.Handler {
+	t.Helper()
+	s, h := newIdolServer(t)
+	mux := http.NewServeMux()
+	mux.Handle("/api/groups/{id}/members", withAdmin(HandleGroupMembers(s)))
+	mux.Handle("/api/idols/{id}/groups", withAdmin(HandleIdolGroups(s)))
+	mux.Handle("/", h)
+	return mux
+}
+
+// TestIdolGroupMoveMembership moves an idol to another group and checks that
+// the old membership ends today, a new one starts today, and both lineups
+// follow.
+func TestIdolGroupMoveMembership(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		header []string
+	}{
+		{"PUT", http.MethodPut, `{"name":"Karina","group_name":"NCT","position":"Leader","version":1}`, nil},
+		{"merge patch", http.MethodPatch, `{"group_name":"NCT"}`, []string{"Content-Type", "application/merge-patch+json"}},
+	}
+	today := time.Now().UTC().Format(models.DateLayout)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newMembershipServer(t)
+			createIdol(t, h, `{"name":"Karina","group_name":"AESPA","position":"Leader"}`)
+
+			if w := do(t, h, tt.method, "/api/idols/1", tt.body, tt.header...); w.Code != http.StatusOK {
+				t.Fatalf("move: status %d, body %s", w.Code, w.Body)
+			}
+			list := decodeBody[struct{ Items []models.Membership }](t, do(t, h, http.MethodGet, "/api/idols/1/groups", "")).Items
+			if len(list) != 2 {
+				t.Fatalf("memberships = %+v, want 2", list)
+			}
+			old, moved := list[0], list[1]
+			if old.GroupName != "AESPA" || old.JoinedOn != nil || old.LeftOn == nil || old.LeftOn.String() != today {
+				t.Errorf("old membership = %+v, want AESPA left on %s", old, today)
+			}
+			if moved.GroupName != "NCT" || moved.JoinedOn == nil || moved.JoinedOn.String() != today || moved.LeftOn != nil {
+				t.Errorf("new membership = %+v, want NCT joined on %s", moved, today)
+			}
+
+			for _, lineup := range []struct {
+				group string
+				n     int
+			}{{"1", 0}, {"2", 1}} {
+				members := decodeBody[struct{ Items []models.Membership }](t, do(t, h, http.MethodGet, "/api/groups/"+lineup.group+"/members", "")).Items
+				if len(members) != lineup.n {
+					t.Errorf("group %s lineup today = %+v, want %d members", lineup.group, members, lineup.n)
+				}
+			}
+
+			// Edits that keep the group leave the memberships alone.
+			if w := do(t, h, http.MethodPatch, "/api/idols/1", `{"position":"Main Dancer"}`, "Content-Type", "application/merge-patch+json"); w.Code != http.StatusOK {
+				t.Fatalf("patch: status %d, body %s", w.Code, w.Body)
+			}
+			if list := decodeBody[struct{ Items []models.Membership }](t, do(t, h, http.MethodGet, "/api/idols/1/groups", "")).Items; len(list) != 2 {
+				t.Errorf("memberships after an edit in place = %+v", list)
+			}
+		})
+	}
+}
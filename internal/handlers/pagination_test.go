@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"kpopapi/internal/models"
+	"kpopapi/internal/store"
+)
+
+// newPagedServer returns newIdolServer holding n idols named Trainee 000
+// and up, created in name order.
+func newPagedServer(t *testing.T, n int) (*store.Memory, http.Handler) {
+	t.Helper()
+	s, h := newIdolServer(t)
+	for i := range n {
+		if _, err := s.Create(context.Background(), models.Idol{Name: fmt.Sprintf("Trainee %03d", i), Group: "NCT", Position: "Main Vocalist"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s, h
+}
+
+// TestIdolListPages follows next_cursor at several page sizes and checks
+// that every idol comes back once, in order.
+func TestIdolListPages(t *testing.T) {
+	_, h := newPagedServer(t, 7)
+	tests := []struct {
+		limit string
+		pages int
+	}{
+		{"1", 7},
+		{"3", 3},
+		{"7", 1},
+		{"100", 1},
+		// Past maxPageSize is clamped, not rejected.
+		{"1000", 1},
+	}
+	for _, tt := range tests {
+		t.Run("limit="+tt.limit, func(t *testing.T) {
+			var names []string
+			pages, cursor := 0, ""
+			for {
+				w := do(t, h, http.MethodGet, "/api/idols?limit="+tt.limit+"&cursor="+cursor, "")
+				if w.Code != http.StatusOK {
+					t.Fatalf("page %d: status %d, body %s", pages, w.Code, w.Body)
+				}
+				page := decodeBody[store.Page](t, w)
+				pages++
+				for _, it := range page.Items {
+					names = append(names, it.Name)
+				}
+				if cursor = page.NextCursor; cursor == "" || pages > 10 {
+					break
+				}
+			}
+			if pages != tt.pages || len(names) != 7 || names[0] != "Trainee 000" || names[6] != "Trainee 006" {
+				t.Errorf("%d pages of %v", pages, names)
+			}
+		})
+	}
+}
+
+func TestIdolListDefaultPageSize(t *testing.T) {
+	_, h := newPagedServer(t, defaultPageSize+1)
+	page := decodeBody[store.Page](t, do(t, h, http.MethodGet, "/api/idols", ""))
+	if len(page.Items) != defaultPageSize || page.NextCursor == "" {
+		t.Errorf("%d items, cursor %q; want %d and a cursor", len(page.Items), page.NextCursor, defaultPageSize)
+	}
+}
+
+func TestIdolListPagingErrors(t *testing.T) {
+	_, h := newPagedServer(t, 2)
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"limit=0", "limit"},
+		{"limit=-1", "limit"},
+		{"limit=ten", "limit"},
+		{"cursor=bogus", "cursor"},
+		{"cursor=" + strings.Repeat("A", 40), "cursor"},
+	}
+	for _, tt := range tests {
+		w := do(t, h, http.MethodGet, "/api/idols?"+tt.query, "")
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("?%s: status %d, body %s; want 400 about %s", tt.query, w.Code, w.Body, tt.want)
+		}
+	}
+}
+
+func TestIdolListArrayFormat(t *testing.T) {
+	_, h := newPagedServer(t, 3)
+	w := do(t, h, http.MethodGet, "/api/idols?format=array&limit=2", "")
+	items := decodeBody[[]models.Idol](t, w)
+	cursor := w.Header().Get("X-Next-Cursor")
+	if len(items) != 2 || cursor == "" {
+		t.Fatalf("first page: %d items, X-Next-Cursor %q", len(items), cursor)
+	}
+	w = do(t, h, http.MethodGet, "/api/idols?format=array&limit=2&cursor="+cursor, "")
+	if items := decodeBody[[]models.Idol](t, w); len(items) != 1 || items[0].Name != "Trainee 002" || w.Header().Get("X-Next-Cursor") != "" {
+		t.Errorf("last page: %+v, X-Next-Cursor %q", items, w.Header().Get("X-Next-Cursor"))
+	}
+}
+
+// TestIdolListArrayIsWhole checks that ?format=array without a limit still
+// returns every idol, past the largest page.
+func TestIdolListArrayIsWhole(t *testing.T) {
+	n := maxPageSize + 5
+	_, h := newPagedServer(t, n)
+	w := do(t, h, http.MethodGet, "/api/idols?format=array", "")
+	items := decodeBody[[]models.Idol](t, w)
+	if len(items) != n || w.Header().Get("X-Next-Cursor") != "" {
+		t.Fatalf("%d items, X-Next-Cursor %q; want %d and none", len(items), w.Header().Get("X-Next-Cursor"), n)
+	}
+	if items[0].Name != "Trainee 000" || items[n-1].Name != fmt.Sprintf("Trainee %03d", n-1) {
+		t.Errorf("order: first %s, last %s", items[0].Name, items[n-1].Name)
+	}
+}
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	_ "golang.org/x/image/webp"
+
+	"kpopapi/internal/models"
+	"kpopapi/internal/storage"
+	"kpopapi/internal/store"
+	"kpopapi/pkg/imaging"
+)
+
+const (
+	// maxPhotoSize caps an uploaded image file.
+	maxPhotoSize = 10 << 20
+	// maxPhotoPixels guards against small files that decode to huge images.
+	maxPhotoPixels = 40_000_000
+	photoQuality   = 90
+	// filesPrefix is the route storage keys are served under.
+	filesPrefix = "/api/files/"
+)
+
+// photoTypes are the accepted upload types, as sniffed from the content.
+var photoTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/webp": true}
+
+// photoSizes are the thumbnails made of every upload, by longest side.
+var photoSizes = []struct {
+	name string
+	size int
+}{{"small", 160}, {"medium", 480}, {"large", 1280}}
+
+// HandleIdolPhotos serves GET and POST /api/idols/{id}/photos. POST takes
+// multipart/form-data with the image in the "photo" field. The image is
+// re-encoded, which drops EXIF and other metadata after its orientation has
+// been applied, and stored with its thumbnails.
+func HandleIdolPhotos(photos store.PhotoStore, files storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseID(r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			list, err := photos.ListPhotos(r.Context(), id)
+			if err != nil {
+				writeStoreError(w, err, "db error")
+				return
+			}
+			for i := range list {
+				photoURLs(&list[i])
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"items": list})
+		case http.MethodPost:
+			data, status, err := readPhotoUpload(w, r)
+			if err != nil {
+				writeJSON(w, status, map[string]string{"error": err.Error()})
+				return
+			}
+			ph, err := storePhoto(r.Context(), files, id, data)
+			if err != nil {
+				var invalid photoError
+				if errors.As(err, &invalid) {
+					writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+					return
+				}
+				log.Printf("photo upload: %v", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "storage error"})
+				return
+			}
+			ph.IdolID, ph.UploadedBy = id, actor(r)
+			saved, err := photos.AddPhoto(r.Context(), ph)
+			if err != nil {
+				deleteVariants(files, ph.Variants)
+				writeStoreError(w, err, "insert error")
+				return
+			}
+			photoURLs(&saved)
+			writeJSON(w, http.StatusCreated, saved)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// HandleFiles serves GET /api/files/{key...} from storage. Keys are random
+// per upload and never reused, so the files can be cached for long.
+func HandleFiles(files storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		key := r.PathValue("key")
+		f, err := files.Open(r.Context(), key)
+		if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidKey) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "storage error"})
+			return
+		}
+		defer f.Close()
+		w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		http.ServeContent(w, r, key, time.Time{}, f)
+	}
+}
+
+// photoError is an upload that is not a usable image.
+type photoError string
+
+func (e photoError) Error() string { return string(e) }
+
+// readPhotoUpload returns the "photo" part of a multipart upload. On failure
+// it also returns the status to answer.
+func readPhotoUpload(w http.ResponseWriter, r *http.Request) ([]byte, int, error) {
+	// Room for the other parts and the multipart framing.
+	r.Body = http.MaxBytesReader(w, r.Body, maxPhotoSize+64<<10)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, http.StatusUnsupportedMediaType, errors.New("body must be multipart/form-data")
+	}
+	for {
+		part, err := mr.NextPart()
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("photo must be at most %d bytes", maxPhotoSize)
+		}
+		if err == io.EOF {
+			return nil, http.StatusBadRequest, errors.New(`missing "photo" field`)
+		}
+		if err != nil {
+			return nil, http.StatusBadRequest, errors.New("invalid multipart body")
+		}
+		if part.FormName() != "photo" {
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(part, maxPhotoSize+1))
+		if errors.As(err, &tooLarge) || len(data) > maxPhotoSize {
+			return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("photo must be at most %d bytes", maxPhotoSize)
+		}
+		if err != nil {
+			return nil, http.StatusBadRequest, errors.New("invalid multipart body")
+		}
+		if ct := http.DetectContentType(data); !photoTypes[ct] {
+			return nil, http.StatusUnsupportedMediaType, errors.New("photo must be a JPEG, PNG or WebP image")
+		}
+		return data, 0, nil
+	}
+}
+
+// storePhoto decodes an upload, writes the re-encoded original and its
+// thumbnails under a fresh key prefix and returns the photo without its
+// idol and uploader.
+func storePhoto(ctx context.Context, files storage.Storage, idolID int64, data []byte) (models.Photo, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return models.Photo{}, photoError("photo cannot be decoded")
+	}
+	if cfg.Width*cfg.Height > maxPhotoPixels {
+		return models.Photo{}, photoError(fmt.Sprintf("photo must be at most %d pixels", maxPhotoPixels))
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return models.Photo{}, photoError("photo cannot be decoded")
+	}
+	if format == "jpeg" {
+		img = imaging.Orient(img, imaging.Orientation(data))
+	}
+	// There is no WebP encoder in the standard library; opaque images are
+	// kept as JPEG and the rest as PNG.
+	ext, contentType := "jpg", "image/jpeg"
+	if format == "png" || !isOpaque(img) {
+		ext, contentType = "png", "image/png"
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return models.Photo{}, err
+	}
+	prefix := fmt.Sprintf("photos/%d/%s/", idolID, hex.EncodeToString(token))
+	type rendition struct {
+		name string
+		img  image.Image
+	}
+	renditions := []rendition{{"original", img}}
+	for _, s := range photoSizes {
+		renditions = append(renditions, rendition{s.name, imaging.Fit(img, s.size)})
+	}
+	ph := models.Photo{ContentType: contentType}
+	for _, rd := range renditions {
+		v, size, err := putVariant(ctx, files, prefix+rd.name+"."+ext, rd.img)
+		if err != nil {
+			deleteVariants(files, ph.Variants)
+			return models.Photo{}, err
+		}
+		v.Name = rd.name
+		if rd.name == "original" {
+			ph.Width, ph.Height, ph.Size = v.Width, v.Height, size
+		}
+		ph.Variants = append(ph.Variants, v)
+	}
+	return ph, nil
+}
+
+// putVariant encodes img by the extension of key and stores it.
+func putVariant(ctx context.Context, files storage.Storage, key string, img image.Image) (models.PhotoVariant, int64, error) {
+	var buf bytes.Buffer
+	var err error
+	if strings.HasSuffix(key, ".png") {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: photoQuality})
+	}
+	if err != nil {
+		return models.PhotoVariant{}, 0, err
+	}
+	size := int64(buf.Len())
+	if err := files.Put(ctx, key, &buf); err != nil {
+		return models.PhotoVariant{}, 0, err
+	}
+	b := img.Bounds()
+	return models.PhotoVariant{Key: key, Width: b.Dx(), Height: b.Dy()}, size, nil
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// deleteVariants removes the files of a photo that could not be recorded.
+func deleteVariants(files storage.Storage, variants []models.PhotoVariant) {
+	for _, v := range variants {
+		if err := files.Delete(context.Background(), v.Key); err != nil {
+			log.Printf("photo cleanup: %v", err)
+		}
+	}
+}
+
+func photoURLs(ph *models.Photo) {
+	for i := range ph.Variants {
+		ph.Variants[i].URL = filesPrefix + ph.Variants[i].Key
+	}
+}
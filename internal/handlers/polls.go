@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"kpopapi/internal/models"
+	"kpopapi/internal/store"
+	"kpopapi/pkg/validation"
+)
+
+const (
+	// maxQuestionLen matches the VARCHAR(200) polls.question column.
+	maxQuestionLen = 200
+	// minPollOptions and maxPollOptions bound the options of a poll.
+	minPollOptions = 2
+	maxPollOptions = 20
+)
+
+type pollOptionInput struct {
+	IdolID  *int64 `json:"idol_id"`
+	GroupID *int64 `json:"group_id"`
+}
+
+// pollInput is a poll as clients write it. Times take the same forms as
+// event times; opens_at defaults to now on create. Options can only be set
+// on create.
+type pollInput struct {
+	Question string            `json:"question"`
+	OpensAt  string            `json:"opens_at"`
+	ClosesAt string            `json:"closes_at"`
+	Options  []pollOptionInput `json:"options"`
+
+	opens, closes time.Time
+}
+
+// validate cleans the text fields in place, checks the payload and parses
+// its times. create tells POST, which takes options, from PUT.
+func (in *pollInput) validate(create bool) error {
+	validation.CleanAll(&in.Question, &in.OpensAt, &in.ClosesAt)
+	var v validation.Validator
+	if v.Required("question", in.Question) {
+		v.MaxLen("question", in.Question, maxQuestionLen)
+	}
+	in.opens = time.Now().In(models.KST)
+	if in.OpensAt != "" || !create {
+		if v.Required("opens_at", in.OpensAt) {
+			t, err := parseEventTime(in.OpensAt, false)
+			if err != nil {
+				v.Add("opens_at", validation.CodeInvalid, "opens_at "+err.Error())
+			}
+			in.opens = t
+		}
+	}
+	if v.Required("closes_at", in.ClosesAt) {
+		t, err := parseEventTime(in.ClosesAt, false)
+		switch {
+		case err != nil:
+			v.Add("closes_at", validation.CodeInvalid, "closes_at "+err.Error())
+		case !t.After(in.opens):
+			v.Add("closes_at", validation.CodeInvalid, "closes_at must be after opens_at")
+		}
+		in.closes = t
+	}
+	if !create {
+		v.Check(len(in.Options) == 0, "options", validation.CodeInvalid, "options cannot be changed")
+		return v.Err()
+	}
+	v.Check(len(in.Options) >= minPollOptions && len(in.Options) <= maxPollOptions, "options", validation.CodeInvalid,
+		fmt.Sprintf("options must have %d to %d entries", minPollOptions, maxPollOptions))
+	seen := map[string]bool{}
+	for i, o := range in.Options {
+		field := fmt.Sprintf("options[%d]", i)
+		var key string
+		switch {
+		case o.IdolID == nil && o.GroupID == nil:
+			v.Add(field, validation.CodeRequired, field+" needs an idol_id or a group_id")
+			continue
+		case o.IdolID != nil && o.GroupID != nil:
+			v.Add(field, validation.CodeInvalid, field+" takes an idol_id or a group_id, not both")
+			continue
+		case o.IdolID != nil:
+			v.Check(*o.IdolID > 0, field+".idol_id", validation.CodeInvalid, field+".idol_id must be positive")
+			key = fmt.Sprint("idol ", *o.IdolID)
+		default:
+			v.Check(*o.GroupID > 0, field+".group_id", validation.CodeInvalid, field+".group_id must be positive")
+			key = fmt.Sprint("group ", *o.GroupID)
+		}
+		if seen[key] {
+			v.Add(field, validation.CodeInvalid, field+" repeats an earlier option")
+		}
+		seen[key] = true
+	}
+	return v.Err()
+}
+
+func (in pollInput) poll() models.Poll {
+	p := models.Poll{Question: in.Question, OpensAt: in.opens, ClosesAt: in.closes}
+	for _, o := range in.Options {
+		p.Options = append(p.Options, models.PollOption{IdolID: o.IdolID, GroupID: o.GroupID})
+	}
+	return p
+}
+
+// decodePoll reads a poll body for an admin write.
+func decodePoll(w http.ResponseWriter, r *http.Request, create bool) (pollInput, bool) {
+	var in pollInput
+	if !isAdmin(r) {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "admin only"})
+		return in, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+		return in, false
+	}
+	if err := in.validate(create); err != nil {
+		writeInvalid(w, err)
+		return in, false
+	}
+	return in, true
+}
+
+// HandlePolls serves GET and POST /api/polls. GET takes an optional
+// ?state=upcoming|open|closed filter. Writes are admin only.
+func HandlePolls(polls store.PollStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			state := r.URL.Query().Get("state")
+			switch state {
+			case "", models.PollUpcoming, models.PollOpen, models.PollClosed:
+			default:
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid state"})
+				return
+			}
+			list, err := polls.ListPolls(r.Context())
+			if err != nil {
+				writeStoreError(w, err, "db error")
+				return
+			}
+			if state != "" {
+				kept := []models.Poll{}
+				for _, p := range list {
+					if p.State == state {
+						kept = append(kept, p)
+					}
+				}
+				list = kept
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"items": list})
+		case http.MethodPost:
+			in, ok := decodePoll(w, r, true)
+			if !ok {
+				return
+			}
+			p := in.poll()
+			p.CreatedBy = actor(r)
+			created, err := polls.CreatePoll(r.Context(), p)
+			if err != nil {
+				writeStoreError(w, err, "insert error")
+				return
+			}
+			writeJSON(w, http.StatusCreated, created)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// HandlePollByID serves GET, PUT and DELETE /api/polls/{id}. GET adds the
+// caller's vote as my_vote. Writes are admin only; PUT changes the question
+// and times but not the options.
+func HandlePollByID(polls store.PollStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseID(r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			p, err := polls.GetPoll(r.Context(), id)
+			if err != nil {
+				writeStoreError(w, err, "db error")
+				return
+			}
+			optionID, err := polls.MyVote(r.Context(), id, actor(r))
+			if err != nil {
+				writeStoreError(w, err, "db error")
+				return
+			}
+			if optionID != 0 {
+				p.MyVote = &optionID
+			}
+			writeJSON(w, http.StatusOK, p)
+		case http.MethodPut:
+			in, ok := decodePoll(w, r, false)
+			if !ok {
+				return
+			}
+			p := in.poll()
+			p.ID = id
+			updated, err := polls.UpdatePoll(r.Context(), p)
+			if err != nil {
+				writeStoreError(w, err, "update error")
+				return
+			}
+			writeJSON(w, http.StatusOK, updated)
+		case http.MethodDelete:
+			if !isAdmin(r) {
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": "admin only"})
+				return
+			}
+			if err := polls.DeletePoll(r.Context(), id); err != nil {
+				writeStoreError(w, err, "delete error")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+type voteInput struct {
+	OptionID int64 `json:"option_id"`
+}
+
+func (in *voteInput) validate() error {
+	var v validation.Validator
+	v.Check(in.OptionID != 0, "option_id", validation.CodeRequired, "option_id is required")
+	v.Check(in.OptionID >= 0, "option_id", validation.CodeInvalid, "option_id must be positive")
+	return v.Err()
+}
+
+// HandlePollVotes serves POST /api/polls/{id}/votes, the caller's one vote
+// in an open poll. It answers with the poll and its updated tally.
+func HandlePollVotes(polls store.PollStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		id, err := parseID(r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		user, ok := currentUser(w, r)
+		if !ok {
+			return
+		}
+		var in voteInput
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+			return
+		}
+		if err := in.validate(); err != nil {
+			writeInvalid(w, err)
+			return
+		}
+		p, err := polls.Vote(r.Context(), id, in.OptionID, user)
+		if err != nil {
+			writeStoreError(w, err, "insert error")
+			return
+		}
+		p.MyVote = &in.OptionID
+		writeJSON(w, http.StatusCreated, p)
+	}
+}
@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"kpopapi/internal/models"
+	"kpopapi/pkg/validation"
+)
+
+// newPollServer adds the poll endpoints to newIdolServer, without claims
+// of their own, and creates Karina (1), Winter (2) and the open poll 1 with
+// options Karina (1), Winter (2) and NCT (3).
+func newPollServer(t *testing.T) http.Handler {
+	t.Helper()
+	s, h := newIdolServer(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/polls", HandlePolls(s))
+	mux.HandleFunc("/api/polls/{id}", HandlePollByID(s))
+	mux.HandleFunc("/api/polls/{id}/votes", HandlePollVotes(s))
+	mux.Handle("/", h)
+	createIdol(t, mux, `{"name":"Karina","group_name":"AESPA","position":"Leader"}`)
+	createIdol(t, mux, `{"name":"Winter","group_name":"AESPA","position":"Main Vocalist"}`)
+	createPoll(t, mux, time.Hour, `{"idol_id":1},{"idol_id":2},{"group_id":2}`)
+	return mux
+}
+
+// at formats now+d as a poll time.
+func at(d time.Duration) string {
+	return time.Now().Add(d).Format(time.RFC3339)
+}
+
+// createPoll creates, as admin, a poll that closes after closesIn.
+func createPoll(t *testing.T, h http.Handler, closesIn time.Duration, options string) models.Poll {
+	t.Helper()
+	body := fmt.Sprintf(`{"question":"Best vocals?","closes_at":%q,"options":[%s]}`, at(closesIn), options)
+	w := do(t, withAdmin(h), http.MethodPost, "/api/polls", body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create poll: status %d, body %s", w.Code, w.Body)
+	}
+	return decodeBody[models.Poll](t, w)
+}
+
+// tally renders the votes of each option of p and its total as "1,0,0=1".
+func tally(p models.Poll) string {
+	var votes []string
+	for _, o := range p.Options {
+		votes = append(votes, fmt.Sprint(o.Votes))
+	}
+	return fmt.Sprintf("%s=%d", strings.Join(votes, ","), p.TotalVotes)
+}
+
+func TestPollVoteOncePerUser(t *testing.T) {
+	h := newPollServer(t)
+	vote := func(user string, option int) *httptest.ResponseRecorder {
+		return do(t, asUser(h, user), http.MethodPost, "/api/polls/1/votes", fmt.Sprintf(`{"option_id":%d}`, option))
+	}
+	tests := []struct {
+		user   string
+		option int
+		status int
+		tally  string
+	}{
+		{"karina", 1, http.StatusCreated, "1,0,0=1"},
+		{"winter", 2, http.StatusCreated, "1,1,0=2"},
+		{"giselle", 2, http.StatusCreated, "1,2,0=3"},
+		{"karina", 2, http.StatusConflict, "1,2,0=3"},
+		{"karina", 1, http.StatusConflict, "1,2,0=3"},
+		{"ningning", 3, http.StatusCreated, "1,2,1=4"},
+	}
+	for _, tt := range tests {
+		w := vote(tt.user, tt.option)
+		if w.Code != tt.status {
+			t.Fatalf("%s votes %d: status %d, want %d (body %s)", tt.user, tt.option, w.Code, tt.status, w.Body)
+		}
+		if w.Code == http.StatusCreated {
+			p := decodeBody[models.Poll](t, w)
+			if got := tally(p); got != tt.tally {
+				t.Errorf("%s votes %d: answered tally %s, want %s", tt.user, tt.option, got, tt.tally)
+			}
+			if p.MyVote == nil || *p.MyVote != int64(tt.option) {
+				t.Errorf("%s votes %d: my_vote %v", tt.user, tt.option, p.MyVote)
+			}
+		}
+		if got := tally(decodeBody[models.Poll](t, do(t, h, http.MethodGet, "/api/polls/1", ""))); got != tt.tally {
+			t.Errorf("%s votes %d: stored tally %s, want %s", tt.user, tt.option, got, tt.tally)
+		}
+	}
+
+	// A refused second vote does not change the first.
+	if p := decodeBody[models.Poll](t, do(t, asUser(h, "karina"), http.MethodGet, "/api/polls/1", "")); p.MyVote == nil || *p.MyVote != 1 {
+		t.Errorf("karina's my_vote = %v, want 1", p.MyVote)
+	}
+	if p := decodeBody[models.Poll](t, do(t, asUser(h, "aeri"), http.MethodGet, "/api/polls/1", "")); p.MyVote != nil {
+		t.Errorf("my_vote of a user who did not vote = %v", *p.MyVote)
+	}
+}
+
+// TestPollVoteRace sends many votes at once and checks that each user
+// counts once and every counted vote is in the tally.
+func TestPollVoteRace(t *testing.T) {
+	h := newPollServer(t)
+	const users, tries = 10, 5
+	var mu sync.Mutex
+	statuses := map[string][]int{}
+	var wg sync.WaitGroup
+	for u := range users {
+		for range tries {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				user := fmt.Sprint("user", u)
+				w := do(t, asUser(h, user), http.MethodPost, "/api/polls/1/votes", fmt.Sprintf(`{"option_id":%d}`, u%3+1))
+				mu.Lock()
+				statuses[user] = append(statuses[user], w.Code)
+				mu.Unlock()
+			}()
+		}
+	}
+	wg.Wait()
+	for user, got := range statuses {
+		created := 0
+		for _, status := range got {
+			switch status {
+			case http.StatusCreated:
+				created++
+			case http.StatusConflict:
+			default:
+				t.Errorf("%s: status %d", user, status)
+			}
+		}
+		if created != 1 {
+			t.Errorf("%s: %d votes counted, want 1", user, created)
+		}
+	}
+	if got := tally(decodeBody[models.Poll](t, do(t, h, http.MethodGet, "/api/polls/1", ""))); got != "4,3,3=10" {
+		t.Errorf("tally %s, want 4,3,3=10", got)
+	}
+}
+
+func TestPollVoteErrors(t *testing.T) {
+	h := newPollServer(t)
+	admin := withAdmin(h)
+	for _, body := range []string{
+		fmt.Sprintf(`{"question":"Upcoming","opens_at":%q,"closes_at":%q,"options":[{"idol_id":1},{"idol_id":2}]}`, at(time.Hour), at(2*time.Hour)),
+		fmt.Sprintf(`{"question":"Closed","opens_at":%q,"closes_at":%q,"options":[{"idol_id":1},{"idol_id":2}]}`, at(-2*time.Hour), at(-time.Hour)),
+	} {
+		if w := do(t, admin, http.MethodPost, "/api/polls", body); w.Code != http.StatusCreated {
+			t.Fatalf("create: status %d, body %s", w.Code, w.Body)
+		}
+	}
+	user := asUser(h, "karina")
+	tests := []struct {
+		name    string
+		handler http.Handler
+		method  string
+		target  string
+		body    string
+		status  int
+	}{
+		{"upcoming poll", user, http.MethodPost, "/api/polls/2/votes", `{"option_id":4}`, http.StatusConflict},
+		{"closed poll", user, http.MethodPost, "/api/polls/3/votes", `{"option_id":6}`, http.StatusConflict},
+		{"option of another poll", user, http.MethodPost, "/api/polls/1/votes", `{"option_id":4}`, http.StatusUnprocessableEntity},
+		{"missing option", user, http.MethodPost, "/api/polls/1/votes", `{"option_id":99}`, http.StatusUnprocessableEntity},
+		{"missing poll", user, http.MethodPost, "/api/polls/9/votes", `{"option_id":1}`, http.StatusNotFound},
+		{"without a token", h, http.MethodPost, "/api/polls/1/votes", `{"option_id":1}`, http.StatusUnauthorized},
+		{"without an option", user, http.MethodPost, "/api/polls/1/votes", `{}`, http.StatusUnprocessableEntity},
+		{"negative option", user, http.MethodPost, "/api/polls/1/votes", `{"option_id":-1}`, http.StatusUnprocessableEntity},
+		{"invalid json", user, http.MethodPost, "/api/polls/1/votes", `{"option_id":"one"}`, http.StatusBadRequest},
+		{"bad poll id", user, http.MethodPost, "/api/polls/first/votes", `{"option_id":1}`, http.StatusBadRequest},
+		{"GET votes", user, http.MethodGet, "/api/polls/1/votes", "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := do(t, tt.handler, tt.method, tt.target, tt.body); w.Code != tt.status {
+				t.Errorf("status %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+	// None of the refused votes counted, nor blocked a real one.
+	if got := tally(decodeBody[models.Poll](t, do(t, h, http.MethodGet, "/api/polls/1", ""))); got != "0,0,0=0" {
+		t.Errorf("tally %s after refused votes", got)
+	}
+	if w := do(t, user, http.MethodPost, "/api/polls/1/votes", `{"option_id":1}`); w.Code != http.StatusCreated {
+		t.Errorf("first real vote: status %d, body %s", w.Code, w.Body)
+	}
+}
+
+func TestPollValidation(t *testing.T) {
+	h := withAdmin(newPollServer(t))
+	closes := at(time.Hour)
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		want   map[string]string
+	}{
+		{"missing question", http.MethodPost, "/api/polls", `{"closes_at":"` + closes + `","options":[{"idol_id":1},{"idol_id":2}]}`,
+			map[string]string{"question": validation.CodeRequired}},
+		{"question too long", http.MethodPost, "/api/polls", `{"question":"` + strings.Repeat("?", 201) + `","closes_at":"` + closes + `","options":[{"idol_id":1},{"idol_id":2}]}`,
+			map[string]string{"question": validation.CodeTooLong}},
+		{"missing closes_at", http.MethodPost, "/api/polls", `{"question":"Best?","options":[{"idol_id":1},{"idol_id":2}]}`,
+			map[string]string{"closes_at": validation.CodeRequired}},
+		{"closes before it opens", http.MethodPost, "/api/polls", `{"question":"Best?","closes_at":"` + at(-time.Hour) + `","options":[{"idol_id":1},{"idol_id":2}]}`,
+			map[string]string{"closes_at": validation.CodeInvalid}},
+		{"bad opens_at", http.MethodPost, "/api/polls", `{"question":"Best?","opens_at":"soon","closes_at":"` + closes + `","options":[{"idol_id":1},{"idol_id":2}]}`,
+			map[string]string{"opens_at": validation.CodeInvalid}},
+		{"one option", http.MethodPost, "/api/polls", `{"question":"Best?","closes_at":"` + closes + `","options":[{"idol_id":1}]}`,
+			map[string]string{"options": validation.CodeInvalid}},
+		{"empty option", http.MethodPost, "/api/polls", `{"question":"Best?","closes_at":"` + closes + `","options":[{"idol_id":1},{}]}`,
+			map[string]string{"options[1]": validation.CodeRequired}},
+		{"idol and group", http.MethodPost, "/api/polls", `{"question":"Best?","closes_at":"` + closes + `","options":[{"idol_id":1},{"idol_id":2,"group_id":1}]}`,
+			map[string]string{"options[1]": validation.CodeInvalid}},
+		{"option id", http.MethodPost, "/api/polls", `{"question":"Best?","closes_at":"` + closes + `","options":[{"idol_id":1},{"group_id":0}]}`,
+			map[string]string{"options[1].group_id": validation.CodeInvalid}},
+		{"repeated option", http.MethodPost, "/api/polls", `{"question":"Best?","closes_at":"` + closes + `","options":[{"idol_id":1},{"idol_id":1}]}`,
+			map[string]string{"options[1]": validation.CodeInvalid}},
+		{"update without opens_at", http.MethodPut, "/api/polls/1", `{"question":"Best?","closes_at":"` + closes + `"}`,
+			map[string]string{"opens_at": validation.CodeRequired}},
+		{"update with options", http.MethodPut, "/api/polls/1", `{"question":"Best?","opens_at":"` + at(-time.Hour) + `","closes_at":"` + closes + `","options":[{"idol_id":1},{"idol_id":2}]}`,
+			map[string]string{"options": validation.CodeInvalid}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fieldErrors(t, do(t, h, tt.method, tt.target, tt.body)); !maps.Equal(got, tt.want) {
+				t.Errorf("errors %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPollLifecycle covers the admin writes, the state filter and what a
+// purge or a delete does to the votes.
+func TestPollLifecycle(t *testing.T) {
+	h := newPollServer(t)
+	admin, user := withAdmin(h), asUser(h, "karina")
+	if w := do(t, user, http.MethodPost, "/api/polls", `{"question":"Mine?"}`); w.Code != http.StatusForbidden {
+		t.Errorf("user POST: status %d, want 403", w.Code)
+	}
+	if w := do(t, user, http.MethodDelete, "/api/polls/1", ""); w.Code != http.StatusForbidden {
+		t.Errorf("user DELETE: status %d, want 403", w.Code)
+	}
+	body := fmt.Sprintf(`{"question":"Best?","closes_at":%q,"options":[{"idol_id":1},{"idol_id":9}]}`, at(time.Hour))
+	if w := do(t, admin, http.MethodPost, "/api/polls", body); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("unknown idol option: status %d, want 422", w.Code)
+	}
+
+	do(t, asUser(h, "winter"), http.MethodPost, "/api/polls/1/votes", `{"option_id":2}`)
+	do(t, user, http.MethodPost, "/api/polls/1/votes", `{"option_id":1}`)
+	// Moving the poll to the future closes it to votes but keeps the tally.
+	w := do(t, admin, http.MethodPut, "/api/polls/1", fmt.Sprintf(`{"question":"Best vocals ever?","opens_at":%q,"closes_at":%q}`, at(time.Hour), at(2*time.Hour)))
+	if p := decodeBody[models.Poll](t, w); w.Code != http.StatusOK || p.State != models.PollUpcoming || tally(p) != "1,1,0=2" || p.Question != "Best vocals ever?" {
+		t.Errorf("PUT: status %d, poll %+v", w.Code, p)
+	}
+	if w := do(t, asUser(h, "giselle"), http.MethodPost, "/api/polls/1/votes", `{"option_id":3}`); w.Code != http.StatusConflict {
+		t.Errorf("vote in an upcoming poll: status %d, want 409", w.Code)
+	}
+	createPoll(t, h, time.Hour, `{"idol_id":1},{"idol_id":2}`)
+
+	for _, tt := range []struct{ query, want string }{
+		{"", "1,2"},
+		{"?state=open", "2"},
+		{"?state=upcoming", "1"},
+		{"?state=closed", ""},
+	} {
+		var ids []string
+		for _, p := range decodeBody[struct{ Items []models.Poll }](t, do(t, h, http.MethodGet, "/api/polls"+tt.query, "")).Items {
+			ids = append(ids, fmt.Sprint(p.ID))
+		}
+		if strings.Join(ids, ",") != tt.want {
+			t.Errorf("list%s = %v, want %s", tt.query, ids, tt.want)
+		}
+	}
+	if w := do(t, h, http.MethodGet, "/api/polls?state=finished", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("bad state: status %d, want 400", w.Code)
+	}
+
+	// Purging an idol drops its option and the votes for it.
+	if w := do(t, h, http.MethodDelete, "/api/idols/2?hard=true", ""); w.Code != http.StatusOK {
+		t.Fatalf("purge: status %d", w.Code)
+	}
+	if p := decodeBody[models.Poll](t, do(t, h, http.MethodGet, "/api/polls/1", "")); len(p.Options) != 2 || tally(p) != "1,0=1" {
+		t.Errorf("after purge = %+v", p)
+	}
+
+	if w := do(t, admin, http.MethodDelete, "/api/polls/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("DELETE: status %d", w.Code)
+	}
+	if w := do(t, h, http.MethodGet, "/api/polls/1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET deleted: status %d, want 404", w.Code)
+	}
+	if w := do(t, admin, http.MethodDelete, "/api/polls/1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE twice: status %d, want 404", w.Code)
+	}
+}
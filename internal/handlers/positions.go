@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"kpopapi/internal/models"
+	"kpopapi/internal/store"
+	"kpopapi/pkg/validation"
+)
+
+type positionInput struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases"`
+}
+
+// validate cleans the name and aliases in place and checks them.
+func (in *positionInput) validate() error {
+	in.Name = validation.Clean(in.Name)
+	var v validation.Validator
+	v.Required("name", in.Name)
+	check := func(field, value string) {
+		v.MaxLen(field, value, maxFieldLen)
+		if len(store.SplitPositions(value)) > 1 {
+			v.Add(field, validation.CodeInvalid, fmt.Sprintf("%s must not contain , / & or ;", field))
+		}
+	}
+	check("name", in.Name)
+	for i := range in.Aliases {
+		in.Aliases[i] = validation.Clean(in.Aliases[i])
+		field := fmt.Sprintf("aliases[%d]", i)
+		if v.Required(field, in.Aliases[i]) {
+			check(field, in.Aliases[i])
+		}
+	}
+	return v.Err()
+}
+
+func (in positionInput) position() models.Position {
+	return models.Position{Name: in.Name, Aliases: in.Aliases}
+}
+
+// decodePosition reads a position body for an admin write.
+func decodePosition(w http.ResponseWriter, r *http.Request) (positionInput, bool) {
+	var in positionInput
+	if !isAdmin(r) {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "admin only"})
+		return in, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+		return in, false
+	}
+	if err := in.validate(); err != nil {
+		writeInvalid(w, err)
+		return in, false
+	}
+	return in, true
+}
+
+// HandlePositions serves GET and POST /api/positions. Writes are admin only.
+func HandlePositions(positions store.PositionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			list, err := positions.ListPositions(r.Context())
+			if err != nil {
+				writeStoreError(w, err, "db error")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"items": list})
+		case http.MethodPost:
+			in, ok := decodePosition(w, r)
+			if !ok {
+				return
+			}
+			p, err := positions.CreatePosition(r.Context(), in.position())
+			if err != nil {
+				writeStoreError(w, err, "insert error")
+				return
+			}
+			writeJSON(w, http.StatusCreated, p)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// HandlePositionByID serves GET, PUT and DELETE /api/positions/{id}. Writes
+// are admin only; renames propagate to idols.
+func HandlePositionByID(positions store.PositionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseID(r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			p, err := positions.GetPosition(r.Context(), id)
+			if err != nil {
+				writeStoreError(w, err, "db error")
+				return
+			}
+			writeJSON(w, http.StatusOK, p)
+		case http.MethodPut:
+			in, ok := decodePosition(w, r)
+			if !ok {
+				return
+			}
+			p := in.position()
+			p.ID = id
+			updated, err := positions.UpdatePosition(r.Context(), p, actor(r))
+			if err != nil {
+				writeStoreError(w, err, "update error")
+				return
+			}
+			writeJSON(w, http.StatusOK, updated)
+		case http.MethodDelete:
+			if !isAdmin(r) {
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": "admin only"})
+				return
+			}
+			if err := positions.DeletePosition(r.Context(), id); err != nil {
+				writeStoreError(w, err, "delete error")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"strings"
+	"testing"
+
+	"kpopapi/internal/models"
+	"kpopapi/internal/store"
+	"kpopapi/pkg/validation"
+)
+
+// newPositionServer adds the position catalogue and idol history to the
+// idol endpoints of newIdolServer.
+func newPositionServer(t *testing.T) (*store.Memory, http.Handler) {
+	t.Helper()
+	s, h := newIdolServer(t)
+	mux := http.NewServeMux()
+	mux.Handle("/api/positions", withAdmin(HandlePositions(s)))
+	mux.Handle("/api/positions/{id}", withAdmin(HandlePositionByID(s)))
+	mux.Handle("/api/idols/{id}/history", withAdmin(HandleIdolHistory(s)))
+	mux.Handle("/", h)
+	return s, mux
+}
+
+// positionID finds a catalogue entry by name.
+func positionID(t *testing.T, h http.Handler, name string) int64 {
+	t.Helper()
+	list := decodeBody[struct{ Items []models.Position }](t, do(t, h, http.MethodGet, "/api/positions", ""))
+	for _, p := range list.Items {
+		if p.Name == name {
+			return p.ID
+		}
+	}
+	t.Fatalf("no position %q", name)
+	return 0
+}
+
+// TestPositionRenameVersionsIdols renames a position and checks that the
+// idols holding it get a new version and an update revision, and that the
+// others are left alone.
+func TestPositionRenameVersionsIdols(t *testing.T) {
+	_, h := newPositionServer(t)
+	createIdol(t, h, `{"name":"Karina","group_name":"AESPA","positions":["Leader","Main Dancer"]}`)
+	createIdol(t, h, `{"name":"Winter","group_name":"AESPA","position":"Main Vocalist"}`)
+
+	id := positionID(t, h, "Main Dancer")
+	w := do(t, h, http.MethodPut, fmt.Sprintf("/api/positions/%d", id), `{"name":"Main Dance","aliases":["Dance Line Lead"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("rename: status %d, body %s", w.Code, w.Body)
+	}
+
+	w = do(t, h, http.MethodGet, "/api/idols/1", "")
+	karina := decodeBody[models.Idol](t, w)
+	if karina.Position != "Leader, Main Dance" || karina.Version != 2 || karina.UpdatedBy != "admin" || w.Header().Get("ETag") != `"2"` {
+		t.Errorf("renamed idol = %+v, ETag %s", karina, w.Header().Get("ETag"))
+	}
+	// The representation read before the rename is stale now.
+	stale := `{"name":"Karina","group_name":"AESPA","position":"Leader","version":1}`
+	if w := do(t, h, http.MethodPut, "/api/idols/1", stale); w.Code != http.StatusConflict {
+		t.Errorf("PUT with the pre-rename version: status %d, want 409", w.Code)
+	}
+
+	history := decodeBody[struct{ Items []models.IdolRevision }](t, do(t, h, http.MethodGet, "/api/idols/1/history", ""))
+	if n := len(history.Items); n != 2 {
+		t.Fatalf("history has %d revisions, want 2", n)
+	}
+	rev := history.Items[1]
+	if rev.Action != store.RevisionUpdate || rev.Version != 2 || rev.Actor != "admin" || rev.Snapshot.Position != "Leader, Main Dance" {
+		t.Errorf("rename revision = %+v", rev)
+	}
+	if old := history.Items[0].Snapshot.Positions; len(old) != 2 || old[1] != "Main Dancer" {
+		t.Errorf("first revision lost the old name: %v", old)
+	}
+
+	if winter := decodeBody[models.Idol](t, do(t, h, http.MethodGet, "/api/idols/2", "")); winter.Version != 1 {
+		t.Errorf("idol without the position was versioned: %+v", winter)
+	}
+
+	// Changing only the aliases leaves every idol as it is.
+	if w := do(t, h, http.MethodPut, fmt.Sprintf("/api/positions/%d", id), `{"name":"Main Dance"}`); w.Code != http.StatusOK {
+		t.Fatalf("alias edit: status %d, body %s", w.Code, w.Body)
+	}
+	if karina := decodeBody[models.Idol](t, do(t, h, http.MethodGet, "/api/idols/1", "")); karina.Version != 2 {
+		t.Errorf("alias edit versioned the idol: %+v", karina)
+	}
+}
+
+func TestPositionCatalogue(t *testing.T) {
+	_, h := newPositionServer(t)
+	w := do(t, h, http.MethodPost, "/api/positions", `{"name":" Lead Visual ","aliases":["Second Visual","lead visual"," Second Visual"]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST: status %d, body %s", w.Code, w.Body)
+	}
+	created := decodeBody[models.Position](t, w)
+	if created.Name != "Lead Visual" || len(created.Aliases) != 1 || created.Aliases[0] != "Second Visual" {
+		t.Errorf("created = %+v", created)
+	}
+	// Idols may name a position by any alias, in any case.
+	karina := createIdol(t, h, `{"name":"Karina","group_name":"AESPA","positions":["second visual","main dance"]}`)
+	if karina.Position != "Lead Visual, Main Dancer" {
+		t.Errorf("aliases resolved to %q", karina.Position)
+	}
+
+	id := fmt.Sprintf("/api/positions/%d", created.ID)
+	if got := decodeBody[models.Position](t, do(t, h, http.MethodGet, id, "")); got.Name != "Lead Visual" {
+		t.Errorf("GET = %+v", got)
+	}
+	if w := do(t, h, http.MethodDelete, id, ""); w.Code != http.StatusConflict {
+		t.Errorf("DELETE in use: status %d, want 409", w.Code)
+	}
+	if w := do(t, h, http.MethodDelete, "/api/idols/1?hard=true", ""); w.Code != http.StatusOK {
+		t.Fatalf("purge: status %d", w.Code)
+	}
+	if w := do(t, h, http.MethodDelete, id, ""); w.Code != http.StatusOK {
+		t.Fatalf("DELETE unused: status %d, body %s", w.Code, w.Body)
+	}
+	if w := do(t, h, http.MethodGet, id, ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET deleted: status %d, want 404", w.Code)
+	}
+	if w := do(t, h, http.MethodPost, "/api/idols", `{"name":"Winter","group_name":"AESPA","position":"Lead Visual"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("idol with a deleted position: status %d, want 422", w.Code)
+	}
+}
+
+func TestPositionErrors(t *testing.T) {
+	long := strings.Repeat("x", 101)
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+		fields map[string]string // field error codes of a 422 validation answer
+	}{
+		{"missing name", http.MethodPost, "/api/positions", `{}`, http.StatusUnprocessableEntity, map[string]string{"name": validation.CodeRequired}},
+		{"name too long", http.MethodPost, "/api/positions", `{"name":"` + long + `"}`, http.StatusUnprocessableEntity, map[string]string{"name": validation.CodeTooLong}},
+		{"name with a separator", http.MethodPost, "/api/positions", `{"name":"Rap/Vocal"}`, http.StatusUnprocessableEntity, map[string]string{"name": validation.CodeInvalid}},
+		{"blank alias", http.MethodPost, "/api/positions", `{"name":"Rap Line","aliases":["Rappers"," "]}`, http.StatusUnprocessableEntity, map[string]string{"aliases[1]": validation.CodeRequired}},
+		{"alias with a separator", http.MethodPost, "/api/positions", `{"name":"Rap Line","aliases":["Rap, Line"]}`, http.StatusUnprocessableEntity, map[string]string{"aliases[0]": validation.CodeInvalid}},
+		{"invalid json", http.MethodPost, "/api/positions", `{`, http.StatusBadRequest, nil},
+		{"duplicate name", http.MethodPost, "/api/positions", `{"name":"leader"}`, http.StatusConflict, nil},
+		{"name is another's alias", http.MethodPost, "/api/positions", `{"name":"Main Vocal"}`, http.StatusConflict, nil},
+		{"alias is another's name", http.MethodPost, "/api/positions", `{"name":"Captain","aliases":["Leader"]}`, http.StatusConflict, nil},
+		{"rename onto another", http.MethodPut, "/api/positions/1", `{"name":"Visual"}`, http.StatusConflict, nil},
+		// Karina's joined positions would outgrow the idol column.
+		{"rename overflows an idol", http.MethodPut, "/api/positions/1", `{"name":"` + long[:95] + `"}`, http.StatusUnprocessableEntity, nil},
+		{"update missing", http.MethodPut, "/api/positions/99", `{"name":"Captain"}`, http.StatusNotFound, nil},
+		{"delete in use", http.MethodDelete, "/api/positions/1", "", http.StatusConflict, nil},
+		{"delete missing", http.MethodDelete, "/api/positions/99", "", http.StatusNotFound, nil},
+		{"bad id", http.MethodGet, "/api/positions/first", "", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newPositionServer(t)
+			createIdol(t, h, `{"name":"Karina","group_name":"AESPA","positions":["Leader","Main Dancer"]}`)
+			if positionID(t, h, "Leader") != 1 {
+				t.Fatal("Leader is not position 1")
+			}
+			w := do(t, h, tt.method, tt.target, tt.body)
+			if tt.fields != nil {
+				if got := fieldErrors(t, w); !maps.Equal(got, tt.fields) {
+					t.Errorf("errors %v, want %v", got, tt.fields)
+				}
+				return
+			}
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+			// A refused write leaves the idol as it was.
+			if karina := decodeBody[models.Idol](t, do(t, h, http.MethodGet, "/api/idols/1", "")); karina.Position != "Leader, Main Dancer" || karina.Version != 1 {
+				t.Errorf("idol after a refused write = %+v", karina)
+			}
+		})
+	}
+}
+
+// TestPositionWritesAdminOnly checks that users can read the catalogue but
+// not change it.
+func TestPositionWritesAdminOnly(t *testing.T) {
+	s, _ := newIdolServer(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/positions", HandlePositions(s))
+	mux.HandleFunc("/api/positions/{id}", HandlePositionByID(s))
+	h := asUser(mux, "user2")
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{http.MethodGet, "/api/positions", "", http.StatusOK},
+		{http.MethodGet, "/api/positions/1", "", http.StatusOK},
+		{http.MethodPost, "/api/positions", `{"name":"Captain"}`, http.StatusForbidden},
+		{http.MethodPut, "/api/positions/1", `{"name":"Captain"}`, http.StatusForbidden},
+		{http.MethodDelete, "/api/positions/1", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if w := do(t, h, tt.method, tt.target, tt.body); w.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.target, w.Code, tt.status)
+		}
+	}
+	if p := decodeBody[models.Position](t, do(t, h, http.MethodGet, "/api/positions/1", "")); p.Name != "Leader" {
+		t.Errorf("position 1 = %+v", p)
+	}
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"maps"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"kpopapi/internal/models"
+	"kpopapi/pkg/validation"
+)
+
+const karinaProfile = `{"name":"Karina","group_name":"AESPA","position":"Leader",
+	"legal_name":"Yu Ji-min","hangul_name":"유지민","birth_date":"2000-04-11","nationality":"KR",
+	"debut_date":"2020-11-17","height_cm":168,"mbti":"ENTP","status":"hiatus"}`
+
+// TestIdolPutKeepsProfile sends the body of a client that predates the
+// profile fields and checks that they survive.
+func TestIdolPutKeepsProfile(t *testing.T) {
+	_, h := newIdolServer(t)
+	created := createIdol(t, h, karinaProfile)
+
+	w := do(t, h, http.MethodPut, "/api/idols/1", `{"name":"Karina","group_name":"AESPA","position":"Main Dancer","version":1}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body)
+	}
+	got := decodeBody[models.Idol](t, w)
+	if got.Position != "Main Dancer" || got.Version != 2 {
+		t.Errorf("update not applied: %+v", got)
+	}
+	if got.LegalName != created.LegalName || got.HangulName != created.HangulName || got.Nationality != "KR" ||
+		got.BirthDate == nil || got.BirthDate.String() != "2000-04-11" ||
+		got.DebutDate == nil || got.DebutDate.String() != "2020-11-17" ||
+		got.HeightCM == nil || *got.HeightCM != 168 || got.MBTI != "ENTP" || got.Status != models.IdolHiatus {
+		t.Errorf("profile lost: %+v", got)
+	}
+
+	// Fields the body names are still replaced, null and "" clearing them.
+	w = do(t, h, http.MethodPut, "/api/idols/1", `{"name":"Karina","group_name":"AESPA","position":"Leader",
+		"legal_name":"","birth_date":null,"status":"active","version":2}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body)
+	}
+	got = decodeBody[models.Idol](t, w)
+	if got.LegalName != "" || got.BirthDate != nil || got.Status != models.IdolActive {
+		t.Errorf("named fields not replaced: %+v", got)
+	}
+	if got.HangulName != created.HangulName || got.MBTI != "ENTP" {
+		t.Errorf("fields left out were not kept: %+v", got)
+	}
+}
+
+func TestIdolPutWithoutVersionKeepsProfile(t *testing.T) {
+	_, h := newIdolServer(t)
+	createIdol(t, h, karinaProfile)
+	w := do(t, h, http.MethodPut, "/api/idols/1", `{"name":"Karina","group_name":"AESPA","position":"Leader"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unconditional PUT: status %d, body %s", w.Code, w.Body)
+	}
+	if got := decodeBody[models.Idol](t, w); got.MBTI != "ENTP" || got.Version != 2 {
+		t.Errorf("got %+v", got)
+	}
+	if w := do(t, h, http.MethodPut, "/api/idols/9", `{"name":"Karina","group_name":"AESPA","position":"Leader"}`); w.Code != http.StatusNotFound {
+		t.Errorf("missing idol: status %d, want 404", w.Code)
+	}
+}
+
+func TestIdolProfileValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string // fields added to a valid idol body
+		want    map[string]string
+	}{
+		{"latin hangul name", `"hangul_name":"Yu Jimin"`, map[string]string{"hangul_name": validation.CodeInvalid}},
+		{"hangul name too long", `"hangul_name":"` + strings.Repeat("유", 101) + `"`, map[string]string{"hangul_name": validation.CodeTooLong}},
+		{"legal name too long", `"legal_name":"` + strings.Repeat("x", 101) + `"`, map[string]string{"legal_name": validation.CodeTooLong}},
+		{"unknown nationality", `"nationality":"XX"`, map[string]string{"nationality": validation.CodeInvalid}},
+		{"nationality not a country", `"nationality":"EU"`, map[string]string{"nationality": validation.CodeInvalid}},
+		{"mbti", `"mbti":"ABCD"`, map[string]string{"mbti": validation.CodeInvalid}},
+		{"mbti suffix", `"mbti":"ENTP-X"`, map[string]string{"mbti": validation.CodeInvalid}},
+		{"status", `"status":"retired"`, map[string]string{"status": validation.CodeOneOf}},
+		{"too short", `"height_cm":99`, map[string]string{"height_cm": validation.CodeInvalid}},
+		{"too tall", `"height_cm":251`, map[string]string{"height_cm": validation.CodeInvalid}},
+		{"born before 1900", `"birth_date":"1899-12-31"`, map[string]string{"birth_date": validation.CodeInvalid}},
+		// The stored debut date would precede this birth, so it comes too.
+		{"born in the future", `"birth_date":"` + time.Now().AddDate(1, 0, 0).Format(time.DateOnly) +
+			`","debut_date":"` + time.Now().AddDate(2, 0, 0).Format(time.DateOnly) + `"`, map[string]string{"birth_date": validation.CodeInvalid}},
+		{"debut before birth", `"birth_date":"2000-04-11","debut_date":"1999-01-01"`, map[string]string{"debut_date": validation.CodeInvalid}},
+		{"debut on the birthday", `"birth_date":"2000-04-11","debut_date":"2000-04-11"`, map[string]string{"debut_date": validation.CodeInvalid}},
+		{"every field at once", `"hangul_name":"Jimin","nationality":"XX","mbti":"X","status":"x","height_cm":1`, map[string]string{
+			"hangul_name": validation.CodeInvalid, "nationality": validation.CodeInvalid, "mbti": validation.CodeInvalid,
+			"status": validation.CodeOneOf, "height_cm": validation.CodeInvalid,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newIdolServer(t)
+			body := `{"name":"Karina","group_name":"AESPA","position":"Leader",` + tt.profile + `}`
+			if got := fieldErrors(t, do(t, h, http.MethodPost, "/api/idols", body)); !maps.Equal(got, tt.want) {
+				t.Errorf("POST errors %v, want %v", got, tt.want)
+			}
+			// Updates check the same fields, merge patches included.
+			createIdol(t, h, karinaProfile)
+			if got := fieldErrors(t, do(t, h, http.MethodPut, "/api/idols/1", body)); !maps.Equal(got, tt.want) {
+				t.Errorf("PUT errors %v, want %v", got, tt.want)
+			}
+			patch := do(t, h, http.MethodPatch, "/api/idols/1", `{`+tt.profile+`}`, "Content-Type", "application/merge-patch+json")
+			if got := fieldErrors(t, patch); !maps.Equal(got, tt.want) {
+				t.Errorf("PATCH errors %v, want %v", got, tt.want)
+			}
+			if got := decodeBody[models.Idol](t, do(t, h, http.MethodGet, "/api/idols/1", "")); got.Version != 1 {
+				t.Errorf("refused update was stored: %+v", got)
+			}
+		})
+	}
+}
+
+// TestIdolProfileNormalized checks the forms the profile fields are stored
+// in.
+func TestIdolProfileNormalized(t *testing.T) {
+	tests := []struct {
+		profile string
+		check   func(models.Idol) bool
+	}{
+		{`"nationality":"kr"`, func(it models.Idol) bool { return it.Nationality == "KR" }},
+		{`"nationality":"KOR"`, func(it models.Idol) bool { return it.Nationality == "KR" }},
+		{`"nationality":"410"`, func(it models.Idol) bool { return it.Nationality == "KR" }},
+		{`"mbti":" entp-a "`, func(it models.Idol) bool { return it.MBTI == "ENTP-A" }},
+		{`"status":"Departed"`, func(it models.Idol) bool { return it.Status == models.IdolDeparted }},
+		{`"hangul_name":" 유 지민 "`, func(it models.Idol) bool { return it.HangulName == "유 지민" }},
+		{`"height_cm":100`, func(it models.Idol) bool { return it.HeightCM != nil && *it.HeightCM == 100 }},
+		{`"birth_date":"1900-01-01"`, func(it models.Idol) bool { return it.BirthDate != nil && it.BirthDate.String() == "1900-01-01" }},
+		{``, func(it models.Idol) bool {
+			return it.Status == models.IdolActive && it.Nationality == "" && it.HeightCM == nil
+		}},
+	}
+	for _, tt := range tests {
+		_, h := newIdolServer(t)
+		body := `{"name":"Karina","group_name":"AESPA","position":"Leader"`
+		if tt.profile != "" {
+			body += "," + tt.profile
+		}
+		if got := createIdol(t, h, body+"}"); !tt.check(got) {
+			t.Errorf("%s stored as %+v", tt.profile, got)
+		}
+	}
+}
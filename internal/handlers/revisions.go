@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"kpopapi/internal/store"
+)
+
+// HandleIdolHistory serves GET /api/idols/{id}/history.
+func HandleIdolHistory(idols store.IdolStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		id, err := parseID(r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		revs, err := idols.History(r.Context(), id)
+		if err != nil {
+			writeStoreError(w, err, "db error")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"items": revs})
+	}
+}
+
+// HandleIdolDiff serves GET /api/idols/{id}/diff?from=&to=.
+func HandleIdolDiff(idols store.IdolStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		id, err := parseID(r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		from, err := versionParam(r, "from")
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		to, err := versionParam(r, "to")
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		a, err := idols.Revision(r.Context(), id, from)
+		if err != nil {
+			writeStoreError(w, err, "db error")
+			return
+		}
+		b, err := idols.Revision(r.Context(), id, to)
+		if err != nil {
+			writeStoreError(w, err, "db error")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"from": a, "to": b, "changes": store.Diff(a.Snapshot, b.Snapshot),
+		})
+	}
+}
+
+// HandleIdolRevert serves POST /api/idols/{id}/revert?to=, which writes the
+// fields of an old revision back as a new version.
+func HandleIdolRevert(idols store.IdolStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		id, err := parseID(r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		to, err := versionParam(r, "to")
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		it, err := idols.Revert(r.Context(), id, to, actor(r))
+		if err != nil {
+			writeStoreError(w, err, "revert error")
+			return
+		}
+		writeIdol(w, http.StatusOK, it)
+	}
+}
+
+func versionParam(r *http.Request, name string) (int, error) {
+	v, err := strconv.Atoi(r.URL.Query().Get(name))
+	if err != nil || v < 1 {
+		return 0, fmt.Errorf("%s must be a positive version number", name)
+	}
+	return v, nil
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"kpopapi/internal/store"
+)
+
+// newSearchServer adds the search endpoint to newIdolServer and creates
+// four idols, the last of them trashed.
+func newSearchServer(t *testing.T) http.Handler {
+	t.Helper()
+	s, h := newIdolServer(t)
+	mux := http.NewServeMux()
+	mux.Handle("/api/idols/search", withAdmin(HandleIdolSearch(s)))
+	mux.Handle("/", h)
+	createIdol(t, mux, `{"name":"Karina","group_name":"AESPA","position":"Leader"}`)
+	createIdol(t, mux, `{"name":"Winter","group_name":"AESPA","position":"Main Vocalist"}`)
+	createIdol(t, mux, `{"name":"Jisung","group_name":"NCT","position":"Main Dancer"}`)
+	createIdol(t, mux, `{"name":"Karin","group_name":"NCT","position":"Visual"}`)
+	if w := do(t, mux, http.MethodDelete, "/api/idols/4", ""); w.Code != http.StatusOK {
+		t.Fatalf("delete: status %d, body %s", w.Code, w.Body)
+	}
+	return mux
+}
+
+func TestIdolSearch(t *testing.T) {
+	h := newSearchServer(t)
+	tests := []struct {
+		name string
+		q    string
+		want string
+	}{
+		{"exact name", "Karina", "Karina"},
+		{"case and spacing", "  kARINA ", "Karina"},
+		{"typo", "Karena", "Karina"},
+		{"group", "aespa", "Karina,Winter"},
+		// Both words rank first; the group alone is still a fuzzy match.
+		{"every word", "winter aespa", "Winter,Karina"},
+		{"position", "dancer", "Jisung"},
+		{"no match", "Taeyeon", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(t, h, http.MethodGet, "/api/idols/search?q="+strings.ReplaceAll(tt.q, " ", "+"), "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d, body %s", w.Code, w.Body)
+			}
+			var got []string
+			for _, res := range decodeBody[struct{ Items []store.SearchResult }](t, w).Items {
+				got = append(got, res.Name)
+				if res.Score <= 0 {
+					t.Errorf("%s scored %v", res.Name, res.Score)
+				}
+			}
+			if strings.Join(got, ",") != tt.want {
+				t.Errorf("q=%q found %v, want %s", tt.q, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestIdolSearchRanking checks that an exact match outranks a near one.
+func TestIdolSearchRanking(t *testing.T) {
+	h := newSearchServer(t)
+	createIdol(t, h, `{"name":"Karin","group_name":"NCT","position":"Visual"}`)
+	list := decodeBody[struct{ Items []store.SearchResult }](t, do(t, h, http.MethodGet, "/api/idols/search?q=karina", "")).Items
+	if len(list) != 2 || list[0].Name != "Karina" || list[1].Name != "Karin" || list[0].Score <= list[1].Score {
+		t.Errorf("ranking = %+v", list)
+	}
+	if list := decodeBody[struct{ Items []store.SearchResult }](t, do(t, h, http.MethodGet, "/api/idols/search?q=karina&limit=1", "")).Items; len(list) != 1 {
+		t.Errorf("limit=1 returned %d results", len(list))
+	}
+}
+
+func TestIdolSearchErrors(t *testing.T) {
+	h := newSearchServer(t)
+	tests := []struct {
+		query  string
+		status int
+	}{
+		{"", http.StatusBadRequest},
+		{"q=%20%20", http.StatusBadRequest},
+		{"q=karina&limit=0", http.StatusBadRequest},
+		{"q=karina&limit=many", http.StatusBadRequest},
+		{"q=karina&limit=1000", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := do(t, h, http.MethodGet, "/api/idols/search?"+tt.query, ""); w.Code != tt.status {
+			t.Errorf("?%s: status %d, want %d", tt.query, w.Code, tt.status)
+		}
+	}
+	if w := do(t, h, http.MethodPost, "/api/idols/search?q=karina", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status %d, want 405", w.Code)
+	}
+}
@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"kpopapi/internal/auth"
+	"kpopapi/internal/models"
+	"kpopapi/internal/store"
+)
+
+const (
+	// streamPollInterval is how often the stream looks for new changes.
+	streamPollInterval = time.Second
+	// streamHeartbeat keeps idle connections open through proxies.
+	streamHeartbeat = 15 * time.Second
+	// streamBatch caps the changes read per query.
+	streamBatch = 100
+	// streamRetry is the reconnect delay suggested to clients, in ms.
+	streamRetry = 3000
+	// streamBuffer is how many changes a stream may fall behind the feed
+	// before it is dropped; the client resumes with Last-Event-ID.
+	streamBuffer = 256
+)
+
+// ChangeFeed polls the change log once for all connected streams and fans
+// new revisions out to them.
+type ChangeFeed struct {
+	changes store.ChangeStore
+	ready   chan struct{}
+
+	mu   sync.Mutex
+	subs map[chan models.IdolRevision]struct{}
+}
+
+// NewChangeFeed returns a feed over changes; Run must be started for it to
+// deliver anything.
+func NewChangeFeed(changes store.ChangeStore) *ChangeFeed {
+	return &ChangeFeed{
+		changes: changes,
+		ready:   make(chan struct{}),
+		subs:    map[chan models.IdolRevision]struct{}{},
+	}
+}
+
+// Run polls for changes every streamPollInterval until ctx is cancelled.
+// Only changes after the latest one at start are published.
+func (f *ChangeFeed) Run(ctx context.Context) {
+	ticker := time.NewTicker(streamPollInterval)
+	defer ticker.Stop()
+	last := int64(-1)
+	for {
+		if last < 0 {
+			id, err := f.changes.LastChangeID(ctx)
+			if err != nil {
+				log.Printf("change feed: %v", err)
+			} else {
+				last = id
+				close(f.ready)
+			}
+		}
+		for last >= 0 {
+			list, err := f.changes.Changes(ctx, last, streamBatch)
+			if err != nil {
+				log.Printf("change feed: %v", err)
+				break
+			}
+			for _, rev := range list {
+				f.publish(rev)
+				last = rev.ID
+			}
+			if len(list) < streamBatch {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// subscribe returns a channel that receives every change published from
+// now on. It waits until the feed knows where the log ends.
+func (f *ChangeFeed) subscribe(ctx context.Context) (chan models.IdolRevision, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-f.ready:
+	}
+	ch := make(chan models.IdolRevision, streamBuffer)
+	f.mu.Lock()
+	f.subs[ch] = struct{}{}
+	f.mu.Unlock()
+	return ch, nil
+}
+
+func (f *ChangeFeed) unsubscribe(ch chan models.IdolRevision) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.subs[ch]; ok {
+		delete(f.subs, ch)
+		close(ch)
+	}
+}
+
+// publish hands rev to every subscriber, closing those that fell too far
+// behind.
+func (f *ChangeFeed) publish(rev models.IdolRevision) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subs {
+		select {
+		case ch <- rev:
+		default:
+			delete(f.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// HandleIdolStream serves GET /api/idols/stream, the idol change log as
+// Server-Sent Events. Every create, update, delete, restore and revert is
+// an event named after the action, with the revision id as event id and
+// the revision as data. A Last-Event-ID header (or ?last_event_id=, for the
+// first connection) resumes after that id; without one the stream starts
+// with the next change. The stream ends with a token_expired event when the
+// bearer token expires and with token_revoked once revoked reports it
+// logged out.
+func HandleIdolStream(feed *ChangeFeed, revoked func(token string) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		claims, ok := auth.ClaimsFromContext(r.Context())
+		token, hasToken := auth.TokenFromContext(r.Context())
+		if !ok || !hasToken || claims.ExpiresAt == nil {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		raw := r.Header.Get("Last-Event-ID")
+		if raw == "" {
+			raw = r.URL.Query().Get("last_event_id")
+		}
+		var last int64
+		if raw != "" {
+			id, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || id < 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid Last-Event-ID"})
+				return
+			}
+			last = id
+		}
+
+		// Subscribe before catching up, so nothing published in between is
+		// lost; the overlap is skipped by id.
+		live, err := feed.subscribe(r.Context())
+		if err != nil {
+			return
+		}
+		defer feed.unsubscribe(live)
+		if raw == "" {
+			if last, err = feed.changes.LastChangeID(r.Context()); err != nil {
+				writeStoreError(w, err, "db error")
+				return
+			}
+		}
+
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "retry: %d\n\n", streamRetry)
+		send := func(rev models.IdolRevision) bool {
+			if rev.ID <= last {
+				return true
+			}
+			data, err := json.Marshal(rev)
+			if err != nil {
+				return false
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", rev.ID, rev.Action, data)
+			last = rev.ID
+			return true
+		}
+		for {
+			list, err := feed.changes.Changes(r.Context(), last, streamBatch)
+			if err != nil {
+				return
+			}
+			for _, rev := range list {
+				if !send(rev) {
+					return
+				}
+			}
+			if len(list) < streamBatch {
+				break
+			}
+		}
+		if rc.Flush() != nil {
+			return
+		}
+
+		expired := time.NewTimer(time.Until(claims.ExpiresAt.Time))
+		defer expired.Stop()
+		check := time.NewTicker(streamPollInterval)
+		defer check.Stop()
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-expired.C:
+				fmt.Fprint(w, "event: token_expired\ndata: {}\n\n")
+				rc.Flush()
+				return
+			case <-check.C:
+				if revoked(token) {
+					fmt.Fprint(w, "event: token_revoked\ndata: {}\n\n")
+					rc.Flush()
+					return
+				}
+				continue
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": ping\n\n")
+			case rev, ok := <-live:
+				if !ok || !send(rev) {
+					// Dropped for falling behind; the client resumes.
+					return
+				}
+			}
+			if rc.Flush() != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"kpopapi/internal/auth"
+	"kpopapi/internal/models"
+	"kpopapi/internal/store"
+)
+
+// openStream connects to srv and returns a function reading the next
+// "event: name id" pair, or "" once the stream ends.
+func openStream(t *testing.T, srv *httptest.Server, lastEventID string) func() string {
+	t.Helper()
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	sc := bufio.NewScanner(resp.Body)
+	return func() string {
+		var id, event string
+		for sc.Scan() {
+			line := sc.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case line == "" && event != "":
+				return strings.TrimSpace(event + " " + id)
+			}
+		}
+		return ""
+	}
+}
+
+func TestIdolStreamSharesOneFeed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := store.NewMemory()
+	if _, err := s.CreateGroup(ctx, models.Group{Name: "AESPA"}); err != nil {
+		t.Fatal(err)
+	}
+	create := func(name string) {
+		t.Helper()
+		if _, err := s.Create(ctx, models.Idol{Name: name, Group: "AESPA", Position: "Leader"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	create("Karina")
+	create("Winter")
+
+	feed := NewChangeFeed(s)
+	go feed.Run(ctx)
+	var loggedOut atomic.Bool
+	claims := &auth.Claims{Username: "user2", Role: "user"}
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+	h := HandleIdolStream(feed, func(token string) bool { return token == "t1" && loggedOut.Load() })
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(auth.WithToken(auth.WithClaims(r.Context(), claims), "t1"))
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	resumed := openStream(t, srv, "1")
+	fresh := openStream(t, srv, "")
+	if got := resumed(); got != "create 2" {
+		t.Errorf("resumed stream caught up with %q, want create 2", got)
+	}
+	create("Ningning")
+	for name, next := range map[string]func() string{"resumed": resumed, "fresh": fresh} {
+		if got := next(); got != "create 3" {
+			t.Errorf("%s stream: %q, want create 3", name, got)
+		}
+	}
+
+	loggedOut.Store(true)
+	if got := fresh(); got != "token_revoked" {
+		t.Errorf("after logout: %q, want token_revoked", got)
+	}
+	if got := fresh(); got != "" {
+		t.Errorf("stream kept going after token_revoked: %q", got)
+	}
+}
@@ -8,12 +8,47 @@ var swaggerSpec = []byte(`{
   "paths": {
     "/api/login": {"post": {"summary": "Login", "requestBody": {"required": true}, "responses": {"200": {"description": "OK"}}}},
     "/api/logout": {"post": {"summary": "Logout", "responses": {"200": {"description": "OK"}}}},
+    "/api/me": {"get": {"summary": "Current user, role and token expiry", "security": [{"bearerAuth": []}], "responses": {"200": {"description": "OK"}}}},
+    "/api/me/favorites": {"get": {"summary": "The caller's favorite idols in order", "security": [{"bearerAuth": []}]}},
+    "/api/me/favorites/{idol_id}": {"put": {"summary": "Add a favorite (201) or move it to position", "security": [{"bearerAuth": []}], "requestBody": {"required": false, "content": {"application/json": {"schema": {"type": "object", "properties": {"position": {"type": "integer", "minimum": 1, "description": "1-based place; omitted keeps an existing favorite in place and adds a new one last"}}}}}}}, "delete": {"summary": "Remove a favorite", "security": [{"bearerAuth": []}]}},
     "/api/data": {"get": {"summary": "Secret data", "security": [{"bearerAuth": []}], "responses": {"200": {"description": "OK"}}}},
     "/api/users": {"get": {"summary": "List users", "security": [{"bearerAuth": []}], "responses": {"200": {"description": "OK"}}}},
-    "/api/idols": {"get": {"summary": "List idols", "security": [{"bearerAuth": []}]}, "post": {"summary": "Create idol", "security": [{"bearerAuth": []}]}},
-    "/api/idols/{id}": {"put": {"summary": "Update idol", "security": [{"bearerAuth": []}]}, "delete": {"summary": "Delete idol", "security": [{"bearerAuth": []}]}}
+    "/api/idols": {"get": {"summary": "List idols (keyset paginated)", "security": [{"bearerAuth": []}], "parameters": [{"name": "limit", "in": "query", "schema": {"type": "integer", "maximum": 100}}, {"name": "cursor", "in": "query", "schema": {"type": "string"}}, {"name": "sort", "in": "query", "description": "name, group_name, created_at or updated_at; prefix with - for descending", "schema": {"type": "string"}}, {"name": "group_id", "in": "query", "schema": {"type": "integer"}}, {"name": "group_name", "in": "query", "schema": {"type": "string"}}, {"name": "position", "in": "query", "schema": {"type": "string"}}, {"name": "name_prefix", "in": "query", "schema": {"type": "string"}}, {"name": "nationality", "in": "query", "description": "ISO 3166-1 country code", "schema": {"type": "string"}}, {"name": "status", "in": "query", "schema": {"type": "string", "enum": ["active", "hiatus", "departed"]}}, {"name": "mbti", "in": "query", "schema": {"type": "string"}}, {"name": "born_after", "in": "query", "description": "exclusive", "schema": {"type": "string", "format": "date"}}, {"name": "born_before", "in": "query", "description": "exclusive", "schema": {"type": "string", "format": "date"}}, {"name": "format", "in": "query", "description": "array returns every match as a bare JSON array; with limit, that page and X-Next-Cursor", "schema": {"type": "string", "enum": ["array"]}}]}, "post": {"summary": "Create idol; retries with the same Idempotency-Key replay the first answer", "security": [{"bearerAuth": []}], "parameters": [{"$ref": "#/components/parameters/IdempotencyKey"}]}},
+    "/api/idols/search": {"get": {"summary": "Full-text and fuzzy idol search", "security": [{"bearerAuth": []}], "parameters": [{"name": "q", "in": "query", "required": true, "schema": {"type": "string"}}, {"name": "limit", "in": "query", "schema": {"type": "integer", "maximum": 100}}]}},
+    "/api/idols/trash": {"get": {"summary": "List soft-deleted idols", "security": [{"bearerAuth": []}]}},
+    "/api/idols/{id}/restore": {"post": {"summary": "Restore a soft-deleted idol", "security": [{"bearerAuth": []}]}},
+    "/api/idols/{id}/history": {"get": {"summary": "Revision history of an idol", "security": [{"bearerAuth": []}]}},
+    "/api/idols/{id}/diff": {"get": {"summary": "Field changes between two revisions", "security": [{"bearerAuth": []}], "parameters": [{"name": "from", "in": "query", "required": true, "schema": {"type": "integer"}}, {"name": "to", "in": "query", "required": true, "schema": {"type": "integer"}}]}},
+    "/api/idols/{id}/revert": {"post": {"summary": "Revert an idol to an earlier revision", "security": [{"bearerAuth": []}], "parameters": [{"name": "to", "in": "query", "required": true, "schema": {"type": "integer"}}]}},
+    "/api/groups": {"get": {"summary": "List groups", "security": [{"bearerAuth": []}]}, "post": {"summary": "Create group", "security": [{"bearerAuth": []}]}},
+    "/api/groups/{id}": {"get": {"summary": "Get group", "security": [{"bearerAuth": []}]}, "put": {"summary": "Update group (renames propagate to idols)", "security": [{"bearerAuth": []}]}, "delete": {"summary": "Delete group (409 while idols, albums or events reference it)", "security": [{"bearerAuth": []}]}},
+    "/api/groups/{id}/members": {"get": {"summary": "Group lineup on a date (today by default)", "security": [{"bearerAuth": []}], "parameters": [{"name": "at", "in": "query", "description": "YYYY-MM-DD", "schema": {"type": "string", "format": "date"}}, {"name": "all", "in": "query", "description": "true lists every membership ever", "schema": {"type": "boolean"}}]}, "post": {"summary": "Add membership (409 if it overlaps another stint)", "security": [{"bearerAuth": []}]}},
+    "/api/groups/{id}/members/{membership_id}": {"put": {"summary": "Update membership role and dates", "security": [{"bearerAuth": []}]}, "delete": {"summary": "Remove membership", "security": [{"bearerAuth": []}]}},
+    "/api/idols/{id}/groups": {"get": {"summary": "Idol membership timeline", "security": [{"bearerAuth": []}]}},
+    "/api/positions": {"get": {"summary": "List positions catalogue", "security": [{"bearerAuth": []}]}, "post": {"summary": "Create position with aliases (admin only)", "security": [{"bearerAuth": []}]}},
+    "/api/positions/{id}": {"get": {"summary": "Get position", "security": [{"bearerAuth": []}]}, "put": {"summary": "Update position (admin only; renames propagate to idols)", "security": [{"bearerAuth": []}]}, "delete": {"summary": "Delete position (admin only; 409 while idols have it)", "security": [{"bearerAuth": []}]}},
+    "/api/idols/import": {"post": {"summary": "Import idols from CSV, JSON array or NDJSON in one transaction; per-row report with line numbers (422 and nothing applied if any row fails)", "security": [{"bearerAuth": []}], "parameters": [{"name": "dry_run", "in": "query", "description": "true reports what would be created, updated or skipped without applying", "schema": {"type": "boolean"}}], "requestBody": {"content": {"text/csv": {}, "application/json": {}, "application/x-ndjson": {}}}}},
+    "/api/idols/export": {"get": {"summary": "Download idols as CSV, NDJSON or XLSX; takes the list filters and sort", "security": [{"bearerAuth": []}], "parameters": [{"name": "format", "in": "query", "schema": {"type": "string", "enum": ["csv", "ndjson", "xlsx"], "default": "csv"}}, {"name": "include_audit", "in": "query", "description": "true adds version, created_at/by and updated_at/by", "schema": {"type": "boolean"}}]}},
+    "/api/idols/batch": {"post": {"summary": "Run create, update and delete operations in one transaction (all or nothing); per-operation status with the new id and version", "security": [{"bearerAuth": []}], "requestBody": {"content": {"application/json": {"schema": {"type": "object", "properties": {"operations": {"type": "array", "maxItems": 500, "items": {"type": "object", "properties": {"op": {"type": "string", "enum": ["create", "update", "delete"]}, "id": {"type": "integer"}, "version": {"type": "integer"}, "idol": {"type": "object"}}}}}}}}}}},
+    "/api/idols/{id}/photos": {"get": {"summary": "List an idol's photos with the URLs of every variant", "security": [{"bearerAuth": []}]}, "post": {"summary": "Upload a JPEG, PNG or WebP photo (at most 10 MB); metadata is stripped and small, medium and large thumbnails are made", "security": [{"bearerAuth": []}], "requestBody": {"content": {"multipart/form-data": {"schema": {"type": "object", "properties": {"photo": {"type": "string", "format": "binary"}}, "required": ["photo"]}}}}}},
+    "/api/files/{key}": {"get": {"summary": "Download a stored file, e.g. a photo variant URL", "security": [{"bearerAuth": []}], "parameters": [{"name": "key", "in": "path", "required": true, "description": "storage key; may contain slashes", "schema": {"type": "string"}}]}},
+    "/api/albums": {"get": {"summary": "List albums by release date", "security": [{"bearerAuth": []}], "parameters": [{"name": "group_id", "in": "query", "schema": {"type": "integer"}}]}, "post": {"summary": "Create album of a group", "security": [{"bearerAuth": []}]}},
+    "/api/albums/{id}": {"get": {"summary": "Get album", "security": [{"bearerAuth": []}]}, "put": {"summary": "Update album", "security": [{"bearerAuth": []}]}, "delete": {"summary": "Delete album with its tracks and credits", "security": [{"bearerAuth": []}]}},
+    "/api/albums/{id}/tracks": {"get": {"summary": "Album tracks with credits", "security": [{"bearerAuth": []}]}, "post": {"summary": "Add track (409 if the number is taken)", "security": [{"bearerAuth": []}], "requestBody": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/TrackInput"}}}}}},
+    "/api/albums/{id}/tracks/{track_id}": {"put": {"summary": "Update track and replace its credits", "security": [{"bearerAuth": []}], "requestBody": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/TrackInput"}}}}}, "delete": {"summary": "Remove track", "security": [{"bearerAuth": []}]}},
+    "/api/idols/{id}/credits": {"get": {"summary": "Tracks an idol is credited on", "security": [{"bearerAuth": []}]}},
+    "/api/events": {"get": {"summary": "Events overlapping [from, to) with derived birthdays, in KST", "security": [{"bearerAuth": []}], "parameters": [{"name": "from", "in": "query", "description": "date or time; today (KST) by default", "schema": {"type": "string"}}, {"name": "to", "in": "query", "description": "exclusive; from + 30 days by default, at most 366 days after from", "schema": {"type": "string"}}, {"name": "group_id", "in": "query", "schema": {"type": "integer"}}, {"name": "kind", "in": "query", "schema": {"type": "string", "enum": ["comeback", "concert", "fan_meeting", "birthday"]}}]}, "post": {"summary": "Create event; times without an offset are KST", "security": [{"bearerAuth": []}], "requestBody": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/EventInput"}}}}}},
+    "/api/events/{id}": {"get": {"summary": "Get event", "security": [{"bearerAuth": []}]}, "put": {"summary": "Update event", "security": [{"bearerAuth": []}], "requestBody": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/EventInput"}}}}}, "delete": {"summary": "Delete event", "security": [{"bearerAuth": []}]}},
+    "/api/calendar.ics": {"get": {"summary": "iCalendar feed of events and yearly birthdays; takes a bearer token or a feed token", "parameters": [{"name": "group", "in": "query", "description": "group id or name", "schema": {"type": "string"}}, {"name": "token", "in": "query", "description": "feed token from /api/me/calendar-token", "schema": {"type": "string"}}], "responses": {"200": {"description": "OK", "content": {"text/calendar": {}}}, "401": {"description": "Missing or revoked token"}}}},
+    "/api/me/calendar-token": {"post": {"summary": "Issue the caller's calendar feed token, revoking the previous one", "security": [{"bearerAuth": []}], "responses": {"201": {"description": "Created"}}}, "delete": {"summary": "Revoke the caller's calendar feed token", "security": [{"bearerAuth": []}], "responses": {"200": {"description": "OK"}, "404": {"description": "No token"}}}},
+    "/api/polls": {"get": {"summary": "Polls with live tallies, the latest to open first", "security": [{"bearerAuth": []}], "parameters": [{"name": "state", "in": "query", "schema": {"type": "string", "enum": ["upcoming", "open", "closed"]}}]}, "post": {"summary": "Create poll (admin only)", "security": [{"bearerAuth": []}], "requestBody": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/PollInput"}}}}}},
+    "/api/polls/{id}": {"get": {"summary": "Get poll with tally and the caller's my_vote", "security": [{"bearerAuth": []}]}, "put": {"summary": "Update question and times (admin only; options are fixed)", "security": [{"bearerAuth": []}], "requestBody": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/PollInput"}}}}}, "delete": {"summary": "Delete poll with its votes (admin only)", "security": [{"bearerAuth": []}]}},
+    "/api/polls/{id}/votes": {"post": {"summary": "Vote once in an open poll (409 on a second vote or a poll that is not open)", "security": [{"bearerAuth": []}], "requestBody": {"content": {"application/json": {"schema": {"type": "object", "required": ["option_id"], "properties": {"option_id": {"type": "integer"}}}}}}, "responses": {"201": {"description": "Poll with the updated tally"}}}},
+    "/api/idols/stream": {"get": {"summary": "Idol changes as Server-Sent Events; event ids are revision ids", "security": [{"bearerAuth": []}], "parameters": [{"name": "Last-Event-ID", "in": "header", "description": "resume after this revision", "schema": {"type": "integer"}}, {"name": "last_event_id", "in": "query", "description": "as Last-Event-ID, for the first connection", "schema": {"type": "integer"}}, {"name": "ticket", "in": "query", "description": "single-use ticket from /api/idols/stream/ticket, for clients that cannot send headers", "schema": {"type": "string"}}], "responses": {"200": {"description": "event stream; ends with token_expired or token_revoked", "content": {"text/event-stream": {}}}}}},
+    "/api/idols/stream/ticket": {"post": {"summary": "Issue a ticket that opens one idol stream within 30 seconds", "security": [{"bearerAuth": []}], "responses": {"201": {"description": "ticket and expires_in seconds"}, "401": {"description": "unauthorized"}}}},
+    "/api/idols/{id}": {"get": {"summary": "Get idol with audit fields (404 if missing or deleted)", "security": [{"bearerAuth": []}]}, "put": {"summary": "Update idol (If-Match or body version; 412/409 on conflict)", "security": [{"bearerAuth": []}], "parameters": [{"name": "If-Match", "in": "header", "schema": {"type": "string"}}]}, "patch": {"summary": "Partially update idol", "security": [{"bearerAuth": []}], "requestBody": {"content": {"application/merge-patch+json": {}, "application/json-patch+json": {}}}}, "delete": {"summary": "Delete idol (If-Match or body version; 412/409 on conflict)", "security": [{"bearerAuth": []}], "parameters": [{"name": "If-Match", "in": "header", "schema": {"type": "string"}}, {"name": "hard", "in": "query", "description": "true purges the row permanently (admin only)", "schema": {"type": "boolean"}}]}}
   },
-  "components": {"securitySchemes": {"bearerAuth": {"type": "http", "scheme": "bearer", "bearerFormat": "JWT"}}}
+  "components": {"securitySchemes": {"bearerAuth": {"type": "http", "scheme": "bearer", "bearerFormat": "JWT"}}, "parameters": {"IdempotencyKey": {"name": "Idempotency-Key", "in": "header", "description": "Client-chosen key (up to 255 characters) that makes an authenticated POST safe to retry: a repeat with the same body gets the stored answer with Idempotent-Replayed: true, the same key with another body 422, a repeat while the first is running 409", "schema": {"type": "string", "maxLength": 255}}}, "schemas": {"PollInput": {"type": "object", "required": ["question", "closes_at"], "properties": {"question": {"type": "string", "maxLength": 200}, "opens_at": {"type": "string", "description": "now by default on create"}, "closes_at": {"type": "string", "example": "2024-06-01T00:00"}, "options": {"type": "array", "description": "create only", "minItems": 2, "maxItems": 20, "items": {"type": "object", "properties": {"idol_id": {"type": "integer"}, "group_id": {"type": "integer"}}}}}}, "EventInput": {"type": "object", "required": ["kind", "title", "starts_at"], "properties": {"kind": {"type": "string", "enum": ["comeback", "concert", "fan_meeting"]}, "title": {"type": "string"}, "description": {"type": "string"}, "venue": {"type": "string"}, "starts_at": {"type": "string", "example": "2024-05-27T18:00"}, "ends_at": {"type": "string"}, "all_day": {"type": "boolean"}, "group_id": {"type": "integer"}, "idol_id": {"type": "integer"}}}, "TrackInput": {"type": "object", "required": ["number", "title"], "properties": {"number": {"type": "integer", "minimum": 1}, "title": {"type": "string"}, "duration_sec": {"type": "integer", "minimum": 1}, "credits": {"type": "array", "items": {"type": "object", "properties": {"idol_id": {"type": "integer"}, "role": {"type": "string", "enum": ["vocals", "rap", "lyrics", "composition"]}}}}}}, "ValidationError": {"description": "422 body of a payload that failed validation", "type": "object", "properties": {"error": {"type": "string", "example": "validation failed"}, "errors": {"type": "array", "items": {"type": "object", "properties": {"field": {"type": "string", "example": "positions[1]"}, "code": {"type": "string", "enum": ["required", "too_long", "one_of", "invalid"]}, "message": {"type": "string"}}}}}}}}
 }`)
 
 func SwaggerSpec(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"kpopapi/internal/store"
+)
+
+// TestIdolTrashTransitions walks each idol state through restore, soft
+// delete and purge and checks the status each step answers.
+func TestIdolTrashTransitions(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  []string // requests run first, as "METHOD target"
+		method string
+		target string
+		status int
+	}{
+		{"restore a trashed idol", []string{"DELETE /api/idols/1"}, http.MethodPost, "/api/idols/1/restore", http.StatusOK},
+		{"restore a live idol", nil, http.MethodPost, "/api/idols/1/restore", http.StatusNotFound},
+		{"restore a purged idol", []string{"DELETE /api/idols/1?hard=true"}, http.MethodPost, "/api/idols/1/restore", http.StatusNotFound},
+		{"restore a missing idol", nil, http.MethodPost, "/api/idols/9/restore", http.StatusNotFound},
+		{"restore a bad id", nil, http.MethodPost, "/api/idols/x/restore", http.StatusBadRequest},
+		{"purge a trashed idol", []string{"DELETE /api/idols/1"}, http.MethodDelete, "/api/idols/1?hard=true", http.StatusOK},
+		{"purge a live idol", nil, http.MethodDelete, "/api/idols/1?hard=true", http.StatusOK},
+		{"purge twice", []string{"DELETE /api/idols/1?hard=true"}, http.MethodDelete, "/api/idols/1?hard=true", http.StatusNotFound},
+		{"soft delete a purged idol", []string{"DELETE /api/idols/1?hard=true"}, http.MethodDelete, "/api/idols/1", http.StatusNotFound},
+		{"soft delete a restored idol", []string{"DELETE /api/idols/1", "POST /api/idols/1/restore"}, http.MethodDelete, "/api/idols/1", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newIdolServer(t)
+			createIdol(t, h, `{"name":"Karina","group_name":"AESPA","position":"Leader"}`)
+			for _, step := range tt.setup {
+				method, target, _ := strings.Cut(step, " ")
+				if w := do(t, h, method, target, ""); w.Code != http.StatusOK {
+					t.Fatalf("%s: status %d, body %s", step, w.Code, w.Body)
+				}
+			}
+			if w := do(t, h, tt.method, tt.target, ""); w.Code != tt.status {
+				t.Errorf("status %d, want %d (body %s)", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+// TestIdolTrashList checks that the trash lists only soft-deleted idols and
+// takes the list's filters and paging.
+func TestIdolTrashList(t *testing.T) {
+	_, h := newIdolServer(t)
+	for _, body := range []string{
+		`{"name":"Karina","group_name":"AESPA","position":"Leader"}`,
+		`{"name":"Winter","group_name":"AESPA","position":"Main Vocalist"}`,
+		`{"name":"Giselle","group_name":"AESPA","position":"Main Rapper"}`,
+		`{"name":"Jisung","group_name":"NCT","position":"Main Dancer"}`,
+	} {
+		createIdol(t, h, body)
+	}
+	for _, id := range []string{"1", "3", "4"} {
+		if w := do(t, h, http.MethodDelete, "/api/idols/"+id, ""); w.Code != http.StatusOK {
+			t.Fatalf("DELETE %s: status %d", id, w.Code)
+		}
+	}
+	if w := do(t, h, http.MethodDelete, "/api/idols/3?hard=true", ""); w.Code != http.StatusOK {
+		t.Fatalf("purge: status %d", w.Code)
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "Karina,Jisung"},
+		{"group_name=AESPA", "Karina"},
+		{"sort=-name", "Karina,Jisung"},
+		{"limit=1", "Karina"},
+	}
+	for _, tt := range tests {
+		w := do(t, h, http.MethodGet, "/api/idols/trash?"+tt.query, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("?%s: status %d, body %s", tt.query, w.Code, w.Body)
+		}
+		var got []string
+		for _, it := range decodeBody[store.Page](t, w).Items {
+			got = append(got, it.Name)
+			if it.DeletedAt == nil {
+				t.Errorf("?%s: live idol %s in the trash", tt.query, it.Name)
+			}
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("?%s = %v, want %s", tt.query, got, tt.want)
+		}
+	}
+	if w := do(t, h, http.MethodGet, "/api/idols/trash?shoe_size=1", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown filter: status %d, want 400", w.Code)
+	}
+	if w := do(t, h, http.MethodDelete, "/api/idols/trash", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE trash: status %d, want 405", w.Code)
+	}
+}
+
+// TestIdolPurgeDropsHistory checks that a purge leaves nothing of the idol
+// behind, its revisions included.
+func TestIdolPurgeDropsHistory(t *testing.T) {
+	s, h := newIdolServer(t)
+	createIdol(t, h, `{"name":"Karina","group_name":"AESPA","position":"Leader"}`)
+	if w := do(t, h, http.MethodDelete, "/api/idols/1?hard=true", ""); w.Code != http.StatusOK || decodeBody[map[string]string](t, w)["status"] != "purged" {
+		t.Fatalf("purge: status %d, body %s", w.Code, w.Body)
+	}
+	if _, err := s.History(context.Background(), 1); err == nil {
+		t.Error("history of a purged idol is still there")
+	}
+	if page := decodeBody[store.Page](t, do(t, h, http.MethodGet, "/api/idols/trash", "")); len(page.Items) != 0 {
+		t.Errorf("purged idol in the trash: %+v", page.Items)
+	}
+}
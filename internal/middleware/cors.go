@@ -5,8 +5,9 @@ import "net/http"
 func CORS(next http.Handler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
         w.Header().Set("Access-Control-Allow-Origin", "*")
-        w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-        w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+        w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, Idempotency-Key")
+        w.Header().Set("Access-Control-Expose-Headers", "X-Next-Cursor, ETag, Content-Disposition, Idempotent-Replayed")
         w.Header().Set("Access-Control-Max-Age", "86400")
         if r.Method == http.MethodOptions {
             w.WriteHeader(http.StatusNoContent)
@@ -0,0 +1,234 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"kpopapi/internal/auth"
+	"kpopapi/internal/store"
+)
+
+const (
+	// IdempotencyHeader names the client's key for a POST.
+	IdempotencyHeader = "Idempotency-Key"
+	// ReplayedHeader marks an answer replayed from a stored response.
+	ReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLen = 255
+	// maxIdempotentBody caps request bodies that are fingerprinted. It is
+	// the largest body any route accepts: a 10 MB photo plus its multipart
+	// framing.
+	maxIdempotentBody = 10<<20 + 64<<10
+	// maxBufferedBody is how much of a body is kept in memory; the rest of
+	// a larger one is spooled to a temporary file.
+	maxBufferedBody = 1 << 20
+	// maxStoredResponse caps the responses that are kept for replay; larger
+	// ones release the key instead.
+	maxStoredResponse = 1 << 20
+)
+
+// Idempotency makes authenticated POST requests that carry an
+// Idempotency-Key safe to retry. The first request with a key runs and its
+// answer is stored for ttl; a repeat with the same method, path and body gets
+// the stored answer back, the same key with another request is rejected
+// with 422 and a repeat that arrives while the first is still running with
+// 409. Server errors are not stored, so those requests can be retried, and a
+// key left unanswered for longer than lease, as when the server died
+// mid-request, is handed to the next retry.
+func Idempotency(keys store.IdempotencyStore, ttl, lease time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyHeader)
+		claims, authed := auth.ClaimsFromContext(r.Context())
+		if r.Method != http.MethodPost || key == "" || !authed {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			writeError(w, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+			return
+		}
+		body, sum, err := spool(r)
+		if errors.Is(err, errBodyTooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "body too large for an idempotent request")
+			return
+		}
+		if err != nil {
+			log.Printf("idempotency body: %v", err)
+			writeError(w, http.StatusBadRequest, "cannot read body")
+			return
+		}
+		defer body.Close()
+		r.Body = body
+
+		now := time.Now()
+		rec := store.IdempotencyRecord{
+			User:           claims.Username,
+			Key:            key,
+			Fingerprint:    sum,
+			ExpiresAt:      now.Add(ttl),
+			LeaseExpiresAt: now.Add(lease),
+		}
+		held, reserved, err := keys.ReserveKey(r.Context(), rec)
+		if errors.Is(err, store.ErrNotFound) {
+			held, reserved, err = keys.ReserveKey(r.Context(), rec)
+		}
+		if err != nil {
+			log.Printf("idempotency key: %v", err)
+			writeError(w, http.StatusInternalServerError, "idempotency error")
+			return
+		}
+		if !reserved {
+			switch {
+			case held.Fingerprint != rec.Fingerprint:
+				writeError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request")
+			case held.Status == 0:
+				writeError(w, http.StatusConflict, "a request with this Idempotency-Key is still in progress")
+			default:
+				replay(w, held)
+			}
+			return
+		}
+		rec.Token = held.Token
+
+		// The request context may be cancelled by now; the key must be
+		// settled anyway.
+		ctx := context.WithoutCancel(r.Context())
+		release := func() {
+			if err := keys.ReleaseKey(ctx, rec); err != nil {
+				log.Printf("idempotency key release: %v", err)
+			}
+		}
+		rw := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		answered := false
+		defer func() {
+			if !answered {
+				// The handler panicked.
+				release()
+			}
+		}()
+		next.ServeHTTP(rw, r)
+		answered = true
+		if rw.status >= http.StatusInternalServerError || rw.overflow {
+			release()
+			return
+		}
+		rec.Status, rec.Header, rec.Body = rw.status, rw.Header().Clone(), rw.body.Bytes()
+		if err := keys.CompleteKey(ctx, rec); errors.Is(err, store.ErrNotFound) {
+			// Outlived its lease; a retry holds the key now and settles it.
+			log.Printf("idempotency key %q of %s was taken over; answer not stored", rec.Key, rec.User)
+		} else if err != nil {
+			log.Printf("idempotency key complete: %v", err)
+		}
+	})
+}
+
+var errBodyTooLarge = errors.New("body too large")
+
+// spool reads the request body once, hashing what makes two requests the
+// same, and returns a replacement body together with that fingerprint.
+// Bodies past maxBufferedBody go to a temporary file that is removed when
+// the replacement is closed.
+func spool(r *http.Request) (io.ReadCloser, string, error) {
+	h := sha256.New()
+	for _, part := range []string{r.Method, r.URL.RequestURI(), r.Header.Get("Content-Type")} {
+		io.WriteString(h, part)
+		h.Write([]byte{0})
+	}
+	src := io.TeeReader(io.LimitReader(r.Body, maxIdempotentBody+1), h)
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(src, maxBufferedBody+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if n <= maxBufferedBody {
+		return io.NopCloser(&buf), hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	f, err := os.CreateTemp("", "idempotent-body-*")
+	if err != nil {
+		return nil, "", err
+	}
+	body := &spoolFile{f}
+	n, err = io.Copy(f, io.MultiReader(&buf, src))
+	if err == nil && n > maxIdempotentBody {
+		err = errBodyTooLarge
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		body.Close()
+		return nil, "", err
+	}
+	return body, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// spoolFile is a spooled request body that deletes itself on Close.
+type spoolFile struct{ *os.File }
+
+func (f *spoolFile) Close() error {
+	err := f.File.Close()
+	if rmErr := os.Remove(f.Name()); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+func replay(w http.ResponseWriter, held store.IdempotencyRecord) {
+	for name, values := range held.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(held.Status)
+	_, _ = w.Write(held.Body)
+}
+
+// recordingWriter passes a response through while keeping a copy of it.
+type recordingWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+	overflow    bool
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	if !rw.wroteHeader {
+		rw.status, rw.wroteHeader = status, true
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	if !rw.overflow {
+		if rw.body.Len()+len(b) > maxStoredResponse {
+			rw.overflow = true
+			rw.body.Reset()
+		} else {
+			rw.body.Write(b)
+		}
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *recordingWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
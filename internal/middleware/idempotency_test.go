@@ -0,0 +1,175 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kpopapi/internal/auth"
+	"kpopapi/internal/store"
+)
+
+// echoServer answers each POST with a running count and a digest of the body
+// it was given, behind Idempotency and as user2.
+func echoServer(keys store.IdempotencyStore, lease time.Duration) (http.Handler, *int) {
+	calls := 0
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		calls++
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "%d %x", calls, sha256.Sum256(body))
+	})
+	claims := &auth.Claims{Username: "user2", Role: "user"}
+	h := Idempotency(keys, time.Hour, lease, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(auth.WithClaims(r.Context(), claims)))
+	}), &calls
+}
+
+func post(h http.Handler, key string, body []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/idols", bytes.NewReader(body))
+	r.Header.Set(IdempotencyHeader, key)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestIdempotencyReplays(t *testing.T) {
+	h, calls := echoServer(store.NewMemory(), time.Minute)
+	first := post(h, "k1", []byte(`{"name":"Karina"}`))
+	if first.Code != http.StatusCreated {
+		t.Fatalf("status %d, body %s", first.Code, first.Body)
+	}
+	again := post(h, "k1", []byte(`{"name":"Karina"}`))
+	if again.Code != http.StatusCreated || again.Body.String() != first.Body.String() || again.Header().Get(ReplayedHeader) != "true" {
+		t.Errorf("retry = %d %q %v, want the stored answer", again.Code, again.Body, again.Header())
+	}
+	if w := post(h, "k1", []byte(`{"name":"Winter"}`)); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("other body: status %d, want 422", w.Code)
+	}
+	if *calls != 1 {
+		t.Errorf("handler ran %d times, want 1", *calls)
+	}
+}
+
+func TestIdempotencySpoolsLargeBodies(t *testing.T) {
+	h, _ := echoServer(store.NewMemory(), time.Minute)
+	body := bytes.Repeat([]byte("0123456789abcdef"), (maxBufferedBody+100)/16)
+	w := post(h, "big", body)
+	if want := fmt.Sprintf("1 %x", sha256.Sum256(body)); w.Code != http.StatusCreated || w.Body.String() != want {
+		t.Fatalf("status %d, body %q, want %q", w.Code, w.Body, want)
+	}
+	// Changing the spooled tail must change the fingerprint.
+	body[len(body)-1] = 'x'
+	if w := post(h, "big", body); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("changed tail: status %d, want 422", w.Code)
+	}
+
+	if w := post(h, "huge", make([]byte, maxIdempotentBody+1)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversize body: status %d, want 413", w.Code)
+	}
+	if w := post(h, "limit", make([]byte, maxIdempotentBody)); w.Code != http.StatusCreated {
+		t.Errorf("body at the limit: status %d, want 201", w.Code)
+	}
+}
+
+// TestIdempotencyLease leaves a key reserved, as a server that died
+// mid-request would, and checks that retries get it once the lease is over.
+func TestIdempotencyLease(t *testing.T) {
+	keys := store.NewMemory()
+	h, calls := echoServer(keys, time.Minute)
+	body := []byte(`{"name":"Karina"}`)
+	_, sum, err := spool(httptest.NewRequest(http.MethodPost, "/api/idols", bytes.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reserve := func(key string, lease time.Time) {
+		t.Helper()
+		_, ok, err := keys.ReserveKey(context.Background(), store.IdempotencyRecord{
+			User: "user2", Key: key, Fingerprint: sum,
+			ExpiresAt: time.Now().Add(time.Hour), LeaseExpiresAt: lease,
+		})
+		if err != nil || !ok {
+			t.Fatalf("reserve %s: %v %v", key, ok, err)
+		}
+	}
+	reserve("running", time.Now().Add(time.Minute))
+	reserve("crashed", time.Now().Add(-time.Second))
+
+	if w := post(h, "running", body); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "in progress") {
+		t.Errorf("within the lease: status %d, want 409", w.Code)
+	}
+	if w := post(h, "crashed", body); w.Code != http.StatusCreated {
+		t.Errorf("after the lease: status %d, body %s, want 201", w.Code, w.Body)
+	}
+	if *calls != 1 {
+		t.Errorf("handler ran %d times, want 1", *calls)
+	}
+	// Answered keys keep replaying however old their lease is.
+	if w := post(h, "crashed", body); w.Header().Get(ReplayedHeader) != "true" {
+		t.Errorf("answered key was not replayed: %d %v", w.Code, w.Header())
+	}
+}
+
+// TestIdempotencyTakeover lets a retry take over the key while the first
+// request is still running past its lease, and checks that the first
+// request's late answer neither overwrites nor releases the retry's
+// reservation.
+func TestIdempotencyTakeover(t *testing.T) {
+	keys := store.NewMemory()
+	claims := &auth.Claims{Username: "user2", Role: "user"}
+	var h http.Handler
+	var calls atomic.Int32
+	entered, finish := make(chan struct{}), make(chan struct{})
+	retried := make(chan *httptest.ResponseRecorder)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		switch n {
+		case 1:
+			go func() { retried <- post(h, "slow", []byte(`{}`)) }()
+			<-entered
+		case 2:
+			close(entered)
+			<-finish
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "answer %d", n)
+	})
+	serve := func(lease time.Duration) http.Handler {
+		idem := Idempotency(keys, time.Hour, lease, next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			idem.ServeHTTP(w, r.WithContext(auth.WithClaims(r.Context(), claims)))
+		})
+	}
+	h = serve(time.Minute)
+
+	// The first request's lease has run out before its handler starts.
+	if w := post(serve(-time.Second), "slow", []byte(`{}`)); w.Body.String() != "answer 1" {
+		t.Fatalf("first request = %d %q", w.Code, w.Body)
+	}
+	// The first request has answered, but the key is still the retry's.
+	if w := post(h, "slow", []byte(`{}`)); w.Code != http.StatusConflict {
+		t.Errorf("during the retry: status %d, body %q, want 409", w.Code, w.Body)
+	}
+	close(finish)
+	if w := <-retried; w.Code != http.StatusCreated || w.Body.String() != "answer 2" {
+		t.Fatalf("retry = %d %q", w.Code, w.Body)
+	}
+	w := post(h, "slow", []byte(`{}`))
+	if w.Header().Get(ReplayedHeader) != "true" || w.Body.String() != "answer 2" {
+		t.Errorf("replay = %d %q %v, want the retry's answer", w.Code, w.Body, w.Header())
+	}
+}
@@ -0,0 +1,61 @@
+package models
+
+import "time"
+
+// Album kinds.
+const (
+	AlbumFull   = "album"
+	AlbumEP     = "ep"
+	AlbumSingle = "single"
+)
+
+// Credit roles.
+const (
+	CreditVocals      = "vocals"
+	CreditRap         = "rap"
+	CreditLyrics      = "lyrics"
+	CreditComposition = "composition"
+)
+
+// Album is a release of a group.
+type Album struct {
+	ID          int64     `json:"id"`
+	GroupID     int64     `json:"group_id"`
+	GroupName   string    `json:"group_name"`
+	Title       string    `json:"title"`
+	Kind        string    `json:"kind"`
+	ReleaseDate *Date     `json:"release_date,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Track is a song on an album with the idols credited on it.
+type Track struct {
+	ID       int64    `json:"id"`
+	AlbumID  int64    `json:"album_id"`
+	Number   int      `json:"number"`
+	Title    string   `json:"title"`
+	Duration *int     `json:"duration_sec,omitempty"`
+	Credits  []Credit `json:"credits"`
+}
+
+// Credit is an idol's part in a track.
+type Credit struct {
+	IdolID   int64  `json:"idol_id"`
+	IdolName string `json:"idol_name"`
+	Role     string `json:"role"`
+}
+
+// IdolCredit is a credit seen from the idol, with the track and album it
+// belongs to.
+type IdolCredit struct {
+	Role        string `json:"role"`
+	TrackID     int64  `json:"track_id"`
+	TrackNumber int    `json:"track_number"`
+	TrackTitle  string `json:"track_title"`
+	AlbumID     int64  `json:"album_id"`
+	AlbumTitle  string `json:"album_title"`
+	GroupID     int64  `json:"group_id"`
+	GroupName   string `json:"group_name"`
+	ReleaseDate *Date  `json:"release_date,omitempty"`
+}
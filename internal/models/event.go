@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// Event kinds. Birthdays are not stored; they are derived from idol birth
+// dates.
+const (
+	EventComeback   = "comeback"
+	EventConcert    = "concert"
+	EventFanMeeting = "fan_meeting"
+	EventBirthday   = "birthday"
+)
+
+// KST is Korea Standard Time. Event times given without an offset are read
+// in it and all event times are shown in it. Korea has not observed
+// daylight saving time since 1988, so a fixed zone is exact.
+var KST = time.FixedZone("KST", 9*60*60)
+
+// Event is a scheduled happening of a group, an idol or both. EndsAt is
+// exclusive and optional. All-day events start at midnight KST and, like
+// derived birthdays, have whole days as their bounds. Derived events have
+// no ID and no timestamps.
+type Event struct {
+	ID          int64      `json:"id,omitzero"`
+	Kind        string     `json:"kind"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Venue       string     `json:"venue"`
+	StartsAt    time.Time  `json:"starts_at"`
+	EndsAt      *time.Time `json:"ends_at,omitempty"`
+	AllDay      bool       `json:"all_day"`
+	GroupID     *int64     `json:"group_id,omitempty"`
+	GroupName   string     `json:"group_name,omitempty"`
+	IdolID      *int64     `json:"idol_id,omitempty"`
+	IdolName    string     `json:"idol_name,omitempty"`
+	CreatedAt   time.Time  `json:"created_at,omitzero"`
+	UpdatedAt   time.Time  `json:"updated_at,omitzero"`
+}
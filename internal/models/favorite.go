@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// Favorite is an idol on a user's favorites list. Position is 1-based and
+// counts only the idols that are shown, so soft-deleted favorites leave no
+// gaps.
+type Favorite struct {
+	Position int       `json:"position"`
+	AddedAt  time.Time `json:"added_at"`
+	Idol     Idol      `json:"idol"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Group statuses.
+const (
+	GroupActive    = "active"
+	GroupHiatus    = "hiatus"
+	GroupDisbanded = "disbanded"
+)
+
+// Group is a group or, when ParentID is set, a subunit of another group.
+type Group struct {
+	ID         int64     `json:"id"`
+	Name       string    `json:"name"`
+	ParentID   *int64    `json:"parent_id,omitempty"`
+	DebutDate  *Date     `json:"debut_date,omitempty"`
+	FandomName string    `json:"fandom_name"`
+	Agency     string    `json:"agency"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
@@ -2,17 +2,40 @@ package models
 
 import "time"
 
+// Idol statuses.
+const (
+    IdolActive   = "active"
+    IdolHiatus   = "hiatus"
+    IdolDeparted = "departed"
+)
+
+// Idol is a single idol. Name is the stage name and LegalName the name on
+// official documents. Positions are catalogue names in priority order;
+// Position is the same list joined with ", " for older clients. Nationality
+// is an ISO 3166-1 alpha-2 code. FavoriteCount is the number of users who
+// have the idol among their favorites.
 type Idol struct {
-    ID        int64      `json:"id"`
-    Name      string     `json:"name"`
-    Group     string     `json:"group"`
-    Position  string     `json:"position"`
-    CreatedAt time.Time  `json:"created_at"`
-    UpdatedAt time.Time  `json:"updated_at"`
-    CreatedBy string     `json:"created_by"`
-    UpdatedBy string     `json:"updated_by"`
-    DeletedAt *time.Time `json:"deleted_at,omitempty"`
-    Version   int        `json:"version"`
+    ID            int64      `json:"id"`
+    Name          string     `json:"name"`
+    GroupID       int64      `json:"group_id"`
+    Group         string     `json:"group_name"`
+    Position      string     `json:"position"`
+    Positions     []string   `json:"positions"`
+    LegalName     string     `json:"legal_name"`
+    HangulName    string     `json:"hangul_name"`
+    BirthDate     *Date      `json:"birth_date,omitempty"`
+    Nationality   string     `json:"nationality"`
+    DebutDate     *Date      `json:"debut_date,omitempty"`
+    HeightCM      *int       `json:"height_cm,omitempty"`
+    MBTI          string     `json:"mbti"`
+    Status        string     `json:"status"`
+    FavoriteCount int        `json:"favorite_count"`
+    CreatedAt     time.Time  `json:"created_at"`
+    UpdatedAt     time.Time  `json:"updated_at"`
+    CreatedBy     string     `json:"created_by"`
+    UpdatedBy     string     `json:"updated_by"`
+    DeletedAt     *time.Time `json:"deleted_at,omitempty"`
+    Version       int        `json:"version"`
 }
 
 
@@ -0,0 +1,33 @@
+package models
+
+// Membership is one stint of an idol in a group or subunit. JoinedOn and
+// LeftOn are optional; LeftOn is exclusive, and an open LeftOn means the
+// idol is still a member.
+type Membership struct {
+	ID        int64  `json:"id"`
+	IdolID    int64  `json:"idol_id"`
+	IdolName  string `json:"idol_name"`
+	GroupID   int64  `json:"group_id"`
+	GroupName string `json:"group_name"`
+	Role      string `json:"role"`
+	JoinedOn  *Date  `json:"joined_on,omitempty"`
+	LeftOn    *Date  `json:"left_on,omitempty"`
+}
+
+// ActiveOn reports whether the membership covers the given date.
+func (m Membership) ActiveOn(d Date) bool {
+	if m.JoinedOn != nil && m.JoinedOn.After(d.Time) {
+		return false
+	}
+	return m.LeftOn == nil || m.LeftOn.After(d.Time)
+}
+
+// Overlaps reports whether two stints share at least one day.
+func (m Membership) Overlaps(o Membership) bool {
+	// [a1, a2) and [b1, b2) overlap when a1 < b2 and b1 < a2; nil bounds
+	// are open.
+	before := func(start, end *Date) bool {
+		return start == nil || end == nil || start.Before(end.Time)
+	}
+	return before(m.JoinedOn, o.LeftOn) && before(o.JoinedOn, m.LeftOn)
+}
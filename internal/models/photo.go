@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Photo is an uploaded idol photo. Variants holds the re-encoded original
+// first, followed by its thumbnails from small to large.
+type Photo struct {
+	ID          int64          `json:"id"`
+	IdolID      int64          `json:"idol_id"`
+	ContentType string         `json:"content_type"`
+	Width       int            `json:"width"`
+	Height      int            `json:"height"`
+	Size        int64          `json:"size"`
+	Variants    []PhotoVariant `json:"variants"`
+	UploadedBy  string         `json:"uploaded_by"`
+	CreatedAt   time.Time      `json:"created_at"`
+}
+
+// PhotoVariant is one stored rendition of a photo. Key is where the file
+// lives in storage; URL is filled in by the HTTP layer.
+type PhotoVariant struct {
+	Name   string `json:"name"`
+	Key    string `json:"-"`
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// Poll states, derived from the open and close times.
+const (
+	PollUpcoming = "upcoming"
+	PollOpen     = "open"
+	PollClosed   = "closed"
+)
+
+// Poll is a question that users answer by voting for one option, from
+// OpensAt until the exclusive ClosesAt. Votes counts are live while the
+// poll is open and final once it closes. MyVote is the option the caller
+// voted for, if any.
+type Poll struct {
+	ID         int64        `json:"id"`
+	Question   string       `json:"question"`
+	Options    []PollOption `json:"options"`
+	OpensAt    time.Time    `json:"opens_at"`
+	ClosesAt   time.Time    `json:"closes_at"`
+	State      string       `json:"state"`
+	TotalVotes int          `json:"total_votes"`
+	MyVote     *int64       `json:"my_vote,omitempty"`
+	CreatedBy  string       `json:"created_by"`
+	CreatedAt  time.Time    `json:"created_at"`
+	UpdatedAt  time.Time    `json:"updated_at"`
+}
+
+// PollOption is an idol or a group to vote for.
+type PollOption struct {
+	ID        int64  `json:"id"`
+	IdolID    *int64 `json:"idol_id,omitempty"`
+	IdolName  string `json:"idol_name,omitempty"`
+	GroupID   *int64 `json:"group_id,omitempty"`
+	GroupName string `json:"group_name,omitempty"`
+	Votes     int    `json:"votes"`
+}
+
+// StateAt returns the state of the poll at t.
+func (p Poll) StateAt(t time.Time) string {
+	switch {
+	case t.Before(p.OpensAt):
+		return PollUpcoming
+	case t.Before(p.ClosesAt):
+		return PollOpen
+	}
+	return PollClosed
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Position is an entry of the managed positions catalogue. Aliases are
+// alternative spellings that resolve to Name when idols are written.
+type Position struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Aliases   []string  `json:"aliases"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
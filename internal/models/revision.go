@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// IdolRevision is a snapshot of an idol taken after one of its mutations.
+// Version is the idol's version after the mutation.
+type IdolRevision struct {
+	ID        int64     `json:"id"`
+	IdolID    int64     `json:"idol_id"`
+	Version   int       `json:"version"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	Snapshot  Idol      `json:"snapshot"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,54 @@
+// Package storage keeps uploaded files behind a small interface so the API
+// can move from the local disk to an object store without touching the
+// handlers.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+// ErrNotFound is returned when no file is stored under a key.
+var ErrNotFound = errors.New("file not found")
+
+// ErrInvalidKey is returned for keys that are empty, absolute or climb out
+// of the storage root.
+var ErrInvalidKey = errors.New("invalid file key")
+
+// Storage stores files under slash-separated keys such as
+// "photos/12/3f9c.../small.jpg".
+type Storage interface {
+	// Put stores r under key, replacing any file already there.
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Open returns the file stored under key.
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Delete removes the file under key; a missing file is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// DeleteAll removes the files under keys, carrying on past failures, and
+// returns their errors joined.
+func DeleteAll(ctx context.Context, s Storage, keys []string) error {
+	var errs []error
+	for _, key := range keys {
+		if err := s.Delete(ctx, key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ValidKey reports whether key is a clean relative path.
+func ValidKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
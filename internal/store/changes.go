@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"sort"
+
+	"kpopapi/internal/models"
+)
+
+// ChangeStore reads the idol revisions as one change log across idols,
+// ordered by revision id.
+type ChangeStore interface {
+	// Changes returns up to limit revisions with an id above after. It
+	// stops before a revision while a transaction that may still commit a
+	// lower id is open, so a reader that resumes after the last id it got
+	// skips no change.
+	Changes(ctx context.Context, after int64, limit int) ([]models.IdolRevision, error)
+	// LastChangeID returns the id of the latest revision Changes would hand
+	// out, or 0; reading on from it skips nothing.
+	LastChangeID(ctx context.Context) (int64, error)
+}
+
+var (
+	_ ChangeStore = (*Postgres)(nil)
+	_ ChangeStore = (*Memory)(nil)
+)
+
+// unsettled finds the lowest revision id above $1 that a reader may not
+// hand out yet, as cutoff; NULL when every visible revision is settled.
+const unsettled = `WITH unsettled AS (
+		SELECT MIN(id) AS cutoff FROM idol_revisions
+		WHERE id > $1 AND settle_xid > pg_snapshot_xmin(pg_current_snapshot()))`
+
+func (p *Postgres) Changes(ctx context.Context, after int64, limit int) ([]models.IdolRevision, error) {
+	// Ids are taken when a revision is inserted but show when its
+	// transaction commits. settle_xid is the snapshot xmax at insert: every
+	// writer that held a lower id then had an xid below it, since revisions
+	// are written after their idol row. Once the oldest open transaction is
+	// past settle_xid those writers are done, and a lower id still missing
+	// was rolled back or purged. The window stops at the first revision that
+	// is not settled yet, however long its neighbours take.
+	rows, err := p.q().QueryContext(ctx, unsettled+`
+		SELECT `+revisionColumns+` FROM idol_revisions, unsettled
+		WHERE id > $1 AND (cutoff IS NULL OR id < cutoff)
+		ORDER BY id LIMIT $2`, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []models.IdolRevision{}
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (p *Postgres) LastChangeID(ctx context.Context) (int64, error) {
+	var id int64
+	err := p.q().QueryRowContext(ctx, unsettled+`
+		SELECT COALESCE(MAX(id), 0) FROM idol_revisions, unsettled
+		WHERE cutoff IS NULL OR id < cutoff`, 0).Scan(&id)
+	return id, err
+}
+
+func (m *Memory) Changes(ctx context.Context, after int64, limit int) ([]models.IdolRevision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := []models.IdolRevision{}
+	for _, revs := range m.revisions {
+		for _, rev := range revs {
+			if rev.ID > after {
+				list = append(list, rev)
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	// Writers hold m.mu until they are done, so every id below the latest
+	// is either here or gone for good.
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+func (m *Memory) LastChangeID(ctx context.Context) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.revSeq, nil
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"kpopapi/internal/models"
+)
+
+// TestMemoryChangesSkipFinalGaps purges an idol, leaving gaps at its
+// revision ids 1 and 3, and checks that the change log reads past them at
+// once rather than waiting for them to fill.
+func TestMemoryChangesSkipFinalGaps(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	if _, err := m.CreateGroup(ctx, models.Group{Name: "AESPA"}); err != nil {
+		t.Fatal(err)
+	}
+	create := func(name string) models.Idol {
+		t.Helper()
+		it, err := m.Create(ctx, models.Idol{Name: name, Group: "AESPA", Position: "Leader"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return it
+	}
+	karina := create("Karina")
+	create("Winter")
+	if err := m.SoftDelete(ctx, karina.ID, 0, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Purge(ctx, karina.ID); err != nil {
+		t.Fatal(err)
+	}
+	create("Ningning")
+
+	tests := []struct {
+		after int64
+		limit int
+		want  []string
+	}{
+		{0, 10, []string{"Winter", "Ningning"}},
+		{0, 1, []string{"Winter"}},
+		{2, 10, []string{"Ningning"}},
+		{4, 10, nil},
+	}
+	for _, tt := range tests {
+		list, err := m.Changes(ctx, tt.after, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, rev := range list {
+			got = append(got, rev.Snapshot.Name)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Changes(%d, %d) = %v, want %v", tt.after, tt.limit, got, tt.want)
+		}
+	}
+	if last, err := m.LastChangeID(ctx); err != nil || last != 4 {
+		t.Errorf("LastChangeID = %d, %v; want 4", last, err)
+	}
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+
+	"kpopapi/internal/models"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []Cursor{
+		{Sort: "id", ID: 1},
+		{Sort: "name", Value: "Karina", ID: 42},
+		{Sort: "-created_at", Value: "2024-05-27T18:00:00.123456Z", ID: 7},
+		{Sort: "name", Value: "카리나 / \"quoted\" & more", ID: 1 << 40},
+	}
+	for _, c := range tests {
+		t.Run(c.Sort, func(t *testing.T) {
+			got, err := DecodeCursor(c.Encode())
+			if err != nil {
+				t.Fatalf("DecodeCursor: %v", err)
+			}
+			if got != c {
+				t.Errorf("got %+v, want %+v", got, c)
+			}
+		})
+	}
+}
+
+func TestCursorIsURLSafe(t *testing.T) {
+	s := Cursor{Sort: "name", Value: "???>>>~~~", ID: 1}.Encode()
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			t.Fatalf("cursor %q contains %q", s, r)
+		}
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	enc := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	tests := map[string]string{
+		"empty":        "",
+		"not base64":   "!!!",
+		"padded":       base64.URLEncoding.EncodeToString([]byte(`{"s":"id","id":1}`)),
+		"not json":     enc("hello"),
+		"no sort":      enc(`{"id":1}`),
+		"zero id":      enc(`{"s":"id","id":0}`),
+		"negative id":  enc(`{"s":"id","id":-3}`),
+		"id as string": enc(`{"s":"id","id":"1"}`),
+	}
+	for name, s := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := DecodeCursor(s); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("DecodeCursor(%q) = %v, want ErrInvalidCursor", s, err)
+			}
+		})
+	}
+}
+
+// TestMemoryListPagesWithTies pages through idols that share their sort
+// value and checks that every idol appears exactly once.
+func TestMemoryListPagesWithTies(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	if _, err := m.CreateGroup(ctx, models.Group{Name: "NCT"}); err != nil {
+		t.Fatal(err)
+	}
+	const n = 7
+	for i := range n {
+		// Two names only, so most rows tie on the sort key.
+		name := fmt.Sprint("Member ", i%2)
+		if _, err := m.Create(ctx, models.Idol{Name: name, Group: "NCT", Position: "Leader"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, sort := range []string{"name", "-name", "created_at", ""} {
+		t.Run("sort="+sort, func(t *testing.T) {
+			seen := map[int64]bool{}
+			opts := ListOptions{Limit: 3, Sort: sort}
+			for pages := 0; ; pages++ {
+				if pages > n {
+					t.Fatal("listing does not end")
+				}
+				page, err := m.List(ctx, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, it := range page.Items {
+					if seen[it.ID] {
+						t.Fatalf("idol %d listed twice", it.ID)
+					}
+					seen[it.ID] = true
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				c, err := DecodeCursor(page.NextCursor)
+				if err != nil {
+					t.Fatal(err)
+				}
+				opts.After = &c
+			}
+			if len(seen) != n {
+				t.Errorf("listed %d idols, want %d", len(seen), n)
+			}
+		})
+	}
+}
+
+func TestListRejectsForeignCursor(t *testing.T) {
+	m := NewMemory()
+	tests := map[string]ListOptions{
+		"other sort":      {Sort: "name", After: &Cursor{Sort: "-name", Value: "x", ID: 1}},
+		"bad time value":  {Sort: "created_at", After: &Cursor{Sort: "created_at", Value: "yesterday", ID: 1}},
+		"sorted by id":    {After: &Cursor{Sort: "name", Value: "x", ID: 1}},
+		"empty time sort": {Sort: "-updated_at", After: &Cursor{Sort: "-updated_at", ID: 1}},
+	}
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			opts.Limit = 10
+			if _, err := m.List(context.Background(), opts); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("List = %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
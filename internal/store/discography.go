@@ -0,0 +1,566 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+
+	"kpopapi/internal/models"
+)
+
+// DiscographyStore is the persistence contract for albums, their tracks and
+// the idols credited on them. Credits of soft-deleted idols are kept but
+// left out of every listing until the idol is restored.
+type DiscographyStore interface {
+	// ListAlbums returns the albums of a group, or of every group when
+	// groupID is 0, by release date with undated albums last.
+	ListAlbums(ctx context.Context, groupID int64) ([]models.Album, error)
+	GetAlbum(ctx context.Context, id int64) (models.Album, error)
+	// CreateAlbum fails with ErrUnknownGroup when the group does not exist.
+	CreateAlbum(ctx context.Context, in models.Album) (models.Album, error)
+	UpdateAlbum(ctx context.Context, in models.Album) (models.Album, error)
+	// DeleteAlbum removes an album with its tracks and credits.
+	DeleteAlbum(ctx context.Context, id int64) error
+	// AlbumTracks returns the tracks of an album in track order.
+	AlbumTracks(ctx context.Context, albumID int64) ([]models.Track, error)
+	// AddTrack adds a track to in.AlbumID. Track numbers are unique per
+	// album (ErrDuplicate) and credited idols must be live (ErrUnknownIdol).
+	AddTrack(ctx context.Context, in models.Track) (models.Track, error)
+	// UpdateTrack overwrites a track of in.AlbumID and replaces its credits.
+	UpdateTrack(ctx context.Context, in models.Track) (models.Track, error)
+	RemoveTrack(ctx context.Context, albumID, id int64) error
+	// IdolCredits returns the credits of a live idol by release date.
+	IdolCredits(ctx context.Context, idolID int64) ([]models.IdolCredit, error)
+}
+
+var (
+	_ DiscographyStore = (*Postgres)(nil)
+	_ DiscographyStore = (*Memory)(nil)
+)
+
+func albumKindOr(kind string) string {
+	if kind == "" {
+		return models.AlbumFull
+	}
+	return kind
+}
+
+const albumSelect = `SELECT a.id, a.group_id, g.name, a.title, a.kind, a.release_date, a.created_at, a.updated_at
+	FROM albums a JOIN groups g ON g.id = a.group_id`
+
+func scanAlbum(row rowScanner) (models.Album, error) {
+	var a models.Album
+	err := row.Scan(&a.ID, &a.GroupID, &a.GroupName, &a.Title, &a.Kind, &a.ReleaseDate, &a.CreatedAt, &a.UpdatedAt)
+	if err != nil {
+		return a, notFoundOr(err)
+	}
+	return a, nil
+}
+
+func (p *Postgres) ListAlbums(ctx context.Context, groupID int64) ([]models.Album, error) {
+	rows, err := p.q().QueryContext(ctx, albumSelect+`
+		WHERE $1 = 0 OR a.group_id = $1 ORDER BY a.release_date NULLS LAST, a.id`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []models.Album{}
+	for rows.Next() {
+		a, err := scanAlbum(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+func (p *Postgres) GetAlbum(ctx context.Context, id int64) (models.Album, error) {
+	return scanAlbum(p.q().QueryRowContext(ctx, albumSelect+" WHERE a.id = $1", id))
+}
+
+func (p *Postgres) CreateAlbum(ctx context.Context, in models.Album) (a models.Album, err error) {
+	err = p.inTx(ctx, func(q querier) error {
+		if err := checkAlbumGroup(ctx, q, in.GroupID); err != nil {
+			return err
+		}
+		if err := q.QueryRowContext(ctx,
+			"INSERT INTO albums (group_id, title, kind, release_date) VALUES ($1,$2,$3,$4) RETURNING id",
+			in.GroupID, in.Title, albumKindOr(in.Kind), in.ReleaseDate).Scan(&in.ID); err != nil {
+			return err
+		}
+		a, err = scanAlbum(q.QueryRowContext(ctx, albumSelect+" WHERE a.id = $1", in.ID))
+		return err
+	})
+	return a, err
+}
+
+func (p *Postgres) UpdateAlbum(ctx context.Context, in models.Album) (a models.Album, err error) {
+	err = p.inTx(ctx, func(q querier) error {
+		if err := checkAlbumGroup(ctx, q, in.GroupID); err != nil {
+			return err
+		}
+		err := q.QueryRowContext(ctx,
+			"UPDATE albums SET group_id=$1, title=$2, kind=$3, release_date=$4, updated_at=NOW() WHERE id=$5 RETURNING id",
+			in.GroupID, in.Title, albumKindOr(in.Kind), in.ReleaseDate, in.ID).Scan(&in.ID)
+		if err != nil {
+			return notFoundOr(err)
+		}
+		a, err = scanAlbum(q.QueryRowContext(ctx, albumSelect+" WHERE a.id = $1", in.ID))
+		return err
+	})
+	return a, err
+}
+
+func checkAlbumGroup(ctx context.Context, q querier, groupID int64) error {
+	var one int
+	err := q.QueryRowContext(ctx, "SELECT 1 FROM groups WHERE id=$1", groupID).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUnknownGroup
+	}
+	return err
+}
+
+func (p *Postgres) DeleteAlbum(ctx context.Context, id int64) error {
+	res, err := p.q().ExecContext(ctx, "DELETE FROM albums WHERE id=$1", id)
+	return affectedOne(res, err)
+}
+
+const trackSelect = "SELECT id, album_id, track_no, title, duration_sec FROM tracks"
+
+func scanTrack(row rowScanner) (models.Track, error) {
+	t := models.Track{Credits: []models.Credit{}}
+	if err := row.Scan(&t.ID, &t.AlbumID, &t.Number, &t.Title, &t.Duration); err != nil {
+		return t, notFoundOr(err)
+	}
+	return t, nil
+}
+
+// queryTracks loads the tracks matching where, then their credits of live
+// idols.
+func queryTracks(ctx context.Context, q querier, where string, args ...interface{}) ([]models.Track, error) {
+	rows, err := q.QueryContext(ctx, trackSelect+" WHERE "+where+" ORDER BY track_no, id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []models.Track{}
+	byID := map[int64]int{}
+	ids := []int64{}
+	for rows.Next() {
+		t, err := scanTrack(rows)
+		if err != nil {
+			return nil, err
+		}
+		byID[t.ID] = len(list)
+		ids = append(ids, t.ID)
+		list = append(list, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return list, nil
+	}
+	crows, err := q.QueryContext(ctx, `SELECT c.track_id, c.idol_id, i.name, c.role
+		FROM track_credits c JOIN idols i ON i.id = c.idol_id
+		WHERE c.track_id = ANY($1) AND i.deleted_at IS NULL
+		ORDER BY c.role, i.name, c.idol_id`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer crows.Close()
+	for crows.Next() {
+		var trackID int64
+		var c models.Credit
+		if err := crows.Scan(&trackID, &c.IdolID, &c.IdolName, &c.Role); err != nil {
+			return nil, err
+		}
+		t := &list[byID[trackID]]
+		t.Credits = append(t.Credits, c)
+	}
+	return list, crows.Err()
+}
+
+func getTrack(ctx context.Context, q querier, id int64) (models.Track, error) {
+	list, err := queryTracks(ctx, q, "id = $1", id)
+	if err != nil {
+		return models.Track{}, err
+	}
+	if len(list) == 0 {
+		return models.Track{}, ErrNotFound
+	}
+	return list[0], nil
+}
+
+func (p *Postgres) AlbumTracks(ctx context.Context, albumID int64) ([]models.Track, error) {
+	if _, err := p.GetAlbum(ctx, albumID); err != nil {
+		return nil, err
+	}
+	return queryTracks(ctx, p.q(), "album_id = $1", albumID)
+}
+
+func (p *Postgres) AddTrack(ctx context.Context, in models.Track) (t models.Track, err error) {
+	err = p.inTx(ctx, func(q querier) error {
+		var one int
+		if err := q.QueryRowContext(ctx, "SELECT 1 FROM albums WHERE id=$1", in.AlbumID).Scan(&one); err != nil {
+			return notFoundOr(err)
+		}
+		if err := q.QueryRowContext(ctx,
+			"INSERT INTO tracks (album_id, track_no, title, duration_sec) VALUES ($1,$2,$3,$4) RETURNING id",
+			in.AlbumID, in.Number, in.Title, in.Duration).Scan(&in.ID); err != nil {
+			return mapConstraint(err)
+		}
+		if err := setCredits(ctx, q, in); err != nil {
+			return err
+		}
+		t, err = getTrack(ctx, q, in.ID)
+		return err
+	})
+	return t, err
+}
+
+func (p *Postgres) UpdateTrack(ctx context.Context, in models.Track) (t models.Track, err error) {
+	err = p.inTx(ctx, func(q querier) error {
+		err := q.QueryRowContext(ctx,
+			"UPDATE tracks SET track_no=$1, title=$2, duration_sec=$3 WHERE id=$4 AND album_id=$5 RETURNING id",
+			in.Number, in.Title, in.Duration, in.ID, in.AlbumID).Scan(&in.ID)
+		if err != nil {
+			return mapConstraint(notFoundOr(err))
+		}
+		if err := setCredits(ctx, q, in); err != nil {
+			return err
+		}
+		t, err = getTrack(ctx, q, in.ID)
+		return err
+	})
+	return t, err
+}
+
+// setCredits replaces the credits of live idols on a track with in.Credits.
+// Credits of soft-deleted idols stay for when they are restored.
+func setCredits(ctx context.Context, q querier, in models.Track) error {
+	if _, err := q.ExecContext(ctx, `DELETE FROM track_credits c USING idols i
+		WHERE c.track_id = $1 AND i.id = c.idol_id AND i.deleted_at IS NULL`, in.ID); err != nil {
+		return err
+	}
+	for _, c := range in.Credits {
+		res, err := q.ExecContext(ctx, `INSERT INTO track_credits (track_id, idol_id, role)
+			SELECT $1, id, $3 FROM idols WHERE id = $2 AND deleted_at IS NULL`, in.ID, c.IdolID, c.Role)
+		if err != nil {
+			return mapConstraint(err)
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrUnknownIdol
+		}
+	}
+	return nil
+}
+
+func (p *Postgres) RemoveTrack(ctx context.Context, albumID, id int64) error {
+	res, err := p.q().ExecContext(ctx, "DELETE FROM tracks WHERE id=$1 AND album_id=$2", id, albumID)
+	return affectedOne(res, err)
+}
+
+func (p *Postgres) IdolCredits(ctx context.Context, idolID int64) ([]models.IdolCredit, error) {
+	if _, err := p.Get(ctx, idolID); err != nil {
+		return nil, err
+	}
+	rows, err := p.q().QueryContext(ctx, `SELECT c.role, t.id, t.track_no, t.title, a.id, a.title, g.id, g.name, a.release_date
+		FROM track_credits c
+		JOIN tracks t ON t.id = c.track_id
+		JOIN albums a ON a.id = t.album_id
+		JOIN groups g ON g.id = a.group_id
+		WHERE c.idol_id = $1
+		ORDER BY a.release_date NULLS LAST, a.id, t.track_no, c.role`, idolID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []models.IdolCredit{}
+	for rows.Next() {
+		var c models.IdolCredit
+		if err := rows.Scan(&c.Role, &c.TrackID, &c.TrackNumber, &c.TrackTitle, &c.AlbumID, &c.AlbumTitle,
+			&c.GroupID, &c.GroupName, &c.ReleaseDate); err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
+
+func (m *Memory) ListAlbums(ctx context.Context, groupID int64) ([]models.Album, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := []models.Album{}
+	for _, a := range m.albums {
+		if groupID == 0 || a.GroupID == groupID {
+			list = append(list, m.fillAlbum(a))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return albumLess(list[i], list[j]) })
+	return list, nil
+}
+
+// albumLess orders albums by release date, undated ones last, then by id.
+func albumLess(a, b models.Album) bool {
+	x, y := a.ReleaseDate, b.ReleaseDate
+	switch {
+	case x != nil && y == nil:
+		return true
+	case x == nil && y != nil:
+		return false
+	case x != nil && !x.Equal(y.Time):
+		return x.Before(y.Time)
+	}
+	return a.ID < b.ID
+}
+
+func (m *Memory) GetAlbum(ctx context.Context, id int64) (models.Album, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	a, ok := m.albums[id]
+	if !ok {
+		return models.Album{}, ErrNotFound
+	}
+	return m.fillAlbum(a), nil
+}
+
+func (m *Memory) CreateAlbum(ctx context.Context, in models.Album) (models.Album, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.groups[in.GroupID]; !ok {
+		return models.Album{}, ErrUnknownGroup
+	}
+	now := time.Now()
+	m.albumSeq++
+	in.ID, in.Kind = m.albumSeq, albumKindOr(in.Kind)
+	in.CreatedAt, in.UpdatedAt = now, now
+	m.albums[in.ID] = in
+	return m.fillAlbum(in), nil
+}
+
+func (m *Memory) UpdateAlbum(ctx context.Context, in models.Album) (models.Album, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.groups[in.GroupID]; !ok {
+		return models.Album{}, ErrUnknownGroup
+	}
+	cur, ok := m.albums[in.ID]
+	if !ok {
+		return models.Album{}, ErrNotFound
+	}
+	in.Kind = albumKindOr(in.Kind)
+	in.CreatedAt, in.UpdatedAt = cur.CreatedAt, time.Now()
+	m.albums[in.ID] = in
+	return m.fillAlbum(in), nil
+}
+
+func (m *Memory) DeleteAlbum(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.albums[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.albums, id)
+	for tid, t := range m.tracks {
+		if t.AlbumID == id {
+			delete(m.tracks, tid)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) AlbumTracks(ctx context.Context, albumID int64) ([]models.Track, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.albums[albumID]; !ok {
+		return nil, ErrNotFound
+	}
+	list := []models.Track{}
+	for _, t := range m.tracks {
+		if t.AlbumID == albumID {
+			list = append(list, m.fillTrack(t))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Number != list[j].Number {
+			return list[i].Number < list[j].Number
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+func (m *Memory) AddTrack(ctx context.Context, in models.Track) (models.Track, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.albums[in.AlbumID]; !ok {
+		return models.Track{}, ErrNotFound
+	}
+	in.ID = 0
+	if err := m.checkTrack(in); err != nil {
+		return models.Track{}, err
+	}
+	m.trackSeq++
+	in.ID = m.trackSeq
+	in.Credits = m.mergeCredits(nil, in.Credits)
+	m.tracks[in.ID] = in
+	return m.fillTrack(in), nil
+}
+
+func (m *Memory) UpdateTrack(ctx context.Context, in models.Track) (models.Track, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur, ok := m.tracks[in.ID]
+	if !ok || cur.AlbumID != in.AlbumID {
+		return models.Track{}, ErrNotFound
+	}
+	if err := m.checkTrack(in); err != nil {
+		return models.Track{}, err
+	}
+	in.Credits = m.mergeCredits(cur.Credits, in.Credits)
+	m.tracks[in.ID] = in
+	return m.fillTrack(in), nil
+}
+
+// checkTrack mirrors the track number constraint and setCredits; callers
+// hold m.mu.
+func (m *Memory) checkTrack(in models.Track) error {
+	for _, t := range m.tracks {
+		if t.ID != in.ID && t.AlbumID == in.AlbumID && t.Number == in.Number {
+			return ErrDuplicate
+		}
+	}
+	type credit struct {
+		idol int64
+		role string
+	}
+	seen := map[credit]bool{}
+	for _, c := range in.Credits {
+		if it, ok := m.idols[c.IdolID]; !ok || it.DeletedAt != nil {
+			return ErrUnknownIdol
+		}
+		k := credit{c.IdolID, c.Role}
+		if seen[k] {
+			return ErrDuplicate
+		}
+		seen[k] = true
+	}
+	return nil
+}
+
+// mergeCredits keeps the credits of soft-deleted idols from cur and adds
+// next, which checkTrack has checked; callers hold m.mu.
+func (m *Memory) mergeCredits(cur, next []models.Credit) []models.Credit {
+	list := []models.Credit{}
+	for _, c := range cur {
+		if m.idols[c.IdolID].DeletedAt != nil {
+			list = append(list, models.Credit{IdolID: c.IdolID, Role: c.Role})
+		}
+	}
+	for _, c := range next {
+		list = append(list, models.Credit{IdolID: c.IdolID, Role: c.Role})
+	}
+	return list
+}
+
+func (m *Memory) RemoveTrack(ctx context.Context, albumID, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.tracks[id]; !ok || t.AlbumID != albumID {
+		return ErrNotFound
+	}
+	delete(m.tracks, id)
+	return nil
+}
+
+func (m *Memory) IdolCredits(ctx context.Context, idolID int64) ([]models.IdolCredit, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if it, ok := m.idols[idolID]; !ok || it.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+	type entry struct {
+		album  models.Album
+		credit models.IdolCredit
+	}
+	var entries []entry
+	for _, t := range m.tracks {
+		a := m.fillAlbum(m.albums[t.AlbumID])
+		for _, c := range t.Credits {
+			if c.IdolID != idolID {
+				continue
+			}
+			entries = append(entries, entry{a, models.IdolCredit{
+				Role: c.Role, TrackID: t.ID, TrackNumber: t.Number, TrackTitle: t.Title,
+				AlbumID: a.ID, AlbumTitle: a.Title, GroupID: a.GroupID, GroupName: a.GroupName, ReleaseDate: a.ReleaseDate,
+			}})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		switch {
+		case a.album.ID != b.album.ID:
+			return albumLess(a.album, b.album)
+		case a.credit.TrackNumber != b.credit.TrackNumber:
+			return a.credit.TrackNumber < b.credit.TrackNumber
+		}
+		return a.credit.Role < b.credit.Role
+	})
+	list := make([]models.IdolCredit, len(entries))
+	for i, e := range entries {
+		list[i] = e.credit
+	}
+	return list, nil
+}
+
+// fillAlbum sets the group name; callers hold m.mu.
+func (m *Memory) fillAlbum(a models.Album) models.Album {
+	a.GroupName = m.groups[a.GroupID].Name
+	return a
+}
+
+// fillTrack copies t with the credits of live idols and their names, in the
+// order the Postgres store lists them; callers hold m.mu.
+func (m *Memory) fillTrack(t models.Track) models.Track {
+	credits := []models.Credit{}
+	for _, c := range t.Credits {
+		if it := m.idols[c.IdolID]; it.DeletedAt == nil {
+			c.IdolName = it.Name
+			credits = append(credits, c)
+		}
+	}
+	sort.Slice(credits, func(i, j int) bool {
+		a, b := credits[i], credits[j]
+		switch {
+		case a.Role != b.Role:
+			return a.Role < b.Role
+		case a.IdolName != b.IdolName:
+			return a.IdolName < b.IdolName
+		}
+		return a.IdolID < b.IdolID
+	})
+	t.Credits = credits
+	return t
+}
+
+// dropCredits removes the credits of a purged idol; callers hold m.mu.
+func (m *Memory) dropCredits(idolID int64) {
+	for id, t := range m.tracks {
+		credits := t.Credits[:0:0]
+		for _, c := range t.Credits {
+			if c.IdolID != idolID {
+				credits = append(credits, c)
+			}
+		}
+		t.Credits = credits
+		m.tracks[id] = t
+	}
+}
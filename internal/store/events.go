@@ -0,0 +1,295 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"kpopapi/internal/models"
+)
+
+// EventFilter selects the events that overlap [From, To). A zero bound is
+// open. Events without an end match when they start inside the range.
+type EventFilter struct {
+	From, To time.Time
+	// GroupID, when set, keeps the events of the group and of the idols whose
+	// group it is.
+	GroupID int64
+	Kind    string
+}
+
+// EventStore is the persistence contract for scheduled events. Events of
+// soft-deleted idols are left out of listings.
+type EventStore interface {
+	// ListEvents returns the matching events by start time.
+	ListEvents(ctx context.Context, f EventFilter) ([]models.Event, error)
+	GetEvent(ctx context.Context, id int64) (models.Event, error)
+	// CreateEvent fails with ErrUnknownGroup or ErrUnknownIdol when the
+	// event is linked to a missing group or idol.
+	CreateEvent(ctx context.Context, in models.Event) (models.Event, error)
+	UpdateEvent(ctx context.Context, in models.Event) (models.Event, error)
+	DeleteEvent(ctx context.Context, id int64) error
+}
+
+var (
+	_ EventStore = (*Postgres)(nil)
+	_ EventStore = (*Memory)(nil)
+)
+
+const eventSelect = `SELECT e.id, e.kind, e.title, e.description, e.venue, e.starts_at, e.ends_at, e.all_day,
+		e.group_id, COALESCE(g.name, ''), e.idol_id, COALESCE(i.name, ''), e.created_at, e.updated_at
+	FROM events e LEFT JOIN groups g ON g.id = e.group_id LEFT JOIN idols i ON i.id = e.idol_id`
+
+func scanEvent(row rowScanner) (models.Event, error) {
+	var e models.Event
+	var endsAt sql.NullTime
+	err := row.Scan(&e.ID, &e.Kind, &e.Title, &e.Description, &e.Venue, &e.StartsAt, &endsAt, &e.AllDay,
+		&e.GroupID, &e.GroupName, &e.IdolID, &e.IdolName, &e.CreatedAt, &e.UpdatedAt)
+	if err != nil {
+		return e, notFoundOr(err)
+	}
+	if endsAt.Valid {
+		e.EndsAt = &endsAt.Time
+	}
+	return inKST(e), nil
+}
+
+// inKST shows the event times in KST.
+func inKST(e models.Event) models.Event {
+	e.StartsAt = e.StartsAt.In(models.KST)
+	if e.EndsAt != nil {
+		end := e.EndsAt.In(models.KST)
+		e.EndsAt = &end
+	}
+	return e
+}
+
+// nullTime is nil for the zero time, so open bounds reach the query as NULL.
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func (p *Postgres) ListEvents(ctx context.Context, f EventFilter) ([]models.Event, error) {
+	rows, err := p.q().QueryContext(ctx, eventSelect+`
+		WHERE (i.id IS NULL OR i.deleted_at IS NULL)
+		  AND ($1::timestamptz IS NULL OR e.starts_at >= $1 OR e.ends_at > $1)
+		  AND ($2::timestamptz IS NULL OR e.starts_at < $2)
+		  AND ($3 = 0 OR e.group_id = $3 OR i.group_id = $3)
+		  AND ($4 = '' OR e.kind = $4)
+		ORDER BY e.starts_at, e.id`, nullTime(f.From), nullTime(f.To), f.GroupID, f.Kind)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []models.Event{}
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+func (p *Postgres) GetEvent(ctx context.Context, id int64) (models.Event, error) {
+	return scanEvent(p.q().QueryRowContext(ctx, eventSelect+" WHERE e.id = $1 AND (i.id IS NULL OR i.deleted_at IS NULL)", id))
+}
+
+func (p *Postgres) CreateEvent(ctx context.Context, in models.Event) (e models.Event, err error) {
+	err = p.inTx(ctx, func(q querier) error {
+		if err := checkLinks(ctx, q, in.GroupID, in.IdolID); err != nil {
+			return err
+		}
+		if err := q.QueryRowContext(ctx, `INSERT INTO events (kind, title, description, venue, starts_at, ends_at, all_day, group_id, idol_id)
+			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9) RETURNING id`,
+			in.Kind, in.Title, in.Description, in.Venue, in.StartsAt, in.EndsAt, in.AllDay, in.GroupID, in.IdolID).Scan(&in.ID); err != nil {
+			return err
+		}
+		e, err = scanEvent(q.QueryRowContext(ctx, eventSelect+" WHERE e.id = $1", in.ID))
+		return err
+	})
+	return e, err
+}
+
+func (p *Postgres) UpdateEvent(ctx context.Context, in models.Event) (e models.Event, err error) {
+	err = p.inTx(ctx, func(q querier) error {
+		if err := checkLinks(ctx, q, in.GroupID, in.IdolID); err != nil {
+			return err
+		}
+		err := q.QueryRowContext(ctx, `UPDATE events SET kind=$1, title=$2, description=$3, venue=$4, starts_at=$5, ends_at=$6,
+			all_day=$7, group_id=$8, idol_id=$9, updated_at=NOW() WHERE id=$10 RETURNING id`,
+			in.Kind, in.Title, in.Description, in.Venue, in.StartsAt, in.EndsAt, in.AllDay, in.GroupID, in.IdolID, in.ID).Scan(&in.ID)
+		if err != nil {
+			return notFoundOr(err)
+		}
+		e, err = scanEvent(q.QueryRowContext(ctx, eventSelect+" WHERE e.id = $1", in.ID))
+		return err
+	})
+	return e, err
+}
+
+// checkLinks verifies that the group and the live idol an event or poll
+// option names exist.
+func checkLinks(ctx context.Context, q querier, groupID, idolID *int64) error {
+	var one int
+	if groupID != nil {
+		err := q.QueryRowContext(ctx, "SELECT 1 FROM groups WHERE id=$1", *groupID).Scan(&one)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUnknownGroup
+		} else if err != nil {
+			return err
+		}
+	}
+	if idolID != nil {
+		err := q.QueryRowContext(ctx, "SELECT 1 FROM idols WHERE id=$1 AND deleted_at IS NULL", *idolID).Scan(&one)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUnknownIdol
+		}
+		return err
+	}
+	return nil
+}
+
+func (p *Postgres) DeleteEvent(ctx context.Context, id int64) error {
+	res, err := p.q().ExecContext(ctx, "DELETE FROM events WHERE id=$1", id)
+	return affectedOne(res, err)
+}
+
+func (m *Memory) ListEvents(ctx context.Context, f EventFilter) ([]models.Event, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := []models.Event{}
+	for _, e := range m.events {
+		if !m.eventVisible(e) || !eventMatches(e, f, m.eventIdolGroup(e)) {
+			continue
+		}
+		list = append(list, m.fillEvent(e))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].StartsAt.Equal(list[j].StartsAt) {
+			return list[i].StartsAt.Before(list[j].StartsAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+// eventMatches mirrors the ListEvents query; idolGroup is the group of the
+// event's idol, if any.
+func eventMatches(e models.Event, f EventFilter, idolGroup int64) bool {
+	if !f.From.IsZero() && e.StartsAt.Before(f.From) && (e.EndsAt == nil || !e.EndsAt.After(f.From)) {
+		return false
+	}
+	if !f.To.IsZero() && !e.StartsAt.Before(f.To) {
+		return false
+	}
+	if f.GroupID != 0 && (e.GroupID == nil || *e.GroupID != f.GroupID) && idolGroup != f.GroupID {
+		return false
+	}
+	return f.Kind == "" || e.Kind == f.Kind
+}
+
+// eventVisible reports whether the event's idol, if any, is live; callers
+// hold m.mu.
+func (m *Memory) eventVisible(e models.Event) bool {
+	return e.IdolID == nil || m.idols[*e.IdolID].DeletedAt == nil
+}
+
+// eventIdolGroup returns the group of the event's idol; callers hold m.mu.
+func (m *Memory) eventIdolGroup(e models.Event) int64 {
+	if e.IdolID == nil {
+		return 0
+	}
+	return m.idols[*e.IdolID].GroupID
+}
+
+func (m *Memory) GetEvent(ctx context.Context, id int64) (models.Event, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	e, ok := m.events[id]
+	if !ok || !m.eventVisible(e) {
+		return models.Event{}, ErrNotFound
+	}
+	return m.fillEvent(e), nil
+}
+
+func (m *Memory) CreateEvent(ctx context.Context, in models.Event) (models.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkLinks(in.GroupID, in.IdolID); err != nil {
+		return models.Event{}, err
+	}
+	now := time.Now()
+	m.eventSeq++
+	in.ID = m.eventSeq
+	in.CreatedAt, in.UpdatedAt = now, now
+	m.events[in.ID] = in
+	return m.fillEvent(in), nil
+}
+
+func (m *Memory) UpdateEvent(ctx context.Context, in models.Event) (models.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur, ok := m.events[in.ID]
+	if !ok {
+		return models.Event{}, ErrNotFound
+	}
+	if err := m.checkLinks(in.GroupID, in.IdolID); err != nil {
+		return models.Event{}, err
+	}
+	in.CreatedAt, in.UpdatedAt = cur.CreatedAt, time.Now()
+	m.events[in.ID] = in
+	return m.fillEvent(in), nil
+}
+
+// checkLinks mirrors the Postgres checkLinks; callers hold m.mu.
+func (m *Memory) checkLinks(groupID, idolID *int64) error {
+	if groupID != nil {
+		if _, ok := m.groups[*groupID]; !ok {
+			return ErrUnknownGroup
+		}
+	}
+	if idolID != nil {
+		if it, ok := m.idols[*idolID]; !ok || it.DeletedAt != nil {
+			return ErrUnknownIdol
+		}
+	}
+	return nil
+}
+
+func (m *Memory) DeleteEvent(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.events[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.events, id)
+	return nil
+}
+
+// fillEvent sets the group and idol names and shows the times in KST;
+// callers hold m.mu.
+func (m *Memory) fillEvent(e models.Event) models.Event {
+	if e.GroupID != nil {
+		e.GroupName = m.groups[*e.GroupID].Name
+	}
+	if e.IdolID != nil {
+		e.IdolName = m.idols[*e.IdolID].Name
+	}
+	return inKST(e)
+}
+
+// dropEvents removes the events of a purged idol; callers hold m.mu.
+func (m *Memory) dropEvents(idolID int64) {
+	for id, e := range m.events {
+		if e.IdolID != nil && *e.IdolID == idolID {
+			delete(m.events, id)
+		}
+	}
+}
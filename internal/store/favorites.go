@@ -0,0 +1,254 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+
+	"kpopapi/internal/models"
+)
+
+// FavoriteStore is the persistence contract for per-user favorites lists.
+// Soft-deleted idols keep their place on a list but are not shown, and
+// positions count only the idols that are shown.
+type FavoriteStore interface {
+	Favorites(ctx context.Context, username string) ([]models.Favorite, error)
+	// SetFavorite puts the live idol at position, shifting the favorites
+	// from there down; a position past the end puts it last. Position 0
+	// adds a new favorite last and leaves an existing one in place. created
+	// reports whether the idol was not a favorite before. It fails with
+	// ErrNotFound when the idol does not exist or is deleted.
+	SetFavorite(ctx context.Context, username string, idolID int64, position int) (f models.Favorite, created bool, err error)
+	RemoveFavorite(ctx context.Context, username string, idolID int64) error
+}
+
+var (
+	_ FavoriteStore = (*Postgres)(nil)
+	_ FavoriteStore = (*Memory)(nil)
+)
+
+// favoriteCountExpr counts the favorites of the idols row.
+const favoriteCountExpr = `(SELECT COUNT(*) FROM favorites fc WHERE fc.idol_id = idols.id)`
+
+// placeFavorite returns order with idolID moved or added as SetFavorite
+// describes; live reports whether an idol is shown.
+func placeFavorite(order []int64, live func(int64) bool, idolID int64, position int) (out []int64, created bool) {
+	created = true
+	rest := make([]int64, 0, len(order)+1)
+	for _, id := range order {
+		if id == idolID {
+			created = false
+			continue
+		}
+		rest = append(rest, id)
+	}
+	if !created && position == 0 {
+		return order, false
+	}
+	at := len(rest)
+	if position > 0 {
+		shown := 0
+		for i, id := range rest {
+			if live(id) {
+				shown++
+			}
+			if shown == position {
+				at = i
+				break
+			}
+		}
+	}
+	out = append(out, rest[:at]...)
+	out = append(out, idolID)
+	return append(out, rest[at:]...), created
+}
+
+// shownPosition returns the 1-based position of idolID among the shown
+// idols of order.
+func shownPosition(order []int64, live func(int64) bool, idolID int64) int {
+	n := 0
+	for _, id := range order {
+		if live(id) {
+			n++
+		}
+		if id == idolID {
+			break
+		}
+	}
+	return n
+}
+
+const favoriteSelect = "SELECT " + idolColumns + ", f.added_at FROM favorites f JOIN idols ON idols.id = f.idol_id"
+
+// addedScanner appends the trailing added_at column to a scanIdol call.
+type addedScanner struct {
+	row   rowScanner
+	added *time.Time
+}
+
+func (s addedScanner) Scan(dest ...interface{}) error {
+	return s.row.Scan(append(dest, s.added)...)
+}
+
+func (p *Postgres) Favorites(ctx context.Context, username string) ([]models.Favorite, error) {
+	rows, err := p.q().QueryContext(ctx, favoriteSelect+`
+		WHERE f.username = $1 AND idols.deleted_at IS NULL
+		ORDER BY f.sort_order, f.idol_id`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []models.Favorite{}
+	for rows.Next() {
+		f := models.Favorite{Position: len(list) + 1}
+		if f.Idol, err = scanIdol(addedScanner{rows, &f.AddedAt}); err != nil {
+			return nil, err
+		}
+		list = append(list, f)
+	}
+	return list, rows.Err()
+}
+
+func (p *Postgres) SetFavorite(ctx context.Context, username string, idolID int64, position int) (f models.Favorite, created bool, err error) {
+	err = p.inTx(ctx, func(q querier) error {
+		// Writers of one user's list take turns so the reordering below
+		// works on the current list.
+		if _, err := q.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('favorites:' || $1))", username); err != nil {
+			return err
+		}
+		var one int
+		err := q.QueryRowContext(ctx, "SELECT 1 FROM idols WHERE id=$1 AND deleted_at IS NULL", idolID).Scan(&one)
+		if err != nil {
+			return notFoundOr(err)
+		}
+		order, live, err := favoriteOrder(ctx, q, username)
+		if err != nil {
+			return err
+		}
+		live[idolID] = true
+		isLive := func(id int64) bool { return live[id] }
+		order, created = placeFavorite(order, isLive, idolID, position)
+		if created {
+			if _, err := q.ExecContext(ctx, "INSERT INTO favorites (username, idol_id, sort_order) VALUES ($1,$2,0)", username, idolID); err != nil {
+				return mapConstraint(err)
+			}
+		}
+		if created || position != 0 {
+			if _, err := q.ExecContext(ctx, `UPDATE favorites f SET sort_order = x.n
+				FROM unnest($2::bigint[]) WITH ORDINALITY AS x(idol_id, n)
+				WHERE f.username = $1 AND f.idol_id = x.idol_id`, username, pq.Array(order)); err != nil {
+				return err
+			}
+		}
+		f.Position = shownPosition(order, isLive, idolID)
+		f.Idol, err = scanIdol(addedScanner{q.QueryRowContext(ctx, favoriteSelect+" WHERE f.username = $1 AND f.idol_id = $2", username, idolID), &f.AddedAt})
+		return err
+	})
+	return f, created, err
+}
+
+// favoriteOrder returns the idols on a user's list in order, and which of
+// them are live.
+func favoriteOrder(ctx context.Context, q querier, username string) ([]int64, map[int64]bool, error) {
+	rows, err := q.QueryContext(ctx, `SELECT f.idol_id, i.deleted_at IS NULL FROM favorites f JOIN idols i ON i.id = f.idol_id
+		WHERE f.username = $1 ORDER BY f.sort_order, f.idol_id`, username)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var order []int64
+	live := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		var ok bool
+		if err := rows.Scan(&id, &ok); err != nil {
+			return nil, nil, err
+		}
+		order = append(order, id)
+		live[id] = ok
+	}
+	return order, live, rows.Err()
+}
+
+func (p *Postgres) RemoveFavorite(ctx context.Context, username string, idolID int64) error {
+	res, err := p.q().ExecContext(ctx, "DELETE FROM favorites WHERE username=$1 AND idol_id=$2", username, idolID)
+	return affectedOne(res, err)
+}
+
+// favoriteEntry is an idol on a user's list in the Memory store.
+type favoriteEntry struct {
+	idolID  int64
+	addedAt time.Time
+}
+
+func (m *Memory) Favorites(ctx context.Context, username string) ([]models.Favorite, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := []models.Favorite{}
+	for _, e := range m.favorites[username] {
+		if it := m.idols[e.idolID]; it.DeletedAt == nil {
+			list = append(list, models.Favorite{Position: len(list) + 1, AddedAt: e.addedAt, Idol: it})
+		}
+	}
+	return list, nil
+}
+
+func (m *Memory) SetFavorite(ctx context.Context, username string, idolID int64, position int) (models.Favorite, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, ok := m.idols[idolID]
+	if !ok || it.DeletedAt != nil {
+		return models.Favorite{}, false, ErrNotFound
+	}
+	entries := m.favorites[username]
+	added := map[int64]time.Time{idolID: time.Now()}
+	order := make([]int64, len(entries))
+	for i, e := range entries {
+		order[i] = e.idolID
+		added[e.idolID] = e.addedAt
+	}
+	isLive := func(id int64) bool { return m.idols[id].DeletedAt == nil }
+	order, created := placeFavorite(order, isLive, idolID, position)
+	entries = make([]favoriteEntry, len(order))
+	for i, id := range order {
+		entries[i] = favoriteEntry{idolID: id, addedAt: added[id]}
+	}
+	m.favorites[username] = entries
+	if created {
+		it.FavoriteCount++
+		m.idols[idolID] = it
+	}
+	f := models.Favorite{Position: shownPosition(order, isLive, idolID), AddedAt: added[idolID], Idol: it}
+	return f, created, nil
+}
+
+func (m *Memory) RemoveFavorite(ctx context.Context, username string, idolID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := m.favorites[username]
+	for i, e := range entries {
+		if e.idolID != idolID {
+			continue
+		}
+		m.favorites[username] = append(entries[:i:i], entries[i+1:]...)
+		it := m.idols[idolID]
+		it.FavoriteCount--
+		m.idols[idolID] = it
+		return nil
+	}
+	return ErrNotFound
+}
+
+// dropFavorites removes a purged idol from every list; callers hold m.mu.
+func (m *Memory) dropFavorites(idolID int64) {
+	for user, entries := range m.favorites {
+		kept := entries[:0]
+		for _, e := range entries {
+			if e.idolID != idolID {
+				kept = append(kept, e)
+			}
+		}
+		m.favorites[user] = kept
+	}
+}
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// FeedTokenStore keeps one calendar feed token per user. Calendar apps
+// cannot send a bearer token, so the feed URL carries this one instead.
+// Only a hash of the token is stored; the token itself is shown once.
+type FeedTokenStore interface {
+	// IssueFeedToken returns a new token for username, revoking the old one.
+	IssueFeedToken(ctx context.Context, username string) (string, error)
+	// RevokeFeedToken drops the token of username; it fails with
+	// ErrNotFound when there is none.
+	RevokeFeedToken(ctx context.Context, username string) error
+	// FeedTokenUser returns the user a token belongs to, or ErrNotFound.
+	FeedTokenUser(ctx context.Context, token string) (string, error)
+}
+
+var (
+	_ FeedTokenStore = (*Postgres)(nil)
+	_ FeedTokenStore = (*Memory)(nil)
+)
+
+func newFeedToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(b)
+	return token, hashFeedToken(token), nil
+}
+
+func hashFeedToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (p *Postgres) IssueFeedToken(ctx context.Context, username string) (string, error) {
+	token, hash, err := newFeedToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = p.q().ExecContext(ctx, `INSERT INTO feed_tokens (username, token_hash) VALUES ($1, $2)
+		ON CONFLICT (username) DO UPDATE SET token_hash = EXCLUDED.token_hash, created_at = NOW()`,
+		username, hash)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (p *Postgres) RevokeFeedToken(ctx context.Context, username string) error {
+	res, err := p.q().ExecContext(ctx, "DELETE FROM feed_tokens WHERE username=$1", username)
+	return affectedOne(res, err)
+}
+
+func (p *Postgres) FeedTokenUser(ctx context.Context, token string) (string, error) {
+	var username string
+	err := p.q().QueryRowContext(ctx, "SELECT username FROM feed_tokens WHERE token_hash=$1", hashFeedToken(token)).Scan(&username)
+	return username, notFoundOr(err)
+}
+
+func (m *Memory) IssueFeedToken(ctx context.Context, username string) (string, error) {
+	token, hash, err := newFeedToken()
+	if err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.feedTokens[username] = hash
+	return token, nil
+}
+
+func (m *Memory) RevokeFeedToken(ctx context.Context, username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.feedTokens[username]; !ok {
+		return ErrNotFound
+	}
+	delete(m.feedTokens, username)
+	return nil
+}
+
+func (m *Memory) FeedTokenUser(ctx context.Context, token string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	hash := hashFeedToken(token)
+	for username, h := range m.feedTokens {
+		if h == hash {
+			return username, nil
+		}
+	}
+	return "", ErrNotFound
+}
@@ -0,0 +1,379 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"kpopapi/internal/models"
+)
+
+var (
+	// ErrUnknownGroup is returned when an idol references a missing group.
+	ErrUnknownGroup = errors.New("unknown group")
+	// ErrDuplicate is returned when a unique name is already taken.
+	ErrDuplicate = errors.New("already exists")
+	// ErrInUse is returned when a row is still referenced by others.
+	ErrInUse = errors.New("still in use")
+	// ErrGroupCycle is returned when a group would become its own subunit.
+	ErrGroupCycle = errors.New("group cannot be a subunit of itself")
+)
+
+// GroupStore is the persistence contract for groups. Idols keep a copy of
+// their group's name in group_name, which renames keep in sync.
+type GroupStore interface {
+	ListGroups(ctx context.Context) ([]models.Group, error)
+	GetGroup(ctx context.Context, id int64) (models.Group, error)
+	CreateGroup(ctx context.Context, in models.Group) (models.Group, error)
+	// UpdateGroup also renames the group in its idols, deleted or not, as a
+	// new version of each by actor.
+	UpdateGroup(ctx context.Context, in models.Group, actor string) (models.Group, error)
+	// DeleteGroup fails with ErrInUse while any idol, deleted or not, album,
+	// event or poll option is in the group.
+	DeleteGroup(ctx context.Context, id int64) error
+}
+
+var (
+	_ GroupStore = (*Postgres)(nil)
+	_ GroupStore = (*Memory)(nil)
+)
+
+func statusOr(status string) string {
+	if status == "" {
+		return models.GroupActive
+	}
+	return status
+}
+
+const groupColumns = "id, name, parent_id, debut_date, fandom_name, agency, status, created_at, updated_at"
+
+func scanGroup(row rowScanner) (models.Group, error) {
+	var g models.Group
+	err := row.Scan(&g.ID, &g.Name, &g.ParentID, &g.DebutDate, &g.FandomName, &g.Agency, &g.Status, &g.CreatedAt, &g.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return g, ErrNotFound
+	}
+	return g, mapConstraint(err)
+}
+
+// mapConstraint turns Postgres unique and foreign key violations into
+// ErrDuplicate and ErrInUse.
+func mapConstraint(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "23505":
+			return ErrDuplicate
+		case "23503":
+			return ErrInUse
+		}
+	}
+	return err
+}
+
+func (p *Postgres) ListGroups(ctx context.Context) ([]models.Group, error) {
+	rows, err := p.q().QueryContext(ctx, "SELECT "+groupColumns+" FROM groups ORDER BY name, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []models.Group{}
+	for rows.Next() {
+		g, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, g)
+	}
+	return list, rows.Err()
+}
+
+func (p *Postgres) GetGroup(ctx context.Context, id int64) (models.Group, error) {
+	return scanGroup(p.q().QueryRowContext(ctx, "SELECT "+groupColumns+" FROM groups WHERE id=$1", id))
+}
+
+func (p *Postgres) CreateGroup(ctx context.Context, in models.Group) (g models.Group, err error) {
+	err = p.inTx(ctx, func(q querier) error {
+		if err := checkParent(ctx, q, in); err != nil {
+			return err
+		}
+		g, err = scanGroup(q.QueryRowContext(ctx,
+			"INSERT INTO groups (name, parent_id, debut_date, fandom_name, agency, status) VALUES ($1,$2,$3,$4,$5,$6) RETURNING "+groupColumns,
+			in.Name, in.ParentID, in.DebutDate, in.FandomName, in.Agency, statusOr(in.Status)))
+		return err
+	})
+	return g, err
+}
+
+// checkParent verifies that in.ParentID exists and is not in.ID or one of
+// its subunits.
+func checkParent(ctx context.Context, q querier, in models.Group) error {
+	if in.ParentID == nil {
+		return nil
+	}
+	var exists, cycle bool
+	err := q.QueryRowContext(ctx, `
+		WITH RECURSIVE up AS (
+			SELECT id, parent_id FROM groups WHERE id=$1
+			UNION
+			SELECT g.id, g.parent_id FROM groups g JOIN up ON g.id = up.parent_id
+		)
+		SELECT EXISTS (SELECT 1 FROM up), EXISTS (SELECT 1 FROM up WHERE id=$2)`,
+		*in.ParentID, in.ID).Scan(&exists, &cycle)
+	switch {
+	case err != nil:
+		return err
+	case !exists:
+		return ErrUnknownGroup
+	case cycle:
+		return ErrGroupCycle
+	}
+	return nil
+}
+
+func (p *Postgres) UpdateGroup(ctx context.Context, in models.Group, actor string) (g models.Group, err error) {
+	err = p.inTx(ctx, func(q querier) error {
+		if err := checkParent(ctx, q, in); err != nil {
+			return err
+		}
+		g, err = scanGroup(q.QueryRowContext(ctx,
+			"UPDATE groups SET name=$1, parent_id=$2, debut_date=$3, fandom_name=$4, agency=$5, status=$6, updated_at=NOW() WHERE id=$7 RETURNING "+groupColumns,
+			in.Name, in.ParentID, in.DebutDate, in.FandomName, in.Agency, statusOr(in.Status), in.ID))
+		if err != nil {
+			return err
+		}
+		rows, err := q.QueryContext(ctx,
+			`UPDATE idols SET "group_name"=$1, updated_by=$3, updated_at=NOW(), version=version+1 WHERE group_id=$2 AND "group_name"<>$1 RETURNING `+idolColumns,
+			g.Name, g.ID, actorOr(actor))
+		if err != nil {
+			return err
+		}
+		var renamed []models.Idol
+		for rows.Next() {
+			it, err := scanIdol(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			renamed = append(renamed, it)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, it := range renamed {
+			if err := insertRevision(ctx, q, RevisionUpdate, it); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return g, err
+}
+
+func (p *Postgres) DeleteGroup(ctx context.Context, id int64) error {
+	res, err := p.q().ExecContext(ctx, "DELETE FROM groups WHERE id=$1", id)
+	return affectedOne(res, mapConstraint(err))
+}
+
+// resolveGroup fills in both GroupID and Group of an idol from whichever is
+// set. GroupID wins; a name must match an existing group, ignoring case.
+func resolveGroup(ctx context.Context, q querier, in *models.Idol) error {
+	if in.GroupID != 0 {
+		err := q.QueryRowContext(ctx, "SELECT name FROM groups WHERE id=$1", in.GroupID).Scan(&in.Group)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUnknownGroup
+		}
+		return err
+	}
+	name := strings.TrimSpace(in.Group)
+	if name == "" {
+		return ErrUnknownGroup
+	}
+	err := q.QueryRowContext(ctx, "SELECT id, name FROM groups WHERE LOWER(name) = LOWER($1)", name).Scan(&in.GroupID, &in.Group)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w %q", ErrUnknownGroup, name)
+	}
+	return err
+}
+
+func (m *Memory) ListGroups(ctx context.Context) ([]models.Group, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := []models.Group{}
+	for _, g := range m.groups {
+		list = append(list, g)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+func (m *Memory) GetGroup(ctx context.Context, id int64) (models.Group, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	g, ok := m.groups[id]
+	if !ok {
+		return g, ErrNotFound
+	}
+	return g, nil
+}
+
+func (m *Memory) CreateGroup(ctx context.Context, in models.Group) (models.Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.groupByName(in.Name); ok {
+		return models.Group{}, ErrDuplicate
+	}
+	if err := m.checkParent(in); err != nil {
+		return models.Group{}, err
+	}
+	return m.createGroup(in), nil
+}
+
+// checkParent mirrors the Postgres checkParent; callers hold m.mu.
+func (m *Memory) checkParent(in models.Group) error {
+	if in.ParentID == nil {
+		return nil
+	}
+	id := *in.ParentID
+	if _, ok := m.groups[id]; !ok {
+		return ErrUnknownGroup
+	}
+	for seen := map[int64]bool{}; id != 0 && !seen[id]; {
+		if id == in.ID {
+			return ErrGroupCycle
+		}
+		seen[id] = true
+		g := m.groups[id]
+		id = 0
+		if g.ParentID != nil {
+			id = *g.ParentID
+		}
+	}
+	return nil
+}
+
+// createGroup inserts in; callers hold m.mu and have checked the name.
+func (m *Memory) createGroup(in models.Group) models.Group {
+	now := time.Now()
+	m.groupSeq++
+	in.ID = m.groupSeq
+	in.Status = statusOr(in.Status)
+	in.CreatedAt, in.UpdatedAt = now, now
+	m.groups[in.ID] = in
+	return in
+}
+
+func (m *Memory) groupByName(name string) (models.Group, bool) {
+	for _, g := range m.groups {
+		if strings.EqualFold(g.Name, name) {
+			return g, true
+		}
+	}
+	return models.Group{}, false
+}
+
+func (m *Memory) UpdateGroup(ctx context.Context, in models.Group, actor string) (models.Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur, ok := m.groups[in.ID]
+	if !ok {
+		return models.Group{}, ErrNotFound
+	}
+	if other, ok := m.groupByName(in.Name); ok && other.ID != in.ID {
+		return models.Group{}, ErrDuplicate
+	}
+	if err := m.checkParent(in); err != nil {
+		return models.Group{}, err
+	}
+	in.Status = statusOr(in.Status)
+	in.CreatedAt, in.UpdatedAt = cur.CreatedAt, time.Now()
+	m.groups[in.ID] = in
+	now := time.Now()
+	for id, it := range m.idols {
+		if it.GroupID == in.ID && it.Group != in.Name {
+			it.Group = in.Name
+			it.UpdatedBy = actorOr(actor)
+			it.UpdatedAt = now
+			it.Version++
+			m.idols[id] = it
+			m.record(RevisionUpdate, it)
+		}
+	}
+	return in, nil
+}
+
+func (m *Memory) DeleteGroup(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.groups[id]; !ok {
+		return ErrNotFound
+	}
+	for _, it := range m.idols {
+		if it.GroupID == id {
+			return ErrInUse
+		}
+	}
+	for _, g := range m.groups {
+		if g.ParentID != nil && *g.ParentID == id {
+			return ErrInUse
+		}
+	}
+	for _, a := range m.albums {
+		if a.GroupID == id {
+			return ErrInUse
+		}
+	}
+	for _, e := range m.events {
+		if e.GroupID != nil && *e.GroupID == id {
+			return ErrInUse
+		}
+	}
+	for _, p := range m.polls {
+		for _, o := range p.Options {
+			if o.GroupID != nil && *o.GroupID == id {
+				return ErrInUse
+			}
+		}
+	}
+	delete(m.groups, id)
+	for mid, ms := range m.memberships {
+		if ms.GroupID == id {
+			delete(m.memberships, mid)
+		}
+	}
+	return nil
+}
+
+// resolveGroup mirrors the Postgres resolveGroup; callers hold m.mu.
+func (m *Memory) resolveGroup(in *models.Idol) error {
+	if in.GroupID != 0 {
+		g, ok := m.groups[in.GroupID]
+		if !ok {
+			return ErrUnknownGroup
+		}
+		in.Group = g.Name
+		return nil
+	}
+	name := strings.TrimSpace(in.Group)
+	if name == "" {
+		return ErrUnknownGroup
+	}
+	g, ok := m.groupByName(name)
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownGroup, name)
+	}
+	in.GroupID, in.Group = g.ID, g.Name
+	return nil
+}
@@ -0,0 +1,213 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// IdempotencyRecord is a request made with an Idempotency-Key and, once the
+// handler has answered, the answer to replay for repeats. Keys are scoped to
+// the user that sent them.
+type IdempotencyRecord struct {
+	User string
+	Key  string
+	// Fingerprint identifies the request the key was first used with.
+	Fingerprint string
+	// Status is 0 while the first request is still being handled.
+	Status    int
+	Header    http.Header
+	Body      []byte
+	ExpiresAt time.Time
+	// LeaseExpiresAt ends a reservation that was never answered, such as
+	// one whose server crashed mid-request, so the key can be retried.
+	LeaseExpiresAt time.Time
+	// Token tells reservations of the same key apart. ReserveKey sets it, and
+	// a request that was slower than its lease cannot settle the reservation
+	// of the retry that took the key over.
+	Token string
+}
+
+// IdempotencyStore keeps idempotency keys until they expire.
+type IdempotencyStore interface {
+	// ReserveKey claims in.User and in.Key for a new request. When the key
+	// is already held and not expired it returns the held record and false
+	// instead; expired keys and unanswered keys past their lease are
+	// reclaimed.
+	ReserveKey(ctx context.Context, in IdempotencyRecord) (IdempotencyRecord, bool, error)
+	// CompleteKey stores the answer of a reserved key. It returns
+	// ErrNotFound when the reservation with in.Token no longer holds the
+	// key.
+	CompleteKey(ctx context.Context, in IdempotencyRecord) error
+	// ReleaseKey drops a reserved key that has no answer, so the request can
+	// be retried. A key reserved again since, under another token, is kept.
+	ReleaseKey(ctx context.Context, in IdempotencyRecord) error
+	// PurgeExpiredKeys removes keys that expired before now and returns how
+	// many were removed.
+	PurgeExpiredKeys(ctx context.Context, now time.Time) (int64, error)
+}
+
+var (
+	_ IdempotencyStore = (*Postgres)(nil)
+	_ IdempotencyStore = (*Memory)(nil)
+)
+
+func newReservationToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (p *Postgres) ReserveKey(ctx context.Context, in IdempotencyRecord) (IdempotencyRecord, bool, error) {
+	token, err := newReservationToken()
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	in.Token = token
+	// A conflicting key is only taken over once it has expired or was left
+	// unanswered past its lease.
+	res, err := p.q().ExecContext(ctx, `INSERT INTO idempotency_keys (username, key, fingerprint, expires_at, lease_expires_at, token)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (username, key) DO UPDATE SET fingerprint = EXCLUDED.fingerprint, status = 0,
+			header = '{}', body = NULL, created_at = NOW(), expires_at = EXCLUDED.expires_at,
+			lease_expires_at = EXCLUDED.lease_expires_at, token = EXCLUDED.token
+		WHERE idempotency_keys.expires_at <= NOW()
+			OR (idempotency_keys.status = 0 AND idempotency_keys.lease_expires_at <= NOW())`,
+		in.User, in.Key, in.Fingerprint, in.ExpiresAt, in.LeaseExpiresAt, in.Token)
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	if n == 1 {
+		return in, true, nil
+	}
+	held := IdempotencyRecord{User: in.User, Key: in.Key}
+	var header []byte
+	err = p.q().QueryRowContext(ctx, `SELECT fingerprint, status, header, COALESCE(body, ''), expires_at, lease_expires_at, token
+		FROM idempotency_keys WHERE username = $1 AND key = $2`, in.User, in.Key).
+		Scan(&held.Fingerprint, &held.Status, &header, &held.Body, &held.ExpiresAt, &held.LeaseExpiresAt, &held.Token)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Purged between the two statements; the caller may retry.
+		return IdempotencyRecord{}, false, ErrNotFound
+	}
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	if err := json.Unmarshal(header, &held.Header); err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	return held, false, nil
+}
+
+func (p *Postgres) CompleteKey(ctx context.Context, in IdempotencyRecord) error {
+	header, err := json.Marshal(in.Header)
+	if err != nil {
+		return err
+	}
+	res, err := p.q().ExecContext(ctx, `UPDATE idempotency_keys SET status = $3, header = $4, body = $5
+		WHERE username = $1 AND key = $2 AND token = $6 AND status = 0`,
+		in.User, in.Key, in.Status, header, in.Body, in.Token)
+	return affectedOne(res, err)
+}
+
+func (p *Postgres) ReleaseKey(ctx context.Context, in IdempotencyRecord) error {
+	_, err := p.q().ExecContext(ctx, `DELETE FROM idempotency_keys WHERE username = $1 AND key = $2 AND token = $3 AND status = 0`,
+		in.User, in.Key, in.Token)
+	return err
+}
+
+func (p *Postgres) PurgeExpiredKeys(ctx context.Context, now time.Time) (int64, error) {
+	res, err := p.q().ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+type idempotencyKey struct{ user, key string }
+
+func (m *Memory) ReserveKey(ctx context.Context, in IdempotencyRecord) (IdempotencyRecord, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := idempotencyKey{in.User, in.Key}
+	now := time.Now()
+	if held, ok := m.idempotency[k]; ok && held.ExpiresAt.After(now) && (held.Status != 0 || held.LeaseExpiresAt.After(now)) {
+		return held, false, nil
+	}
+	token, err := newReservationToken()
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	in.Status, in.Header, in.Body, in.Token = 0, nil, nil, token
+	m.idempotency[k] = in
+	return in, true, nil
+}
+
+func (m *Memory) CompleteKey(ctx context.Context, in IdempotencyRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := idempotencyKey{in.User, in.Key}
+	held, ok := m.idempotency[k]
+	if !ok || held.Status != 0 || held.Token != in.Token {
+		return ErrNotFound
+	}
+	held.Status, held.Header, held.Body = in.Status, in.Header.Clone(), append([]byte(nil), in.Body...)
+	m.idempotency[k] = held
+	return nil
+}
+
+func (m *Memory) ReleaseKey(ctx context.Context, in IdempotencyRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := idempotencyKey{in.User, in.Key}
+	if held, ok := m.idempotency[k]; ok && held.Status == 0 && held.Token == in.Token {
+		delete(m.idempotency, k)
+	}
+	return nil
+}
+
+func (m *Memory) PurgeExpiredKeys(ctx context.Context, now time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for k, held := range m.idempotency {
+		if !held.ExpiresAt.After(now) {
+			delete(m.idempotency, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// RunKeyPurger removes expired idempotency keys every interval until ctx is
+// cancelled.
+func RunKeyPurger(ctx context.Context, keys IdempotencyStore, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := keys.PurgeExpiredKeys(ctx, time.Now()); err != nil {
+			log.Printf("idempotency key purge failed: %v", err)
+		} else if n > 0 {
+			log.Printf("idempotency key purge removed %d keys", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"kpopapi/internal/models"
+)
+
+var (
+	// ErrUnknownIdol is returned when a membership references a missing idol.
+	ErrUnknownIdol = errors.New("unknown idol")
+	// ErrOverlap is returned when a membership overlaps another stint of the
+	// same idol in the same group.
+	ErrOverlap = errors.New("membership overlaps an existing one")
+)
+
+// MembershipStore is the persistence contract for time-bounded group
+// memberships. An idol's group_id stays its primary group; creating an idol
+// opens a membership there if none is open, and moving it to another group
+// ends the open membership in the old group today and opens one from today
+// in the new.
+type MembershipStore interface {
+	// GroupMembers lists the members of a group, only those active on at
+	// when it is set. Soft-deleted idols are left out.
+	GroupMembers(ctx context.Context, groupID int64, at *models.Date) ([]models.Membership, error)
+	// IdolMemberships returns an idol's memberships ordered by join date.
+	IdolMemberships(ctx context.Context, idolID int64) ([]models.Membership, error)
+	AddMembership(ctx context.Context, in models.Membership) (models.Membership, error)
+	// UpdateMembership changes role and dates of a membership of in.GroupID.
+	UpdateMembership(ctx context.Context, in models.Membership) (models.Membership, error)
+	RemoveMembership(ctx context.Context, groupID, id int64) error
+}
+
+var (
+	_ MembershipStore = (*Postgres)(nil)
+	_ MembershipStore = (*Memory)(nil)
+)
+
+const membershipSelect = `SELECT m.id, m.idol_id, i.name, m.group_id, g.name, m.role, m.joined_on, m.left_on
+	FROM group_memberships m JOIN idols i ON i.id = m.idol_id JOIN groups g ON g.id = m.group_id`
+
+func scanMembership(row rowScanner) (models.Membership, error) {
+	var ms models.Membership
+	err := row.Scan(&ms.ID, &ms.IdolID, &ms.IdolName, &ms.GroupID, &ms.GroupName, &ms.Role, &ms.JoinedOn, &ms.LeftOn)
+	if err != nil {
+		return ms, notFoundOr(err)
+	}
+	return ms, nil
+}
+
+func queryMemberships(ctx context.Context, q querier, query string, args ...interface{}) ([]models.Membership, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []models.Membership{}
+	for rows.Next() {
+		ms, err := scanMembership(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, ms)
+	}
+	return list, rows.Err()
+}
+
+func (p *Postgres) GroupMembers(ctx context.Context, groupID int64, at *models.Date) ([]models.Membership, error) {
+	if _, err := p.GetGroup(ctx, groupID); err != nil {
+		return nil, err
+	}
+	return queryMemberships(ctx, p.q(), membershipSelect+`
+		WHERE m.group_id = $1 AND i.deleted_at IS NULL
+		  AND ($2::date IS NULL OR ((m.joined_on IS NULL OR m.joined_on <= $2) AND (m.left_on IS NULL OR m.left_on > $2)))
+		ORDER BY m.joined_on NULLS FIRST, i.name, m.id`, groupID, at)
+}
+
+func (p *Postgres) IdolMemberships(ctx context.Context, idolID int64) ([]models.Membership, error) {
+	if _, err := p.Get(ctx, idolID); err != nil {
+		return nil, err
+	}
+	return queryMemberships(ctx, p.q(), membershipSelect+`
+		WHERE m.idol_id = $1 ORDER BY m.joined_on NULLS FIRST, m.id`, idolID)
+}
+
+func (p *Postgres) AddMembership(ctx context.Context, in models.Membership) (ms models.Membership, err error) {
+	err = p.inTx(ctx, func(q querier) error {
+		if err := lockIdolMemberships(ctx, q, in); err != nil {
+			return err
+		}
+		if err := checkOverlap(ctx, q, in); err != nil {
+			return err
+		}
+		if err := q.QueryRowContext(ctx,
+			"INSERT INTO group_memberships (idol_id, group_id, role, joined_on, left_on) VALUES ($1,$2,$3,$4,$5) RETURNING id",
+			in.IdolID, in.GroupID, in.Role, in.JoinedOn, in.LeftOn).Scan(&in.ID); err != nil {
+			return err
+		}
+		ms, err = scanMembership(q.QueryRowContext(ctx, membershipSelect+" WHERE m.id = $1", in.ID))
+		return err
+	})
+	return ms, err
+}
+
+func (p *Postgres) UpdateMembership(ctx context.Context, in models.Membership) (ms models.Membership, err error) {
+	err = p.inTx(ctx, func(q querier) error {
+		err := q.QueryRowContext(ctx, "SELECT idol_id FROM group_memberships WHERE id=$1 AND group_id=$2",
+			in.ID, in.GroupID).Scan(&in.IdolID)
+		if err != nil {
+			return notFoundOr(err)
+		}
+		if err := lockIdolMemberships(ctx, q, in); err != nil {
+			return err
+		}
+		if err := checkOverlap(ctx, q, in); err != nil {
+			return err
+		}
+		if _, err := q.ExecContext(ctx, "UPDATE group_memberships SET role=$1, joined_on=$2, left_on=$3 WHERE id=$4",
+			in.Role, in.JoinedOn, in.LeftOn, in.ID); err != nil {
+			return err
+		}
+		ms, err = scanMembership(q.QueryRowContext(ctx, membershipSelect+" WHERE m.id = $1", in.ID))
+		return err
+	})
+	return ms, err
+}
+
+func (p *Postgres) RemoveMembership(ctx context.Context, groupID, id int64) error {
+	res, err := p.q().ExecContext(ctx, "DELETE FROM group_memberships WHERE id=$1 AND group_id=$2", id, groupID)
+	return affectedOne(res, err)
+}
+
+// lockIdolMemberships checks that the group and the live idol of in exist
+// and locks the idol row so concurrent writers cannot both pass
+// checkOverlap.
+func lockIdolMemberships(ctx context.Context, q querier, in models.Membership) error {
+	var one int
+	if err := q.QueryRowContext(ctx, "SELECT 1 FROM groups WHERE id=$1", in.GroupID).Scan(&one); err != nil {
+		return notFoundOr(err)
+	}
+	err := q.QueryRowContext(ctx, "SELECT 1 FROM idols WHERE id=$1 AND deleted_at IS NULL FOR UPDATE", in.IdolID).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUnknownIdol
+	}
+	return err
+}
+
+func checkOverlap(ctx context.Context, q querier, in models.Membership) error {
+	var overlap bool
+	err := q.QueryRowContext(ctx, `SELECT EXISTS (
+		SELECT 1 FROM group_memberships
+		WHERE idol_id = $1 AND group_id = $2 AND id <> $3
+		  AND (joined_on IS NULL OR $5::date IS NULL OR joined_on < $5::date)
+		  AND ($4::date IS NULL OR left_on IS NULL OR $4::date < left_on))`,
+		in.IdolID, in.GroupID, in.ID, in.JoinedOn, in.LeftOn).Scan(&overlap)
+	if err == nil && overlap {
+		return ErrOverlap
+	}
+	return err
+}
+
+// openMembership records that it is a member of its group since joined,
+// which may be nil, unless an open membership already says so.
+func openMembership(ctx context.Context, q querier, it models.Idol, joined *models.Date) error {
+	_, err := q.ExecContext(ctx, `INSERT INTO group_memberships (idol_id, group_id, joined_on)
+		SELECT $1, $2, $3 WHERE NOT EXISTS (
+			SELECT 1 FROM group_memberships WHERE idol_id = $1 AND group_id = $2 AND left_on IS NULL)`,
+		it.ID, it.GroupID, joined)
+	return err
+}
+
+// moveMembership ends the open membership of it in the group it moved away
+// from and opens one in its new group, both as of today. A stint that has
+// not started yet ends on the day it starts.
+func moveMembership(ctx context.Context, q querier, it models.Idol, from int64) error {
+	today := membershipToday()
+	if _, err := q.ExecContext(ctx, `UPDATE group_memberships SET left_on = GREATEST(joined_on, $3::date)
+		WHERE idol_id = $1 AND group_id = $2 AND left_on IS NULL`, it.ID, from, today); err != nil {
+		return err
+	}
+	return openMembership(ctx, q, it, &today)
+}
+
+// membershipToday is the date group moves take effect on.
+func membershipToday() models.Date {
+	return models.Date{Time: time.Now().UTC().Truncate(24 * time.Hour)}
+}
+
+func (m *Memory) GroupMembers(ctx context.Context, groupID int64, at *models.Date) ([]models.Membership, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.groups[groupID]; !ok {
+		return nil, ErrNotFound
+	}
+	list := []models.Membership{}
+	for _, ms := range m.memberships {
+		it := m.idols[ms.IdolID]
+		if ms.GroupID != groupID || it.DeletedAt != nil || (at != nil && !ms.ActiveOn(*at)) {
+			continue
+		}
+		list = append(list, m.fillMembership(ms))
+	}
+	sortMemberships(list, func(a, b models.Membership) bool {
+		if a.IdolName != b.IdolName {
+			return a.IdolName < b.IdolName
+		}
+		return a.ID < b.ID
+	})
+	return list, nil
+}
+
+func (m *Memory) IdolMemberships(ctx context.Context, idolID int64) ([]models.Membership, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if it, ok := m.idols[idolID]; !ok || it.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+	list := []models.Membership{}
+	for _, ms := range m.memberships {
+		if ms.IdolID == idolID {
+			list = append(list, m.fillMembership(ms))
+		}
+	}
+	sortMemberships(list, func(a, b models.Membership) bool { return a.ID < b.ID })
+	return list, nil
+}
+
+// sortMemberships orders by join date, unknown dates first, then by tie.
+func sortMemberships(list []models.Membership, tie func(a, b models.Membership) bool) {
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i].JoinedOn, list[j].JoinedOn
+		switch {
+		case a == nil && b != nil:
+			return true
+		case a != nil && b == nil:
+			return false
+		case a != nil && !a.Equal(b.Time):
+			return a.Before(b.Time)
+		}
+		return tie(list[i], list[j])
+	})
+}
+
+func (m *Memory) AddMembership(ctx context.Context, in models.Membership) (models.Membership, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	in.ID = 0
+	if err := m.checkMembership(in); err != nil {
+		return models.Membership{}, err
+	}
+	m.membershipSeq++
+	in.ID = m.membershipSeq
+	m.memberships[in.ID] = in
+	return m.fillMembership(in), nil
+}
+
+func (m *Memory) UpdateMembership(ctx context.Context, in models.Membership) (models.Membership, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur, ok := m.memberships[in.ID]
+	if !ok || cur.GroupID != in.GroupID {
+		return models.Membership{}, ErrNotFound
+	}
+	in.IdolID = cur.IdolID
+	if err := m.checkMembership(in); err != nil {
+		return models.Membership{}, err
+	}
+	m.memberships[in.ID] = in
+	return m.fillMembership(in), nil
+}
+
+func (m *Memory) RemoveMembership(ctx context.Context, groupID, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ms, ok := m.memberships[id]; !ok || ms.GroupID != groupID {
+		return ErrNotFound
+	}
+	delete(m.memberships, id)
+	return nil
+}
+
+// checkMembership mirrors lockIdolMemberships and checkOverlap; callers
+// hold m.mu.
+func (m *Memory) checkMembership(in models.Membership) error {
+	if _, ok := m.groups[in.GroupID]; !ok {
+		return ErrNotFound
+	}
+	if it, ok := m.idols[in.IdolID]; !ok || it.DeletedAt != nil {
+		return ErrUnknownIdol
+	}
+	for _, ms := range m.memberships {
+		if ms.ID != in.ID && ms.IdolID == in.IdolID && ms.GroupID == in.GroupID && ms.Overlaps(in) {
+			return ErrOverlap
+		}
+	}
+	return nil
+}
+
+// fillMembership sets the idol and group names; callers hold m.mu.
+func (m *Memory) fillMembership(ms models.Membership) models.Membership {
+	ms.IdolName = m.idols[ms.IdolID].Name
+	ms.GroupName = m.groups[ms.GroupID].Name
+	return ms
+}
+
+// openMembership mirrors the Postgres openMembership; callers hold m.mu.
+func (m *Memory) openMembership(it models.Idol, joined *models.Date) {
+	for _, ms := range m.memberships {
+		if ms.IdolID == it.ID && ms.GroupID == it.GroupID && ms.LeftOn == nil {
+			return
+		}
+	}
+	m.membershipSeq++
+	m.memberships[m.membershipSeq] = models.Membership{ID: m.membershipSeq, IdolID: it.ID, GroupID: it.GroupID, JoinedOn: joined}
+}
+
+// moveMembership mirrors the Postgres moveMembership; callers hold m.mu.
+func (m *Memory) moveMembership(it models.Idol, from int64) {
+	today := membershipToday()
+	for id, ms := range m.memberships {
+		if ms.IdolID == it.ID && ms.GroupID == from && ms.LeftOn == nil {
+			left := today
+			if ms.JoinedOn != nil && ms.JoinedOn.After(today.Time) {
+				left = *ms.JoinedOn
+			}
+			ms.LeftOn = &left
+			m.memberships[id] = ms
+		}
+	}
+	m.openMembership(it, &today)
+}
+
+// dropMemberships removes the memberships of a purged idol; callers hold
+// m.mu.
+func (m *Memory) dropMemberships(idolID int64) {
+	for id, ms := range m.memberships {
+		if ms.IdolID == idolID {
+			delete(m.memberships, id)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package store
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"kpopapi/internal/models"
+)
+
+var (
+	_ IdolStore   = (*Memory)(nil)
+	_ AtomicStore = (*Memory)(nil)
+)
+
+// Memory is a thread-safe in-memory implementation of the store interfaces,
+// intended for tests and running the API without Postgres.
+type Memory struct {
+	mu sync.RWMutex
+	memoryState
+}
+
+// memoryState is everything a Memory holds. Atomic works on a clone of it.
+type memoryState struct {
+	idols     map[int64]models.Idol
+	nextID    int64
+	revisions map[int64][]models.IdolRevision
+	revSeq    int64
+	groups    map[int64]models.Group
+	groupSeq  int64
+
+	memberships   map[int64]models.Membership
+	membershipSeq int64
+
+	positions   map[int64]models.Position
+	positionSeq int64
+
+	photos   map[int64]models.Photo
+	photoSeq int64
+
+	albums   map[int64]models.Album
+	albumSeq int64
+	tracks   map[int64]models.Track
+	trackSeq int64
+
+	events   map[int64]models.Event
+	eventSeq int64
+
+	// favorites holds each user's list in order.
+	favorites map[string][]favoriteEntry
+
+	polls         map[int64]models.Poll
+	pollSeq       int64
+	pollOptionSeq int64
+	pollVotes     map[pollVoteKey]int64
+
+	idempotency map[idempotencyKey]IdempotencyRecord
+
+	// feedTokens maps each user to the hash of their calendar feed token.
+	feedTokens map[string]string
+}
+
+// NewMemory returns an empty store whose positions catalogue holds
+// DefaultPositions, like a freshly migrated database.
+func NewMemory() *Memory {
+	m := &Memory{memoryState: memoryState{
+		idols:     make(map[int64]models.Idol),
+		nextID:    1,
+		revisions: make(map[int64][]models.IdolRevision),
+		groups:    make(map[int64]models.Group),
+
+		memberships: make(map[int64]models.Membership),
+		positions:   make(map[int64]models.Position),
+		photos:      make(map[int64]models.Photo),
+		albums:      make(map[int64]models.Album),
+		tracks:      make(map[int64]models.Track),
+		events:      make(map[int64]models.Event),
+		favorites:   make(map[string][]favoriteEntry),
+		polls:       make(map[int64]models.Poll),
+		pollVotes:   make(map[pollVoteKey]int64),
+		idempotency: make(map[idempotencyKey]IdempotencyRecord),
+		feedTokens:  make(map[string]string),
+	}}
+	for _, p := range DefaultPositions {
+		m.createPosition(p)
+	}
+	return m
+}
+
+// clone returns a copy of st that shares nothing writable with it. Every
+// map must be cloned here; slices held in maps are copied too, since some
+// writers update their elements in place.
+func (st memoryState) clone() memoryState {
+	c := st
+	c.idols = maps.Clone(st.idols)
+	c.revisions = make(map[int64][]models.IdolRevision, len(st.revisions))
+	for id, revs := range st.revisions {
+		c.revisions[id] = slices.Clone(revs)
+	}
+	c.groups = maps.Clone(st.groups)
+	c.memberships = maps.Clone(st.memberships)
+	c.positions = maps.Clone(st.positions)
+	c.photos = maps.Clone(st.photos)
+	c.albums = maps.Clone(st.albums)
+	c.tracks = maps.Clone(st.tracks)
+	c.events = maps.Clone(st.events)
+	c.favorites = make(map[string][]favoriteEntry, len(st.favorites))
+	for user, list := range st.favorites {
+		c.favorites[user] = slices.Clone(list)
+	}
+	c.polls = make(map[int64]models.Poll, len(st.polls))
+	for id, p := range st.polls {
+		p.Options = slices.Clone(p.Options)
+		c.polls[id] = p
+	}
+	c.pollVotes = maps.Clone(st.pollVotes)
+	c.idempotency = maps.Clone(st.idempotency)
+	c.feedTokens = maps.Clone(st.feedTokens)
+	return c
+}
+
+// Atomic runs fn on a copy of the store and keeps the copy when fn
+// succeeds. Other callers wait until fn returns.
+func (m *Memory) Atomic(ctx context.Context, fn func(tx Tx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := &Memory{memoryState: m.memoryState.clone()}
+	if err := fn(c); err != nil {
+		return err
+	}
+	m.memoryState = c.memoryState
+	return nil
+}
+
+func (m *Memory) List(ctx context.Context, opts ListOptions) (Page, error) {
+	pl, err := planList(opts)
+	if err != nil {
+		return Page{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := []models.Idol{}
+	for _, it := range m.idols {
+		if (it.DeletedAt != nil) == opts.Deleted && pl.match(it) && pl.afterCursor(it) {
+			list = append(list, it)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return pl.less(list[i], list[j]) })
+	if pl.limit > 0 && len(list) > pl.limit+1 {
+		list = list[:pl.limit+1]
+	}
+	return pl.page(list), nil
+}
+
+// Each calls fn on a snapshot of the matching idols, outside the lock.
+func (m *Memory) Each(ctx context.Context, opts ListOptions, fn func(models.Idol) error) error {
+	opts.Limit = 0
+	page, err := m.List(ctx, opts)
+	if err != nil {
+		return err
+	}
+	for _, it := range page.Items {
+		if err := fn(it); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Get(ctx context.Context, id int64) (models.Idol, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	it, ok := m.idols[id]
+	if !ok || it.DeletedAt != nil {
+		return models.Idol{}, ErrNotFound
+	}
+	return it, nil
+}
+
+func (m *Memory) Create(ctx context.Context, in models.Idol) (models.Idol, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.resolveGroup(&in); err != nil {
+		return models.Idol{}, err
+	}
+	if err := m.resolvePositions(&in); err != nil {
+		return models.Idol{}, err
+	}
+	now := time.Now()
+	in.ID = m.nextID
+	m.nextID++
+	in.CreatedBy = actorOr(in.CreatedBy)
+	in.UpdatedBy = in.CreatedBy
+	in.CreatedAt, in.UpdatedAt = now, now
+	in.Status = idolStatusOr(in.Status)
+	in.DeletedAt = nil
+	in.FavoriteCount = 0
+	in.Version = 1
+	m.idols[in.ID] = in
+	m.openMembership(in, nil)
+	m.record(RevisionCreate, in)
+	return in, nil
+}
+
+func (m *Memory) Update(ctx context.Context, in models.Idol, version int) (models.Idol, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, err := m.update(in, version)
+	if err != nil {
+		return models.Idol{}, err
+	}
+	m.record(RevisionUpdate, it)
+	return it, nil
+}
+
+// update applies the editable fields of in; callers hold m.mu.
+func (m *Memory) update(in models.Idol, version int) (models.Idol, error) {
+	cur, ok := m.idols[in.ID]
+	if !ok || cur.DeletedAt != nil {
+		return models.Idol{}, ErrNotFound
+	}
+	if version != 0 && version != cur.Version {
+		return models.Idol{}, ErrVersionConflict
+	}
+	if err := m.resolveGroup(&in); err != nil {
+		return models.Idol{}, err
+	}
+	if err := m.resolvePositions(&in); err != nil {
+		return models.Idol{}, err
+	}
+	from := cur.GroupID
+	cur.Name, cur.GroupID, cur.Group = in.Name, in.GroupID, in.Group
+	cur.Position, cur.Positions = in.Position, in.Positions
+	cur.LegalName, cur.HangulName, cur.BirthDate, cur.Nationality = in.LegalName, in.HangulName, in.BirthDate, in.Nationality
+	cur.DebutDate, cur.HeightCM, cur.MBTI, cur.Status = in.DebutDate, in.HeightCM, in.MBTI, idolStatusOr(in.Status)
+	cur.UpdatedBy = actorOr(in.UpdatedBy)
+	cur.UpdatedAt = time.Now()
+	cur.Version++
+	m.idols[cur.ID] = cur
+	if cur.GroupID != from {
+		m.moveMembership(cur, from)
+	} else {
+		m.openMembership(cur, nil)
+	}
+	return cur, nil
+}
+
+func (m *Memory) SoftDelete(ctx context.Context, id int64, version int, actor string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur, ok := m.idols[id]
+	if !ok || cur.DeletedAt != nil {
+		return ErrNotFound
+	}
+	if version != 0 && version != cur.Version {
+		return ErrVersionConflict
+	}
+	now := time.Now()
+	cur.DeletedAt = &now
+	cur.UpdatedAt = now
+	cur.UpdatedBy = actorOr(actor)
+	cur.Version++
+	m.idols[id] = cur
+	m.record(RevisionDelete, cur)
+	return nil
+}
+
+func (m *Memory) Restore(ctx context.Context, id int64, actor string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur, ok := m.idols[id]
+	if !ok || cur.DeletedAt == nil {
+		return ErrNotFound
+	}
+	cur.DeletedAt = nil
+	cur.UpdatedAt = time.Now()
+	cur.UpdatedBy = actorOr(actor)
+	cur.Version++
+	m.idols[id] = cur
+	m.record(RevisionRestore, cur)
+	return nil
+}
+
+func (m *Memory) Purge(ctx context.Context, id int64) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.idols[id]; !ok {
+		return nil, ErrNotFound
+	}
+	return m.purge(id), nil
+}
+
+func (m *Memory) PurgeDeletedBefore(ctx context.Context, cutoff time.Time) (int64, []string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	var keys []string
+	for id, it := range m.idols {
+		if it.DeletedAt != nil && it.DeletedAt.Before(cutoff) {
+			keys = append(keys, m.purge(id)...)
+			n++
+		}
+	}
+	return n, keys, nil
+}
+
+// purge removes an idol and everything that refers to it and returns the
+// keys of its photo files; callers hold m.mu.
+func (m *Memory) purge(id int64) []string {
+	delete(m.idols, id)
+	delete(m.revisions, id)
+	m.dropMemberships(id)
+	m.dropCredits(id)
+	m.dropEvents(id)
+	m.dropFavorites(id)
+	m.dropPollOptions(id)
+	return m.dropPhotos(id)
+}
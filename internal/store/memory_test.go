@@ -0,0 +1,116 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"kpopapi/internal/models"
+)
+
+// TestMemoryAtomicRollsBackEverything purges an idol inside a failed Atomic
+// and checks that nothing the purge touched is lost.
+func TestMemoryAtomicRollsBackEverything(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	g, err := m.CreateGroup(ctx, models.Group{Name: "AESPA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	karina, err := m.Create(ctx, models.Idol{Name: "Karina", GroupID: g.ID, Position: "Leader"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	winter, err := m.Create(ctx, models.Idol{Name: "Winter", GroupID: g.ID, Position: "Main Vocalist"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := m.SetFavorite(ctx, "user2", karina.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	poll, err := m.CreatePoll(ctx, models.Poll{
+		Question: "Best vocal?", OpensAt: now.Add(-time.Hour), ClosesAt: now.Add(time.Hour),
+		Options: []models.PollOption{{IdolID: &karina.ID}, {IdolID: &winter.ID}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	boom := errors.New("boom")
+	err = m.Atomic(ctx, func(tx Tx) error {
+		if _, err := tx.Purge(ctx, karina.ID); err != nil {
+			return err
+		}
+		// Votes change poll options in place.
+		if _, err := tx.(*Memory).Vote(ctx, poll.ID, poll.Options[1].ID, "user2"); err != nil {
+			return err
+		}
+		return boom
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("Atomic = %v, want boom", err)
+	}
+
+	if _, err := m.Get(ctx, karina.ID); err != nil {
+		t.Errorf("idol: %v", err)
+	}
+	if revs, _ := m.History(ctx, karina.ID); len(revs) != 1 {
+		t.Errorf("history has %d revisions, want 1", len(revs))
+	}
+	if favs, _ := m.Favorites(ctx, "user2"); len(favs) != 1 {
+		t.Errorf("favorites = %+v, want Karina", favs)
+	}
+	got, err := m.GetPoll(ctx, poll.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Options) != 2 || got.TotalVotes != 0 || got.Options[1].Votes != 0 {
+		t.Errorf("poll after rollback = %+v", got)
+	}
+	if id, _ := m.MyVote(ctx, poll.ID, "user2"); id != 0 {
+		t.Errorf("vote kept after rollback: option %d", id)
+	}
+}
+
+func TestMemoryAtomicNests(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	if _, err := m.CreateGroup(ctx, models.Group{Name: "NCT"}); err != nil {
+		t.Fatal(err)
+	}
+	create := func(tx Tx, name string) error {
+		_, err := tx.Create(ctx, models.Idol{Name: name, Group: "NCT", Position: "Leader"})
+		return err
+	}
+	err := m.Atomic(ctx, func(tx Tx) error {
+		if err := create(tx, "Taeyong"); err != nil {
+			return err
+		}
+		inner := tx.Atomic(ctx, func(tx Tx) error {
+			if err := create(tx, "Ghost"); err != nil {
+				return err
+			}
+			return errors.New("discard")
+		})
+		if inner == nil {
+			t.Error("inner Atomic: want its error")
+		}
+		return create(tx, "Jisung")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := m.List(ctx, ListOptions{Limit: 10, Sort: "name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, it := range page.Items {
+		names = append(names, it.Name)
+	}
+	if len(names) != 2 || names[0] != "Jisung" || names[1] != "Taeyong" {
+		t.Errorf("idols = %v, want [Jisung Taeyong]", names)
+	}
+}
@@ -0,0 +1,156 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+
+	"kpopapi/internal/models"
+)
+
+// PhotoStore is the persistence contract for idol photo metadata. The files
+// themselves live in a storage.Storage under the variants' keys.
+type PhotoStore interface {
+	// AddPhoto records a photo of a live idol; ErrNotFound if there is none.
+	AddPhoto(ctx context.Context, in models.Photo) (models.Photo, error)
+	// ListPhotos returns the photos of a live idol, oldest first.
+	ListPhotos(ctx context.Context, idolID int64) ([]models.Photo, error)
+}
+
+var (
+	_ PhotoStore = (*Postgres)(nil)
+	_ PhotoStore = (*Memory)(nil)
+)
+
+// photoVariantRow is how a variant is kept in idol_photos.variants.
+type photoVariantRow struct {
+	Name   string `json:"name"`
+	Key    string `json:"key"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+const photoColumns = "id, idol_id, content_type, width, height, size_bytes, variants, uploaded_by, created_at"
+
+func scanPhoto(row rowScanner) (models.Photo, error) {
+	var ph models.Photo
+	var variants []byte
+	if err := row.Scan(&ph.ID, &ph.IdolID, &ph.ContentType, &ph.Width, &ph.Height, &ph.Size, &variants, &ph.UploadedBy, &ph.CreatedAt); err != nil {
+		return ph, notFoundOr(err)
+	}
+	var rows []photoVariantRow
+	if err := json.Unmarshal(variants, &rows); err != nil {
+		return ph, err
+	}
+	for _, v := range rows {
+		ph.Variants = append(ph.Variants, models.PhotoVariant{Name: v.Name, Key: v.Key, Width: v.Width, Height: v.Height})
+	}
+	return ph, nil
+}
+
+func (p *Postgres) AddPhoto(ctx context.Context, in models.Photo) (models.Photo, error) {
+	rows := make([]photoVariantRow, len(in.Variants))
+	for i, v := range in.Variants {
+		rows[i] = photoVariantRow{Name: v.Name, Key: v.Key, Width: v.Width, Height: v.Height}
+	}
+	variants, err := json.Marshal(rows)
+	if err != nil {
+		return models.Photo{}, err
+	}
+	return scanPhoto(p.q().QueryRowContext(ctx, `INSERT INTO idol_photos (idol_id, content_type, width, height, size_bytes, variants, uploaded_by)
+		SELECT id, $2, $3, $4, $5, $6, $7 FROM idols WHERE id = $1 AND deleted_at IS NULL
+		RETURNING `+photoColumns,
+		in.IdolID, in.ContentType, in.Width, in.Height, in.Size, variants, in.UploadedBy))
+}
+
+func (p *Postgres) ListPhotos(ctx context.Context, idolID int64) ([]models.Photo, error) {
+	if _, err := p.Get(ctx, idolID); err != nil {
+		return nil, err
+	}
+	rows, err := p.q().QueryContext(ctx, "SELECT "+photoColumns+" FROM idol_photos WHERE idol_id = $1 ORDER BY id", idolID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []models.Photo{}
+	for rows.Next() {
+		ph, err := scanPhoto(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, ph)
+	}
+	return list, rows.Err()
+}
+
+// deletePhotos removes the photos of the given idols and returns the keys of
+// their files.
+func deletePhotos(ctx context.Context, q querier, idolIDs []int64) ([]string, error) {
+	rows, err := q.QueryContext(ctx, "DELETE FROM idol_photos WHERE idol_id = ANY($1) RETURNING variants", pq.Array(idolIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var variants []byte
+		if err := rows.Scan(&variants); err != nil {
+			return nil, err
+		}
+		var vs []photoVariantRow
+		if err := json.Unmarshal(variants, &vs); err != nil {
+			return nil, err
+		}
+		for _, v := range vs {
+			keys = append(keys, v.Key)
+		}
+	}
+	return keys, rows.Err()
+}
+
+func (m *Memory) AddPhoto(ctx context.Context, in models.Photo) (models.Photo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if it, ok := m.idols[in.IdolID]; !ok || it.DeletedAt != nil {
+		return models.Photo{}, ErrNotFound
+	}
+	m.photoSeq++
+	in.ID, in.CreatedAt = m.photoSeq, time.Now()
+	in.Variants = append([]models.PhotoVariant(nil), in.Variants...)
+	m.photos[in.ID] = in
+	return in, nil
+}
+
+func (m *Memory) ListPhotos(ctx context.Context, idolID int64) ([]models.Photo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if it, ok := m.idols[idolID]; !ok || it.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+	list := []models.Photo{}
+	for _, ph := range m.photos {
+		if ph.IdolID == idolID {
+			list = append(list, ph)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+// dropPhotos removes the photos of a purged idol and returns the keys of
+// their files; callers hold m.mu.
+func (m *Memory) dropPhotos(idolID int64) []string {
+	var keys []string
+	for id, ph := range m.photos {
+		if ph.IdolID == idolID {
+			for _, v := range ph.Variants {
+				keys = append(keys, v.Key)
+			}
+			delete(m.photos, id)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,377 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+
+	"kpopapi/internal/models"
+)
+
+var (
+	// ErrPollNotOpen is returned for a vote outside the poll's open time.
+	ErrPollNotOpen = errors.New("poll is not open")
+	// ErrAlreadyVoted is returned for a second vote of a user in a poll.
+	ErrAlreadyVoted = errors.New("already voted")
+	// ErrUnknownOption is returned for a vote for an option of another poll.
+	ErrUnknownOption = errors.New("unknown option")
+)
+
+// PollStore is the persistence contract for polls and their votes. Each
+// option carries its tally, which a vote updates in the same transaction,
+// so results never need to count the votes.
+type PollStore interface {
+	// ListPolls returns every poll, the latest to open first.
+	ListPolls(ctx context.Context) ([]models.Poll, error)
+	GetPoll(ctx context.Context, id int64) (models.Poll, error)
+	// CreatePoll fails with ErrUnknownIdol or ErrUnknownGroup when an option
+	// names a missing group or idol.
+	CreatePoll(ctx context.Context, in models.Poll) (models.Poll, error)
+	// UpdatePoll changes the question and times; options are fixed.
+	UpdatePoll(ctx context.Context, in models.Poll) (models.Poll, error)
+	// DeletePoll removes a poll with its options and votes.
+	DeletePoll(ctx context.Context, id int64) error
+	// Vote records the user's vote and returns the poll with the new
+	// tally. It fails with ErrPollNotOpen, ErrUnknownOption or
+	// ErrAlreadyVoted.
+	Vote(ctx context.Context, pollID, optionID int64, username string) (models.Poll, error)
+	// MyVote returns the option the user voted for, or 0.
+	MyVote(ctx context.Context, pollID int64, username string) (int64, error)
+}
+
+var (
+	_ PollStore = (*Postgres)(nil)
+	_ PollStore = (*Memory)(nil)
+)
+
+// finishPoll sets the derived fields of p.
+func finishPoll(p models.Poll, now time.Time) models.Poll {
+	p.State = p.StateAt(now)
+	p.TotalVotes = 0
+	for _, o := range p.Options {
+		p.TotalVotes += o.Votes
+	}
+	return p
+}
+
+const pollSelect = "SELECT id, question, opens_at, closes_at, created_by, created_at, updated_at FROM polls"
+
+func scanPoll(row rowScanner) (models.Poll, error) {
+	p := models.Poll{Options: []models.PollOption{}}
+	err := row.Scan(&p.ID, &p.Question, &p.OpensAt, &p.ClosesAt, &p.CreatedBy, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return p, notFoundOr(err)
+	}
+	return p, nil
+}
+
+// loadPolls runs a poll query and attaches the options of every poll.
+func loadPolls(ctx context.Context, q querier, query string, args ...interface{}) ([]models.Poll, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []models.Poll{}
+	var ids []int64
+	for rows.Next() {
+		p, err := scanPoll(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+		ids = append(ids, p.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return list, nil
+	}
+	options, err := pollOptions(ctx, q, ids)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i := range list {
+		if o, ok := options[list[i].ID]; ok {
+			list[i].Options = o
+		}
+		list[i] = finishPoll(list[i], now)
+	}
+	return list, nil
+}
+
+// pollOptions returns the options of the polls by poll id, in order.
+func pollOptions(ctx context.Context, q querier, pollIDs []int64) (map[int64][]models.PollOption, error) {
+	rows, err := q.QueryContext(ctx, `SELECT o.poll_id, o.id, o.idol_id, COALESCE(i.name, ''), o.group_id, COALESCE(g.name, ''), o.votes
+		FROM poll_options o LEFT JOIN idols i ON i.id = o.idol_id LEFT JOIN groups g ON g.id = o.group_id
+		WHERE o.poll_id = ANY($1) ORDER BY o.poll_id, o.sort_order`, pq.Array(pollIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	options := make(map[int64][]models.PollOption)
+	for rows.Next() {
+		var pollID int64
+		var o models.PollOption
+		if err := rows.Scan(&pollID, &o.ID, &o.IdolID, &o.IdolName, &o.GroupID, &o.GroupName, &o.Votes); err != nil {
+			return nil, err
+		}
+		options[pollID] = append(options[pollID], o)
+	}
+	return options, rows.Err()
+}
+
+func getPoll(ctx context.Context, q querier, id int64) (models.Poll, error) {
+	list, err := loadPolls(ctx, q, pollSelect+" WHERE id = $1", id)
+	if err != nil {
+		return models.Poll{}, err
+	}
+	if len(list) == 0 {
+		return models.Poll{}, ErrNotFound
+	}
+	return list[0], nil
+}
+
+func (p *Postgres) ListPolls(ctx context.Context) ([]models.Poll, error) {
+	return loadPolls(ctx, p.q(), pollSelect+" ORDER BY opens_at DESC, id DESC")
+}
+
+func (p *Postgres) GetPoll(ctx context.Context, id int64) (models.Poll, error) {
+	return getPoll(ctx, p.q(), id)
+}
+
+func (p *Postgres) CreatePoll(ctx context.Context, in models.Poll) (poll models.Poll, err error) {
+	err = p.inTx(ctx, func(q querier) error {
+		if err := q.QueryRowContext(ctx,
+			"INSERT INTO polls (question, opens_at, closes_at, created_by) VALUES ($1,$2,$3,$4) RETURNING id",
+			in.Question, in.OpensAt, in.ClosesAt, actorOr(in.CreatedBy)).Scan(&in.ID); err != nil {
+			return err
+		}
+		for i, o := range in.Options {
+			if err := checkLinks(ctx, q, o.GroupID, o.IdolID); err != nil {
+				return err
+			}
+			if _, err := q.ExecContext(ctx, "INSERT INTO poll_options (poll_id, sort_order, idol_id, group_id) VALUES ($1,$2,$3,$4)",
+				in.ID, i+1, o.IdolID, o.GroupID); err != nil {
+				return mapConstraint(err)
+			}
+		}
+		poll, err = getPoll(ctx, q, in.ID)
+		return err
+	})
+	return poll, err
+}
+
+func (p *Postgres) UpdatePoll(ctx context.Context, in models.Poll) (poll models.Poll, err error) {
+	err = p.inTx(ctx, func(q querier) error {
+		res, err := q.ExecContext(ctx, "UPDATE polls SET question=$1, opens_at=$2, closes_at=$3, updated_at=NOW() WHERE id=$4",
+			in.Question, in.OpensAt, in.ClosesAt, in.ID)
+		if err := affectedOne(res, err); err != nil {
+			return err
+		}
+		poll, err = getPoll(ctx, q, in.ID)
+		return err
+	})
+	return poll, err
+}
+
+func (p *Postgres) DeletePoll(ctx context.Context, id int64) error {
+	res, err := p.q().ExecContext(ctx, "DELETE FROM polls WHERE id=$1", id)
+	return affectedOne(res, err)
+}
+
+func (p *Postgres) Vote(ctx context.Context, pollID, optionID int64, username string) (poll models.Poll, err error) {
+	err = p.inTx(ctx, func(q querier) error {
+		var open bool
+		err := q.QueryRowContext(ctx, "SELECT opens_at <= NOW() AND NOW() < closes_at FROM polls WHERE id=$1", pollID).Scan(&open)
+		if err != nil {
+			return notFoundOr(err)
+		}
+		if !open {
+			return ErrPollNotOpen
+		}
+		_, err = q.ExecContext(ctx, "INSERT INTO poll_votes (poll_id, option_id, username) VALUES ($1,$2,$3)", pollID, optionID, username)
+		switch err := mapConstraint(err); {
+		case errors.Is(err, ErrDuplicate):
+			return ErrAlreadyVoted
+		case errors.Is(err, ErrInUse):
+			// The option is not one of the poll's.
+			return ErrUnknownOption
+		case err != nil:
+			return err
+		}
+		if _, err := q.ExecContext(ctx, "UPDATE poll_options SET votes = votes + 1 WHERE id=$1", optionID); err != nil {
+			return err
+		}
+		poll, err = getPoll(ctx, q, pollID)
+		return err
+	})
+	return poll, err
+}
+
+func (p *Postgres) MyVote(ctx context.Context, pollID int64, username string) (int64, error) {
+	var optionID int64
+	err := p.q().QueryRowContext(ctx, "SELECT option_id FROM poll_votes WHERE poll_id=$1 AND username=$2", pollID, username).Scan(&optionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return optionID, err
+}
+
+// pollVoteKey identifies a user's vote in the Memory store.
+type pollVoteKey struct {
+	pollID   int64
+	username string
+}
+
+func (m *Memory) ListPolls(ctx context.Context) ([]models.Poll, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	list := []models.Poll{}
+	for _, p := range m.polls {
+		list = append(list, m.fillPoll(p, now))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].OpensAt.Equal(list[j].OpensAt) {
+			return list[i].OpensAt.After(list[j].OpensAt)
+		}
+		return list[i].ID > list[j].ID
+	})
+	return list, nil
+}
+
+func (m *Memory) GetPoll(ctx context.Context, id int64) (models.Poll, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.polls[id]
+	if !ok {
+		return models.Poll{}, ErrNotFound
+	}
+	return m.fillPoll(p, time.Now()), nil
+}
+
+func (m *Memory) CreatePoll(ctx context.Context, in models.Poll) (models.Poll, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	options := make([]models.PollOption, len(in.Options))
+	for i, o := range in.Options {
+		if err := m.checkLinks(o.GroupID, o.IdolID); err != nil {
+			return models.Poll{}, err
+		}
+		m.pollOptionSeq++
+		options[i] = models.PollOption{ID: m.pollOptionSeq, IdolID: o.IdolID, GroupID: o.GroupID}
+	}
+	now := time.Now()
+	m.pollSeq++
+	in.ID = m.pollSeq
+	in.Options = options
+	in.CreatedBy = actorOr(in.CreatedBy)
+	in.CreatedAt, in.UpdatedAt = now, now
+	m.polls[in.ID] = in
+	return m.fillPoll(in, now), nil
+}
+
+func (m *Memory) UpdatePoll(ctx context.Context, in models.Poll) (models.Poll, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur, ok := m.polls[in.ID]
+	if !ok {
+		return models.Poll{}, ErrNotFound
+	}
+	now := time.Now()
+	cur.Question, cur.OpensAt, cur.ClosesAt = in.Question, in.OpensAt, in.ClosesAt
+	cur.UpdatedAt = now
+	m.polls[cur.ID] = cur
+	return m.fillPoll(cur, now), nil
+}
+
+func (m *Memory) DeletePoll(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.polls[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.polls, id)
+	for k := range m.pollVotes {
+		if k.pollID == id {
+			delete(m.pollVotes, k)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Vote(ctx context.Context, pollID, optionID int64, username string) (models.Poll, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.polls[pollID]
+	if !ok {
+		return models.Poll{}, ErrNotFound
+	}
+	now := time.Now()
+	if p.StateAt(now) != models.PollOpen {
+		return models.Poll{}, ErrPollNotOpen
+	}
+	i := slices.IndexFunc(p.Options, func(o models.PollOption) bool { return o.ID == optionID })
+	if i < 0 {
+		return models.Poll{}, ErrUnknownOption
+	}
+	key := pollVoteKey{pollID, username}
+	if _, ok := m.pollVotes[key]; ok {
+		return models.Poll{}, ErrAlreadyVoted
+	}
+	m.pollVotes[key] = optionID
+	// Earlier readers may hold the old slice.
+	p.Options = slices.Clone(p.Options)
+	p.Options[i].Votes++
+	m.polls[pollID] = p
+	return m.fillPoll(p, now), nil
+}
+
+func (m *Memory) MyVote(ctx context.Context, pollID int64, username string) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.pollVotes[pollVoteKey{pollID, username}], nil
+}
+
+// fillPoll sets the option names and the derived fields; callers hold m.mu.
+func (m *Memory) fillPoll(p models.Poll, now time.Time) models.Poll {
+	options := make([]models.PollOption, len(p.Options))
+	for i, o := range p.Options {
+		if o.IdolID != nil {
+			o.IdolName = m.idols[*o.IdolID].Name
+		}
+		if o.GroupID != nil {
+			o.GroupName = m.groups[*o.GroupID].Name
+		}
+		options[i] = o
+	}
+	p.Options = options
+	return finishPoll(p, now)
+}
+
+// dropPollOptions removes the options of a purged idol with their votes;
+// callers hold m.mu.
+func (m *Memory) dropPollOptions(idolID int64) {
+	for id, p := range m.polls {
+		i := slices.IndexFunc(p.Options, func(o models.PollOption) bool { return o.IdolID != nil && *o.IdolID == idolID })
+		if i < 0 {
+			continue
+		}
+		optionID := p.Options[i].ID
+		p.Options = slices.Delete(slices.Clone(p.Options), i, i+1)
+		m.polls[id] = p
+		for k, o := range m.pollVotes {
+			if k.pollID == id && o == optionID {
+				delete(m.pollVotes, k)
+			}
+		}
+	}
+}
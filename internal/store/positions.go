@@ -0,0 +1,461 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+
+	"kpopapi/internal/models"
+)
+
+// ErrUnknownPosition is returned when an idol names a position that is
+// neither a catalogue name nor an alias.
+var ErrUnknownPosition = errors.New("unknown position")
+
+// ErrPositionsTooLong is returned when the joined position names of an idol
+// would not fit the position column.
+var ErrPositionsTooLong = errors.New("positions too long")
+
+// maxPositionLen matches the VARCHAR(100) idols.position column, which holds
+// the position names joined with ", ".
+const maxPositionLen = 100
+
+// joinPositions joins names into the position column value, failing with
+// ErrPositionsTooLong when it does not fit.
+func joinPositions(names []string) (string, error) {
+	joined := strings.Join(names, ", ")
+	if utf8.RuneCountInString(joined) > maxPositionLen {
+		return "", fmt.Errorf("%w: %q is longer than %d characters", ErrPositionsTooLong, joined, maxPositionLen)
+	}
+	return joined, nil
+}
+
+// PositionStore is the persistence contract for the positions catalogue.
+// Names and aliases are unique across the catalogue, ignoring case. Idols
+// keep their positions joined in position, which renames keep in sync.
+type PositionStore interface {
+	ListPositions(ctx context.Context) ([]models.Position, error)
+	GetPosition(ctx context.Context, id int64) (models.Position, error)
+	CreatePosition(ctx context.Context, in models.Position) (models.Position, error)
+	// UpdatePosition renames a position. Every idol whose position string
+	// changes with it gets a new version and revision under actor.
+	UpdatePosition(ctx context.Context, in models.Position, actor string) (models.Position, error)
+	// DeletePosition fails with ErrInUse while any idol has the position.
+	DeletePosition(ctx context.Context, id int64) error
+}
+
+var (
+	_ PositionStore = (*Postgres)(nil)
+	_ PositionStore = (*Memory)(nil)
+)
+
+// positionSeparator splits legacy position strings such as
+// "Leader, Main Vocalist" or "Main Rapper/Visual". The 0006 migration uses
+// the same pattern.
+var positionSeparator = regexp.MustCompile(`\s*[,/&;]\s*`)
+
+// SplitPositions splits a free-text position string into its terms.
+func SplitPositions(s string) []string {
+	var terms []string
+	for _, t := range positionSeparator.Split(strings.TrimSpace(s), -1) {
+		if t = strings.TrimSpace(t); t != "" {
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+// positionTerms returns the positions an idol write asks for: Positions when
+// set, otherwise the split Position string.
+func positionTerms(in models.Idol) []string {
+	if len(in.Positions) > 0 {
+		return in.Positions
+	}
+	return SplitPositions(in.Position)
+}
+
+// positionKeys returns the lower-cased name and aliases of p.
+func positionKeys(p models.Position) []string {
+	keys := []string{strings.ToLower(p.Name)}
+	for _, a := range p.Aliases {
+		keys = append(keys, strings.ToLower(a))
+	}
+	return keys
+}
+
+// cleanAliases trims aliases and drops empty ones, duplicates and those
+// equal to the name.
+func cleanAliases(p models.Position) models.Position {
+	seen := map[string]bool{strings.ToLower(p.Name): true}
+	aliases := []string{}
+	for _, a := range p.Aliases {
+		a = strings.TrimSpace(a)
+		if a != "" && !seen[strings.ToLower(a)] {
+			seen[strings.ToLower(a)] = true
+			aliases = append(aliases, a)
+		}
+	}
+	p.Aliases = aliases
+	return p
+}
+
+const positionColumns = "id, name, aliases, created_at, updated_at"
+
+// positionsExpr selects the position names of the idols row in priority
+// order.
+const positionsExpr = `ARRAY(SELECT p.name FROM idol_positions ip JOIN positions p ON p.id = ip.position_id
+	WHERE ip.idol_id = idols.id ORDER BY ip.priority)`
+
+func scanPosition(row rowScanner) (models.Position, error) {
+	var p models.Position
+	err := row.Scan(&p.ID, &p.Name, pq.Array(&p.Aliases), &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return p, mapConstraint(notFoundOr(err))
+	}
+	return p, nil
+}
+
+func (p *Postgres) ListPositions(ctx context.Context) ([]models.Position, error) {
+	rows, err := p.q().QueryContext(ctx, "SELECT "+positionColumns+" FROM positions ORDER BY name, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []models.Position{}
+	for rows.Next() {
+		pos, err := scanPosition(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, pos)
+	}
+	return list, rows.Err()
+}
+
+func (p *Postgres) GetPosition(ctx context.Context, id int64) (models.Position, error) {
+	return scanPosition(p.q().QueryRowContext(ctx, "SELECT "+positionColumns+" FROM positions WHERE id=$1", id))
+}
+
+func (p *Postgres) CreatePosition(ctx context.Context, in models.Position) (pos models.Position, err error) {
+	in = cleanAliases(in)
+	err = p.inTx(ctx, func(q querier) error {
+		if err := checkPositionKeys(ctx, q, in); err != nil {
+			return err
+		}
+		pos, err = scanPosition(q.QueryRowContext(ctx,
+			"INSERT INTO positions (name, aliases) VALUES ($1,$2) RETURNING "+positionColumns,
+			in.Name, pq.Array(in.Aliases)))
+		return err
+	})
+	return pos, err
+}
+
+func (p *Postgres) UpdatePosition(ctx context.Context, in models.Position, actor string) (pos models.Position, err error) {
+	in = cleanAliases(in)
+	err = p.inTx(ctx, func(q querier) error {
+		if err := checkPositionKeys(ctx, q, in); err != nil {
+			return err
+		}
+		pos, err = scanPosition(q.QueryRowContext(ctx,
+			"UPDATE positions SET name=$1, aliases=$2, updated_at=NOW() WHERE id=$3 RETURNING "+positionColumns,
+			in.Name, pq.Array(in.Aliases), in.ID))
+		if err != nil {
+			return err
+		}
+		// The rebuilt names must still fit idols.position.
+		var long sql.NullString
+		err = q.QueryRowContext(ctx, `SELECT rebuilt FROM (
+				SELECT string_agg(p.name, ', ' ORDER BY ip.priority) AS rebuilt FROM idol_positions ip
+				JOIN positions p ON p.id = ip.position_id
+				WHERE ip.idol_id IN (SELECT idol_id FROM idol_positions WHERE position_id = $1)
+				GROUP BY ip.idol_id) r
+			WHERE char_length(rebuilt) > $2 LIMIT 1`, pos.ID, maxPositionLen).Scan(&long)
+		if err == nil {
+			_, err = joinPositions([]string{long.String})
+			return err
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		rows, err := q.QueryContext(ctx, `UPDATE idols SET position = r.rebuilt, updated_by = $2, updated_at = NOW(), version = version + 1
+			FROM (SELECT ip.idol_id, string_agg(p.name, ', ' ORDER BY ip.priority) AS rebuilt FROM idol_positions ip
+				JOIN positions p ON p.id = ip.position_id
+				WHERE ip.idol_id IN (SELECT idol_id FROM idol_positions WHERE position_id = $1)
+				GROUP BY ip.idol_id) r
+			WHERE idols.id = r.idol_id AND idols.position <> r.rebuilt
+			RETURNING `+idolColumns, pos.ID, actorOr(actor))
+		if err != nil {
+			return err
+		}
+		var renamed []models.Idol
+		for rows.Next() {
+			it, err := scanIdol(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			renamed = append(renamed, it)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, it := range renamed {
+			if err := insertRevision(ctx, q, RevisionUpdate, it); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return pos, err
+}
+
+func (p *Postgres) DeletePosition(ctx context.Context, id int64) error {
+	res, err := p.q().ExecContext(ctx, "DELETE FROM positions WHERE id=$1", id)
+	return affectedOne(res, mapConstraint(err))
+}
+
+// checkPositionKeys fails with ErrDuplicate when the name or an alias of in
+// is already the name or an alias of another position. The table lock keeps
+// two writers from claiming the same alias.
+func checkPositionKeys(ctx context.Context, q querier, in models.Position) error {
+	if _, err := q.ExecContext(ctx, "LOCK TABLE positions IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+		return err
+	}
+	var taken bool
+	err := q.QueryRowContext(ctx, `SELECT EXISTS (
+		SELECT 1 FROM positions WHERE id <> $1 AND (LOWER(name) = ANY($2)
+			OR EXISTS (SELECT 1 FROM unnest(aliases) a WHERE LOWER(a) = ANY($2))))`,
+		in.ID, pq.Array(positionKeys(in))).Scan(&taken)
+	if err == nil && taken {
+		return ErrDuplicate
+	}
+	return err
+}
+
+// resolvePositions maps the requested positions of in to catalogue entries
+// by name or alias, drops repeats and rewrites Positions and Position with
+// the catalogue names. It returns the position ids in priority order.
+func resolvePositions(ctx context.Context, q querier, in *models.Idol) ([]int64, error) {
+	var ids []int64
+	names := []string{}
+	seen := map[int64]bool{}
+	for _, term := range positionTerms(*in) {
+		var id int64
+		var name string
+		err := q.QueryRowContext(ctx, `SELECT id, name FROM positions
+			WHERE LOWER(name) = LOWER($1) OR EXISTS (SELECT 1 FROM unnest(aliases) a WHERE LOWER(a) = LOWER($1))`,
+			strings.TrimSpace(term)).Scan(&id, &name)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w %q", ErrUnknownPosition, term)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+			names = append(names, name)
+		}
+	}
+	joined, err := joinPositions(names)
+	if err != nil {
+		return nil, err
+	}
+	in.Positions, in.Position = names, joined
+	return ids, nil
+}
+
+// setIdolPositions replaces the positions of an idol; ids are in priority
+// order.
+func setIdolPositions(ctx context.Context, q querier, idolID int64, ids []int64) error {
+	if _, err := q.ExecContext(ctx, "DELETE FROM idol_positions WHERE idol_id=$1", idolID); err != nil {
+		return err
+	}
+	_, err := q.ExecContext(ctx, `INSERT INTO idol_positions (idol_id, position_id, priority)
+		SELECT $1, t.position_id, t.priority FROM unnest($2::int[]) WITH ORDINALITY AS t(position_id, priority)`,
+		idolID, pq.Array(ids))
+	return err
+}
+
+// DefaultPositions is the catalogue a fresh database starts with.
+var DefaultPositions = []models.Position{
+	{Name: "Leader"},
+	{Name: "Main Vocalist", Aliases: []string{"Main Vocal"}},
+	{Name: "Lead Vocalist", Aliases: []string{"Lead Vocal"}},
+	{Name: "Sub Vocalist", Aliases: []string{"Sub Vocal"}},
+	{Name: "Main Rapper", Aliases: []string{"Main Rap"}},
+	{Name: "Lead Rapper", Aliases: []string{"Lead Rap"}},
+	{Name: "Sub Rapper", Aliases: []string{"Sub Rap"}},
+	{Name: "Main Dancer", Aliases: []string{"Main Dance"}},
+	{Name: "Lead Dancer", Aliases: []string{"Lead Dance"}},
+	{Name: "Visual", Aliases: []string{"Face of the Group"}},
+	{Name: "Center", Aliases: []string{"Centre"}},
+	{Name: "Maknae", Aliases: []string{"Youngest"}},
+}
+
+func (m *Memory) ListPositions(ctx context.Context) ([]models.Position, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := []models.Position{}
+	for _, p := range m.positions {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+func (m *Memory) GetPosition(ctx context.Context, id int64) (models.Position, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.positions[id]
+	if !ok {
+		return p, ErrNotFound
+	}
+	return p, nil
+}
+
+func (m *Memory) CreatePosition(ctx context.Context, in models.Position) (models.Position, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	in.ID = 0
+	if err := m.checkPositionKeys(in); err != nil {
+		return models.Position{}, err
+	}
+	return m.createPosition(in), nil
+}
+
+// createPosition inserts in; callers hold m.mu and have checked its keys.
+func (m *Memory) createPosition(in models.Position) models.Position {
+	now := time.Now()
+	m.positionSeq++
+	in = cleanAliases(in)
+	in.ID = m.positionSeq
+	in.CreatedAt, in.UpdatedAt = now, now
+	m.positions[in.ID] = in
+	return in
+}
+
+func (m *Memory) UpdatePosition(ctx context.Context, in models.Position, actor string) (models.Position, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur, ok := m.positions[in.ID]
+	if !ok {
+		return models.Position{}, ErrNotFound
+	}
+	if err := m.checkPositionKeys(in); err != nil {
+		return models.Position{}, err
+	}
+	in = cleanAliases(in)
+	in.CreatedAt, in.UpdatedAt = cur.CreatedAt, time.Now()
+	renamed := map[int64]models.Idol{}
+	for id, it := range m.idols {
+		if !slices.Contains(it.Positions, cur.Name) {
+			continue
+		}
+		// Copy so recorded revisions keep the old name.
+		it.Positions = slices.Clone(it.Positions)
+		for i, name := range it.Positions {
+			if name == cur.Name {
+				it.Positions[i] = in.Name
+			}
+		}
+		joined, err := joinPositions(it.Positions)
+		if err != nil {
+			return models.Position{}, err
+		}
+		if joined == it.Position {
+			continue
+		}
+		it.Position = joined
+		it.UpdatedBy = actorOr(actor)
+		it.UpdatedAt = in.UpdatedAt
+		it.Version++
+		renamed[id] = it
+	}
+	m.positions[in.ID] = in
+	maps.Copy(m.idols, renamed)
+	for _, it := range renamed {
+		m.record(RevisionUpdate, it)
+	}
+	return in, nil
+}
+
+func (m *Memory) DeletePosition(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.positions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	for _, it := range m.idols {
+		if slices.Contains(it.Positions, p.Name) {
+			return ErrInUse
+		}
+	}
+	delete(m.positions, id)
+	return nil
+}
+
+// checkPositionKeys mirrors the Postgres checkPositionKeys; callers hold
+// m.mu.
+func (m *Memory) checkPositionKeys(in models.Position) error {
+	keys := positionKeys(cleanAliases(in))
+	for _, p := range m.positions {
+		if p.ID == in.ID {
+			continue
+		}
+		for _, k := range positionKeys(p) {
+			if slices.Contains(keys, k) {
+				return ErrDuplicate
+			}
+		}
+	}
+	return nil
+}
+
+// resolvePositions mirrors the Postgres resolvePositions; callers hold m.mu.
+func (m *Memory) resolvePositions(in *models.Idol) error {
+	names := []string{}
+	seen := map[int64]bool{}
+	for _, term := range positionTerms(*in) {
+		p, ok := m.positionByKey(strings.ToLower(strings.TrimSpace(term)))
+		if !ok {
+			return fmt.Errorf("%w %q", ErrUnknownPosition, term)
+		}
+		if !seen[p.ID] {
+			seen[p.ID] = true
+			names = append(names, p.Name)
+		}
+	}
+	joined, err := joinPositions(names)
+	if err != nil {
+		return err
+	}
+	in.Positions, in.Position = names, joined
+	return nil
+}
+
+func (m *Memory) positionByKey(key string) (models.Position, bool) {
+	for _, p := range m.positions {
+		if slices.Contains(positionKeys(p), key) {
+			return p, true
+		}
+	}
+	return models.Position{}, false
+}
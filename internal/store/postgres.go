@@ -0,0 +1,371 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"kpopapi/internal/models"
+)
+
+var (
+	_ IdolStore   = (*Postgres)(nil)
+	_ AtomicStore = (*Postgres)(nil)
+)
+
+// Postgres implements the store interfaces on top of database/sql.
+type Postgres struct {
+	db *sql.DB
+	// tx is set on the copy Atomic hands to its callback.
+	tx *sql.Tx
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+// querier is the subset of *sql.DB and *sql.Tx the store runs queries on.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// q returns the transaction of an Atomic view, or the pool.
+func (p *Postgres) q() querier {
+	if p.tx != nil {
+		return p.tx
+	}
+	return p.db
+}
+
+// inTx runs fn in a transaction that is committed when fn returns nil.
+// Inside an Atomic view fn joins the view's transaction.
+func (p *Postgres) inTx(ctx context.Context, fn func(q querier) error) error {
+	if p.tx != nil {
+		return fn(p.tx)
+	}
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *Postgres) Atomic(ctx context.Context, fn func(tx Tx) error) error {
+	if p.tx != nil {
+		return p.savepoint(ctx, fn)
+	}
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(&Postgres{db: p.db, tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// savepoint runs a nested Atomic unit. A failed statement aborts the whole
+// transaction in Postgres, so the unit is rolled back to its savepoint to
+// keep the outer transaction usable.
+func (p *Postgres) savepoint(ctx context.Context, fn func(tx Tx) error) error {
+	if _, err := p.tx.ExecContext(ctx, "SAVEPOINT atomic"); err != nil {
+		return err
+	}
+	if err := fn(p); err != nil {
+		if _, rerr := p.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT atomic"); rerr != nil {
+			return rerr
+		}
+		if _, rerr := p.tx.ExecContext(ctx, "RELEASE SAVEPOINT atomic"); rerr != nil {
+			return rerr
+		}
+		return err
+	}
+	_, err := p.tx.ExecContext(ctx, "RELEASE SAVEPOINT atomic")
+	return err
+}
+
+const idolColumns = `id, name, group_id, "group_name", position, ` + positionsExpr + `, legal_name, hangul_name, birth_date, nationality, debut_date, height_cm, mbti, status, ` + favoriteCountExpr + `, created_at, updated_at, created_by, updated_by, deleted_at, version`
+
+// idolStatusOr defaults an empty idol status to active.
+func idolStatusOr(status string) string {
+	if status == "" {
+		return models.IdolActive
+	}
+	return status
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanIdol(row rowScanner) (models.Idol, error) {
+	var it models.Idol
+	var deletedAt sql.NullTime
+	err := row.Scan(&it.ID, &it.Name, &it.GroupID, &it.Group, &it.Position, pq.Array(&it.Positions),
+		&it.LegalName, &it.HangulName, &it.BirthDate, &it.Nationality, &it.DebutDate, &it.HeightCM, &it.MBTI, &it.Status, &it.FavoriteCount,
+		&it.CreatedAt, &it.UpdatedAt, &it.CreatedBy, &it.UpdatedBy, &deletedAt, &it.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return it, ErrNotFound
+	}
+	if err != nil {
+		return it, err
+	}
+	if deletedAt.Valid {
+		t := deletedAt.Time
+		it.DeletedAt = &t
+	}
+	return it, nil
+}
+
+func (p *Postgres) List(ctx context.Context, opts ListOptions) (Page, error) {
+	pl, err := planList(opts)
+	if err != nil {
+		return Page{}, err
+	}
+	var args sqlArgs
+	// Fetch one extra row to learn whether another page follows.
+	query := listQuery(pl, opts, &args) + " LIMIT " + args.add(pl.limit+1)
+	list := []models.Idol{}
+	err = p.each(ctx, query, args, func(it models.Idol) error {
+		list = append(list, it)
+		return nil
+	})
+	if err != nil {
+		return Page{}, err
+	}
+	return pl.page(list), nil
+}
+
+// Each streams the rows from the database cursor without buffering them.
+func (p *Postgres) Each(ctx context.Context, opts ListOptions, fn func(models.Idol) error) error {
+	pl, err := planList(opts)
+	if err != nil {
+		return err
+	}
+	var args sqlArgs
+	return p.each(ctx, listQuery(pl, opts, &args), args, fn)
+}
+
+func listQuery(pl listPlan, opts ListOptions, args *sqlArgs) string {
+	live := "deleted_at IS NULL"
+	if opts.Deleted {
+		live = "deleted_at IS NOT NULL"
+	}
+	where := append([]string{live}, pl.where(args)...)
+	return "SELECT " + idolColumns + " FROM idols WHERE " + strings.Join(where, " AND ") + " ORDER BY " + pl.orderBy()
+}
+
+func (p *Postgres) each(ctx context.Context, query string, args []interface{}, fn func(models.Idol) error) error {
+	rows, err := p.q().QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		it, err := scanIdol(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(it); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (p *Postgres) Get(ctx context.Context, id int64) (models.Idol, error) {
+	return getIdol(ctx, p.q(), id)
+}
+
+func getIdol(ctx context.Context, q querier, id int64) (models.Idol, error) {
+	return scanIdol(q.QueryRowContext(ctx, "SELECT "+idolColumns+" FROM idols WHERE id=$1 AND deleted_at IS NULL", id))
+}
+
+func (p *Postgres) Create(ctx context.Context, in models.Idol) (it models.Idol, err error) {
+	err = p.inTx(ctx, func(q querier) error {
+		if err := resolveGroup(ctx, q, &in); err != nil {
+			return err
+		}
+		positions, err := resolvePositions(ctx, q, &in)
+		if err != nil {
+			return err
+		}
+		it, err = scanIdol(q.QueryRowContext(ctx,
+			"INSERT INTO idols (name, group_id, \"group_name\", position, legal_name, hangul_name, birth_date, nationality, debut_date, height_cm, mbti, status, created_by, updated_by) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$13) RETURNING "+idolColumns,
+			in.Name, in.GroupID, in.Group, in.Position, in.LegalName, in.HangulName, in.BirthDate, in.Nationality, in.DebutDate, in.HeightCM, in.MBTI, idolStatusOr(in.Status),
+			actorOr(in.CreatedBy)))
+		if err != nil {
+			return err
+		}
+		if err := setIdolPositions(ctx, q, it.ID, positions); err != nil {
+			return err
+		}
+		it.Positions = in.Positions
+		if err := openMembership(ctx, q, it, nil); err != nil {
+			return err
+		}
+		return insertRevision(ctx, q, RevisionCreate, it)
+	})
+	return it, err
+}
+
+func (p *Postgres) Update(ctx context.Context, in models.Idol, version int) (it models.Idol, err error) {
+	err = p.inTx(ctx, func(q querier) error {
+		it, err = updateIdol(ctx, q, in, version)
+		if err != nil {
+			return err
+		}
+		return insertRevision(ctx, q, RevisionUpdate, it)
+	})
+	return it, err
+}
+
+func updateIdol(ctx context.Context, q querier, in models.Idol, version int) (models.Idol, error) {
+	// The row lock keeps the group read here the one the update replaces.
+	var from int64
+	err := q.QueryRowContext(ctx, "SELECT group_id FROM idols WHERE id=$1 AND deleted_at IS NULL FOR UPDATE", in.ID).Scan(&from)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return models.Idol{}, err
+	}
+	if err := resolveGroup(ctx, q, &in); err != nil {
+		return models.Idol{}, err
+	}
+	positions, err := resolvePositions(ctx, q, &in)
+	if err != nil {
+		return models.Idol{}, err
+	}
+	it, err := scanIdol(q.QueryRowContext(ctx,
+		"UPDATE idols SET name=$1, group_id=$2, \"group_name\"=$3, position=$4, legal_name=$5, hangul_name=$6, birth_date=$7, nationality=$8, debut_date=$9, height_cm=$10, mbti=$11, status=$12, updated_by=$13, updated_at=NOW(), version=version+1 WHERE id=$14 AND deleted_at IS NULL AND ($15 = 0 OR version = $15) RETURNING "+idolColumns,
+		in.Name, in.GroupID, in.Group, in.Position, in.LegalName, in.HangulName, in.BirthDate, in.Nationality, in.DebutDate, in.HeightCM, in.MBTI, idolStatusOr(in.Status),
+		actorOr(in.UpdatedBy), in.ID, version))
+	if errors.Is(err, ErrNotFound) && version != 0 {
+		return it, conflictOrMissing(ctx, q, in.ID)
+	}
+	if err != nil {
+		return it, err
+	}
+	if err := setIdolPositions(ctx, q, it.ID, positions); err != nil {
+		return it, err
+	}
+	it.Positions = in.Positions
+	if from != it.GroupID {
+		return it, moveMembership(ctx, q, it, from)
+	}
+	return it, openMembership(ctx, q, it, nil)
+}
+
+func (p *Postgres) SoftDelete(ctx context.Context, id int64, version int, actor string) error {
+	return p.inTx(ctx, func(q querier) error {
+		it, err := scanIdol(q.QueryRowContext(ctx,
+			"UPDATE idols SET deleted_at=NOW(), updated_at=NOW(), updated_by=$3, version=version+1 WHERE id=$1 AND deleted_at IS NULL AND ($2 = 0 OR version = $2) RETURNING "+idolColumns,
+			id, version, actorOr(actor)))
+		if errors.Is(err, ErrNotFound) && version != 0 {
+			return conflictOrMissing(ctx, q, id)
+		}
+		if err != nil {
+			return err
+		}
+		return insertRevision(ctx, q, RevisionDelete, it)
+	})
+}
+
+func (p *Postgres) Restore(ctx context.Context, id int64, actor string) error {
+	return p.inTx(ctx, func(q querier) error {
+		it, err := scanIdol(q.QueryRowContext(ctx,
+			"UPDATE idols SET deleted_at=NULL, updated_at=NOW(), updated_by=$2, version=version+1 WHERE id=$1 AND deleted_at IS NOT NULL RETURNING "+idolColumns,
+			id, actorOr(actor)))
+		if err != nil {
+			return err
+		}
+		return insertRevision(ctx, q, RevisionRestore, it)
+	})
+}
+
+func (p *Postgres) Purge(ctx context.Context, id int64) ([]string, error) {
+	n, keys, err := p.purge(ctx, "id = $1", id)
+	if err == nil && n == 0 {
+		err = ErrNotFound
+	}
+	return keys, err
+}
+
+func (p *Postgres) PurgeDeletedBefore(ctx context.Context, cutoff time.Time) (int64, []string, error) {
+	return p.purge(ctx, "deleted_at IS NOT NULL AND deleted_at < $1", cutoff)
+}
+
+// purge deletes the idols matching where, collecting the keys of their
+// photo files before the cascade drops the rows that name them. The idols
+// are locked first so no photo can be added to them in between.
+func (p *Postgres) purge(ctx context.Context, where string, arg any) (int64, []string, error) {
+	var ids []int64
+	var keys []string
+	err := p.inTx(ctx, func(q querier) error {
+		rows, err := q.QueryContext(ctx, "SELECT id FROM idols WHERE "+where+" FOR UPDATE", arg)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		if err := rows.Err(); err != nil || len(ids) == 0 {
+			return err
+		}
+		if keys, err = deletePhotos(ctx, q, ids); err != nil {
+			return err
+		}
+		_, err = q.ExecContext(ctx, "DELETE FROM idols WHERE id = ANY($1)", pq.Array(ids))
+		return err
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return int64(len(ids)), keys, nil
+}
+
+// conflictOrMissing tells apart the two reasons a versioned write can match
+// no rows.
+func conflictOrMissing(ctx context.Context, q querier, id int64) error {
+	if _, err := getIdol(ctx, q, id); err != nil {
+		return err
+	}
+	return ErrVersionConflict
+}
+
+// affectedOne maps an UPDATE/DELETE that touched no rows to ErrNotFound.
+func affectedOne(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// notFoundOr maps sql.ErrNoRows to ErrNotFound.
+func notFoundOr(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
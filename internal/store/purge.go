@@ -0,0 +1,40 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"kpopapi/internal/storage"
+)
+
+// RunTrashPurger permanently removes idols that have been in the trash for
+// longer than retention, along with their photo files in files, checking
+// every interval until ctx is cancelled. A non-positive retention disables
+// purging.
+func RunTrashPurger(ctx context.Context, idols IdolStore, files storage.Storage, retention, interval time.Duration) {
+	if retention <= 0 {
+		return
+	}
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, keys, err := idols.PurgeDeletedBefore(ctx, time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("trash purge failed: %v", err)
+		} else if n > 0 {
+			log.Printf("trash purge removed %d idols", n)
+			if err := storage.DeleteAll(ctx, files, keys); err != nil {
+				log.Printf("trash purge files: %v", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"kpopapi/internal/models"
+	"kpopapi/internal/storage"
+)
+
+// TestRunTrashPurgerRemovesPhotoFiles runs one purge pass and checks that
+// only the files of idols trashed past the retention are deleted.
+func TestRunTrashPurgerRemovesPhotoFiles(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	files, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.CreateGroup(ctx, models.Group{Name: "AESPA"}); err != nil {
+		t.Fatal(err)
+	}
+	photo := func(name, key string) models.Idol {
+		t.Helper()
+		it, err := m.Create(ctx, models.Idol{Name: name, Group: "AESPA", Position: "Leader"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := files.Put(ctx, key, strings.NewReader("jpeg")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.AddPhoto(ctx, models.Photo{IdolID: it.ID, Variants: []models.PhotoVariant{{Name: "original", Key: key}}}); err != nil {
+			t.Fatal(err)
+		}
+		return it
+	}
+	old := photo("Karina", "photos/1/old.jpg")
+	recent := photo("Winter", "photos/2/recent.jpg")
+	for _, it := range []models.Idol{old, recent} {
+		if err := m.SoftDelete(ctx, it.ID, 0, "admin"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.mu.Lock()
+	past := time.Now().Add(-48 * time.Hour)
+	it := m.idols[old.ID]
+	it.DeletedAt = &past
+	m.idols[old.ID] = it
+	m.mu.Unlock()
+
+	// A cancelled context stops the purger after its first pass.
+	done, cancel := context.WithCancel(ctx)
+	cancel()
+	RunTrashPurger(done, m, files, 24*time.Hour, time.Hour)
+
+	if f, err := files.Open(ctx, "photos/1/old.jpg"); err == nil {
+		f.Close()
+		t.Error("purged idol's photo kept")
+	}
+	f, err := files.Open(ctx, "photos/2/recent.jpg")
+	if err != nil {
+		t.Fatalf("photo of an idol still in the trash: %v", err)
+	}
+	f.Close()
+}
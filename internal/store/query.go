@@ -0,0 +1,328 @@
+package store
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"kpopapi/internal/models"
+)
+
+// QueryError reports an unsupported or malformed listing parameter.
+type QueryError struct {
+	Param   string `json:"param"`
+	Message string `json:"message"`
+}
+
+func (e *QueryError) Error() string {
+	return e.Param + ": " + e.Message
+}
+
+type filterOp int
+
+const (
+	opEqualFold filterOp = iota
+	opPrefix
+	opEqualInt
+	// opHasFold matches when any element of an array column equals the value,
+	// ignoring case.
+	opHasFold
+	// opDateAfter and opDateBefore compare a date column with a YYYY-MM-DD
+	// value, both exclusive; rows without a date never match.
+	opDateAfter
+	opDateBefore
+)
+
+// filterSpec allowlists one filter and binds it to a column. opHasFold
+// filters read values instead of field.
+type filterSpec struct {
+	column string
+	op     filterOp
+	field  func(models.Idol) string
+	values func(models.Idol) []string
+}
+
+var idolFilters = map[string]filterSpec{
+	"group_id":    {column: "group_id", op: opEqualInt, field: func(it models.Idol) string { return strconv.FormatInt(it.GroupID, 10) }},
+	"group_name":  {column: `"group_name"`, op: opEqualFold, field: func(it models.Idol) string { return it.Group }},
+	"position":    {column: positionsExpr, op: opHasFold, values: func(it models.Idol) []string { return it.Positions }},
+	"name_prefix": {column: "name", op: opPrefix, field: func(it models.Idol) string { return it.Name }},
+	"born_after":  {column: "birth_date", op: opDateAfter, field: func(it models.Idol) string { return dateField(it.BirthDate) }},
+	"born_before": {column: "birth_date", op: opDateBefore, field: func(it models.Idol) string { return dateField(it.BirthDate) }},
+	"nationality": {column: "nationality", op: opEqualFold, field: func(it models.Idol) string { return it.Nationality }},
+	"status":      {column: "status", op: opEqualFold, field: func(it models.Idol) string { return it.Status }},
+	"mbti":        {column: "mbti", op: opEqualFold, field: func(it models.Idol) string { return it.MBTI }},
+}
+
+// dateField formats an optional date for filtering; a missing date is "".
+func dateField(d *models.Date) string {
+	if d == nil {
+		return ""
+	}
+	return d.String()
+}
+
+type sortKind int
+
+const (
+	kindText sortKind = iota
+	kindTime
+)
+
+// sortSpec allowlists one sort key. Rows are always tie-broken by id.
+type sortSpec struct {
+	column string
+	kind   sortKind
+	field  func(models.Idol) string
+}
+
+func timeField(get func(models.Idol) time.Time) func(models.Idol) string {
+	return func(it models.Idol) string { return get(it).UTC().Format(time.RFC3339Nano) }
+}
+
+var idolSorts = map[string]sortSpec{
+	"name":       {column: "name", kind: kindText, field: func(it models.Idol) string { return it.Name }},
+	"group_name": {column: `"group_name"`, kind: kindText, field: func(it models.Idol) string { return it.Group }},
+	"created_at": {column: "created_at", kind: kindTime, field: timeField(func(it models.Idol) time.Time { return it.CreatedAt })},
+	"updated_at": {column: "updated_at", kind: kindTime, field: timeField(func(it models.Idol) time.Time { return it.UpdatedAt })},
+}
+
+// IdolFilterNames returns the allowlisted idol filters, sorted.
+func IdolFilterNames() []string { return sortedKeys(idolFilters) }
+
+// IdolSortKeys returns the allowlisted idol sort keys, sorted.
+func IdolSortKeys() []string { return sortedKeys(idolSorts) }
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type boundFilter struct {
+	filterSpec
+	value string
+}
+
+// listPlan is a ListOptions that has been checked against the allowlists.
+type listPlan struct {
+	sortParam string
+	sort      *sortSpec // nil sorts by id only
+	desc      bool
+	filters   []boundFilter
+	after     *Cursor
+	limit     int
+}
+
+func planList(opts ListOptions) (listPlan, error) {
+	pl := listPlan{sortParam: opts.Sort, after: opts.After, limit: opts.Limit}
+	if pl.sortParam == "" {
+		pl.sortParam = "id"
+	} else {
+		key := strings.TrimPrefix(opts.Sort, "-")
+		spec, ok := idolSorts[key]
+		if !ok {
+			return pl, &QueryError{Param: "sort", Message: "unsupported sort key; allowed: " + strings.Join(IdolSortKeys(), ", ")}
+		}
+		pl.sort = &spec
+		pl.desc = key != opts.Sort
+	}
+	for _, name := range sortedKeys(opts.Filters) {
+		spec, ok := idolFilters[name]
+		if !ok {
+			return pl, &QueryError{Param: name, Message: "unsupported filter; allowed: " + strings.Join(IdolFilterNames(), ", ")}
+		}
+		value := opts.Filters[name]
+		switch spec.op {
+		case opEqualInt:
+			if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+				return pl, &QueryError{Param: name, Message: "must be an integer"}
+			}
+		case opDateAfter, opDateBefore:
+			if _, err := models.ParseDate(value); err != nil {
+				return pl, &QueryError{Param: name, Message: "must be a date (YYYY-MM-DD)"}
+			}
+		}
+		pl.filters = append(pl.filters, boundFilter{spec, value})
+	}
+	if c := opts.After; c != nil {
+		if c.Sort != pl.sortParam {
+			return pl, ErrInvalidCursor
+		}
+		if pl.sort != nil && pl.sort.kind == kindTime {
+			if _, err := time.Parse(time.RFC3339Nano, c.Value); err != nil {
+				return pl, ErrInvalidCursor
+			}
+		}
+	}
+	return pl, nil
+}
+
+// page trims a result fetched with limit+1 rows down to limit and builds the
+// cursor for the following page when there are more rows.
+func (pl listPlan) page(items []models.Idol) Page {
+	if pl.limit <= 0 || len(items) <= pl.limit {
+		return Page{Items: items}
+	}
+	items = items[:pl.limit]
+	last := items[len(items)-1]
+	c := Cursor{Sort: pl.sortParam, ID: last.ID}
+	if pl.sort != nil {
+		c.Value = pl.sort.field(last)
+	}
+	return Page{Items: items, NextCursor: c.Encode()}
+}
+
+// sqlArgs collects positional arguments and hands out their placeholders.
+type sqlArgs []interface{}
+
+func (a *sqlArgs) add(v interface{}) string {
+	*a = append(*a, v)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// where returns the SQL predicates for the plan's filters and cursor. Column
+// names only ever come from the allowlists; values are always placeholders.
+func (pl listPlan) where(args *sqlArgs) []string {
+	var conds []string
+	for _, f := range pl.filters {
+		switch f.op {
+		case opEqualFold:
+			conds = append(conds, fmt.Sprintf("LOWER(%s) = LOWER(%s)", f.column, args.add(f.value)))
+		case opPrefix:
+			conds = append(conds, fmt.Sprintf(`LOWER(%s) LIKE LOWER(%s) ESCAPE '\'`, f.column, args.add(likeEscaper.Replace(f.value)+"%")))
+		case opEqualInt:
+			conds = append(conds, fmt.Sprintf("%s = %s", f.column, args.add(f.value)))
+		case opHasFold:
+			conds = append(conds, fmt.Sprintf("EXISTS (SELECT 1 FROM unnest(%s) v WHERE LOWER(v) = LOWER(%s))", f.column, args.add(f.value)))
+		case opDateAfter:
+			conds = append(conds, fmt.Sprintf("%s > %s::date", f.column, args.add(f.value)))
+		case opDateBefore:
+			conds = append(conds, fmt.Sprintf("%s < %s::date", f.column, args.add(f.value)))
+		}
+	}
+	if c := pl.after; c != nil {
+		cmp := ">"
+		if pl.desc {
+			cmp = "<"
+		}
+		if pl.sort == nil {
+			conds = append(conds, fmt.Sprintf("id %s %s", cmp, args.add(c.ID)))
+		} else {
+			v := args.add(c.Value)
+			if pl.sort.kind == kindTime {
+				v += "::timestamptz"
+			}
+			conds = append(conds, fmt.Sprintf("(%s, id) %s (%s, %s)", pl.sort.column, cmp, v, args.add(c.ID)))
+		}
+	}
+	return conds
+}
+
+func (pl listPlan) orderBy() string {
+	dir := "ASC"
+	if pl.desc {
+		dir = "DESC"
+	}
+	if pl.sort == nil {
+		return "id " + dir
+	}
+	return pl.sort.column + " " + dir + ", id " + dir
+}
+
+// match reports whether it passes the plan's filters (memory store).
+func (pl listPlan) match(it models.Idol) bool {
+	for _, f := range pl.filters {
+		if f.op == opHasFold {
+			if !slices.ContainsFunc(f.values(it), func(v string) bool { return strings.EqualFold(v, f.value) }) {
+				return false
+			}
+			continue
+		}
+		v := f.field(it)
+		switch f.op {
+		case opEqualFold:
+			if !strings.EqualFold(v, f.value) {
+				return false
+			}
+		case opPrefix:
+			if !strings.HasPrefix(strings.ToLower(v), strings.ToLower(f.value)) {
+				return false
+			}
+		case opEqualInt:
+			a, _ := strconv.ParseInt(v, 10, 64)
+			b, _ := strconv.ParseInt(f.value, 10, 64)
+			if a != b {
+				return false
+			}
+		case opDateAfter:
+			// YYYY-MM-DD strings sort like the dates they hold.
+			if v == "" || v <= f.value {
+				return false
+			}
+		case opDateBefore:
+			if v == "" || v >= f.value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// compare orders it against a sort value and id in ascending terms.
+func (pl listPlan) compare(it models.Idol, value string, id int64) int {
+	if pl.sort != nil {
+		var c int
+		switch pl.sort.kind {
+		case kindTime:
+			a, _ := time.Parse(time.RFC3339Nano, pl.sort.field(it))
+			b, _ := time.Parse(time.RFC3339Nano, value)
+			c = a.Compare(b)
+		default:
+			c = strings.Compare(pl.sort.field(it), value)
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	switch {
+	case it.ID < id:
+		return -1
+	case it.ID > id:
+		return 1
+	}
+	return 0
+}
+
+// less orders two rows according to the plan (memory store).
+func (pl listPlan) less(a, b models.Idol) bool {
+	var bv string
+	if pl.sort != nil {
+		bv = pl.sort.field(b)
+	}
+	c := pl.compare(a, bv, b.ID)
+	if pl.desc {
+		return c > 0
+	}
+	return c < 0
+}
+
+// afterCursor reports whether it comes after the plan's cursor (memory store).
+func (pl listPlan) afterCursor(it models.Idol) bool {
+	if pl.after == nil {
+		return true
+	}
+	c := pl.compare(it, pl.after.Value, pl.after.ID)
+	if pl.desc {
+		return c < 0
+	}
+	return c > 0
+}
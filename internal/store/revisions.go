@@ -0,0 +1,189 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"time"
+
+	"kpopapi/internal/models"
+)
+
+// Revision actions.
+const (
+	RevisionCreate  = "create"
+	RevisionUpdate  = "update"
+	RevisionDelete  = "delete"
+	RevisionRestore = "restore"
+	RevisionRevert  = "revert"
+)
+
+// FieldChange is one field that differs between two idol snapshots.
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// diffIgnored are bookkeeping fields that change on every write, and
+// favorite_count, which users change without editing the idol.
+var diffIgnored = map[string]bool{"version": true, "updated_at": true, "updated_by": true, "favorite_count": true}
+
+// Diff lists the fields that differ between two snapshots, by JSON name.
+func Diff(from, to models.Idol) []FieldChange {
+	a, b := toMap(from), toMap(to)
+	keys := make(map[string]bool)
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+	changes := []FieldChange{}
+	for _, k := range sortedKeys(keys) {
+		if diffIgnored[k] || reflect.DeepEqual(a[k], b[k]) {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: k, From: a[k], To: b[k]})
+	}
+	return changes
+}
+
+func toMap(it models.Idol) map[string]interface{} {
+	b, _ := json.Marshal(it)
+	var m map[string]interface{}
+	_ = json.Unmarshal(b, &m)
+	return m
+}
+
+// insertRevision records it as the result of action. The actor is the
+// idol's updated_by, which every mutation sets.
+func insertRevision(ctx context.Context, q querier, action string, it models.Idol) error {
+	snap, err := json.Marshal(it)
+	if err != nil {
+		return err
+	}
+	_, err = q.ExecContext(ctx,
+		"INSERT INTO idol_revisions (idol_id, version, action, actor, snapshot) VALUES ($1,$2,$3,$4,$5)",
+		it.ID, it.Version, action, it.UpdatedBy, string(snap))
+	return err
+}
+
+const revisionColumns = "id, idol_id, version, action, actor, snapshot, created_at"
+
+func scanRevision(row rowScanner) (models.IdolRevision, error) {
+	var rev models.IdolRevision
+	var snap []byte
+	err := row.Scan(&rev.ID, &rev.IdolID, &rev.Version, &rev.Action, &rev.Actor, &snap, &rev.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return rev, ErrNotFound
+	}
+	if err != nil {
+		return rev, err
+	}
+	return rev, json.Unmarshal(snap, &rev.Snapshot)
+}
+
+func (p *Postgres) History(ctx context.Context, id int64) ([]models.IdolRevision, error) {
+	rows, err := p.q().QueryContext(ctx, "SELECT "+revisionColumns+" FROM idol_revisions WHERE idol_id=$1 ORDER BY version", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []models.IdolRevision{}
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, ErrNotFound
+	}
+	return list, nil
+}
+
+func (p *Postgres) Revision(ctx context.Context, id int64, version int) (models.IdolRevision, error) {
+	return getRevision(ctx, p.q(), id, version)
+}
+
+func getRevision(ctx context.Context, q querier, id int64, version int) (models.IdolRevision, error) {
+	return scanRevision(q.QueryRowContext(ctx,
+		"SELECT "+revisionColumns+" FROM idol_revisions WHERE idol_id=$1 AND version=$2", id, version))
+}
+
+func (p *Postgres) Revert(ctx context.Context, id int64, version int, actor string) (it models.Idol, err error) {
+	err = p.inTx(ctx, func(q querier) error {
+		rev, err := getRevision(ctx, q, id, version)
+		if err != nil {
+			return err
+		}
+		in := rev.Snapshot
+		in.ID, in.UpdatedBy = id, actor
+		if it, err = updateIdol(ctx, q, in, 0); err != nil {
+			return err
+		}
+		return insertRevision(ctx, q, RevisionRevert, it)
+	})
+	return it, err
+}
+
+// record appends a revision for it; callers hold m.mu.
+func (m *Memory) record(action string, it models.Idol) {
+	m.revSeq++
+	m.revisions[it.ID] = append(m.revisions[it.ID], models.IdolRevision{
+		ID: m.revSeq, IdolID: it.ID, Version: it.Version, Action: action,
+		Actor: it.UpdatedBy, Snapshot: it, CreatedAt: time.Now(),
+	})
+}
+
+func (m *Memory) History(ctx context.Context, id int64) ([]models.IdolRevision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	revs := m.revisions[id]
+	if len(revs) == 0 {
+		return nil, ErrNotFound
+	}
+	list := append([]models.IdolRevision(nil), revs...)
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+func (m *Memory) Revision(ctx context.Context, id int64, version int) (models.IdolRevision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.revision(id, version)
+}
+
+func (m *Memory) revision(id int64, version int) (models.IdolRevision, error) {
+	for _, rev := range m.revisions[id] {
+		if rev.Version == version {
+			return rev, nil
+		}
+	}
+	return models.IdolRevision{}, ErrNotFound
+}
+
+func (m *Memory) Revert(ctx context.Context, id int64, version int, actor string) (models.Idol, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rev, err := m.revision(id, version)
+	if err != nil {
+		return models.Idol{}, err
+	}
+	in := rev.Snapshot
+	in.UpdatedBy = actor
+	it, err := m.update(in, 0)
+	if err != nil {
+		return models.Idol{}, err
+	}
+	m.record(RevisionRevert, it)
+	return it, nil
+}
@@ -0,0 +1,148 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode"
+
+	"kpopapi/internal/models"
+)
+
+// SearchResult is an idol with its relevance score for a search query.
+type SearchResult struct {
+	models.Idol
+	Score float64 `json:"score"`
+}
+
+// similarityThreshold mirrors pg_trgm's default similarity threshold so both
+// stores treat the same typos as matches.
+const similarityThreshold = 0.3
+
+// Postgres ranks full-text matches with ts_rank and adds the best trigram
+// similarity across the searched columns, so misspellings still match.
+func (p *Postgres) Search(ctx context.Context, q string, limit int) ([]SearchResult, error) {
+	rows, err := p.q().QueryContext(ctx, `
+		SELECT `+idolColumns+`,
+			ts_rank(search_vector, plainto_tsquery('simple', $1))
+			+ GREATEST(similarity(name, $1), similarity("group_name", $1), similarity(position, $1)) AS score
+		FROM idols
+		WHERE deleted_at IS NULL
+			AND (search_vector @@ plainto_tsquery('simple', $1)
+				OR name % $1 OR "group_name" % $1 OR position % $1)
+		ORDER BY score DESC, id
+		LIMIT $2`, q, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []SearchResult{}
+	for rows.Next() {
+		var res SearchResult
+		if res.Idol, err = scanIdol(scoreScanner{rows, &res.Score}); err != nil {
+			return nil, err
+		}
+		list = append(list, res)
+	}
+	return list, rows.Err()
+}
+
+// scoreScanner appends a trailing score column to a scanIdol call.
+type scoreScanner struct {
+	row   rowScanner
+	score *float64
+}
+
+func (s scoreScanner) Scan(dest ...interface{}) error {
+	return s.row.Scan(append(dest, s.score)...)
+}
+
+// Search scores every live idol in Go: the share of query words found in the
+// idol's words stands in for ts_rank, plus trigram similarity as in pg_trgm.
+func (m *Memory) Search(ctx context.Context, q string, limit int) ([]SearchResult, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := []SearchResult{}
+	for _, it := range m.idols {
+		if it.DeletedAt != nil {
+			continue
+		}
+		if score, ok := scoreIdol(it, q); ok {
+			list = append(list, SearchResult{Idol: it, Score: score})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Score != list[j].Score {
+			return list[i].Score > list[j].Score
+		}
+		return list[i].ID < list[j].ID
+	})
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+func scoreIdol(it models.Idol, q string) (float64, bool) {
+	fields := []string{it.Name, it.Group, it.Position}
+	var words map[string]bool
+	for _, f := range fields {
+		for _, w := range splitWords(f) {
+			if words == nil {
+				words = make(map[string]bool)
+			}
+			words[w] = true
+		}
+	}
+	qwords := splitWords(q)
+	var hits int
+	for _, w := range qwords {
+		if words[w] {
+			hits++
+		}
+	}
+	var text float64
+	if len(qwords) > 0 {
+		text = float64(hits) / float64(len(qwords))
+	}
+	var sim float64
+	for _, f := range fields {
+		sim = max(sim, trigramSimilarity(f, q))
+	}
+	return text + sim, (hits > 0 && hits == len(qwords)) || sim >= similarityThreshold
+}
+
+func splitWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// trigrams returns the pg_trgm trigram set of s: each lower-cased word is
+// padded with two spaces in front and one behind.
+func trigrams(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range splitWords(s) {
+		r := []rune("  " + w + " ")
+		for i := 0; i+3 <= len(r); i++ {
+			set[string(r[i:i+3])] = true
+		}
+	}
+	return set
+}
+
+// trigramSimilarity matches pg_trgm's similarity(): shared trigrams over the
+// size of the union.
+func trigramSimilarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+	var shared int
+	for t := range ta {
+		if tb[t] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(ta)+len(tb)-shared)
+}
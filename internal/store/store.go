@@ -0,0 +1,100 @@
+// Package store provides persistence for the API resources behind small
+// interfaces so the HTTP layer can run against Postgres or in memory.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"kpopapi/internal/models"
+)
+
+// ErrNotFound is returned when a row does not exist or is soft-deleted.
+var ErrNotFound = errors.New("not found")
+
+// ErrVersionConflict is returned when a write names a version that is no
+// longer the current one.
+var ErrVersionConflict = errors.New("version conflict")
+
+// ListOptions controls a keyset-paginated idol listing.
+type ListOptions struct {
+	// Limit is the page size; callers are expected to clamp it.
+	Limit int
+	// After, when set, resumes the listing after the row it points at.
+	After *Cursor
+	// Filters maps allowlisted filter names (see IdolFilterNames) to values.
+	Filters map[string]string
+	// Sort is an allowlisted sort key (see IdolSortKeys), optionally prefixed
+	// with "-" for descending order. Empty sorts by id.
+	Sort string
+	// Deleted lists soft-deleted idols (the trash) instead of live ones.
+	Deleted bool
+}
+
+// Page is one page of a listing. NextCursor is empty on the last page.
+type Page struct {
+	Items      []models.Idol `json:"items"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// IdolStore is the persistence contract for idols.
+type IdolStore interface {
+	// List returns a page of live idols, or of soft-deleted ones when
+	// opts.Deleted is set.
+	List(ctx context.Context, opts ListOptions) (Page, error)
+	// Each calls fn for every idol List would return, ignoring Limit, in
+	// order and without loading them all at once. An error from fn stops
+	// the iteration and is returned.
+	Each(ctx context.Context, opts ListOptions, fn func(models.Idol) error) error
+	// Get returns a single idol that is not soft-deleted.
+	Get(ctx context.Context, id int64) (models.Idol, error)
+	// Create inserts in and returns the stored row with id and audit fields set.
+	Create(ctx context.Context, in models.Idol) (models.Idol, error)
+	// Update overwrites name, group and position of in.ID and bumps its
+	// version. A non-zero version must match the stored one.
+	Update(ctx context.Context, in models.Idol, version int) (models.Idol, error)
+	// SoftDelete marks the idol as deleted without removing the row and bumps
+	// its version. A non-zero version must match the stored one.
+	SoftDelete(ctx context.Context, id int64, version int, actor string) error
+	// Restore clears deleted_at on a soft-deleted idol and bumps its version.
+	Restore(ctx context.Context, id int64, actor string) error
+	// History returns every revision of an idol, oldest first. Every
+	// mutation above records one revision under the idol's new version.
+	History(ctx context.Context, id int64) ([]models.IdolRevision, error)
+	// Revision returns the revision recorded at the given version.
+	Revision(ctx context.Context, id int64, version int) (models.IdolRevision, error)
+	// Revert copies the editable fields of the given revision back onto the
+	// live idol as a new version.
+	Revert(ctx context.Context, id int64, version int, actor string) (models.Idol, error)
+	// Purge permanently removes an idol, deleted or not, with its history.
+	// It returns the storage keys of the idol's photo files, which the
+	// caller deletes once the purge has committed.
+	Purge(ctx context.Context, id int64) ([]string, error)
+	// PurgeDeletedBefore permanently removes idols soft-deleted before cutoff
+	// and returns how many were removed and the keys of their photo files.
+	PurgeDeletedBefore(ctx context.Context, cutoff time.Time) (int64, []string, error)
+	// Search ranks live idols by how well name, group and position match q.
+	Search(ctx context.Context, q string, limit int) ([]SearchResult, error)
+}
+
+// AtomicStore runs several idol writes as one unit.
+type AtomicStore interface {
+	// Atomic runs fn against a view of the store whose writes are committed
+	// together when fn returns nil and discarded when it returns an error.
+	Atomic(ctx context.Context, fn func(tx Tx) error) error
+}
+
+// Tx is the view of the store inside Atomic. Its own Atomic nests: a failed
+// inner unit discards only its own writes and the outer unit carries on.
+type Tx interface {
+	IdolStore
+	AtomicStore
+}
+
+func actorOr(actor string) string {
+	if actor == "" {
+		return "system"
+	}
+	return actor
+}
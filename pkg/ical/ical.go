@@ -0,0 +1,105 @@
+// Package ical writes iCalendar (RFC 5545) streams. Content lines end with
+// CRLF and are folded at 75 octets without splitting UTF-8 sequences; TEXT
+// values are escaped by Text.
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ContentType is the media type of .ics files.
+const ContentType = "text/calendar; charset=utf-8"
+
+// maxLine is the longest content line in octets, without the CRLF.
+const maxLine = 75
+
+// Writer writes content lines. The first error is kept and returned by
+// Flush.
+type Writer struct {
+	buf *bufio.Writer
+	err error
+}
+
+// NewWriter returns a Writer on w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{buf: bufio.NewWriter(w)}
+}
+
+// Begin opens a component such as VCALENDAR or VEVENT.
+func (w *Writer) Begin(component string) { w.Prop("BEGIN", component) }
+
+// End closes a component opened with Begin.
+func (w *Writer) End(component string) { w.Prop("END", component) }
+
+// Prop writes a property with a value that is already in its iCalendar
+// form. name may carry parameters, e.g. "DTSTART;VALUE=DATE".
+func (w *Writer) Prop(name, value string) {
+	w.line(name + ":" + value)
+}
+
+// Text writes a property with a TEXT value, escaping it.
+func (w *Writer) Text(name, value string) {
+	w.Prop(name, EscapeText(value))
+}
+
+// Flush writes buffered lines to the underlying writer.
+func (w *Writer) Flush() error {
+	if w.err == nil {
+		w.err = w.buf.Flush()
+	}
+	return w.err
+}
+
+// line writes s folded into lines of at most maxLine octets; continuation
+// lines start with a space.
+func (w *Writer) line(s string) {
+	limit := maxLine
+	for w.err == nil {
+		if len(s) <= limit {
+			_, w.err = w.buf.WriteString(s + "\r\n")
+			return
+		}
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		if _, w.err = w.buf.WriteString(s[:cut] + "\r\n "); w.err != nil {
+			return
+		}
+		s = s[cut:]
+		limit = maxLine - 1
+	}
+}
+
+// EscapeText escapes a TEXT value: backslashes, semicolons and commas are
+// escaped, line breaks become \n and other control characters are dropped.
+func EscapeText(s string) string {
+	var b strings.Builder
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == ';' || r == ',':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n' || r == '\r':
+			b.WriteString(`\n`)
+		case r == '\t' || r >= 0x20 && r != 0x7f:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Date formats the date of t as a DATE value.
+func Date(t time.Time) string { return t.Format("20060102") }
+
+// LocalTime formats t as a DATE-TIME value in t's location, to be written
+// with a TZID parameter.
+func LocalTime(t time.Time) string { return t.Format("20060102T150405") }
+
+// UTC formats t as a DATE-TIME value in UTC.
+func UTC(t time.Time) string { return t.UTC().Format("20060102T150405Z") }
@@ -0,0 +1,134 @@
+package ical
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// write returns what a Writer puts out for one property.
+func write(t *testing.T, name, value string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Prop(name, value)
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// unfold undoes line folding as a reader would.
+func unfold(s string) string {
+	return strings.TrimSuffix(strings.ReplaceAll(s, "\r\n ", ""), "\r\n")
+}
+
+func TestFolding(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		lines int
+	}{
+		{"short", "Karina's birthday", 1},
+		// "SUMMARY:" is 8 octets.
+		{"exactly 75", strings.Repeat("x", 67), 1},
+		{"76", strings.Repeat("x", 68), 2},
+		{"continuations hold 74", strings.Repeat("x", 67+74), 2},
+		{"three lines", strings.Repeat("x", 67+74+1), 3},
+		{"hangul", strings.Repeat("카리나", 40), 6},
+		{"mixed widths", strings.Repeat("a카", 60), 4},
+		{"emoji", strings.Repeat("🎂", 50), 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := write(t, "SUMMARY", tt.value)
+			if !strings.HasSuffix(out, "\r\n") {
+				t.Fatalf("%q does not end with CRLF", out)
+			}
+			lines := strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+			if len(lines) != tt.lines {
+				t.Errorf("%d lines, want %d: %q", len(lines), tt.lines, lines)
+			}
+			for i, line := range lines {
+				if len(line) > maxLine {
+					t.Errorf("line %d is %d octets", i, len(line))
+				}
+				if i > 0 && !strings.HasPrefix(line, " ") {
+					t.Errorf("continuation %d does not start with a space: %q", i, line)
+				}
+				if !utf8.ValidString(line) {
+					t.Errorf("line %d splits a UTF-8 sequence: %q", i, line)
+				}
+			}
+			if got := unfold(out); got != "SUMMARY:"+tt.value {
+				t.Errorf("unfolded = %q", got)
+			}
+		})
+	}
+}
+
+func TestEscapeText(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Seoul", "Seoul"},
+		{`a\b`, `a\\b`},
+		{"KSPO Dome; Seoul, Korea", `KSPO Dome\; Seoul\, Korea`},
+		{"one\ntwo", `one\ntwo`},
+		{"one\r\ntwo\rthree", `one\ntwo\nthree`},
+		{"tab\tkept", "tab\tkept"},
+		{"bell\a and del\x7f dropped", "bell and del dropped"},
+		{"카리나", "카리나"},
+	}
+	for _, tt := range tests {
+		if got := EscapeText(tt.in); got != tt.want {
+			t.Errorf("EscapeText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWriterComponents(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Begin("VEVENT")
+	w.Text("LOCATION", "Seoul, Korea")
+	w.Prop("DTSTART;VALUE=DATE", "20240411")
+	w.End("VEVENT")
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := "BEGIN:VEVENT\r\nLOCATION:Seoul\\, Korea\r\nDTSTART;VALUE=DATE:20240411\r\nEND:VEVENT\r\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) { return 0, errors.New("closed") }
+
+func TestWriterKeepsFirstError(t *testing.T) {
+	w := NewWriter(failWriter{})
+	// Enough to overflow the buffer.
+	for range 100 {
+		w.Text("DESCRIPTION", strings.Repeat("x", 200))
+	}
+	if err := w.Flush(); err == nil {
+		t.Error("Flush: want the write error")
+	}
+}
+
+func TestTimeValues(t *testing.T) {
+	kst := time.FixedZone("KST", 9*60*60)
+	at := time.Date(2024, time.April, 11, 8, 30, 5, 0, kst)
+	if got := Date(at); got != "20240411" {
+		t.Errorf("Date = %s", got)
+	}
+	if got := LocalTime(at); got != "20240411T083005" {
+		t.Errorf("LocalTime = %s", got)
+	}
+	if got := UTC(at); got != "20240410T233005Z" {
+		t.Errorf("UTC = %s", got)
+	}
+}
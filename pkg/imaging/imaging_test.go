@@ -0,0 +1,150 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+// segment returns a JPEG marker segment carrying data.
+func segment(marker byte, data []byte) []byte {
+	seg := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(data)+2))
+	return append(seg, data...)
+}
+
+// exif returns an APP1 segment whose first IFD holds a tag 0x0100 entry and
+// then the orientation tag.
+func exif(order string, orientation uint16) []byte {
+	bo := binary.ByteOrder(binary.LittleEndian)
+	if order == "MM" {
+		bo = binary.BigEndian
+	}
+	tiff := make([]byte, 8+2+2*12+4)
+	copy(tiff, order)
+	bo.PutUint16(tiff[2:], 42)
+	bo.PutUint32(tiff[4:], 8)
+	bo.PutUint16(tiff[8:], 2)
+	for i, tag := range []uint16{0x0100, 0x0112} {
+		e := tiff[10+i*12:]
+		bo.PutUint16(e, tag)
+		bo.PutUint16(e[2:], 3) // SHORT
+		bo.PutUint32(e[4:], 1)
+		bo.PutUint16(e[8:], orientation)
+	}
+	return segment(0xE1, append([]byte("Exif\x00\x00"), tiff...))
+}
+
+func jpeg(segments ...[]byte) []byte {
+	b := []byte{0xFF, 0xD8}
+	for _, s := range segments {
+		b = append(b, s...)
+	}
+	return append(b, 0xFF, 0xD9)
+}
+
+func TestOrientation(t *testing.T) {
+	jfif := segment(0xE0, []byte("JFIF\x00\x01\x02\x00\x00\x01\x00\x01\x00\x00"))
+	sos := segment(0xDA, []byte{1, 2, 3})
+	truncated := exif("II", 6)
+	truncated = truncated[:len(truncated)-20]
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"little endian", jpeg(exif("II", 6)), 6},
+		{"big endian", jpeg(exif("MM", 8)), 8},
+		{"after other segments", jpeg(jfif, exif("MM", 3)), 3},
+		{"upright", jpeg(exif("II", 1)), 1},
+		{"out of range", jpeg(exif("II", 9)), 1},
+		{"zero", jpeg(exif("MM", 0)), 1},
+		{"no exif", jpeg(jfif), 1},
+		{"exif after scan", jpeg(sos, exif("II", 6)), 1},
+		{"bad byte order", jpeg(segment(0xE1, []byte("Exif\x00\x00XX\x00\x2a\x00\x00\x00\x08"))), 1},
+		{"truncated segment", append([]byte{0xFF, 0xD8}, truncated...), 1},
+		{"not a jpeg", []byte("\x89PNG\r\n\x1a\n"), 1},
+		{"empty", nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Orientation(tt.data); got != tt.want {
+				t.Errorf("Orientation = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestOrient turns a 3x2 image stored as
+//
+//	0 1 2
+//	3 4 5
+//
+// for every orientation and compares the rows it displays as.
+func TestOrient(t *testing.T) {
+	// A bounds origin away from zero checks that Min is honoured.
+	src := image.NewGray(image.Rect(10, 20, 13, 22))
+	for i := range 6 {
+		src.SetGray(10+i%3, 20+i/3, grayOf(i))
+	}
+	tests := []struct {
+		orientation int
+		want        [][]int
+	}{
+		{1, [][]int{{0, 1, 2}, {3, 4, 5}}},
+		{2, [][]int{{2, 1, 0}, {5, 4, 3}}},
+		{3, [][]int{{5, 4, 3}, {2, 1, 0}}},
+		{4, [][]int{{3, 4, 5}, {0, 1, 2}}},
+		{5, [][]int{{0, 3}, {1, 4}, {2, 5}}},
+		{6, [][]int{{3, 0}, {4, 1}, {5, 2}}},
+		{7, [][]int{{5, 2}, {4, 1}, {3, 0}}},
+		{8, [][]int{{2, 5}, {1, 4}, {0, 3}}},
+		{9, [][]int{{0, 1, 2}, {3, 4, 5}}},
+	}
+	for _, tt := range tests {
+		if got := rows(Orient(src, tt.orientation)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("orientation %d: %v, want %v", tt.orientation, got, tt.want)
+		}
+	}
+}
+
+func grayOf(i int) color.Gray {
+	return color.Gray{Y: uint8(i * 40)}
+}
+
+// rows reads an image back as the indexes grayOf gave its pixels.
+func rows(img image.Image) [][]int {
+	b := img.Bounds()
+	out := make([][]int, b.Dy())
+	for y := range out {
+		for x := 0; x < b.Dx(); x++ {
+			r, _, _, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			out[y] = append(out[y], int(r>>8)/40)
+		}
+	}
+	return out
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		w, h, size int
+		want       image.Point
+	}{
+		{400, 200, 100, image.Pt(100, 50)},
+		{200, 400, 100, image.Pt(50, 100)},
+		{1000, 3, 100, image.Pt(100, 1)},
+		{100, 100, 100, image.Pt(100, 100)},
+	}
+	for _, tt := range tests {
+		src := image.NewNRGBA(image.Rect(0, 0, tt.w, tt.h))
+		got := Fit(src, tt.size)
+		if got.Bounds().Size() != tt.want {
+			t.Errorf("Fit(%dx%d, %d) = %v, want %v", tt.w, tt.h, tt.size, got.Bounds().Size(), tt.want)
+		}
+		if tt.w <= tt.size && tt.h <= tt.size && got != image.Image(src) {
+			t.Errorf("Fit(%dx%d, %d) copied an image that already fits", tt.w, tt.h, tt.size)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package jsonpatch
+
+import (
+	"errors"
+	"testing"
+)
+
+// sameJSON reports whether a and b encode the same JSON value.
+func sameJSON(t *testing.T, a, b string) bool {
+	t.Helper()
+	av, err := decode([]byte(a))
+	if err != nil {
+		t.Fatalf("decode %s: %v", a, err)
+	}
+	bv, err := decode([]byte(b))
+	if err != nil {
+		t.Fatalf("decode %s: %v", b, err)
+	}
+	return equal(av, bv)
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name, doc, patch, want string
+	}{
+		{"set member", `{"a":1}`, `{"b":2}`, `{"a":1,"b":2}`},
+		{"replace member", `{"a":1}`, `{"a":"x"}`, `{"a":"x"}`},
+		{"null removes", `{"a":1,"b":2}`, `{"a":null}`, `{"b":2}`},
+		{"nested merge", `{"a":{"b":1,"c":2}}`, `{"a":{"c":null,"d":3}}`, `{"a":{"b":1,"d":3}}`},
+		{"array replaced whole", `{"a":[1,2]}`, `{"a":[3]}`, `{"a":[3]}`},
+		{"non-object patch replaces", `{"a":1}`, `[1]`, `[1]`},
+		{"object over scalar", `{"a":1}`, `{"a":{"b":2}}`, `{"a":{"b":2}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergePatch([]byte(tt.doc), []byte(tt.patch))
+			if err != nil {
+				t.Fatalf("MergePatch: %v", err)
+			}
+			if !sameJSON(t, string(got), tt.want) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergePatchInvalid(t *testing.T) {
+	if _, err := MergePatch([]byte(`{`), []byte(`{}`)); err == nil {
+		t.Error("invalid document: want error")
+	}
+	if _, err := MergePatch([]byte(`{}`), []byte(`{`)); err == nil {
+		t.Error("invalid patch: want error")
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name, doc, patch, want string
+	}{
+		{"add member", `{"a":1}`, `[{"op":"add","path":"/b","value":2}]`, `{"a":1,"b":2}`},
+		{"add array insert", `{"a":[1,2]}`, `[{"op":"add","path":"/a/1","value":9}]`, `{"a":[1,9,2]}`},
+		{"add array end", `{"a":[1]}`, `[{"op":"add","path":"/a/-","value":2}]`, `{"a":[1,2]}`},
+		{"add nested array", `{"a":[[1,2]]}`, `[{"op":"add","path":"/a/0/1","value":9}]`, `{"a":[[1,9,2]]}`},
+		{"add root", `{"a":1}`, `[{"op":"add","path":"","value":[1]}]`, `[1]`},
+		{"remove member", `{"a":1,"b":2}`, `[{"op":"remove","path":"/a"}]`, `{"b":2}`},
+		{"remove array element", `{"a":[1,2,3]}`, `[{"op":"remove","path":"/a/1"}]`, `{"a":[1,3]}`},
+		{"remove nested array", `{"a":[[1,2],[3]]}`, `[{"op":"remove","path":"/a/0/0"}]`, `{"a":[[2],[3]]}`},
+		{"replace member", `{"a":1}`, `[{"op":"replace","path":"/a","value":"x"}]`, `{"a":"x"}`},
+		{"replace array element", `{"a":[1,2]}`, `[{"op":"replace","path":"/a/0","value":9}]`, `{"a":[9,2]}`},
+		{"replace nested array", `[[1,2]]`, `[{"op":"replace","path":"/0/1","value":9}]`, `[[1,9]]`},
+		{"move", `{"a":{"b":1}}`, `[{"op":"move","from":"/a/b","path":"/c"}]`, `{"a":{},"c":1}`},
+		{"move within array", `{"a":[1,2,3]}`, `[{"op":"move","from":"/a/0","path":"/a/-"}]`, `{"a":[2,3,1]}`},
+		{"copy", `{"a":[1]}`, `[{"op":"copy","from":"/a","path":"/b"},{"op":"add","path":"/b/-","value":2}]`, `{"a":[1],"b":[1,2]}`},
+		{"escaped pointer", `{"a/b":1,"c~d":2}`, `[{"op":"remove","path":"/a~1b"},{"op":"remove","path":"/c~0d"}]`, `{}`},
+		{"test passes", `{"a":1}`, `[{"op":"test","path":"/a","value":1.0}]`, `{"a":1}`},
+		{"test nested number", `{"a":{"b":1}}`, `[{"op":"test","path":"/a","value":{"b":1.0}}]`, `{"a":{"b":1}}`},
+		{"test number in array", `{"a":[1,{"b":2}]}`, `[{"op":"test","path":"/a","value":[1.0,{"b":2e0}]}]`, `{"a":[1,{"b":2}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply([]byte(tt.doc), []byte(tt.patch))
+			if err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			if !sameJSON(t, string(got), tt.want) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	tests := []struct {
+		name, doc, patch string
+		testFailed       bool
+	}{
+		{"unknown op", `{}`, `[{"op":"frob","path":"/a"}]`, false},
+		{"missing value", `{}`, `[{"op":"add","path":"/a"}]`, false},
+		{"bad pointer", `{}`, `[{"op":"add","path":"a","value":1}]`, false},
+		{"missing parent", `{}`, `[{"op":"add","path":"/a/b","value":1}]`, false},
+		{"index out of range", `{"a":[1]}`, `[{"op":"add","path":"/a/2","value":1}]`, false},
+		{"leading zero index", `{"a":[1,2]}`, `[{"op":"remove","path":"/a/01"}]`, false},
+		{"remove missing", `{"a":1}`, `[{"op":"remove","path":"/b"}]`, false},
+		{"remove root", `{"a":1}`, `[{"op":"remove","path":""}]`, false},
+		{"replace missing", `{"a":1}`, `[{"op":"replace","path":"/b","value":1}]`, false},
+		{"move into itself", `{"a":{"b":1}}`, `[{"op":"move","from":"/a","path":"/a/b/c"}]`, false},
+		{"test fails", `{"a":1}`, `[{"op":"test","path":"/a","value":2}]`, true},
+		{"test nested fails", `{"a":{"b":1}}`, `[{"op":"test","path":"/a","value":{"b":1,"c":2}}]`, true},
+		{"test type differs", `{"a":1}`, `[{"op":"test","path":"/a","value":"1"}]`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Apply([]byte(tt.doc), []byte(tt.patch))
+			if err == nil {
+				t.Fatal("want error")
+			}
+			if got := errors.Is(err, ErrTestFailed); got != tt.testFailed {
+				t.Errorf("errors.Is(err, ErrTestFailed) = %v, want %v (err %v)", got, tt.testFailed, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+// Package validation checks request payloads field by field and collects
+// every failure, so a client can fix a whole form in one round trip.
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Error codes of a FieldError.
+const (
+	CodeRequired = "required"
+	CodeTooLong  = "too_long"
+	CodeOneOf    = "one_of"
+	CodeInvalid  = "invalid"
+)
+
+// FieldError is one failed check. Field is the JSON name of the field, with
+// an index for list elements, e.g. "positions[1]".
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Errors is the list of failures of one payload.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Clean trims surrounding white space and normalizes s to NFC, so the same
+// text typed on different systems is stored and compared the same way.
+func Clean(s string) string {
+	return norm.NFC.String(strings.TrimSpace(s))
+}
+
+// CleanAll cleans every string in place.
+func CleanAll(ss ...*string) {
+	for _, s := range ss {
+		*s = Clean(*s)
+	}
+}
+
+// Validator collects field errors. The zero value is ready to use.
+type Validator struct {
+	errs Errors
+}
+
+// Add records a failure.
+func (v *Validator) Add(field, code, message string) {
+	v.errs = append(v.errs, FieldError{Field: field, Code: code, Message: message})
+}
+
+// Check records a failure unless ok holds.
+func (v *Validator) Check(ok bool, field, code, message string) {
+	if !ok {
+		v.Add(field, code, message)
+	}
+}
+
+// Required checks that value is not blank.
+func (v *Validator) Required(field, value string) bool {
+	if strings.TrimSpace(value) == "" {
+		v.Add(field, CodeRequired, field+" is required")
+		return false
+	}
+	return true
+}
+
+// MaxLen checks that value has at most max characters.
+func (v *Validator) MaxLen(field, value string, max int) bool {
+	if utf8.RuneCountInString(value) > max {
+		v.Add(field, CodeTooLong, fmt.Sprintf("%s must be at most %d characters", field, max))
+		return false
+	}
+	return true
+}
+
+// OneOf checks that a non-empty value is one of allowed.
+func (v *Validator) OneOf(field, value string, allowed ...string) bool {
+	if value == "" {
+		return true
+	}
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	v.Add(field, CodeOneOf, fmt.Sprintf("%s must be one of %s", field, strings.Join(allowed, ", ")))
+	return false
+}
+
+// Nest records the failures of a nested payload with their fields prefixed
+// by field, e.g. "idol.name". Any other error is recorded against field.
+func (v *Validator) Nest(field string, err error) {
+	var errs Errors
+	if !errors.As(err, &errs) {
+		if err != nil {
+			v.Add(field, CodeInvalid, err.Error())
+		}
+		return
+	}
+	for _, fe := range errs {
+		fe.Field = field + "." + fe.Field
+		v.errs = append(v.errs, fe)
+	}
+}
+
+// Err returns the collected failures as Errors, or nil when there are none.
+func (v *Validator) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
@@ -0,0 +1,121 @@
+package validation
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestClean(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"", ""},
+		{"  Karina \t\n", "Karina"},
+		{"\u3000Karina ", "Karina"},
+		// Decomposed Hangul and Latin become their composed forms.
+		{"\u1100\u1161", "\uac00"},
+		{"Beyonce\u0301", "Beyonc\u00e9"},
+		{"in  between", "in  between"},
+	}
+	for _, tt := range tests {
+		if got := Clean(tt.in); got != tt.want {
+			t.Errorf("Clean(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCleanAll(t *testing.T) {
+	a, b := " a ", "e\u0301 "
+	CleanAll(&a, &b)
+	if a != "a" || b != "\u00e9" {
+		t.Errorf("CleanAll = %q, %q", a, b)
+	}
+}
+
+func TestValidatorChecks(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(v *Validator) bool
+		ok   bool
+		code string
+	}{
+		{"required set", func(v *Validator) bool { return v.Required("name", "Karina") }, true, ""},
+		{"required empty", func(v *Validator) bool { return v.Required("name", "") }, false, CodeRequired},
+		{"required blank", func(v *Validator) bool { return v.Required("name", " \t") }, false, CodeRequired},
+		{"max len at limit", func(v *Validator) bool { return v.MaxLen("name", "abc", 3) }, true, ""},
+		{"max len over", func(v *Validator) bool { return v.MaxLen("name", "abcd", 3) }, false, CodeTooLong},
+		{"max len counts runes", func(v *Validator) bool { return v.MaxLen("name", "카리나", 3) }, true, ""},
+		{"one of match", func(v *Validator) bool { return v.OneOf("status", "active", "active", "hiatus") }, true, ""},
+		{"one of empty", func(v *Validator) bool { return v.OneOf("status", "", "active") }, true, ""},
+		{"one of is exact", func(v *Validator) bool { return v.OneOf("status", "Active", "active") }, false, CodeOneOf},
+		{"check ok", func(v *Validator) bool { v.Check(true, "x", CodeInvalid, "bad"); return true }, true, ""},
+		{"check fails", func(v *Validator) bool { v.Check(false, "x", CodeInvalid, "bad"); return false }, false, CodeInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v Validator
+			if got := tt.run(&v); got != tt.ok {
+				t.Errorf("returned %v, want %v", got, tt.ok)
+			}
+			err := v.Err()
+			if tt.ok {
+				if err != nil {
+					t.Errorf("Err = %v, want nil", err)
+				}
+				return
+			}
+			var errs Errors
+			if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Code != tt.code {
+				t.Errorf("Err = %#v, want one %s error", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestValidatorCollectsEveryFailure(t *testing.T) {
+	var v Validator
+	v.Required("name", "")
+	v.MaxLen("group_name", strings.Repeat("x", 101), 100)
+	v.OneOf("status", "retired", "active", "hiatus")
+	want := Errors{
+		{Field: "name", Code: CodeRequired, Message: "name is required"},
+		{Field: "group_name", Code: CodeTooLong, Message: "group_name must be at most 100 characters"},
+		{Field: "status", Code: CodeOneOf, Message: "status must be one of active, hiatus"},
+	}
+	err := v.Err()
+	var got Errors
+	if !errors.As(err, &got) || !reflect.DeepEqual(got, want) {
+		t.Fatalf("Err = %#v, want %#v", err, want)
+	}
+	if msg := err.Error(); msg != "name is required; group_name must be at most 100 characters; status must be one of active, hiatus" {
+		t.Errorf("Error() = %q", msg)
+	}
+}
+
+func TestValidatorNest(t *testing.T) {
+	var inner Validator
+	inner.Required("name", "")
+	inner.Add("positions[1]", CodeRequired, "positions[1] is required")
+
+	var v Validator
+	v.Nest("idol", inner.Err())
+	v.Nest("ignored", nil)
+	v.Nest("raw", errors.New("broken"))
+	want := Errors{
+		{Field: "idol.name", Code: CodeRequired, Message: "name is required"},
+		{Field: "idol.positions[1]", Code: CodeRequired, Message: "positions[1] is required"},
+		{Field: "raw", Code: CodeInvalid, Message: "broken"},
+	}
+	var got Errors
+	if !errors.As(v.Err(), &got) || !reflect.DeepEqual(got, want) {
+		t.Errorf("Err = %#v, want %#v", got, want)
+	}
+}
+
+func TestZeroValidatorHasNoError(t *testing.T) {
+	var v Validator
+	// A nil Errors in an error interface would not compare equal to nil.
+	if err := v.Err(); err != nil {
+		t.Errorf("Err = %#v, want nil", err)
+	}
+}
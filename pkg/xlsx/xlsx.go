@@ -0,0 +1,142 @@
+// Package xlsx writes single-sheet Office Open XML workbooks row by row, so a
+// sheet can be streamed without holding it in memory. Strings are written as
+// inline strings, which keeps the workbook free of a shared strings table.
+package xlsx
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ContentType is the media type of .xlsx files.
+const ContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+var staticParts = []struct{ name, body string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`},
+}
+
+// Writer streams one worksheet. Call WriteRow for every row and Close at the
+// end; nothing is valid until Close returns.
+type Writer struct {
+	zw  *zip.Writer
+	buf *bufio.Writer
+	err error
+}
+
+// NewWriter starts a workbook whose only sheet is called sheetName.
+func NewWriter(w io.Writer, sheetName string) (*Writer, error) {
+	zw := zip.NewWriter(w)
+	for _, p := range staticParts {
+		if err := writePart(zw, p.name, p.body); err != nil {
+			return nil, err
+		}
+	}
+	err := writePart(zw, "xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="`+escape(sheetName)+`" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`)
+	if err != nil {
+		return nil, err
+	}
+	// The sheet is the last part, so it can stay open while rows arrive.
+	sw, err := zw.CreateHeader(&zip.FileHeader{Name: "xl/worksheets/sheet1.xml", Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return nil, err
+	}
+	xw := &Writer{zw: zw, buf: bufio.NewWriter(sw)}
+	xw.writeString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	return xw, xw.err
+}
+
+func writePart(zw *zip.Writer, name, body string) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, body)
+	return err
+}
+
+// WriteRow appends a row. Cells may be strings, integers, floats, bools or
+// nil for an empty cell; anything else is written with its fmt %v text.
+func (w *Writer) WriteRow(cells ...interface{}) error {
+	w.writeString("<row>")
+	for _, c := range cells {
+		switch v := c.(type) {
+		case nil:
+			w.writeString("<c/>")
+		case string:
+			w.writeString(`<c t="inlineStr"><is><t xml:space="preserve">` + escape(v) + `</t></is></c>`)
+		case int:
+			w.writeString("<c><v>" + strconv.Itoa(v) + "</v></c>")
+		case int64:
+			w.writeString("<c><v>" + strconv.FormatInt(v, 10) + "</v></c>")
+		case float64:
+			w.writeString("<c><v>" + strconv.FormatFloat(v, 'g', -1, 64) + "</v></c>")
+		case bool:
+			b := "0"
+			if v {
+				b = "1"
+			}
+			w.writeString(`<c t="b"><v>` + b + "</v></c>")
+		default:
+			w.writeString(`<c t="inlineStr"><is><t xml:space="preserve">` + escape(fmt.Sprint(v)) + `</t></is></c>`)
+		}
+	}
+	w.writeString("</row>")
+	return w.err
+}
+
+// Flush pushes buffered rows to the underlying writer.
+func (w *Writer) Flush() error {
+	if w.err == nil {
+		w.err = w.buf.Flush()
+	}
+	if w.err == nil {
+		w.err = w.zw.Flush()
+	}
+	return w.err
+}
+
+// Close ends the sheet and writes the zip directory.
+func (w *Writer) Close() error {
+	w.writeString("</sheetData></worksheet>")
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return w.zw.Close()
+}
+
+func (w *Writer) writeString(s string) {
+	if w.err == nil {
+		_, w.err = w.buf.WriteString(s)
+	}
+}
+
+// escape escapes s for XML text; characters XML cannot hold become U+FFFD.
+func escape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
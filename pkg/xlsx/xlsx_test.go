@@ -0,0 +1,169 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// sheet is the part of a worksheet the tests read back.
+type sheet struct {
+	Rows []struct {
+		Cells []struct {
+			Type   string `xml:"t,attr"`
+			Value  string `xml:"v"`
+			Inline string `xml:"is>t"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// readBack writes rows to a workbook and returns its parts by name.
+func readBack(t *testing.T, sheetName string, rows ...[]interface{}) map[string]string {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, sheetName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err := w.WriteRow(row...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("not a zip: %v", err)
+	}
+	parts := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[f.Name] = string(b)
+	}
+	return parts
+}
+
+func TestWorkbookParts(t *testing.T) {
+	parts := readBack(t, `Idols & "more"`)
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/_rels/workbook.xml.rels", "xl/workbook.xml", "xl/worksheets/sheet1.xml"} {
+		body, ok := parts[name]
+		if !ok {
+			t.Errorf("missing part %s", name)
+			continue
+		}
+		if err := xml.Unmarshal([]byte(body), new(struct{})); err != nil {
+			t.Errorf("%s is not XML: %v", name, err)
+		}
+	}
+	var wb struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := xml.Unmarshal([]byte(parts["xl/workbook.xml"]), &wb); err != nil {
+		t.Fatal(err)
+	}
+	if len(wb.Sheets) != 1 || wb.Sheets[0].Name != `Idols & "more"` {
+		t.Errorf("sheets = %+v", wb.Sheets)
+	}
+}
+
+func TestWriteRowCells(t *testing.T) {
+	tests := []struct {
+		name       string
+		cell       interface{}
+		typ, value string
+	}{
+		{"string", "Karina", "inlineStr", "Karina"},
+		{"empty string", "", "inlineStr", ""},
+		{"markup", `<b>"A&B"</b>`, "inlineStr", `<b>"A&B"</b>`},
+		{"hangul", "카리나", "inlineStr", "카리나"},
+		{"spaces kept", "  two  ", "inlineStr", "  two  "},
+		{"control char", "a\x00b", "inlineStr", "a�b"},
+		{"int", 42, "", "42"},
+		{"negative int64", int64(-7), "", "-7"},
+		{"float", 1.5, "", "1.5"},
+		{"true", true, "b", "1"},
+		{"false", false, "b", "0"},
+		{"nil", nil, "", ""},
+		{"other", struct{ A int }{3}, "inlineStr", "{3}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := readBack(t, "Sheet1", []interface{}{tt.cell})
+			var s sheet
+			if err := xml.Unmarshal([]byte(parts["xl/worksheets/sheet1.xml"]), &s); err != nil {
+				t.Fatal(err)
+			}
+			if len(s.Rows) != 1 || len(s.Rows[0].Cells) != 1 {
+				t.Fatalf("sheet = %+v", s)
+			}
+			c := s.Rows[0].Cells[0]
+			got := c.Value
+			if c.Type == "inlineStr" {
+				got = c.Inline
+			}
+			if c.Type != tt.typ || got != tt.value {
+				t.Errorf("cell t=%q value %q, want t=%q value %q", c.Type, got, tt.typ, tt.value)
+			}
+		})
+	}
+}
+
+func TestRowsKeepOrder(t *testing.T) {
+	parts := readBack(t, "Sheet1",
+		[]interface{}{"id", "name"},
+		[]interface{}{1, "Karina"},
+		[]interface{}{2, "Winter", nil, true},
+	)
+	var s sheet
+	if err := xml.Unmarshal([]byte(parts["xl/worksheets/sheet1.xml"]), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Rows) != 3 {
+		t.Fatalf("%d rows, want 3", len(s.Rows))
+	}
+	if n := len(s.Rows[2].Cells); n != 4 {
+		t.Errorf("last row has %d cells, want 4", n)
+	}
+	if got := s.Rows[1].Cells[1].Inline; got != "Karina" {
+		t.Errorf("B2 = %q", got)
+	}
+}
+
+type failWriter struct{ after int }
+
+func (f *failWriter) Write(p []byte) (int, error) {
+	if f.after -= len(p); f.after < 0 {
+		return 0, errors.New("disk full")
+	}
+	return len(p), nil
+}
+
+func TestWriteErrorSticks(t *testing.T) {
+	w, err := NewWriter(&failWriter{after: 2048}, "Sheet1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("x", 1<<16)
+	for range 10 {
+		_ = w.WriteRow(long)
+	}
+	if err := w.Close(); err == nil {
+		t.Error("Close after a failed write: want error")
+	}
+}